@@ -24,7 +24,7 @@ func (agg *Identity) ReactTo(aev retro.Event) error {
 		}
 	case *events.SetDisplayName:
 	case *events.SetAvatar:
-		if len(ev.ImgData) > 0 {
+		if ev.Img != nil || len(ev.ImgData) > 0 {
 			agg.HasAvatar = true
 		}
 	default:
@@ -33,6 +33,12 @@ func (agg *Identity) ReactTo(aev retro.Event) error {
 	return nil
 }
 
+// OwnedBy returns true if urn is the identity itself, identities are
+// only managed by sessions authenticated as them.
+func (agg *Identity) OwnedBy(urn retro.URN) bool {
+	return urn == agg.URN()
+}
+
 func init() {
 	Register("identity", &Identity{})
 }
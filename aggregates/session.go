@@ -27,6 +27,18 @@ func (agg *Session) ReactTo(aev retro.Event) error {
 	return nil
 }
 
+// Identity returns the URN of the identity associated with the session,
+// it makes Session a retro.IdentifiedSession.
+func (agg *Session) Identity() (retro.URN, bool) {
+	return agg.IdentityURN, agg.HasIdentity
+}
+
+// SchemaVersion allows Session to be snapshotted, it must be bumped
+// whenever the exported fields change.
+func (agg *Session) SchemaVersion() int {
+	return 1
+}
+
 func init() {
 	Register("session", &Session{})
 }
@@ -35,6 +35,12 @@ func (wa WidgetsApp) CreationOfListingsAllowed() bool {
 	return true
 }
 
+// SchemaVersion allows WidgetsApp to be snapshotted, it must be bumped
+// whenever the exported fields change.
+func (wa *WidgetsApp) SchemaVersion() int {
+	return 1
+}
+
 func init() {
 	Register("_", &WidgetsApp{})
 }
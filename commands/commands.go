@@ -13,17 +13,34 @@ func NewManifest() retro.CommandManifest {
 	return &manifest{
 		m: make(map[reflect.Type][]retro.Command),
 		a: make(map[retro.Command]reflect.Type),
+		p: make(map[retro.Command][]retro.Policy),
 	}
 }
 
 var DefaultManifest = NewManifest()
 
-func Register(agg retro.Aggregate, cmd retro.Command) error {
-	return DefaultManifest.Register(agg, cmd)
+// Register registers cmd for agg in the DefaultManifest, the command may
+// only be applied if all policies authorize it (see package policy).
+func Register(agg retro.Aggregate, cmd retro.Command, policies ...retro.Policy) error {
+	if err := DefaultManifest.Register(agg, cmd); err != nil {
+		return err
+	}
+	return require(cmd, policies)
 }
 
-func RegisterWithArgs(agg retro.Aggregate, cmd retro.Command, arg retro.CommandArgs) error {
-	return DefaultManifest.RegisterWithArgs(agg, cmd, arg)
+// RegisterWithArgs is Register for commands which take args of arg's type.
+func RegisterWithArgs(agg retro.Aggregate, cmd retro.Command, arg retro.CommandArgs, policies ...retro.Policy) error {
+	if err := DefaultManifest.RegisterWithArgs(agg, cmd, arg); err != nil {
+		return err
+	}
+	return require(cmd, policies)
+}
+
+func require(cmd retro.Command, policies []retro.Policy) error {
+	if len(policies) == 0 {
+		return nil
+	}
+	return DefaultManifest.(retro.PolicyManifest).Require(cmd, policies...)
 }
 
 type manifest struct {
@@ -31,6 +48,8 @@ type manifest struct {
 	m map[reflect.Type][]retro.Command
 	// Command:Args
 	a map[retro.Command]reflect.Type
+	// Command:Policies
+	p map[retro.Command][]retro.Policy
 }
 
 func (m *manifest) Register(agg retro.Aggregate, cmd retro.Command) error {
@@ -53,6 +72,24 @@ func (m *manifest) RegisterWithArgs(agg retro.Aggregate, cmd retro.Command, arg
 	return nil
 }
 
+// Require adds policies which must authorize cmd before it is applied, cmd
+// must be registered already.
+func (m *manifest) Require(cmd retro.Command, policies ...retro.Policy) error {
+	for _, cmds := range m.m {
+		for _, registered := range cmds {
+			if registered == cmd {
+				m.p[cmd] = append(m.p[cmd], policies...)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("can't require policies for command %s, command is not registered", reflect.TypeOf(cmd))
+}
+
+func (m *manifest) PoliciesFor(c retro.Command) []retro.Policy {
+	return m.p[c]
+}
+
 func (m *manifest) ArgTypeFor(c retro.Command) (retro.CommandArgs, bool) {
 	if at, ok := m.a[c]; ok {
 		return reflect.New(at).Elem().Addr().Interface(), true
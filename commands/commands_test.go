@@ -69,3 +69,19 @@ func Test_Commands_RegisterWithArgs(t *testing.T) {
 		}
 	}
 }
+
+func Test_Commands_Require(t *testing.T) {
+	var (
+		m   = NewManifest()
+		dc  = &dummyCmd{}
+		pol = retro.PolicyFunc(func(context.Context, retro.Session, retro.Aggregate) error { return nil })
+	)
+
+	err := m.(retro.PolicyManifest).Require(dc, pol)
+	test.H(t).NotNil(err)
+
+	test.H(t).IsNil(m.Register(&dummyAggregate{}, dc))
+	test.H(t).IsNil(m.(retro.PolicyManifest).Require(dc, pol, pol))
+	test.H(t).IntEql(len(m.(retro.PolicyManifest).PoliciesFor(dc)), 2)
+	test.H(t).IntEql(len(m.(retro.PolicyManifest).PoliciesFor(&otherDummyCmd{})), 0)
+}
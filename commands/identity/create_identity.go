@@ -13,9 +13,9 @@ import (
 )
 
 type CreateArgs struct {
-	Name            string `json:"name"`
-	PubliclyVisible bool   `json:"publiclyVisible"`
-	Avatar          []byte `json:"avatar"`
+	Name            string         `json:"name" validate:"required,maxlen=64"`
+	PubliclyVisible bool           `json:"publiclyVisible"`
+	Avatar          *retro.BlobRef `json:"avatar" validate:"maxbytes=1048576"`
 }
 
 type CreateIdentity struct {
@@ -47,8 +47,8 @@ func (cmd *CreateIdentity) Apply(ctx context.Context, w io.Writer, session retro
 		ownEvents = append(ownEvents, events.SetVisibility{Radius: "public"})
 	}
 
-	if len(cmd.args.Avatar) > 0 {
-		ownEvents = append(ownEvents, events.SetAvatar{ImgData: cmd.args.Avatar})
+	if cmd.args.Avatar != nil {
+		ownEvents = append(ownEvents, events.SetAvatar{Img: cmd.args.Avatar})
 	}
 
 	return retro.CommandResult{
@@ -4,12 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 
 	"github.com/retro-framework/go-retro/aggregates"
 	"github.com/retro-framework/go-retro/commands"
 	"github.com/retro-framework/go-retro/events"
+	"github.com/retro-framework/go-retro/framework/policy"
 	"github.com/retro-framework/go-retro/framework/retro"
 )
 
@@ -25,16 +25,10 @@ func (cmd *HideIdentity) SetState(agg retro.Aggregate) error {
 	return errors.New("can't cast")
 }
 
+// Apply hides the identity, the policies it is registered with ensure that
+// the session is authenticated as the identity.
 func (cmd *HideIdentity) Apply(ctxt context.Context, w io.Writer, session retro.Session, repo retro.Repo) (retro.CommandResult, error) {
 
-	s := session.(*aggregates.Session)
-	if !s.HasIdentity {
-		return nil, fmt.Errorf("you haven't authenticated or you don't have an identity")
-	}
-	if s.IdentityURN != cmd.identity.URN() {
-		return nil, fmt.Errorf("Identity name on session does not match, this is not your profile")
-	}
-
 	json.NewEncoder(w).Encode(cmd.identity)
 
 	return retro.CommandResult{
@@ -45,5 +39,5 @@ func (cmd *HideIdentity) Apply(ctxt context.Context, w io.Writer, session retro.
 }
 
 func init() {
-	commands.Register(&aggregates.Identity{}, &HideIdentity{})
+	commands.Register(&aggregates.Identity{}, &HideIdentity{}, policy.Authenticated, policy.OwnsTarget)
 }
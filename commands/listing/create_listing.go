@@ -9,15 +9,16 @@ import (
 	"github.com/retro-framework/go-retro/aggregates"
 	"github.com/retro-framework/go-retro/commands"
 	"github.com/retro-framework/go-retro/events"
+	"github.com/retro-framework/go-retro/framework/policy"
 	"github.com/retro-framework/go-retro/framework/retro"
 )
 
 type Args struct {
-	Name       string   `json:"name"`
-	Desc       string   `json:"desc"`
-	PublishNow bool     `json:"publishNow"`
-	StartPrice uint16   `json:"startPrice"`
-	Images     [][]byte `json:"images"`
+	Name       string          `json:"name" validate:"required,maxlen=100"`
+	Desc       string          `json:"desc" validate:"maxlen=5000"`
+	PublishNow bool            `json:"publishNow"`
+	StartPrice uint16          `json:"startPrice" validate:"required,min=1"`
+	Images     []retro.BlobRef `json:"images" validate:"maxlen=10,maxbytes=1048576"`
 }
 
 type CreateListing struct {
@@ -42,19 +43,12 @@ func (cmd *CreateListing) SetArgs(a retro.CommandArgs) error {
 	return nil
 }
 
+// Apply creates a listing, the session must be authenticated (see the
+// policies it is registered with).
 func (cmd *CreateListing) Apply(ctx context.Context, w io.Writer, session retro.Session, repo retro.Repo) (retro.CommandResult, error) {
 
-	if s, ok := session.(*aggregates.Session); ok {
-		if !s.HasIdentity {
-			return nil, fmt.Errorf("can't create listing on anon session")
-		}
-		// TODO: maybe recommend a command to issue first?
-	} else {
-		return nil, fmt.Errorf("can't cast session, world is broken")
-	}
-
 	if !cmd.wa.CreationOfListingsAllowed() {
-		return nil, fmt.Errorf("creation of listings is forbidden")
+		return nil, retro.ForbiddenError{Reason: "creation of listings is not allowed"}
 	}
 
 	var listing = &aggregates.Listing{}
@@ -64,8 +58,8 @@ func (cmd *CreateListing) Apply(ctx context.Context, w io.Writer, session retro.
 		events.SetDescription{Desc: cmd.args.Desc},
 	}
 
-	for _, img := range cmd.args.Images {
-		ownEvents = append(ownEvents, events.CreateListingImage{img})
+	for i := range cmd.args.Images {
+		ownEvents = append(ownEvents, events.CreateListingImage{Img: &cmd.args.Images[i]})
 	}
 
 	if cmd.args.PublishNow {
@@ -89,5 +83,5 @@ func (cmd *CreateListing) Render(ctx context.Context, w io.Writer, session retro
 }
 
 func init() {
-	commands.RegisterWithArgs(&aggregates.WidgetsApp{}, &CreateListing{}, &Args{})
+	commands.RegisterWithArgs(&aggregates.WidgetsApp{}, &CreateListing{}, &Args{}, policy.Authenticated)
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/retro-framework/go-retro/framework/retro"
+)
+
+// aggregateServer renders aggregates as JSON, as they are now or, given
+// ?at= (see requestContext), as they were at a point in the past.
+type aggregateServer struct {
+	m retro.AggregateManifest
+	r retro.Repo
+}
+
+func (srv aggregateServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, err := requestContext(req)
+	if err != nil {
+		writeProblem(w, retro.BadArgsError{Err: err})
+		return
+	}
+
+	// The root aggregate lives at "_", everything else at "type/id".
+	var (
+		path    = mux.Vars(req)["path"]
+		aggType = strings.SplitN(path, "/", 2)[0]
+	)
+	agg, err := srv.m.ForPath(aggType)
+	if err != nil || agg == nil {
+		http.NotFound(w, req)
+		return
+	}
+	if !srv.r.Exists(ctx, retro.PartitionName(path)) {
+		http.NotFound(w, req)
+		return
+	}
+	if err := agg.SetName(retro.PartitionName(path)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Erased partitions are gone, unknown refs not found (see statusFor).
+	if err := srv.r.Rehydrate(ctx, agg, retro.PartitionName(path)); err != nil {
+		writeProblem(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	var enc = json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(agg); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
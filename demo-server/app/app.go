@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -16,8 +15,10 @@ import (
 	"time"
 
 	"github.com/retro-framework/go-retro/framework/engine"
+	"github.com/retro-framework/go-retro/framework/object"
 	"github.com/retro-framework/go-retro/framework/retro"
 	"github.com/retro-framework/go-retro/projections"
+	"golang.org/x/xerrors"
 
 	identityCmd "github.com/retro-framework/go-retro/commands/identity"
 	listingCmd "github.com/retro-framework/go-retro/commands/listing"
@@ -48,15 +49,19 @@ type renderCtx struct {
 
 	// General thing
 	Sessions projections.Sessions
+
+	// Errors maps form fields to the reason they failed validation
+	Errors map[string]string
 }
 
 func NewServer(
 	e engine.Engine,
 	session projections.Sessions,
+	blobs object.BlobStore,
 	tplDir string,
 	mountPoint string,
 ) server {
-	var s = &server{e: e, session: session}
+	var s = &server{e: e, session: session, blobs: blobs}
 	s.parseTemplates(e, tplDir)
 	return *s
 }
@@ -70,6 +75,28 @@ type server struct {
 	template   *template.Template
 	mountpoint string
 	session    projections.Sessions
+
+	// blobs stores uploaded images, commands reference them by hash
+	blobs object.BlobStore
+}
+
+// renderInvalid renders the form tpl again with the reasons its fields
+// failed validation, other errors are printed as they are.
+func (s server) renderInvalid(w http.ResponseWriter, req *http.Request, tpl string, err error) {
+	var ves retro.ValidationErrors
+	if !xerrors.As(err, &ves) {
+		fmt.Fprint(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	err = s.template.ExecuteTemplate(w, tpl, renderCtx{
+		r:        req,
+		Sessions: s.session,
+		Errors:   ves.Fields(),
+	})
+	if err != nil {
+		fmt.Fprint(w, err)
+	}
 }
 
 func (s server) NewProfileServer(projection projections.Profiles) profile {
@@ -87,7 +114,7 @@ func (s server) IndexHandler(w http.ResponseWriter, req *http.Request) {
 		Flash:    GetFlash(w, req, "message"),
 	})
 	if err != nil {
-		fmt.Fprintf(w, err.Error())
+		fmt.Fprint(w, err)
 	}
 }
 
@@ -103,7 +130,7 @@ func (p profile) ShowHandler(w http.ResponseWriter, req *http.Request) {
 		Flash:    GetFlash(w, req, "message"),
 	})
 	if err != nil {
-		fmt.Fprintf(w, err.Error())
+		fmt.Fprint(w, err)
 	}
 }
 
@@ -115,13 +142,13 @@ func (p profile) NewHandler(w http.ResponseWriter, req *http.Request) {
 			Flash:    GetFlash(w, req, "message"),
 		})
 		if err != nil {
-			fmt.Fprintf(w, err.Error())
+			fmt.Fprint(w, err)
 		}
 	} else if req.Method == "POST" {
 
 		err := req.ParseMultipartForm(1 << 20)
 		if err != nil {
-			fmt.Fprintf(w, err.Error())
+			fmt.Fprint(w, err)
 		}
 
 		var cmd = struct {
@@ -134,7 +161,7 @@ func (p profile) NewHandler(w http.ResponseWriter, req *http.Request) {
 
 		// Parse the publishNow into a bool
 		if s, err := strconv.ParseBool(req.Form.Get("visibilityPublic")); err != nil {
-			fmt.Fprintf(w, err.Error())
+			fmt.Fprint(w, err)
 		} else {
 			cmd.Args.PubliclyVisible = s
 		}
@@ -142,20 +169,20 @@ func (p profile) NewHandler(w http.ResponseWriter, req *http.Request) {
 		// Parse the files attached, if any
 		avatar, _, err := req.FormFile("avatar")
 		if err != nil {
-			fmt.Fprintf(w, err.Error())
+			fmt.Fprint(w, err)
 			return
 		}
 		defer avatar.Close()
-		avatarBuf, err := ioutil.ReadAll(avatar)
+		avatarRef, err := p.blobs.WriteBlob(avatar)
 		if err != nil {
-			fmt.Fprintf(w, err.Error())
+			fmt.Fprint(w, err)
 			return
 		}
-		cmd.Args.Avatar = avatarBuf
+		cmd.Args.Avatar = &avatarRef
 
 		cmdB, err := json.Marshal(cmd)
 		if err != nil {
-			fmt.Fprintf(w, err.Error())
+			fmt.Fprint(w, err)
 			return
 		}
 
@@ -164,13 +191,13 @@ func (p profile) NewHandler(w http.ResponseWriter, req *http.Request) {
 			sessionIDStr = req.Context().Value(ContextKeySessionID)
 		)
 
-		_, err = p.e.Apply(req.Context(), &b, retro.SessionID(sessionIDStr.(string)), cmdB)
+		res, err := p.e.Apply(req.Context(), &b, retro.SessionID(sessionIDStr.(string)), cmdB)
 		if err != nil {
-			fmt.Fprintf(w, err.Error())
+			p.renderInvalid(w, req, "profile/new", err)
 			return
 		}
 
-		SetFlash(w, "message", []byte(fmt.Sprintf("Profile %q created successfully!", b.String())))
+		SetFlash(w, "message", []byte(fmt.Sprintf("Profile %q created successfully!", namedAggregate(res, "identity"))))
 		http.Redirect(w, req, "/demo-app/", http.StatusFound)
 	}
 }
@@ -188,7 +215,7 @@ func (l listing) ListHandler(w http.ResponseWriter, req *http.Request) {
 
 	var listings, err = l.projection.PublishedListings(req.Context())
 	if err != nil {
-		fmt.Fprintf(w, err.Error())
+		fmt.Fprint(w, err)
 		return
 	}
 
@@ -201,7 +228,7 @@ func (l listing) ListHandler(w http.ResponseWriter, req *http.Request) {
 
 	err = l.template.ExecuteTemplate(w, "listing/list", rtx)
 	if err != nil {
-		fmt.Fprintf(w, err.Error())
+		fmt.Fprint(w, err)
 	}
 
 }
@@ -215,13 +242,13 @@ func (l listing) NewHandler(w http.ResponseWriter, req *http.Request) {
 			Flash:    GetFlash(w, req, "message"),
 		})
 		if err != nil {
-			fmt.Fprintf(w, err.Error())
+			fmt.Fprint(w, err)
 		}
 	} else if req.Method == "POST" {
 
 		err := req.ParseMultipartForm(1 << 20)
 		if err != nil {
-			fmt.Fprintf(w, err.Error())
+			fmt.Fprint(w, err)
 		}
 
 		var cmd = struct {
@@ -237,14 +264,14 @@ func (l listing) NewHandler(w http.ResponseWriter, req *http.Request) {
 
 		// Parse the publishNow into a bool
 		if s, err := strconv.ParseBool(req.Form.Get("publishNow")); err != nil {
-			fmt.Fprintf(w, err.Error())
+			fmt.Fprint(w, err)
 		} else {
 			cmd.Args.PublishNow = s
 		}
 
 		// Parse the startPrice into an Int16
 		if price, err := strconv.ParseInt(req.FormValue("startPrice"), 10, 16); err != nil {
-			fmt.Fprintf(w, err.Error())
+			fmt.Fprint(w, err)
 		} else {
 			cmd.Args.StartPrice = uint16(price)
 		}
@@ -254,20 +281,20 @@ func (l listing) NewHandler(w http.ResponseWriter, req *http.Request) {
 			f, err := img.Open()
 			defer f.Close()
 			if err != nil {
-				fmt.Fprintf(w, err.Error())
+				fmt.Fprint(w, err)
 				continue
 			}
-			b, err := ioutil.ReadAll(f)
+			ref, err := l.blobs.WriteBlob(f)
 			if err != nil {
-				fmt.Fprintf(w, err.Error())
+				fmt.Fprint(w, err)
 				continue
 			}
-			cmd.Args.Images = append(cmd.Args.Images, b)
+			cmd.Args.Images = append(cmd.Args.Images, ref)
 		}
 
 		cmdB, err := json.Marshal(cmd)
 		if err != nil {
-			fmt.Fprintf(w, err.Error())
+			fmt.Fprint(w, err)
 			return
 		}
 
@@ -275,17 +302,28 @@ func (l listing) NewHandler(w http.ResponseWriter, req *http.Request) {
 			b            bytes.Buffer
 			sessionIDStr = req.Context().Value(ContextKeySessionID)
 		)
-		_, err = l.e.Apply(req.Context(), &b, retro.SessionID(sessionIDStr.(string)), cmdB)
+		res, err := l.e.Apply(req.Context(), &b, retro.SessionID(sessionIDStr.(string)), cmdB)
 		if err != nil {
-			fmt.Fprintf(w, err.Error())
+			l.renderInvalid(w, req, "listing/new", err)
 			return
 		}
 
-		SetFlash(w, "message", []byte(fmt.Sprintf("Listing %q created successfully!", b.String())))
+		SetFlash(w, "message", []byte(fmt.Sprintf("Listing %q created successfully!", namedAggregate(res, "listing"))))
 		http.Redirect(w, req, "/demo-app/listings", http.StatusFound)
 	}
 }
 
+// namedAggregate returns the name of the first aggregate of the given type
+// which was created by the command.
+func namedAggregate(res engine.ApplyResult, typ string) string {
+	for _, pn := range res.Named {
+		if pn.Dirname() == typ {
+			return string(pn)
+		}
+	}
+	return ""
+}
+
 var (
 	hasIdentity = func(rtx renderCtx) bool {
 		return false
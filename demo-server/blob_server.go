@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/retro-framework/go-retro/framework/object"
+	"github.com/retro-framework/go-retro/framework/storage/fs"
+)
+
+// maxBlobBytes limits blobs uploaded to the blobServer, it matches the
+// limit commands place on the images they reference.
+const maxBlobBytes = 1 << 20
+
+// blobServer serves blobs with the content type detected from their first
+// bytes, and stores blobs uploaded for commands to reference. Blobs are
+// addressed by their content so the hash makes a strong ETag and they may
+// be cached forever.
+type blobServer struct {
+	db object.DB
+}
+
+func (srv blobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		srv.serve(w, r)
+	case http.MethodPost:
+		srv.store(w, r)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (srv blobServer) serve(w http.ResponseWriter, r *http.Request) {
+
+	var (
+		hash = mux.Vars(r)["hash"]
+		etag = fmt.Sprintf("%q", hash)
+	)
+
+	if strings.Contains(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	rc, size, err := srv.db.OpenBlob(hash)
+	switch err {
+	case nil:
+	case fs.ErrNoSuchObject, fs.ErrNotABlob, fs.ErrBadObjectHashForRetrieve, fs.ErrUnableToDecodeHashForRetrieve, fs.ErrUnsupportedHash:
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	default:
+		log.Println(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	// Peek errors for blobs shorter than the 512 bytes sniffed, whatever
+	// could be read is still what DetectContentType wants.
+	var br = bufio.NewReaderSize(rc, 512)
+	sniffed, _ := br.Peek(512)
+
+	// Anything other than an image is served as a download, so that
+	// uploads can't be used to serve pages from our origin.
+	var contentType = http.DetectContentType(sniffed)
+	if !strings.HasPrefix(contentType, "image/") {
+		contentType = "application/octet-stream"
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, br); err != nil {
+		log.Println(err)
+	}
+}
+
+// store writes the request body as a blob and responds with a reference to
+// it, which can be given to commands as args.
+func (srv blobServer) store(w http.ResponseWriter, r *http.Request) {
+	var body = &bodyReader{Reader: http.MaxBytesReader(w, r.Body, maxBlobBytes)}
+	ref, err := srv.db.WriteBlob(body)
+	if body.err != nil {
+		// Reading the body fails when it is larger than maxBlobBytes,
+		// otherwise the client has gone away and won't see this anyway.
+		http.Error(w, body.err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ref)
+}
+
+// bodyReader records the error reading the request body, telling it apart
+// from errors storing the blob.
+type bodyReader struct {
+	io.Reader
+	err error
+}
+
+func (br *bodyReader) Read(p []byte) (int, error) {
+	n, err := br.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		br.err = err
+	}
+	return n, err
+}
@@ -28,6 +28,8 @@ import (
 
 	"github.com/retro-framework/go-retro/framework/depot"
 	"github.com/retro-framework/go-retro/framework/engine"
+	"github.com/retro-framework/go-retro/framework/index"
+	"github.com/retro-framework/go-retro/framework/packing"
 	"github.com/retro-framework/go-retro/framework/repository"
 	"github.com/retro-framework/go-retro/framework/resolver"
 	"github.com/retro-framework/go-retro/framework/retro"
@@ -43,16 +45,38 @@ type clock struct{}
 
 func (c clock) Now() time.Time { return time.Now().UTC() }
 
+// newPacker packs the events for images in CBOR, which stores the bytes
+// of images set before they were stored as blobs as they are rather than
+// as base64. Changing which events are packed in
+// CBOR changes the hashes of new events, replays of history packed
+// otherwise report them as divergences.
+//
+// The events of identities are encrypted with keys from keys, so that they
+// can be erased (see eraseMain).
+func newPacker(keys retro.KeyStore) packing.Packer {
+	return packing.NewEncryptingPacker(
+		packing.NewCBORPacker("set_avatar", "create_listing_image", "associate_image"),
+		keys,
+		"identity/*",
+	)
+}
+
 func main() {
 
 	var (
 		storagePath string
+		keysPath    string
+		signingKey  string
+		trustedKeys string
 		listenAddr  = fmt.Sprintf(":%s", os.Getenv("PORT"))
 	)
 
 	var ctx = context.Background()
 
 	flag.StringVar(&storagePath, "storage_path", "/tmp", "storage dir for the depot")
+	flag.StringVar(&keysPath, "keys_path", "", "storage dir for the keys of encrypted partitions (default storage_path)")
+	flag.StringVar(&signingKey, "signing_key", "", "ed25519 private key (PEM) to sign checkpoints with")
+	flag.StringVar(&trustedKeys, "trusted_keys", "", "comma separated ed25519 public keys (PEM), refs are only moved to checkpoints signed by these or the signing key")
 	flag.Parse()
 
 	storagePath, err := filepath.Abs(storagePath)
@@ -61,6 +85,25 @@ func main() {
 	}
 	log.Println("Using Storage Path:", storagePath)
 
+	if keysPath == "" {
+		keysPath = storagePath
+	}
+	var keys = fs.KeyStore{BasePath: keysPath}
+
+	signer, trusted, err := loadKeys(signingKey, trustedKeys)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch flag.Arg(0) {
+	case "replay":
+		os.Exit(replayMain(ctx, storagePath, keys, flag.Args()[1:], os.Stdout))
+	case "erase":
+		os.Exit(eraseMain(storagePath, keys, flag.Args()[1:], os.Stdout))
+	case "verify":
+		os.Exit(verifyMain(storagePath, trusted, flag.Args()[1:], os.Stdout))
+	}
+
 	templatePath, err := filepath.Abs("./app/tpl/")
 	log.Println("Using Template Path:", templatePath)
 
@@ -79,14 +122,26 @@ func main() {
 	}
 	opentracing.SetGlobalTracer(tracer)
 
+	var (
+		packer    = newPacker(keys)
+		depotOpts []depot.Option
+	)
+	if signer != nil {
+		packer = packing.NewSigningPacker(packer, signer)
+	}
+	if trustedKeys != "" {
+		depotOpts = append(depotOpts, depot.WithVerifier(packing.NewVerifier(trusted...)))
+	}
+
 	var (
 		odb   = &fs.ObjectStore{BasePath: storagePath}
 		refdb = &fs.RefStore{BasePath: storagePath}
 
-		objDBSrv = objectDBServer{odb}
+		objDBSrv = objectDBServer{odb, packer}
 		refDBSrv = refDBServer{refdb}
-		d        = depot.NewSimple(odb, refdb)
-		r        = repository.NewSimpleRepository(odb, refdb, events.DefaultManifest)
+		idx      = index.New(odb, index.WithPacker(packer))
+		d        = depot.NewSimple(odb, refdb, append(depotOpts, depot.WithIndex(idx), depot.WithEventManifest(events.DefaultManifest), depot.WithPacker(packer))...)
+		r        = repository.NewSimpleRepository(odb, refdb, events.DefaultManifest, repository.WithIndex(idx), repository.WithPacker(packer))
 		idFn     = func() (string, error) {
 			b := make([]byte, 12)
 			_, err := rand.Read(b)
@@ -95,8 +150,8 @@ func main() {
 			}
 			return fmt.Sprintf("%x", b), nil
 		}
-		rFn = resolver.New(aggregates.DefaultManifest, commands.DefaultManifest)
-		e   = engine.New(d, r, rFn, idFn, clock{}, aggregates.DefaultManifest, events.DefaultManifest)
+		rFn = resolver.New(aggregates.DefaultManifest, commands.DefaultManifest, resolver.WithBlobs(odb))
+		e   = engine.New(d, r, rFn, idFn, clock{}, aggregates.DefaultManifest, events.DefaultManifest, engine.WithPacker(packer))
 	)
 
 	esClient, err := elastic.NewClient(
@@ -134,12 +189,16 @@ func main() {
 	rMux.Handle("/list/commands", commandManifestServer{commands.DefaultManifest}).Methods("GET")
 	rMux.Handle("/list/events", eventManifestServer{events.DefaultManifest}).Methods("GET")
 	rMux.Handle("/obj/{hash}", objDBSrv).Methods("GET")
+	rMux.Handle("/blob/{hash}", blobServer{odb}).Methods("GET", "HEAD")
+	rMux.Handle("/blob", blobServer{odb}).Methods("POST")
 	rMux.Handle("/ref/", refDBSrv).Methods("GET")
 	rMux.Handle("/apply", engineServer{e}).Methods("POST")
+	rMux.Handle("/query", engineServer{e}).Methods("POST")
+	rMux.Handle("/aggregate/{path:.+}", aggregateServer{aggregates.DefaultManifest, r}).Methods("GET")
 
 	var (
 		appMount = "/demo-app"
-		demoApp  = app.NewServer(e, sessionsProjection, templatePath, appMount)
+		demoApp  = app.NewServer(e, sessionsProjection, odb, templatePath, appMount)
 		profile  = demoApp.NewProfileServer(profilesProjection)
 		listing  = demoApp.NewListingServer(listingsProjection)
 	)
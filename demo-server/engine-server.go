@@ -1,13 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/retro-framework/go-retro/framework/engine"
+	"github.com/retro-framework/go-retro/framework/packing"
+	"github.com/retro-framework/go-retro/framework/ref"
 	"github.com/retro-framework/go-retro/framework/retro"
+	"golang.org/x/xerrors"
 )
 
 type engineServer struct {
@@ -21,26 +31,30 @@ func (e engineServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if req.URL.Path != "/apply" {
+	if req.URL.Path != "/apply" && req.URL.Path != "/query" {
 		http.NotFound(w, req)
 		return
 	}
 
-	var ctx = req.Context()
+	ctx, err := requestContext(req)
+	if err != nil {
+		writeProblem(w, retro.BadArgsError{Err: err})
+		return
+	}
 
-	spnApply, ctx := opentracing.StartSpanFromContext(ctx, "/apply")
+	spnApply, ctx := opentracing.StartSpanFromContext(ctx, req.URL.Path)
 	defer spnApply.Finish()
 
 	// Check if we have a session cookie, if not we'll get one and
-	// set it into the response
-	var (
-		err error
-		sid retro.SessionID
-	)
-	if sessionCookie, _ := req.Cookie("retroSessionID"); sessionCookie == nil {
+	// set it into the response. Queries and dry runs store nothing, not
+	// even the session, they are run with a fresh session instead.
+	var sid retro.SessionID
+	if sessionCookie, _ := req.Cookie("retroSessionID"); sessionCookie != nil {
+		sid = retro.SessionID(sessionCookie.Value)
+	} else if req.URL.Path == "/apply" && !engine.DryRunFromContext(ctx) {
 		sid, err = e.e.StartSession(ctx)
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			writeProblem(w, err)
 			return
 		}
 		cookie := http.Cookie{
@@ -49,8 +63,6 @@ func (e engineServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			Expires: time.Now().Add(6 * time.Hour),
 		}
 		http.SetCookie(w, &cookie)
-	} else {
-		sid = retro.SessionID(sessionCookie.Value)
 	}
 
 	body, err := ioutil.ReadAll(req.Body)
@@ -60,10 +72,132 @@ func (e engineServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 	spnApply.SetTag("payload", string(body))
 
-	_, err = e.e.Apply(ctx, w, sid, body)
+	// Queries run read-only commands, against past state if the request
+	// is pinned with ?at=
+	if req.URL.Path == "/query" {
+		var out bytes.Buffer
+		if err := e.e.Query(ctx, &out, sid, body); err != nil {
+			writeProblem(w, err)
+			return
+		}
+		out.WriteTo(w)
+		return
+	}
+
+	// The result of applying a command is returned as JSON, whatever the
+	// command rendered is included as output. The result of a dry run
+	// describes what would have been written.
+	var out bytes.Buffer
+	res, err := e.e.Apply(ctx, &out, sid, body)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeProblem(w, err)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		engine.ApplyResult
+		Output string `json:"output"`
+	}{res, out.String()})
+}
 
+// problem is an RFC 7807 problem details body, Type names the class of
+// error (see statusFor) and Detail is the error itself. Errors lists the
+// args which failed validation, if any.
+type problem struct {
+	Type   string                 `json:"type"`
+	Title  string                 `json:"title"`
+	Status int                    `json:"status"`
+	Detail string                 `json:"detail,omitempty"`
+	Errors retro.ValidationErrors `json:"errors,omitempty"`
+}
+
+// writeProblem writes err as a JSON problem body with the status for its
+// class.
+func writeProblem(w http.ResponseWriter, err error) {
+	var (
+		status, typ = statusFor(err)
+		ves         retro.ValidationErrors
+		ve          retro.ValidationError
+	)
+	if !xerrors.As(err, &ves) && xerrors.As(err, &ve) {
+		ves = retro.ValidationErrors{ve}
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem{
+		Type:   typ,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: err.Error(),
+		Errors: ves,
+	})
+}
+
+// statusFor returns the HTTP status and problem type for an error
+// returned by the engine, errors which aren't classified in retro are
+// internal errors.
+func statusFor(err error) (int, string) {
+	for _, c := range []struct {
+		err    error
+		status int
+		typ    string
+	}{
+		{retro.ErrNotFound, http.StatusNotFound, "not-found"},
+		{retro.ErrErased, http.StatusGone, "erased"},
+		{retro.ErrUnknownCommand, http.StatusBadRequest, "unknown-command"},
+		{retro.ErrBadArgs, http.StatusBadRequest, "bad-args"},
+		{retro.ErrForbidden, http.StatusForbidden, "forbidden"},
+		{retro.ErrConflict, http.StatusConflict, "conflict"},
+		{retro.ErrValidation, http.StatusUnprocessableEntity, "validation"},
+	} {
+		if xerrors.Is(err, c.err) {
+			return c.status, c.typ
+		}
+	}
+	return http.StatusInternalServerError, "internal"
+}
+
+// requestContext returns the context of the request carrying the branch
+// (?branch=) and the point in the past (?at=) to read from, if given.
+//
+// Commands may be applied to a sandbox branch (e.g for QA or "what-if"
+// simulations) rather than the default branch. Reads may be pinned to a
+// checkpoint hash (?at=sha256:...) or a timestamp (?at=2019-02-01T00:00:00Z).
+// Commands can be previewed without writing anything with ?dryRun=true.
+func requestContext(req *http.Request) (context.Context, error) {
+	var ctx = req.Context()
+	if branch := req.URL.Query().Get("branch"); branch != "" {
+		branch, err := ref.BranchRef(branch)
+		if err != nil {
+			return nil, fmt.Errorf("branch is not a valid branch name: %s", err)
+		}
+		ctx = ref.WithBranch(ctx, branch)
+	}
+	if dryRun := req.URL.Query().Get("dryRun"); dryRun != "" {
+		b, err := strconv.ParseBool(dryRun)
+		if err != nil {
+			return nil, fmt.Errorf("dryRun must be a boolean: %s", err)
+		}
+		if b {
+			ctx = engine.WithDryRun(ctx)
+		}
+	}
+	if at := req.URL.Query().Get("at"); at != "" {
+		var pin ref.Pin
+		if prefix := string(packing.HashAlgoNameSHA256) + ":"; strings.HasPrefix(at, prefix) {
+			b, err := hex.DecodeString(strings.TrimPrefix(at, prefix))
+			if err != nil {
+				return nil, fmt.Errorf("at is not a valid checkpoint hash: %s", err)
+			}
+			pin.Checkpoint = packing.NewHash(packing.HashAlgoNameSHA256, b)
+		} else {
+			t, err := time.Parse(time.RFC3339, at)
+			if err != nil {
+				return nil, fmt.Errorf("at must be a checkpoint hash or an RFC3339 timestamp: %s", err)
+			}
+			pin.Time = t
+		}
+		ctx = ref.WithPin(ctx, pin)
+	}
+	return ctx, nil
 }
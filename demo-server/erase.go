@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/namsral/flag"
+
+	"github.com/retro-framework/go-retro/framework/depot"
+	"github.com/retro-framework/go-retro/framework/retro"
+	"github.com/retro-framework/go-retro/framework/storage/fs"
+)
+
+// eraseMain implements the erase subcommand, it deletes the blobs only
+// the events of the given partitions reference (e.g avatars) and then the
+// keys of the partitions, after which their events can no longer be read:
+//
+//	demo-server -storage_path /tmp erase identity/abc123
+//
+// The events stay in the depot and history keeps their hashes, reading
+// them returns retro.ErrErased, their blobs are no longer served. Blobs
+// another partition references too are kept. Projections drop the
+// partitions the next time they see one of their events. The returned
+// exit status is 2 if the partitions could not be erased.
+func eraseMain(storagePath string, keys retro.KeyStore, args []string, w io.Writer) int {
+
+	var fset = flag.NewFlagSet("erase", flag.ContinueOnError)
+	if err := fset.Parse(args); err != nil {
+		return 2
+	}
+	if fset.NArg() == 0 {
+		fmt.Fprintln(w, "erase: no partitions given")
+		return 2
+	}
+
+	var pns []retro.PartitionName
+	for _, pn := range fset.Args() {
+		pns = append(pns, retro.PartitionName(pn))
+	}
+
+	var (
+		odb   = &fs.ObjectStore{BasePath: storagePath}
+		refdb = &fs.RefStore{BasePath: storagePath}
+		d     = depot.NewSimple(odb, refdb, depot.WithPacker(newPacker(keys))).(*depot.Simple)
+	)
+
+	if err := d.Erase(keys, pns...); err != nil {
+		fmt.Fprintf(w, "erase failed: %s\n", err)
+		return 2
+	}
+	for _, pn := range pns {
+		fmt.Fprintf(w, "%s: erased\n", pn)
+	}
+	return 0
+}
@@ -15,6 +15,7 @@ import (
 
 type objectDBServer struct {
 	db object.DB
+	jp packing.Packer
 }
 
 func (srv objectDBServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -24,7 +25,7 @@ func (srv objectDBServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	var (
 		jsonEnc = json.NewEncoder(w)
-		jp      = packing.NewJSONPacker()
+		jp      = srv.jp
 	)
 
 	var vars = mux.Vars(r)
@@ -43,6 +44,9 @@ func (srv objectDBServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case packing.ObjectTypeAffix:
 		af, _ := jp.UnpackAffix(hashedObj.Contents())
 		jsonEnc.Encode(af)
+	case packing.ObjectTypeCommand:
+		cmd, _ := jp.UnpackCommand(hashedObj.Contents())
+		jsonEnc.Encode(cmd)
 	case packing.ObjectTypeEvent:
 		var evPlaceholder map[string]interface{}
 		evName, evEncodedString, _ := jp.UnpackEvent(hashedObj.Contents())
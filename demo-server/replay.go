@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/namsral/flag"
+
+	"github.com/retro-framework/go-retro/aggregates"
+	"github.com/retro-framework/go-retro/commands"
+	"github.com/retro-framework/go-retro/events"
+
+	"github.com/retro-framework/go-retro/framework/ref"
+	"github.com/retro-framework/go-retro/framework/replay"
+	"github.com/retro-framework/go-retro/framework/repository"
+	"github.com/retro-framework/go-retro/framework/resolver"
+	"github.com/retro-framework/go-retro/framework/retro"
+	"github.com/retro-framework/go-retro/framework/storage/fs"
+)
+
+// replayMain implements the replay subcommand, it replays the commands of
+// a branch with the commands compiled into this binary and reports the
+// checkpoints whose events diverge from the stored ones:
+//
+//	demo-server -storage_path /tmp replay -branch master -v
+//
+// The returned exit status is 1 if any checkpoint diverged, 2 if the
+// replay could not be run.
+func replayMain(ctx context.Context, storagePath string, keys retro.KeyStore, args []string, w io.Writer) int {
+
+	var (
+		fset    = flag.NewFlagSet("replay", flag.ContinueOnError)
+		branch  string
+		verbose bool
+	)
+	fset.StringVar(&branch, "branch", ref.DefaultBranch, "branch to replay")
+	fset.BoolVar(&verbose, "v", false, "also list checkpoints which replayed cleanly or were skipped")
+	if err := fset.Parse(args); err != nil {
+		return 2
+	}
+	branch, err := ref.BranchRef(branch)
+	if err != nil {
+		fmt.Fprintf(w, "replay: %s\n", err)
+		return 2
+	}
+
+	var (
+		odb    = &fs.ObjectStore{BasePath: storagePath}
+		refdb  = &fs.RefStore{BasePath: storagePath}
+		packer = newPacker(keys)
+		r      = repository.NewSimpleRepository(odb, refdb, events.DefaultManifest, repository.WithPacker(packer))
+		rFn    = resolver.New(aggregates.DefaultManifest, commands.DefaultManifest)
+	)
+
+	results, err := replay.New(odb, refdb, r, rFn, aggregates.DefaultManifest, events.DefaultManifest, replay.WithPacker(packer)).Run(ref.WithBranch(ctx, branch))
+	if err != nil {
+		fmt.Fprintf(w, "replay failed: %s\n", err)
+		return 2
+	}
+
+	var diverged, skipped int
+	for _, res := range results {
+		var cmd = fmt.Sprintf("%s/%s", res.Command.Path, res.Command.Name)
+		if len(res.Command.Batch) > 0 {
+			cmd = fmt.Sprintf("batch of %d commands", len(res.Command.Batch))
+		}
+		switch {
+		case res.Err != nil:
+			diverged++
+			fmt.Fprintf(w, "FAIL %s %s: %s\n", res.Checkpoint, cmd, res.Err)
+		case len(res.Divergences) > 0:
+			diverged++
+			fmt.Fprintf(w, "DIFF %s %s\n", res.Checkpoint, cmd)
+			for _, d := range res.Divergences {
+				fmt.Fprintf(w, "     %s\n       stored:   %v\n       replayed: %v\n", d.Partition, d.Stored, d.Replayed)
+			}
+		case res.Skipped != "":
+			skipped++
+			if verbose {
+				fmt.Fprintf(w, "SKIP %s: %s\n", res.Checkpoint, res.Skipped)
+			}
+		default:
+			if verbose {
+				fmt.Fprintf(w, "OK   %s %s\n", res.Checkpoint, cmd)
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "replayed %d checkpoints of %s, %d diverged, %d skipped\n", len(results), branch, diverged, skipped)
+	if diverged > 0 {
+		return 1
+	}
+	return 0
+}
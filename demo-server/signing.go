@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/namsral/flag"
+
+	"github.com/retro-framework/go-retro/framework/packing"
+	"github.com/retro-framework/go-retro/framework/ref"
+	"github.com/retro-framework/go-retro/framework/storage"
+	"github.com/retro-framework/go-retro/framework/storage/fs"
+)
+
+// loadKeys reads the key checkpoints are signed with and the keys trusted
+// to have signed them, both are optional. Keys are PEM files as written by
+// openssl:
+//
+//	openssl genpkey -algorithm ed25519 -out signing.pem
+//	openssl pkey -in signing.pem -pubout -out signing.pub.pem
+//
+// trustedKeyPaths is a comma separated list of public keys, the signing
+// key is trusted too.
+func loadKeys(signingKeyPath, trustedKeyPaths string) (ed25519.PrivateKey, []ed25519.PublicKey, error) {
+
+	var (
+		signingKey ed25519.PrivateKey
+		trusted    []ed25519.PublicKey
+	)
+
+	if signingKeyPath != "" {
+		der, err := readPEM(signingKeyPath, "PRIVATE KEY")
+		if err != nil {
+			return nil, nil, err
+		}
+		key, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %s", signingKeyPath, err)
+		}
+		var ok bool
+		if signingKey, ok = key.(ed25519.PrivateKey); !ok {
+			return nil, nil, fmt.Errorf("%s: not an ed25519 key", signingKeyPath)
+		}
+		trusted = append(trusted, signingKey.Public().(ed25519.PublicKey))
+	}
+
+	for _, path := range strings.Split(trustedKeyPaths, ",") {
+		if path == "" {
+			continue
+		}
+		der, err := readPEM(path, "PUBLIC KEY")
+		if err != nil {
+			return nil, nil, err
+		}
+		key, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %s", path, err)
+		}
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s: not an ed25519 key", path)
+		}
+		trusted = append(trusted, pub)
+	}
+
+	return signingKey, trusted, nil
+}
+
+func readPEM(path, blockType string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s: no %s PEM block", path, blockType)
+	}
+	return block.Bytes, nil
+}
+
+// verifyMain implements the verify subcommand, it verifies the signatures
+// of the checkpoints of a branch with the trusted keys and reports those
+// which are unsigned or badly signed:
+//
+//	demo-server -storage_path /tmp -trusted_keys signing.pub.pem verify -branch master
+//
+// The returned exit status is 1 if any checkpoint did not verify, 2 if the
+// branch could not be verified.
+func verifyMain(storagePath string, trusted []ed25519.PublicKey, args []string, w io.Writer) int {
+
+	var (
+		fset   = flag.NewFlagSet("verify", flag.ContinueOnError)
+		branch string
+	)
+	fset.StringVar(&branch, "branch", ref.DefaultBranch, "branch to verify")
+	if err := fset.Parse(args); err != nil {
+		return 2
+	}
+	if len(trusted) == 0 {
+		fmt.Fprintln(w, "verify: no trusted keys, set -signing_key or -trusted_keys")
+		return 2
+	}
+	branch, err := ref.BranchRef(branch)
+	if err != nil {
+		fmt.Fprintf(w, "verify: %s\n", err)
+		return 2
+	}
+
+	var (
+		odb   = &fs.ObjectStore{BasePath: storagePath}
+		refdb = &fs.RefStore{BasePath: storagePath}
+	)
+
+	head, err := refdb.Retrieve(branch)
+	if err != nil {
+		fmt.Fprintf(w, "verify failed: %s\n", err)
+		return 2
+	}
+
+	unverified, err := storage.NewWalker(odb).Verify(head, nil, packing.NewVerifier(trusted...))
+	if err != nil {
+		fmt.Fprintf(w, "verify failed: %s\n", err)
+		return 2
+	}
+	for _, u := range unverified {
+		fmt.Fprintf(w, "FAIL %s: %s\n", u.Checkpoint, u.Err)
+	}
+
+	fmt.Fprintf(w, "verified %s, %d checkpoints did not verify\n", branch, len(unverified))
+	if len(unverified) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -10,31 +10,60 @@ type AssociateIdentity struct {
 	Identity retro.URNAble `json:"identity"`
 }
 
-func (ai *AssociateIdentity) UnmarshalJSON(b []byte) error {
+// identityRef is how AssociateIdentity stores the identity since version 2,
+// version 1 stored the whole identity aggregate, naming it by "name".
+type identityRef struct {
+	URN retro.URN `json:"urn"`
+}
 
-	var tmp = struct {
-		Identity struct {
-			Name string `json:"name"`
-			URN  string `json:"urn"`
-		} `json:"identity"`
-	}{}
+func (ai AssociateIdentity) MarshalJSON() ([]byte, error) {
+	var tmp struct {
+		Identity *identityRef `json:"identity"`
+	}
+	if ai.Identity != nil {
+		tmp.Identity = &identityRef{ai.Identity.URN()}
+	}
+	return json.Marshal(tmp)
+}
 
-	err := json.Unmarshal(b, &tmp)
-	if err != nil {
+func (ai *AssociateIdentity) UnmarshalJSON(b []byte) error {
+	var tmp struct {
+		Identity *identityRef `json:"identity"`
+	}
+	if err := json.Unmarshal(b, &tmp); err != nil {
 		return err
 	}
-
-	if tmp.Identity.Name != "" {
-		ai.Identity = WeakURNReference{retro.URN(tmp.Identity.Name)}
+	if tmp.Identity != nil && tmp.Identity.URN != "" {
+		ai.Identity = WeakURNReference{tmp.Identity.URN}
 	}
+	return nil
+}
 
-	if tmp.Identity.URN != "" {
-		ai.Identity = WeakURNReference{retro.URN(tmp.Identity.URN)}
+// upcastAssociateIdentityV1 keeps only the URN of the identity, stored as
+// "name" by version 1 (or "urn", by doubles in tests).
+func upcastAssociateIdentityV1(b []byte) ([]byte, error) {
+	var v1 struct {
+		Identity *struct {
+			Name retro.URN `json:"name"`
+			URN  retro.URN `json:"urn"`
+		} `json:"identity"`
 	}
-
-	return nil
+	if err := json.Unmarshal(b, &v1); err != nil {
+		return nil, err
+	}
+	var v2 struct {
+		Identity *identityRef `json:"identity"`
+	}
+	if v1.Identity != nil {
+		v2.Identity = &identityRef{v1.Identity.Name}
+		if v1.Identity.URN != "" {
+			v2.Identity.URN = v1.Identity.URN
+		}
+	}
+	return json.Marshal(v2)
 }
 
 func init() {
-	Register(&AssociateIdentity{})
+	RegisterVersion(&AssociateIdentity{}, 2)
+	RegisterUpcaster("associate_identity", 1, upcastAssociateIdentityV1)
 }
@@ -43,3 +43,16 @@ func Test_AssociateIdentity(t *testing.T) {
 	})
 
 }
+
+func Test_AssociateIdentity_Upcast(t *testing.T) {
+
+	var v1 = `{"identity":{"hasAvatar":false,"isPublic":false,"name":"identity/83a3097a45e74423dce29cdb"}}`
+
+	b, err := DefaultManifest.(retro.VersionedEventManifest).Upcast("associate_identity", 1, []byte(v1))
+	test.H(t).IsNil(err)
+	test.H(t).StringEql(string(b), `{"identity":{"urn":"identity/83a3097a45e74423dce29cdb"}}`)
+
+	var aiEv = AssociateIdentity{}
+	test.H(t).IsNil(json.Unmarshal(b, &aiEv))
+	test.H(t).StringEql(string(aiEv.Identity.URN()), "identity/83a3097a45e74423dce29cdb")
+}
@@ -1,7 +1,12 @@
 package events
 
+import "github.com/retro-framework/go-retro/framework/retro"
+
+// CreateListingImage references the image in a blob, Data holds the image
+// of listings created before images were stored as blobs.
 type CreateListingImage struct {
-	Data []byte `json:"data"`
+	Img  *retro.BlobRef `json:"img,omitempty"`
+	Data []byte         `json:"data,omitempty"`
 }
 
 func init() {
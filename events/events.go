@@ -18,16 +18,37 @@ var (
 
 type manifest struct {
 	m map[string]reflect.Type
+
+	// versions holds the latest version of events registered with a
+	// version, upcasters the upcaster from each older version.
+	versions  map[string]int
+	upcasters map[string]map[int]retro.Upcaster
 }
 
 func NewManifest() retro.EventManifest {
-	return &manifest{make(map[string]reflect.Type)}
+	return &manifest{
+		m:         make(map[string]reflect.Type),
+		versions:  make(map[string]int),
+		upcasters: make(map[string]map[int]retro.Upcaster),
+	}
 }
 
 func Register(ev retro.Event) error {
 	return DefaultManifest.Register(ev)
 }
 
+// RegisterVersion registers ev with the DefaultManifest as version of its
+// schema, see (*manifest).RegisterVersion.
+func RegisterVersion(ev retro.Event, version int) error {
+	return DefaultManifest.(*manifest).RegisterVersion(ev, version)
+}
+
+// RegisterUpcaster registers up with the DefaultManifest, see
+// (*manifest).RegisterUpcaster.
+func RegisterUpcaster(evName string, from int, up retro.Upcaster) error {
+	return DefaultManifest.(*manifest).RegisterUpcaster(evName, from, up)
+}
+
 func (m *manifest) RegisterAs(evName string, ev retro.Event) error {
 	var v = m.toType(ev)
 	if _, exists := m.m[evName]; exists {
@@ -45,6 +66,65 @@ func (m *manifest) Register(ev retro.Event) error {
 	return m.RegisterAs(flect.Underscore(evName), ev)
 }
 
+// RegisterVersion registers ev as Register does, as the given version of
+// its schema. Upcasters from every older version must be registered with
+// RegisterUpcaster for events packed in those versions to be read.
+func (m *manifest) RegisterVersion(ev retro.Event, version int) error {
+	if version < 1 {
+		return fmt.Errorf("can't register event %s as version %d, versions start at 1", m.toType(ev), version)
+	}
+	if err := m.Register(ev); err != nil {
+		return err
+	}
+	m.versions[flect.Underscore(m.toType(ev).Name())] = version
+	return nil
+}
+
+// RegisterUpcaster registers up to rewrite payloads of the event
+// registered with evName from version from to version from+1.
+func (m *manifest) RegisterUpcaster(evName string, from int, up retro.Upcaster) error {
+	if from < 1 {
+		return fmt.Errorf("can't register upcaster for event %s from version %d, versions start at 1", evName, from)
+	}
+	if _, exists := m.upcasters[evName][from]; exists {
+		return fmt.Errorf("can't register upcaster for event %s from version %d, already registered", evName, from)
+	}
+	if m.upcasters[evName] == nil {
+		m.upcasters[evName] = make(map[int]retro.Upcaster)
+	}
+	m.upcasters[evName][from] = up
+	return nil
+}
+
+func (m *manifest) VersionFor(evName string) int {
+	if v, ok := m.versions[evName]; ok {
+		return v
+	}
+	return 1
+}
+
+// Upcast chains the upcasters registered for evName from version to the
+// latest version, it returns an error if one is missing or the payload is
+// from a version newer than the latest.
+func (m *manifest) Upcast(evName string, version int, payload []byte) ([]byte, error) {
+	var latest = m.VersionFor(evName)
+	if version > latest {
+		return nil, fmt.Errorf("can't upcast event %s from version %d, latest known version is %d", evName, version, latest)
+	}
+	for v := version; v < latest; v++ {
+		up, ok := m.upcasters[evName][v]
+		if !ok {
+			return nil, fmt.Errorf("can't upcast event %s from version %d, no upcaster registered", evName, v)
+		}
+		var err error
+		payload, err = up(payload)
+		if err != nil {
+			return nil, errors.Wrapf(err, "upcasting event %s from version %d", evName, v)
+		}
+	}
+	return payload, nil
+}
+
 func (m *manifest) KeyFor(ev retro.Event) (string, error) {
 	for name, tepy := range m.m {
 		if tepy == m.toType(ev) {
@@ -1,6 +1,7 @@
 package events
 
 import (
+	"strings"
 	"testing"
 
 	test "github.com/retro-framework/go-retro/framework/test_helper"
@@ -29,3 +30,46 @@ func Test_Events_RegisterEv_TakesEitherPointerOrConcrete(t *testing.T) {
 	// err = Register(&dummyEv{})
 	// assertErrEql(err, fmt.Errorf("Can't register command commands.dummyCmd for aggregate commands.dummyAggregate, command already registered"))
 }
+
+type versionedEv struct {
+	Name string `json:"name"`
+}
+
+func Test_Events_Upcast(t *testing.T) {
+
+	var m = NewManifest().(*manifest)
+	test.H(t).IsNil(m.RegisterVersion(&versionedEv{}, 3))
+	test.H(t).IntEql(m.VersionFor("versioned_ev"), 3)
+	test.H(t).IntEql(m.VersionFor("unknown"), 1)
+
+	test.H(t).IsNil(m.RegisterUpcaster("versioned_ev", 2, func(b []byte) ([]byte, error) {
+		return []byte(strings.Replace(string(b), "title", "name", 1)), nil
+	}))
+
+	t.Run("errors if an upcaster is missing from the chain", func(t *testing.T) {
+		_, err := m.Upcast("versioned_ev", 1, []byte(`{"label":"x"}`))
+		test.H(t).NotNil(err)
+	})
+
+	test.H(t).IsNil(m.RegisterUpcaster("versioned_ev", 1, func(b []byte) ([]byte, error) {
+		return []byte(strings.Replace(string(b), "label", "title", 1)), nil
+	}))
+	test.H(t).NotNil(m.RegisterUpcaster("versioned_ev", 1, func(b []byte) ([]byte, error) { return b, nil }))
+
+	t.Run("chains upcasters from older versions", func(t *testing.T) {
+		b, err := m.Upcast("versioned_ev", 1, []byte(`{"label":"x"}`))
+		test.H(t).IsNil(err)
+		test.H(t).StringEql(string(b), `{"name":"x"}`)
+	})
+
+	t.Run("leaves the latest version as it is", func(t *testing.T) {
+		b, err := m.Upcast("versioned_ev", 3, []byte(`{"name":"x"}`))
+		test.H(t).IsNil(err)
+		test.H(t).StringEql(string(b), `{"name":"x"}`)
+	})
+
+	t.Run("errors for versions newer than the latest", func(t *testing.T) {
+		_, err := m.Upcast("versioned_ev", 4, []byte(`{"name":"x"}`))
+		test.H(t).NotNil(err)
+	})
+}
@@ -1,8 +1,13 @@
 package events
 
+import "github.com/retro-framework/go-retro/framework/retro"
+
+// SetAvatar references the image in a blob, ImgData holds the image of
+// avatars set before images were stored as blobs.
 type SetAvatar struct {
-	ContentType string `json:"contentType"`
-	ImgData     []byte `json:"imgData"`
+	ContentType string         `json:"contentType"`
+	Img         *retro.BlobRef `json:"img,omitempty"`
+	ImgData     []byte         `json:"imgData,omitempty"`
 }
 
 func init() {
@@ -5,6 +5,7 @@ package depot
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
 	"io/ioutil"
 	"log"
 	"os"
@@ -13,12 +14,15 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/retro-framework/go-retro/events" // TODO: Fix this don't reach out of framework!
+	"github.com/retro-framework/go-retro/framework/index"
 	"github.com/retro-framework/go-retro/framework/object"
 	"github.com/retro-framework/go-retro/framework/packing"
 	"github.com/retro-framework/go-retro/framework/ref"
 	"github.com/retro-framework/go-retro/framework/retro"
+	"github.com/retro-framework/go-retro/framework/storage"
 	"github.com/retro-framework/go-retro/framework/storage/fs"
 	"github.com/retro-framework/go-retro/framework/storage/memory"
+	"golang.org/x/xerrors"
 )
 
 type DummyEvSetAuthorName struct {
@@ -93,6 +97,10 @@ func Test_Depot(t *testing.T) {
 		affixFourB, _ = jp.PackAffix(packing.Affix{
 			"article/first": []retro.Hash{associateArticleAuthor2.Hash()},
 		})
+
+		affixFourC, _ = jp.PackAffix(packing.Affix{
+			"article/second": []retro.Hash{setArticleTitle2.Hash()},
+		})
 	)
 
 	var clock = Predictable5sJumpClock{}
@@ -148,6 +156,16 @@ func Test_Depot(t *testing.T) {
 			},
 			ParentHashes: []retro.Hash{checkpointThree.Hash()},
 		})
+
+		checkpointFourC, _ = jp.PackCheckpoint(packing.Checkpoint{
+			AffixHash:   affixFourC.Hash(),
+			CommandDesc: []byte(`{"update":"article"}`),
+			Fields: map[string]string{
+				"session": "hello world",
+				"date":    clock.Now().Format(time.RFC3339),
+			},
+			ParentHashes: []retro.Hash{checkpointThree.Hash()},
+		})
 	)
 
 	baseTmpdir, err := ioutil.TempDir("", "depot_common_test")
@@ -218,6 +236,14 @@ func Test_Depot(t *testing.T) {
 				refdb: refdbs["memory"](),
 			}
 		},
+		"memory+index": func() retro.Depot {
+			var odb = populateOdb(odbs["memory"]())
+			return &Simple{
+				objdb: odb,
+				refdb: refdbs["memory"](),
+				index: index.New(odb),
+			}
+		},
 		// "fs":        &Simple{objdb: odbs["fs"], refdb: refdbs["fs"], eventManifest: evManifest},
 		// "fs+memory": &Simple{objdb: odbs["memory"], refdb: refdbs["fs"], eventManifest: evManifest},
 		// "memory+fs": &Simple{objdb: odbs["fs"], refdb: refdbs["memory"], eventManifest: evManifest},
@@ -236,7 +262,7 @@ func Test_Depot(t *testing.T) {
 			t.Run("iterates over correct events in correct order", func(t *testing.T) {
 
 				var depot = depotFn()
-				depot.MoveHeadPointer(nil, checkpointThree.Hash())
+				depot.MoveHeadPointer(context.Background(), nil, checkpointThree.Hash())
 
 				var (
 					expectedResult = map[retro.PartitionName][]string{
@@ -343,7 +369,7 @@ func Test_Depot(t *testing.T) {
 			t.Run("propagates new partitions after a consumer has consumed all that existed at start time", func(t *testing.T) {
 
 				var depot = depotFn()
-				depot.MoveHeadPointer(nil, checkpointThree.Hash())
+				depot.MoveHeadPointer(context.Background(), nil, checkpointThree.Hash())
 
 				var ctx, cancelFn = context.WithTimeout(context.Background(), 1*time.Second)
 				defer cancelFn()
@@ -367,7 +393,7 @@ func Test_Depot(t *testing.T) {
 					depot.StorePacked(associateArticleAuthor2)
 					depot.StorePacked(affixFourA)
 					depot.StorePacked(checkpointFourA)
-					depot.MoveHeadPointer(checkpointThree.Hash(), checkpointFourA.Hash())
+					depot.MoveHeadPointer(context.Background(), checkpointThree.Hash(), checkpointFourA.Hash())
 				}()
 
 				for {
@@ -393,7 +419,7 @@ func Test_Depot(t *testing.T) {
 
 			t.Run("propagates new events after a consumer has reached the head pointer", func(t *testing.T) {
 				var depot = depotFn()
-				depot.MoveHeadPointer(nil, checkpointThree.Hash())
+				depot.MoveHeadPointer(context.Background(), nil, checkpointThree.Hash())
 
 				var ctx, cancelFn = context.WithTimeout(context.Background(), 1*time.Second)
 				defer cancelFn()
@@ -406,7 +432,7 @@ func Test_Depot(t *testing.T) {
 					depot.StorePacked(associateArticleAuthor2)
 					depot.StorePacked(affixFourB)
 					depot.StorePacked(checkpointFourB)
-					depot.MoveHeadPointer(checkpointThree.Hash(), checkpointFourB.Hash())
+					depot.MoveHeadPointer(context.Background(), checkpointThree.Hash(), checkpointFourB.Hash())
 				}
 
 				var handleEvents = func(ctx context.Context, evi retro.EventIterator) {
@@ -467,12 +493,260 @@ func Test_Depot(t *testing.T) {
 
 			})
 
+			t.Run("only moves the head pointer in a fast forward fashion", func(t *testing.T) {
+
+				var depot = depotFn()
+				depot.StorePacked(setAuthorName2, associateArticleAuthor2, affixFourA, affixFourB, checkpointFourA, checkpointFourB)
+
+				var refMoves = make(chan retro.RefMove, 3)
+				depot.(*Simple).subscribers = append(depot.(*Simple).subscribers, refMoves)
+
+				if err := depot.MoveHeadPointer(context.Background(), nil, checkpointThree.Hash()); err != nil {
+					t.Fatal(err)
+				}
+				if rm := <-refMoves; rm.FF {
+					t.Errorf("expected creating the branch not to be considered a fast forward")
+				}
+
+				if err := depot.MoveHeadPointer(context.Background(), checkpointThree.Hash(), checkpointFourA.Hash()); err != nil {
+					t.Fatal(err)
+				}
+				if rm := <-refMoves; !rm.FF || rm.Old.String() != checkpointThree.Hash().String() {
+					t.Errorf("expected ref move from checkpoint three to four (a) to be a fast forward, got %#v", rm)
+				}
+
+				if err := depot.MoveHeadPointer(context.Background(), checkpointFourA.Hash(), checkpointFourB.Hash()); err != ErrNotFastForward {
+					t.Errorf("expected moving between siblings to fail with %q got %q", ErrNotFastForward, err)
+				}
+
+				if err := depot.MoveHeadPointer(context.Background(), nil, checkpointFourB.Hash()); err != storage.ErrRefChanged {
+					t.Errorf("expected moving from stale head to fail with %q got %q", storage.ErrRefChanged, err)
+				}
+
+				if err := depot.MoveHeadPointer(context.Background(), checkpointThree.Hash(), checkpointFourB.Hash()); err != storage.ErrRefChanged {
+					t.Errorf("expected moving from stale head to fail with %q got %q", storage.ErrRefChanged, err)
+				}
+
+				head, _ := depot.HeadPointer(context.Background())
+				if head.String() != checkpointFourA.Hash().String() {
+					t.Errorf("expected head pointer to be left on checkpoint four (a), got %s", head)
+				}
+			})
+
+			t.Run("reports checkpoints it can't index", func(t *testing.T) {
+
+				var depot = depotFn().(*Simple)
+				if depot.index == nil {
+					t.Skip("depot has no index")
+				}
+
+				var jp = packing.NewJSONPacker()
+				packedAffix, _ := jp.PackAffix(packing.Affix{})
+				orphan, _ := jp.PackCheckpoint(packing.Checkpoint{
+					AffixHash:    packedAffix.Hash(),
+					ParentHashes: []retro.Hash{packing.NewPackedObject("not stored").Hash()},
+					Fields:       map[string]string{"date": "2019-02-01T00:00:00Z"},
+				})
+
+				if err := depot.StorePacked(packedAffix, orphan); err == nil {
+					t.Errorf("expected storing a checkpoint whose parent is missing to fail")
+				}
+			})
+
+			t.Run("refuses to move the head pointer to unverified checkpoints", func(t *testing.T) {
+
+				var (
+					depot       = depotFn()
+					ctx         = context.Background()
+					pub, key, _ = ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte{1}, 32)))
+				)
+
+				signedFourA, err := packing.NewSigningPacker(jp, key).PackCheckpoint(packing.Checkpoint{
+					AffixHash:    affixFourA.Hash(),
+					CommandDesc:  []byte(`{"update":"article"}`),
+					Fields:       map[string]string{"session": "hello world", "date": clock.Now().Format(time.RFC3339)},
+					ParentHashes: []retro.Hash{checkpointThree.Hash()},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				depot.StorePacked(setAuthorName2, associateArticleAuthor2, affixFourA, checkpointFourA, signedFourA)
+
+				// History from before verification was enabled is trusted.
+				if err := depot.MoveHeadPointer(ctx, nil, checkpointThree.Hash()); err != nil {
+					t.Fatal(err)
+				}
+				depot.(*Simple).verifier = packing.NewVerifier(pub)
+
+				err = depot.MoveHeadPointer(ctx, checkpointThree.Hash(), checkpointFourA.Hash())
+				if !xerrors.Is(err, storage.ErrUnverified) || !xerrors.Is(err, packing.ErrUnsigned) {
+					t.Errorf("expected moving to an unsigned checkpoint to fail with %q got %q", packing.ErrUnsigned, err)
+				}
+
+				if err := depot.MoveHeadPointer(ctx, checkpointThree.Hash(), signedFourA.Hash()); err != nil {
+					t.Errorf("expected moving to a signed checkpoint to succeed, got %q", err)
+				}
+
+				head, _ := depot.HeadPointer(ctx)
+				if head.String() != signedFourA.Hash().String() {
+					t.Errorf("expected head pointer to be on the signed checkpoint four (a), got %s", head)
+				}
+			})
+
+			t.Run("creates branches which move independently", func(t *testing.T) {
+
+				var (
+					depot = depotFn()
+					ctx   = context.Background()
+					qaCtx = ref.WithBranch(ctx, "qa-1")
+				)
+				depot.StorePacked(setAuthorName2, associateArticleAuthor2, affixFourA, checkpointFourA)
+				depot.MoveHeadPointer(ctx, nil, checkpointThree.Hash())
+
+				var bd = depot.(retro.BranchableDepot)
+				if err := bd.CreateBranch(ctx, "qa-1", checkpointTwo.Hash()); err != nil {
+					t.Fatal(err)
+				}
+				if err := bd.CreateBranch(ctx, "refs/heads/qa-1", checkpointThree.Hash()); err != storage.ErrRefChanged {
+					t.Errorf("expected creating an existing branch to fail with %q got %q", storage.ErrRefChanged, err)
+				}
+				if err := bd.CreateBranch(ctx, "qa-2", affixOne.Hash()); err != ErrNotACheckpoint {
+					t.Errorf("expected branching from an affix to fail with %q got %q", ErrNotACheckpoint, err)
+				}
+				for _, name := range []string{"../../etc/x", "/qa-3", "qa//3", "qa-3.lock", "qa\n3"} {
+					if err := bd.CreateBranch(ctx, name, checkpointTwo.Hash()); !xerrors.Is(err, storage.ErrInvalidRefName) {
+						t.Errorf("expected creating branch %q to fail with %q got %q", name, storage.ErrInvalidRefName, err)
+					}
+					if _, err := depot.(retro.MergeableDepot).Merge(ctx, "master", name); !xerrors.Is(err, storage.ErrInvalidRefName) {
+						t.Errorf("expected merging branch %q to fail with %q got %q", name, storage.ErrInvalidRefName, err)
+					}
+				}
+
+				if err := depot.MoveHeadPointer(qaCtx, checkpointTwo.Hash(), checkpointFourA.Hash()); err != nil {
+					t.Fatal(err)
+				}
+
+				masterHead, _ := depot.HeadPointer(ctx)
+				if masterHead.String() != checkpointThree.Hash().String() {
+					t.Errorf("expected master to be left on checkpoint three, got %s", masterHead)
+				}
+				qaHead, _ := depot.HeadPointer(qaCtx)
+				if qaHead.String() != checkpointFourA.Hash().String() {
+					t.Errorf("expected qa-1 to be on checkpoint four (a), got %s", qaHead)
+				}
+
+				var (
+					watchCtx, cancelFn = context.WithTimeout(qaCtx, 1*time.Second)
+					authors            = depot.Watch(watchCtx, "author/*")
+					seen               = map[string]bool{}
+				)
+				defer cancelFn()
+				for len(seen) < 2 {
+					evIter, err := authors.Next(watchCtx)
+					if err != nil {
+						t.Fatalf("expected to see author/maxine and author/paul on qa-1, saw %v (%s)", seen, err)
+					}
+					seen[evIter.Pattern()] = true
+				}
+			})
+
+			t.Run("merges branches which touched disjoint partitions", func(t *testing.T) {
+
+				var (
+					depot = depotFn()
+					ctx   = context.Background()
+				)
+				depot.StorePacked(
+					setAuthorName2, associateArticleAuthor2,
+					affixFourA, affixFourB, affixFourC,
+					checkpointFourA, checkpointFourB, checkpointFourC,
+				)
+				depot.MoveHeadPointer(ctx, nil, checkpointThree.Hash())
+
+				var (
+					bd = depot.(retro.BranchableDepot)
+					md = depot.(retro.MergeableDepot)
+				)
+				bd.CreateBranch(ctx, "feature", checkpointThree.Hash())
+				bd.CreateBranch(ctx, "conflicting", checkpointThree.Hash())
+				depot.MoveHeadPointer(ctx, checkpointThree.Hash(), checkpointFourA.Hash())
+				depot.MoveHeadPointer(ref.WithBranch(ctx, "feature"), checkpointThree.Hash(), checkpointFourB.Hash())
+				depot.MoveHeadPointer(ref.WithBranch(ctx, "conflicting"), checkpointThree.Hash(), checkpointFourC.Hash())
+
+				mergeHash, err := md.Merge(ctx, DefaultBranchName, "feature")
+				if err != nil {
+					t.Fatal(err)
+				}
+				head, _ := depot.HeadPointer(ctx)
+				if head.String() != mergeHash.String() {
+					t.Errorf("expected head to be the merge checkpoint %s, got %s", mergeHash, head)
+				}
+
+				t.Run("does nothing when the branch was already merged", func(t *testing.T) {
+					again, err := md.Merge(ctx, DefaultBranchName, "feature")
+					if err != nil {
+						t.Fatal(err)
+					}
+					if again.String() != mergeHash.String() {
+						t.Errorf("expected merging twice to be a no-op")
+					}
+				})
+
+				t.Run("returns a typed error listing the conflicting partitions", func(t *testing.T) {
+					_, err := md.Merge(ctx, DefaultBranchName, "conflicting")
+					if !xerrors.Is(err, ErrMergeConflict) {
+						t.Fatalf("expected a merge conflict error, got %q", err)
+					}
+					var mcErr MergeConflictError
+					if !xerrors.As(err, &mcErr) {
+						t.Fatalf("expected error to be a MergeConflictError, got %T", err)
+					}
+					if diff := cmp.Diff(mcErr.Partitions, []retro.PartitionName{"article/second"}); diff != "" {
+						t.Errorf("conflicting partitions differ: (-got +want)\n%s", diff)
+					}
+					head, _ := depot.HeadPointer(ctx)
+					if head.String() != mergeHash.String() {
+						t.Errorf("expected head to be left alone after a conflict")
+					}
+				})
+
+				t.Run("replays merged history in order", func(t *testing.T) {
+					var (
+						watchCtx, cancelFn = context.WithTimeout(ctx, 1*time.Second)
+						articles           = depot.Watch(watchCtx, "article/first")
+						want               = []string{
+							"set_article_title",
+							"associate_article_author",
+							"set_article_title",
+							"set_article_body",
+							"associate_article_author",
+						}
+						got []string
+					)
+					defer cancelFn()
+					evIter, err := articles.Next(watchCtx)
+					if err != nil {
+						t.Fatal(err)
+					}
+					for len(got) < len(want) {
+						ev, err := evIter.Next(watchCtx)
+						if err != nil {
+							t.Fatalf("expected %v, got %v (%s)", want, got, err)
+						}
+						got = append(got, ev.Name())
+					}
+					if diff := cmp.Diff(got, want); diff != "" {
+						t.Errorf("replayed events differ: (-got +want)\n%s", diff)
+					}
+				})
+			})
+
 			t.Run("has a simple next API that does not rely on channels", func(t *testing.T) {
 
 				var success = make(chan bool)
 
 				var depot = depotFn()
-				depot.MoveHeadPointer(nil, checkpointThree.Hash())
+				depot.MoveHeadPointer(context.Background(), nil, checkpointThree.Hash())
 
 				var ctx, cancelFn = context.WithTimeout(context.Background(), 1*time.Second)
 				defer cancelFn()
@@ -519,3 +793,102 @@ func Test_Depot(t *testing.T) {
 		})
 	}
 }
+
+type DummyEvSetAvatar struct {
+	Img *retro.BlobRef `json:"img"`
+}
+
+func Test_Erase(t *testing.T) {
+
+	var (
+		objdb  = &memory.ObjectStore{}
+		refdb  = &memory.RefStore{}
+		keys   = &memory.KeyStore{}
+		packer = packing.NewEncryptingPacker(packing.NewJSONPacker(), keys, "identity/*")
+		depot  = NewSimple(objdb, refdb, WithPacker(packer)).(*Simple)
+	)
+
+	var blob = func(s string) retro.BlobRef {
+		blobRef, err := objdb.WriteBlob(bytes.NewReader([]byte(s)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return blobRef
+	}
+
+	var (
+		avatar = blob("only identity/1 references this")
+		shared = blob("identity/1 and listing/1 reference this")
+		other  = blob("identity/2 references this")
+	)
+
+	// commit writes a root checkpoint setting the given blobs and points
+	// branch at it, blobs on any branch must be kept.
+	var commit = func(branch string, blobs map[retro.PartitionName][]retro.BlobRef) {
+		var affix = packing.Affix{}
+		for pn, blobRefs := range blobs {
+			for i := range blobRefs {
+				packedEv, err := packing.PackPartitionEvent(packer, pn, "set_avatar", 1, DummyEvSetAvatar{&blobRefs[i]})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := depot.StorePacked(packedEv); err != nil {
+					t.Fatal(err)
+				}
+				affix[pn] = append(affix[pn], packedEv.Hash())
+			}
+		}
+		packedAffix, err := packer.PackAffix(affix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packedCheckpoint, err := packer.PackCheckpoint(packing.Checkpoint{
+			AffixHash: packedAffix.Hash(),
+			Fields:    map[string]string{"date": "2019-02-01T00:00:00Z"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := depot.StorePacked(packedAffix, packedCheckpoint); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := refdb.Write(branch, packedCheckpoint.Hash()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commit(ref.DefaultBranch, map[retro.PartitionName][]retro.BlobRef{"identity/1": {avatar, shared}})
+	commit("refs/heads/other", map[retro.PartitionName][]retro.BlobRef{"listing/1": {shared}, "identity/2": {other}})
+
+	var readable = func(blobRef retro.BlobRef) bool {
+		rc, _, err := objdb.OpenBlob(blobRef.Hash)
+		if err != nil {
+			return false
+		}
+		rc.Close()
+		return true
+	}
+
+	t.Run("makes blobs only the erased partitions reference unreadable", func(t *testing.T) {
+		if err := depot.Erase(keys, "identity/1"); err != nil {
+			t.Fatal(err)
+		}
+		if readable(avatar) {
+			t.Errorf("expected the blob of identity/1 to be deleted")
+		}
+		if !readable(shared) {
+			t.Errorf("expected the blob shared with listing/1 to be kept")
+		}
+		if !readable(other) {
+			t.Errorf("expected the blob of identity/2 to be kept")
+		}
+		if _, err := keys.Key("identity/1", false); !xerrors.Is(err, retro.ErrErased) {
+			t.Errorf("expected the key of identity/1 to be erased, got %v", err)
+		}
+	})
+
+	t.Run("erases partitions again", func(t *testing.T) {
+		if err := depot.Erase(keys, "identity/1"); err != nil {
+			t.Errorf("expected erasing twice to succeed, got %v", err)
+		}
+	})
+}
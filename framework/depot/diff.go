@@ -0,0 +1,27 @@
+package depot
+
+import (
+	"context"
+	"sort"
+
+	"github.com/retro-framework/go-retro/framework/retro"
+)
+
+// PartitionsTouched returns the (sorted) partitions written to by the
+// checkpoints which are reachable from head but not from since. The Engine
+// uses this to decide whether a command can be re-parented onto a head
+// which moved whilst the command was being applied.
+func (s *Simple) PartitionsTouched(ctx context.Context, since, head retro.Hash) ([]retro.PartitionName, error) {
+
+	touched, err := s.partitionsTouched(head, since)
+	if err != nil {
+		return nil, err
+	}
+
+	var res = make([]retro.PartitionName, 0, len(touched))
+	for pn := range touched {
+		res = append(res, pn)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res, nil
+}
@@ -0,0 +1,125 @@
+package depot
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/xerrors"
+
+	"github.com/retro-framework/go-retro/framework/object"
+	"github.com/retro-framework/go-retro/framework/packing"
+	"github.com/retro-framework/go-retro/framework/ref"
+	"github.com/retro-framework/go-retro/framework/retro"
+	"github.com/retro-framework/go-retro/framework/storage"
+)
+
+// Erase erases the partitions. The blobs their events reference are
+// deleted, unless an event of another partition references them too, then
+// their keys are deleted from keys (see retro.KeyStore) after which their
+// events can no longer be read.
+//
+// Referenced blobs are found by reading every event reachable from any
+// branch (see packing.BlobRefs), so the depot's packer must be able to
+// unpack the events of the partitions, e.g an EncryptingPacker with keys.
+// Blobs only referenced from checkpoints no branch reaches are left.
+//
+// The object store must be an object.BlobDeleter and the ref store a
+// ref.ListableStore, otherwise ErrCantErase is returned. Blobs are deleted
+// before keys so that erasing again after an error finds them.
+func (s *Simple) Erase(keys retro.KeyStore, pns ...retro.PartitionName) error {
+
+	var (
+		lrefdb, listable = s.refdb.(ref.ListableStore)
+		bdb, deletable   = s.objdb.(object.BlobDeleter)
+	)
+	if !listable || !deletable {
+		return ErrCantErase
+	}
+
+	refs, err := lrefdb.Ls()
+	if err != nil {
+		return errors.Wrap(err, "can't list refs")
+	}
+
+	var (
+		erased   = make(map[retro.PartitionName]bool, len(pns))
+		walked   = make(map[string]bool)
+		read     = make(map[string][]string)
+		orphaned = make(map[string]bool)
+		kept     = make(map[string]bool)
+		jp       = s.jp()
+	)
+	for _, pn := range pns {
+		erased[pn] = true
+	}
+
+	for name, head := range refs {
+		if !ref.IsBranch(name) {
+			continue
+		}
+		// History shared with branches walked earlier is skipped.
+		err := s.walker().Walk(head, walked, func(wc storage.WalkedCheckpoint) error {
+			walked[wc.CheckpointHash.String()] = true
+			for pn, evHashes := range wc.Affix {
+				for _, evHash := range evHashes {
+					hashes, cached := read[evHash.String()]
+					if !cached {
+						var err error
+						if hashes, err = blobRefs(s.objdb, jp, evHash); err != nil {
+							return err
+						}
+						read[evHash.String()] = hashes
+					}
+					for _, h := range hashes {
+						if erased[pn] {
+							orphaned[h] = true
+						} else {
+							kept[h] = true
+						}
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "can't walk history of %s", name)
+		}
+	}
+
+	for h := range orphaned {
+		if kept[h] {
+			continue
+		}
+		rc, _, err := s.objdb.OpenBlob(h)
+		if err != nil {
+			// deleted by an earlier erasure
+			continue
+		}
+		rc.Close()
+		if err := bdb.DeleteBlob(h); err != nil {
+			return errors.Wrapf(err, "can't delete blob %s", h)
+		}
+	}
+
+	for _, pn := range pns {
+		if err := keys.Delete(pn); err != nil {
+			return errors.Wrapf(err, "can't delete key of %s", pn)
+		}
+	}
+	return nil
+}
+
+// blobRefs returns the hashes of the blobs the event references, events
+// which have been erased already reference none.
+func blobRefs(objdb object.Source, jp packing.Packer, evHash retro.Hash) ([]string, error) {
+	packedEv, err := objdb.RetrievePacked(evHash.String())
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't retrieve event %s", evHash)
+	}
+	_, _, payload, err := jp.UnpackVersionedEvent(packedEv.Contents())
+	if xerrors.Is(err, retro.ErrErased) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't unpack event %s", evHash)
+	}
+	return packing.BlobRefs(payload)
+}
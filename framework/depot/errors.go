@@ -3,5 +3,10 @@ package depot
 import "golang.org/x/xerrors"
 
 var (
-	ErrWritePacked = xerrors.New("depot: could not write packed object")
+	ErrWritePacked    = xerrors.New("depot: could not write packed object")
+	ErrNotFastForward = xerrors.New("depot: new head does not have the old head as an ancestor")
+	ErrNotACheckpoint = xerrors.New("depot: object is not a checkpoint")
+	ErrMergeConflict  = xerrors.New("depot: merge conflict")
+	ErrNoIndex        = xerrors.New("depot: no index")
+	ErrCantErase      = xerrors.New("depot: stores can't list refs or delete blobs")
 )
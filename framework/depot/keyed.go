@@ -0,0 +1,38 @@
+package depot
+
+import (
+	"context"
+	"time"
+
+	"github.com/retro-framework/go-retro/framework/ref"
+	"github.com/retro-framework/go-retro/framework/retro"
+	"golang.org/x/xerrors"
+)
+
+// Keyed returns the most recent checkpoint reachable from the head of the
+// branch named in ctx which was stored with the idempotency key, and its
+// date. The lookup is served by the index, a depot without one returns
+// ErrNoIndex rather than walking the history.
+func (s *Simple) Keyed(ctx context.Context, key string) (retro.Hash, time.Time, error) {
+	if s.index == nil {
+		return nil, time.Time{}, ErrNoIndex
+	}
+	head, err := s.HeadPointer(ctx)
+	if err != nil || head == nil {
+		return nil, time.Time{}, err
+	}
+	entries, err := s.index.Keyed(ref.BranchFromContext(ctx), head, key)
+	if err != nil {
+		return nil, time.Time{}, xerrors.Errorf("depot: can't look up idempotency key %q: %w", key, err)
+	}
+	if len(entries) == 0 {
+		return nil, time.Time{}, nil
+	}
+	var latest = entries[len(entries)-1]
+	return latest.Checkpoint, latest.Time, nil
+}
+
+// RetrievePacked retrieves a stored object.
+func (s *Simple) RetrievePacked(hash string) (retro.HashedObject, error) {
+	return s.objdb.RetrievePacked(hash)
+}
@@ -0,0 +1,139 @@
+package depot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/retro-framework/go-retro/framework/packing"
+	"github.com/retro-framework/go-retro/framework/ref"
+	"github.com/retro-framework/go-retro/framework/retro"
+)
+
+// MergeConflictError is returned by Merge when both branches wrote to the
+// same partitions since their common ancestor. It matches ErrMergeConflict
+// with xerrors.Is.
+type MergeConflictError struct {
+	Into       string
+	From       string
+	Partitions []retro.PartitionName
+}
+
+func (e MergeConflictError) Error() string {
+	var names = make([]string, len(e.Partitions))
+	for i, p := range e.Partitions {
+		names[i] = string(p)
+	}
+	return fmt.Sprintf("depot: merge conflict merging %s into %s on partitions %s", e.From, e.Into, strings.Join(names, ", "))
+}
+
+func (e MergeConflictError) Is(target error) bool {
+	return target == ErrMergeConflict
+}
+
+// Merge merges the branch from into the branch into by writing a checkpoint
+// (with an empty affix) which has the heads of both branches as parents and
+// moving into to it. The merge is only allowed if the two branches touched
+// disjoint sets of partitions since their common ancestor(s), otherwise a
+// MergeConflictError listing the partitions is returned.
+//
+// If from is already an ancestor of into nothing is written and the head of
+// into is returned. Invalid branch names are refused with an error matching
+// storage.ErrInvalidRefName.
+func (s *Simple) Merge(ctx context.Context, into, from string) (retro.Hash, error) {
+
+	intoRef, err := ref.BranchRef(into)
+	if err != nil {
+		return nil, err
+	}
+	fromRef, err := ref.BranchRef(from)
+	if err != nil {
+		return nil, err
+	}
+
+	intoHead, err := s.refdb.Retrieve(intoRef)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't retrieve head of %s", intoRef)
+	}
+	fromHead, err := s.refdb.Retrieve(fromRef)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't retrieve head of %s", fromRef)
+	}
+
+	fromTouched, err := s.partitionsTouched(fromHead, intoHead)
+	if err != nil {
+		return nil, err
+	}
+	if fromTouched == nil {
+		// from is an ancestor of into, nothing to merge
+		return intoHead, nil
+	}
+	intoTouched, err := s.partitionsTouched(intoHead, fromHead)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []retro.PartitionName
+	for pn := range fromTouched {
+		if intoTouched[pn] {
+			conflicts = append(conflicts, pn)
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Slice(conflicts, func(i, j int) bool { return conflicts[i] < conflicts[j] })
+		return nil, MergeConflictError{Into: intoRef, From: fromRef, Partitions: conflicts}
+	}
+
+	var jp = s.jp()
+
+	packedAffix, err := jp.PackAffix(packing.Affix{})
+	if err != nil {
+		return nil, errors.Wrap(err, "can't pack merge affix")
+	}
+
+	packedCheckpoint, err := jp.PackCheckpoint(packing.Checkpoint{
+		AffixHash:    packedAffix.Hash(),
+		ParentHashes: []retro.Hash{intoHead, fromHead},
+		Fields: map[string]string{
+			"date": s.now().Format(time.RFC3339),
+		},
+		CommandDesc: []byte(fmt.Sprintf("merge %s into %s", fromRef, intoRef)),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "can't pack merge checkpoint")
+	}
+
+	if err := s.StorePacked(packedAffix, packedCheckpoint); err != nil {
+		return nil, err
+	}
+
+	if err := s.MoveHeadPointer(ref.WithBranch(ctx, intoRef), intoHead, packedCheckpoint.Hash()); err != nil {
+		return nil, err
+	}
+
+	return packedCheckpoint.Hash(), nil
+}
+
+// partitionsTouched returns the partitions written by checkpoints which are
+// reachable from head but not from since (which may be nil), see
+// storage.Walker.Exclusive. The result is nil if there are no such
+// checkpoints.
+func (s *Simple) partitionsTouched(head, since retro.Hash) (map[retro.PartitionName]bool, error) {
+	exclusive, err := s.walker().Exclusive(head, since)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't walk history of %s", head)
+	}
+	if len(exclusive) == 0 {
+		return nil, nil
+	}
+	var touched = make(map[retro.PartitionName]bool)
+	for _, wc := range exclusive {
+		for pn := range wc.Affix {
+			touched[pn] = true
+		}
+	}
+	return touched, nil
+}
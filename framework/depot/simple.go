@@ -7,10 +7,12 @@ import (
 	"os"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/retro-framework/go-retro/framework/index"
 	"github.com/retro-framework/go-retro/framework/matcher"
 	"github.com/retro-framework/go-retro/framework/object"
 	"github.com/retro-framework/go-retro/framework/packing"
@@ -21,8 +23,9 @@ import (
 )
 
 // DefaultBranchName is defined so that without an override changes
-// will move the ref named by this branch name
-const DefaultBranchName = "refs/heads/master"
+// will move the ref named by this branch name, the override is
+// given by the context, see ref.WithBranch.
+const DefaultBranchName = ref.DefaultBranch
 
 // NewSimpleStub returns a simple Depot stub which will yield the given events in the fixture
 // as a single checkpoint with a single affix with a generic set of placeholder metadata.
@@ -74,8 +77,67 @@ func NewSimpleStub(t *testing.T,
 	return &Simple{objdb: objDB, refdb: refDB}
 }
 
-func NewSimple(odb object.DB, refdb ref.DB) retro.Depot {
-	return &Simple{objdb: odb, refdb: refdb}
+// Option configures optional behaviour of a Simple depot.
+type Option func(*Simple)
+
+// WithClock sets the clock used to date checkpoints which the depot writes
+// itself (e.g merges), the default is the wall clock in UTC.
+func WithClock(c retro.Clock) Option {
+	return func(s *Simple) {
+		s.clock = c
+	}
+}
+
+// WithIndex makes the depot add stored checkpoints to idx and use it to
+// find the checkpoints relevant to a Watch()er without walking the whole
+// history. The same index should be given to the Repo.
+func WithIndex(idx *index.Index) Option {
+	return func(s *Simple) {
+		s.index = idx
+	}
+}
+
+// WithPacker sets the Packer used to unpack events for Watch()ers and to
+// pack the checkpoints the depot writes itself, the default is a
+// JSONPacker.
+func WithPacker(p packing.Packer) Option {
+	return func(s *Simple) {
+		s.packer = p
+	}
+}
+
+// WithEventManifest makes the event iterators of the depot upcast events
+// packed in an older version of their schema to the latest version known
+// to evm (see retro.VersionedEventManifest). The same manifest should be
+// given to the Repo.
+func WithEventManifest(evm retro.EventManifest) Option {
+	return func(s *Simple) {
+		s.evm = evm
+	}
+}
+
+// WithVerifier makes the depot refuse to move refs to checkpoints whose
+// signatures v does not verify (see packing.SigningPacker). Every
+// checkpoint a ref would newly reach is verified, history already reachable
+// from the ref's old head is trusted. A storage.UnverifiedError is returned
+// for the first checkpoint which does not verify.
+//
+// A new ref (e.g from CreateBranch) has no old head to trust, so the whole
+// history it would point to is verified, which costs a walk of all of it.
+// Unsigned history written before signing was enabled can't be branched
+// from.
+func WithVerifier(v *packing.Verifier) Option {
+	return func(s *Simple) {
+		s.verifier = v
+	}
+}
+
+func NewSimple(odb object.DB, refdb ref.DB, opts ...Option) retro.Depot {
+	var s = &Simple{objdb: odb, refdb: refdb}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // EmptySimpleMemory returns an empty depot to keep the type system happy
@@ -92,7 +154,7 @@ func EmptySimpleMemory() retro.Depot {
 // in case of failure falls back to the default branch
 // name.
 func (s *Simple) HeadPointer(ctx context.Context) (retro.Hash, error) {
-	ptr, err := s.refdb.Retrieve(refFromCtx(ctx))
+	ptr, err := s.refdb.Retrieve(ref.BranchFromContext(ctx))
 	if err == storage.ErrUnknownRef {
 		return nil, nil
 	}
@@ -137,21 +199,43 @@ type Simple struct {
 	objdb object.DB
 	refdb ref.DB
 
-	subscribers []chan<- retro.RefMove
+	clock    retro.Clock
+	index    *index.Index
+	evm      retro.EventManifest
+	packer   packing.Packer
+	verifier *packing.Verifier
+
+	subscribersMu sync.Mutex
+	subscribers   []chan<- retro.RefMove
 }
 
-// TODO: make this respect the actual value that might come in a context
-func refFromCtx(ctx context.Context) string {
-	return DefaultBranchName
+// walker returns a storage.Walker unpacking with jp().
+func (s *Simple) walker() storage.Walker {
+	return storage.NewWalker(s.objdb, storage.WithPacker(s.jp()))
 }
 
-// Watch makes the world go round
-func (s *Simple) Watch(_ context.Context, partition string) retro.PartitionIterator {
+// jp returns the Packer set with WithPacker, or a JSONPacker.
+func (s *Simple) jp() packing.Packer {
+	if s.packer == nil {
+		return packing.NewJSONPacker()
+	}
+	return s.packer
+}
+
+// Watch makes the world go round, it watches the branch named in the
+// context.
+func (s *Simple) Watch(ctx context.Context, partition string) retro.PartitionIterator {
 	var subscriberNotificationCh = make(chan retro.RefMove)
+	s.subscribersMu.Lock()
 	s.subscribers = append(s.subscribers, subscriberNotificationCh)
+	s.subscribersMu.Unlock()
 	return &simplePartitionIterator{
 		objdb:          s.objdb,
 		refdb:          s.refdb,
+		index:          s.index,
+		evm:            s.evm,
+		packer:         s.jp(),
+		branch:         ref.BranchFromContext(ctx),
 		pattern:        partition,
 		matcher:        matcher.NewGlobPattern(partition),
 		subscribedOn:   subscriberNotificationCh,
@@ -161,45 +245,129 @@ func (s *Simple) Watch(_ context.Context, partition string) retro.PartitionItera
 
 // StorePacked takes a variable number of hashed objects, packs and stores them
 // in the object store backing the Simple Depot
-func (s Simple) StorePacked(packed ...retro.HashedObject) error {
+//
+// If the depot has an index stored checkpoints are added to it, so their
+// affixes and parents must be stored before or together with them. If a
+// checkpoint can't be indexed the error is returned, the objects are stored
+// regardless.
+func (s *Simple) StorePacked(packed ...retro.HashedObject) error {
 	for _, p := range packed {
 		_, err := s.objdb.WritePacked(p)
 		if err != nil {
 			return errors.Wrap(err, "can't store packed")
 		}
 	}
+	if s.index != nil {
+		for _, p := range packed {
+			if p.Type() == packing.ObjectTypeCheckpoint {
+				if err := s.index.Add(p.Hash()); err != nil {
+					return errors.Wrapf(err, "can't index checkpoint %s", p.Hash())
+				}
+			}
+		}
+	}
 	return nil
 }
 
-// MoveHeadPointer overwrites the DefaultBranchName with
-// the new reference given. It needs to be made safer, see TODO.
+// MoveHeadPointer moves the branch named in the context (or
+// DefaultBranchName) from old to new. Only fast forward moves are allowed,
+// new must have old as an ancestor otherwise ErrNotFastForward is returned.
+// A nil old means the branch is expected to not exist yet.
+//
+// The ref is moved with a compare-and-swap, if someone else moved the ref
+// in the meantime storage.ErrRefChanged is returned and the caller is
+// expected to retry on top of the new head.
 //
-// TODO: check for fastforward 🔜 before allowing write and/or something
-// to make this not totally unsafe
-func (s Simple) MoveHeadPointer(old, new retro.Hash) error {
-	_, err := s.refdb.Write(DefaultBranchName, new)
-	if err == nil {
-		s.notifySubscribers(old, new)
+// If the depot has a verifier (see WithVerifier) the checkpoints the ref
+// would newly reach are verified first.
+func (s *Simple) MoveHeadPointer(ctx context.Context, old, new retro.Hash) error {
+	var (
+		branch = ref.BranchFromContext(ctx)
+		ff     bool
+	)
+	if old != nil {
+		isFF, err := s.walker().IsAncestor(old, new)
+		if err != nil {
+			return errors.Wrap(err, "can't check for fast forward")
+		}
+		if !isFF {
+			return ErrNotFastForward
+		}
+		ff = true
+	}
+	if s.verifier != nil {
+		if err := s.verify(old, new); err != nil {
+			return err
+		}
+	}
+	if err := s.refdb.CompareAndSwap(branch, old, new); err != nil {
+		return err
+	}
+	if old == nil || old.String() != new.String() {
+		s.notifySubscribers(branch, old, new, ff)
+	}
+	return nil
+}
+
+// verify verifies the checkpoints reachable from new but not from old, the
+// first which does not verify is returned as a storage.UnverifiedError.
+// History shared with old is only walked as far as needed to tell it apart
+// (see storage.Walker.Exclusive), if old is nil all of new's history is
+// verified.
+func (s *Simple) verify(old, new retro.Hash) error {
+	unverified, err := s.walker().Verify(new, old, s.verifier)
+	if err != nil {
+		return errors.Wrap(err, "can't walk history to verify")
+	}
+	if len(unverified) > 0 {
+		return unverified[0]
 	}
-	return err
+	return nil
 }
 
-// notifySubscribers takes old,new so that we can notify subscribers whether
-// this is fast forward or not. That _should_ be as easy as fetching the
-// new from the store, and checking that it has the old one as its only
-// parent hash.
+func (s *Simple) now() time.Time {
+	if s.clock == nil {
+		return time.Now().UTC()
+	}
+	return s.clock.Now()
+}
+
+// CreateBranch creates a new branch pointing at the checkpoint from. The
+// branch name may be given short ("qa-1") or as a full ref. Creating a
+// branch which already exists fails with storage.ErrRefChanged, invalid
+// names are refused with an error matching storage.ErrInvalidRefName.
 //
-// Probably significant that this receives a _copy_ of subscribers, as the
-// receiver is not a pointer type.
+// With a verifier (see WithVerifier) all of from's history is verified.
+func (s *Simple) CreateBranch(ctx context.Context, name string, from retro.Hash) error {
+	name, err := ref.BranchRef(name)
+	if err != nil {
+		return err
+	}
+	packedCheckpoint, err := s.objdb.RetrievePacked(from.String())
+	if err != nil {
+		return errors.Wrap(err, "can't retrieve branch point")
+	}
+	if packedCheckpoint.Type() != packing.ObjectTypeCheckpoint {
+		return ErrNotACheckpoint
+	}
+	return s.MoveHeadPointer(ref.WithBranch(ctx, name), nil, from)
+}
+
+// notifySubscribers tells every Watch()er that the ref moved, ff indicates
+// whether new has old as an ancestor.
 //
 // It also comes to my mind whether subscribers can be global, or whether
 // they need to be differentiated by which pattern they searched for
 // I suspect "global" (to the Depot instance) is ok for the time being.
-func (s Simple) notifySubscribers(old, new retro.Hash) error {
-	for _, subscriber := range s.subscribers {
+func (s *Simple) notifySubscribers(name string, old, new retro.Hash, ff bool) error {
+	s.subscribersMu.Lock()
+	var subscribers = make([]chan<- retro.RefMove, len(s.subscribers))
+	copy(subscribers, s.subscribers)
+	s.subscribersMu.Unlock()
+	for _, subscriber := range subscribers {
 		go func(subscriber chan<- retro.RefMove) {
 			select {
-			case subscriber <- retro.RefMove{Old: old, New: new}:
+			case subscriber <- retro.RefMove{Name: name, Old: old, New: new, FF: ff}:
 				// TODO: something about metrics ?
 			case <-time.After(1 * time.Minute):
 				fmt.Fprintf(os.Stderr, "blocked for one minute waiting to notify subscriber, skipping.")
@@ -9,12 +9,19 @@ import (
 	"github.com/retro-framework/go-retro/framework/packing"
 	"github.com/retro-framework/go-retro/framework/retro"
 	"github.com/retro-framework/go-retro/framework/storage"
+	"golang.org/x/xerrors"
 )
 
 // SimpleEventIter emits events on a given partition
 type simpleEventIterator struct {
 	objdb object.DB
 
+	// evm is optional, if present payloads are upcast to the latest
+	// version of their event.
+	evm retro.EventManifest
+
+	packer packing.Packer
+
 	pattern string
 
 	stackCh chan storage.AffixStack
@@ -57,7 +64,7 @@ func (s *simpleEventIterator) Events(ctx context.Context) (<-chan retro.Persiste
 
 func (s *simpleEventIterator) events(ctx context.Context, out chan retro.PersistedEvent, outErr chan error) (<-chan retro.PersistedEvent, <-chan error) {
 
-	var jp *packing.JSONPacker
+	var jp = s.packer
 
 	var drainStack = func(ctx context.Context, out chan<- retro.PersistedEvent, outErr chan<- error, stack storage.AffixStack) {
 		for {
@@ -87,13 +94,27 @@ func (s *simpleEventIterator) events(ctx context.Context, out chan retro.Persist
 							return
 						}
 
-						evName, evPayload, err := jp.UnpackEvent(packedEv.Contents())
+						evName, evVersion, evPayload, err := jp.UnpackVersionedEvent(packedEv.Contents())
+						if xerrors.Is(err, retro.ErrErased) {
+							// Handled by whoever is iterating, e.g to drop
+							// what they know of the partition.
+							outErr <- err
+							return
+						}
 						if err != nil {
 							// TODO: test me
 							outErr <- errors.Wrap(err, fmt.Sprintf("can't unpack event %s", packedEv.Contents()))
 							return
 						}
 
+						if s.evm != nil {
+							evPayload, err = retro.UpcastEvent(s.evm, evName, evVersion, evPayload)
+							if err != nil {
+								outErr <- errors.Wrap(err, fmt.Sprintf("can't upcast event %s", evName))
+								return
+							}
+						}
+
 						pEv := PersistedEv{
 							time:          h.Time,
 							bytes:         evPayload,
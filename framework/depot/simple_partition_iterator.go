@@ -3,9 +3,9 @@ package depot
 import (
 	"context"
 	"fmt"
-	"time"
 
 	"github.com/pkg/errors"
+	"github.com/retro-framework/go-retro/framework/index"
 	"github.com/retro-framework/go-retro/framework/matcher"
 	"github.com/retro-framework/go-retro/framework/object"
 	"github.com/retro-framework/go-retro/framework/packing"
@@ -20,6 +20,17 @@ type simplePartitionIterator struct {
 	objdb object.DB
 	refdb ref.DB
 
+	// index is optional, if present it is used to find the relevant
+	// checkpoints of the existing history instead of walking it.
+	index *index.Index
+
+	// evm is optional, it and packer are handed to the event iterators
+	evm    retro.EventManifest
+	packer packing.Packer
+
+	// branch is the full name of the ref being watched
+	branch string
+
 	pattern string
 	matcher retro.Matcher
 
@@ -81,6 +92,8 @@ func (s *simplePartitionIterator) partitions(ctx context.Context, out chan retro
 		//
 		evIter := &simpleEventIterator{
 			objdb:   s.objdb,
+			evm:     s.evm,
+			packer:  s.packer,
 			matcher: matcher.NewGlobPattern(kp),
 			pattern: kp,
 			stackCh: make(chan storage.AffixStack, 1),
@@ -95,15 +108,54 @@ func (s *simplePartitionIterator) partitions(ctx context.Context, out chan retro
 	}
 
 	var collectRelevantCheckpoints = func(from, to retro.Hash) error {
-		var st storage.AffixStack
-		// enqueueCheckpointIfRelevant will push the checkpoint and any ancestors
-		// onto the stack and we'll continue when the recursive enqueueCheckpointIfRelevant
-		// breaks the loop and we come back here.
-		var err = s.enqueueCheckpointIfRelevant(from, to, &st)
+		// The index can only tell us everything reachable from to, which
+		// is what we want for the initial history, moves of the ref
+		// usually only bring a few new checkpoints which are cheap to walk.
+		if from == nil && s.index != nil {
+			entries, err := s.index.Matching(s.branch, to, s.matcher)
+			if err != nil {
+				return errors.Wrap(err, "error when looking up relevant partitions in index")
+			}
+			stacks <- index.Stack(entries)
+			return nil
+		}
+		var except map[string]bool
+		if from != nil {
+			except = map[string]bool{from.String(): true}
+		}
+		walked, err := storage.NewWalker(s.objdb, storage.WithPacker(s.packer)).Ordered(to, except)
 		if err != nil {
 			return errors.Wrap(err, "error when stacking relevant partitions")
 		}
-		stacks <- st
+		// Walking a merge we'll have gone down the merged in side all the
+		// way to the root, drop everything the consumer has already seen.
+		var seen map[string]bool
+		for _, wc := range walked {
+			if from != nil && len(wc.Parents) > 1 {
+				seen, err = storage.NewWalker(s.objdb, storage.WithPacker(s.packer)).Ancestors(from)
+				if err != nil {
+					return errors.Wrap(err, "error when looking up already seen checkpoints")
+				}
+				break
+			}
+		}
+		var relevant []storage.RelevantCheckpoint
+		for _, wc := range walked {
+			if seen[wc.CheckpointHash.String()] {
+				continue
+			}
+			for partition := range wc.Affix {
+				matched, err := s.matcher.DoesMatch(partition)
+				if err != nil {
+					return errors.Wrapf(err, "error checking partition name %s against pattern %s for match", partition, s.pattern)
+				}
+				if matched {
+					relevant = append(relevant, wc.RelevantCheckpoint)
+					break
+				}
+			}
+		}
+		stacks <- storage.NewAffixStack(relevant)
 		return nil
 	}
 
@@ -114,8 +166,8 @@ func (s *simplePartitionIterator) partitions(ctx context.Context, out chan retro
 			close(outErr)
 		}()
 
-		// Resolve the head ref for the given ctx
-		checkpointHash, err := s.refdb.Retrieve(refFromCtx(ctx))
+		// Resolve the head ref for the branch being watched
+		checkpointHash, err := s.refdb.Retrieve(s.branch)
 		if err != nil {
 			outErr <- errors.Wrap(err, "unknown reference, can't lookup partitions")
 			return
@@ -134,11 +186,16 @@ func (s *simplePartitionIterator) partitions(ctx context.Context, out chan retro
 			case newStack, ok := <-stacks:
 				if ok {
 					for _, kp := range newStack.KnownPartitions {
+						// affixes matching the pattern may carry
+						// other partitions too.
+						if matched, _ := s.matcher.DoesMatch(kp); !matched {
+							continue
+						}
 						emitPartitionIterator(ctx, out, newStack, string(kp))
 					}
 				}
 			case refMoved, ok := <-s.subscribedOn:
-				if ok {
+				if ok && refMoved.Name == s.branch {
 					go collectRelevantCheckpoints(refMoved.Old, refMoved.New)
 				}
 			case <-ctx.Done():
@@ -150,96 +207,3 @@ func (s *simplePartitionIterator) partitions(ctx context.Context, out chan retro
 
 	return out, outErr
 }
-
-// enqueueCheckpointIfRelevant pushes checkpoint hashes and affix metadata onto a stack
-// which the caller can then drain. enqueueCheckpointIfRelevant is expected to be called
-// with a HEAD ref so that the most recent checkpoint on any given thread is pushed onto
-// the stack first, and emitted last.
-func (s *simplePartitionIterator) enqueueCheckpointIfRelevant(fromHash, toHash retro.Hash, st *storage.AffixStack) error {
-
-	var jp *packing.JSONPacker
-
-	// Unpack a Checkpoint
-	packedCheckpoint, err := s.objdb.RetrievePacked(toHash.String())
-	if err != nil {
-		// TODO: test this case
-		return errors.Wrap(err, fmt.Sprintf("can't read object %s", toHash.String()))
-	}
-
-	if packedCheckpoint.Type() != packing.ObjectTypeCheckpoint {
-		// TODO: test this case
-		return errors.Wrap(err, fmt.Sprintf("object was not a %s but a %s", packing.ObjectTypeCheckpoint, packedCheckpoint.Type()))
-	}
-
-	checkpoint, err := jp.UnpackCheckpoint(packedCheckpoint.Contents())
-	if err != nil {
-		// TODO: test this case
-		return errors.Wrap(err, fmt.Sprintf("can't read object %s", toHash.String()))
-	}
-
-	// Unpack the Affix
-	packedAffix, err := s.objdb.RetrievePacked(checkpoint.AffixHash.String())
-	if err != nil {
-		// TODO: test this case
-		return errors.Wrap(err, fmt.Sprintf("retrieve affix %s for checkpoint %s", checkpoint.AffixHash.String(), packedCheckpoint.Hash().String()))
-	}
-
-	if packedAffix.Type() != packing.ObjectTypeAffix {
-		// TODO: test this case
-		return errors.Wrap(err, fmt.Sprintf("object was not a %s but a %s", packing.ObjectTypeAffix, packedCheckpoint.Type()))
-	}
-
-	affix, err := jp.UnpackAffix(packedAffix.Contents())
-	if err != nil {
-		// TODO: test this case
-		return errors.Wrap(err, fmt.Sprintf("unpack affix %s for checkpoint %s", checkpoint.AffixHash.String(), packedCheckpoint.Hash().String()))
-	}
-
-	for partition := range affix {
-		matched, err := s.matcher.DoesMatch(partition)
-		if err != nil {
-			// TODO: test this case
-			return errors.Wrap(err, fmt.Sprintf("error checking partition name %s against pattern %s for match", partition, s.pattern))
-		}
-		if matched {
-
-			// Ensure we can get the date field header and parse it, else raise an error.
-			dateStr, ok := checkpoint.Fields["date"]
-			if !ok {
-				// TODO: test this case
-				return fmt.Errorf("error retrieving date field from checkpoint fields (checkpoint hash %s)", toHash.String())
-			}
-
-			t, err := time.Parse(time.RFC3339, dateStr)
-			if err != nil {
-				// TODO: test this case
-				return errors.Wrap(err, fmt.Sprintf("parsing date %q as rfc3339", dateStr))
-			}
-
-			st.Push(storage.RelevantCheckpoint{
-				Time:           t,
-				CheckpointHash: packedCheckpoint.Hash(),
-				Affix:          affix,
-			})
-		}
-	}
-
-	// TODO: we are not deterministic when parents are ordered, we push stuff in a maybe random order
-	// subject possibly to the order the hashes are written by the packer, which I believe to be alphabetic
-	// Either way we should peek into a structure and find out which checkpoint is younger and start there
-	for _, parentCheckpointHash := range checkpoint.ParentHashes {
-		// early return, we've come as far back in the ancestry
-		// as we were asked.
-		if parentCheckpointHash != nil && fromHash != nil {
-			if parentCheckpointHash.String() == fromHash.String() {
-				return nil
-			}
-		}
-		err := s.enqueueCheckpointIfRelevant(fromHash, parentCheckpointHash, st)
-		if err != nil {
-			errors.Wrap(err, fmt.Sprintf("error looking up parent hash %s for checkpoint %s", parentCheckpointHash.String(), packedCheckpoint.Hash().String()))
-		}
-	}
-
-	return nil
-}
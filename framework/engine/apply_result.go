@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"github.com/retro-framework/go-retro/framework/retro"
+)
+
+// ApplyResult describes the checkpoint written by Apply.
+type ApplyResult struct {
+	// Checkpoint is the hash of the new checkpoint, the head of the
+	// branch after Apply.
+	Checkpoint retro.Hash `json:"checkpoint"`
+
+	// PreviousHead is the checkpoint the head pointer was moved from, the
+	// parent of Checkpoint. It is nil if Apply created the branch.
+	PreviousHead retro.Hash `json:"previousHead,omitempty"`
+
+	// Partitions lists the partitions events were written to, sorted.
+	Partitions []retro.PartitionName `json:"partitions"`
+
+	// Named lists the names assigned to aggregates which the command
+	// created without naming them, sorted.
+	Named []retro.PartitionName `json:"named,omitempty"`
+
+	// Events lists the events written to each partition in order.
+	Events map[retro.PartitionName][]WrittenEvent `json:"events"`
+
+	// DryRun is true if nothing was written (see WithDryRun), Checkpoint
+	// is the hash the checkpoint would have had.
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// Errors lists why the checkpoint of a dry run could not be written,
+	// Apply returns an error instead.
+	Errors []string `json:"errors,omitempty"`
+
+	// Repeated is true if nothing was applied because a checkpoint was
+	// stored for the session with the same idempotency key within the
+	// idempotency window (see WithIdempotencyWindow), the result describes
+	// that checkpoint.
+	Repeated bool `json:"repeated,omitempty"`
+}
+
+// WrittenEvent is an event written by Apply.
+type WrittenEvent struct {
+	Name string     `json:"name"`
+	Hash retro.Hash `json:"hash"`
+}
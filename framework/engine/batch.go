@@ -0,0 +1,32 @@
+package engine
+
+import (
+	"context"
+	"io"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/retro-framework/go-retro/framework/retro"
+)
+
+// ApplyBatch applies the commands in order as a single unit, each command
+// sees the state (including the session) yielded by the commands before it
+// and all the resulting events are written as one affix in one checkpoint.
+// If any command fails to resolve or apply nothing is written and the head
+// pointer is not moved.
+//
+// The checkpoint references all the commands, stored as a batch (see
+// packing.Command), conflicts with checkpoints landing concurrently are
+// handled as they are by Apply and re-run the whole batch.
+func (e *Engine) ApplyBatch(ctx context.Context, w io.Writer, sid retro.SessionID, cmds [][]byte) (ApplyResult, error) {
+
+	// Tracing
+	spnApply, ctx := opentracing.StartSpanFromContext(ctx, "engine.ApplyBatch")
+	spnApply.SetTag("commands", len(cmds))
+	defer spnApply.Finish()
+
+	if len(cmds) == 0 {
+		return ApplyResult{}, Error{Op: "empty-batch", Err: retro.ErrBadArgs, Msg: "batch contains no commands"}
+	}
+
+	return e.applyAll(ctx, w, sid, cmds)
+}
@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/retro-framework/go-retro/framework/packing"
+	"github.com/retro-framework/go-retro/framework/retro"
+)
+
+// DefaultRedactedArgs are the args which are removed from commands before
+// they are stored unless WithRedactedArgs says otherwise.
+var DefaultRedactedArgs = []string{"password", "avatar", "images"}
+
+// WithRedactedArgs sets the names of the args which are removed from
+// commands before they are stored alongside the checkpoints they yield,
+// e.g secrets or large binary payloads. Names are matched case
+// insensitively at any depth of the args.
+func WithRedactedArgs(names ...string) Option {
+	return func(e *Engine) {
+		e.redactedArgs = names
+	}
+}
+
+// packCommand packs the command descriptions as sent by the client, minus
+// the redacted args. More than one command is packed as a batch.
+func (e *Engine) packCommand(cmdDescs ...[]byte) (retro.HashedObject, error) {
+
+	var cmds = make([]packing.Command, 0, len(cmdDescs))
+	for _, cmdDesc := range cmdDescs {
+		cmd, err := e.redactedCommand(cmdDesc)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+	}
+
+	if len(cmds) == 1 {
+		return e.packer.PackCommand(cmds[0])
+	}
+	return e.packer.PackCommand(packing.Command{Batch: cmds})
+}
+
+// commandDesc summarizes the command for the checkpoint, the commands of a
+// batch are listed in order.
+func commandDesc(cmd packing.Command) string {
+	if len(cmd.Batch) == 0 {
+		return filepath.Join(cmd.Path, cmd.Name)
+	}
+	var descs = make([]string, len(cmd.Batch))
+	for i, bc := range cmd.Batch {
+		descs[i] = commandDesc(bc)
+	}
+	return strings.Join(descs, ", ")
+}
+
+// redactedCommand parses the command description and removes the
+// redacted args.
+func (e *Engine) redactedCommand(cmdDesc []byte) (packing.Command, error) {
+
+	var cmd packing.Command
+	if err := json.Unmarshal(cmdDesc, &cmd); err != nil {
+		return cmd, err
+	}
+
+	if len(cmd.Args) > 0 && len(e.redactedArgs) > 0 {
+		var (
+			args  interface{}
+			dec   = json.NewDecoder(bytes.NewReader(cmd.Args))
+			names = make(map[string]bool, len(e.redactedArgs))
+		)
+		dec.UseNumber()
+		if err := dec.Decode(&args); err != nil {
+			return cmd, err
+		}
+		for _, name := range e.redactedArgs {
+			names[strings.ToLower(name)] = true
+		}
+		cmd.Redacted = redact(args, "args", names)
+		if len(cmd.Redacted) > 0 {
+			redactedArgs, err := json.Marshal(args)
+			if err != nil {
+				return cmd, err
+			}
+			cmd.Args = redactedArgs
+		}
+	}
+
+	return cmd, nil
+}
+
+// redact deletes the keys in names from v, which is decoded JSON, and
+// returns the dotted paths of the deleted keys.
+func redact(v interface{}, path string, names map[string]bool) []string {
+	var redacted []string
+	switch t := v.(type) {
+	case map[string]interface{}:
+		var keys = make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if names[strings.ToLower(k)] {
+				delete(t, k)
+				redacted = append(redacted, path+"."+k)
+				continue
+			}
+			redacted = append(redacted, redact(t[k], path+"."+k, names)...)
+		}
+	case []interface{}:
+		// every element shares the same path, record it only once
+		var seen = make(map[string]bool)
+		for _, elem := range t {
+			for _, p := range redact(elem, path+"[]", names) {
+				if !seen[p] {
+					seen[p] = true
+					redacted = append(redacted, p)
+				}
+			}
+		}
+	}
+	return redacted
+}
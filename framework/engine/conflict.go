@@ -0,0 +1,106 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/retro-framework/go-retro/framework/retro"
+	"golang.org/x/xerrors"
+)
+
+// ErrConcurrentWrite is matched (with xerrors.Is) by ConflictError, as is
+// retro.ErrConflict.
+var ErrConcurrentWrite = xerrors.New("engine: concurrent write")
+
+// DefaultConflictRetries is the number of times a command is re-run if
+// checkpoints which landed whilst it was being applied touched partitions
+// it read or wrote.
+const DefaultConflictRetries = 3
+
+// ConflictError is returned when the head pointer moved whilst a command
+// was being applied and the checkpoints which landed touched partitions
+// the command read or wrote, even after re-running the command. Partitions
+// may be empty if the Depot can't tell which partitions were touched.
+type ConflictError struct {
+	Attempts   int
+	Partitions []retro.PartitionName
+}
+
+func (e ConflictError) Error() string {
+	var names = make([]string, len(e.Partitions))
+	for i, p := range e.Partitions {
+		names[i] = string(p)
+	}
+	return fmt.Sprintf("engine: concurrent write conflict after %d attempt(s) on partitions %s", e.Attempts, strings.Join(names, ", "))
+}
+
+func (e ConflictError) Is(target error) bool {
+	return target == ErrConcurrentWrite || target == retro.ErrConflict
+}
+
+// conflicts returns the partitions in the read or write sets which were
+// touched by the checkpoints between since and head. If the depot can't
+// tell which partitions were touched every move of the head is treated as
+// a conflict.
+func (e *Engine) conflicts(ctx context.Context, since, head retro.Hash, rw map[retro.PartitionName]bool) (bool, []retro.PartitionName, error) {
+	dd, ok := e.depot.(retro.DiffableDepot)
+	if !ok {
+		return true, nil, nil
+	}
+	touched, err := dd.PartitionsTouched(ctx, since, head)
+	if err != nil {
+		return false, nil, err
+	}
+	var res []retro.PartitionName
+	for _, pn := range touched {
+		if rw[pn] {
+			res = append(res, pn)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return len(res) > 0, res, nil
+}
+
+// trackingRepo records the partitions a command reads whilst being
+// resolved and applied, they form the read set used to decide whether
+// the command can be re-parented onto a moved head.
+type trackingRepo struct {
+	retro.Repo
+
+	mu   sync.Mutex
+	read map[retro.PartitionName]bool
+}
+
+func newTrackingRepo(r retro.Repo) *trackingRepo {
+	return &trackingRepo{Repo: r, read: make(map[retro.PartitionName]bool)}
+}
+
+func (r *trackingRepo) Exists(ctx context.Context, pn retro.PartitionName) bool {
+	r.track(pn)
+	return r.Repo.Exists(ctx, pn)
+}
+
+func (r *trackingRepo) Rehydrate(ctx context.Context, agg retro.Aggregate, pn retro.PartitionName) error {
+	r.track(pn)
+	return r.Repo.Rehydrate(ctx, agg, pn)
+}
+
+func (r *trackingRepo) track(pn retro.PartitionName) {
+	r.mu.Lock()
+	r.read[pn] = true
+	r.mu.Unlock()
+}
+
+// readSet returns a copy of the partitions read so far.
+func (r *trackingRepo) readSet() map[retro.PartitionName]bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var res = make(map[retro.PartitionName]bool, len(r.read))
+	for pn := range r.read {
+		res[pn] = true
+	}
+	return res
+}
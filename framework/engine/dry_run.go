@@ -0,0 +1,41 @@
+package engine
+
+import (
+	"context"
+	"io"
+
+	"github.com/retro-framework/go-retro/framework/retro"
+)
+
+type ctxKey int
+
+const dryRunCtxKey ctxKey = iota
+
+// WithDryRun returns a copy of ctx in which Apply runs as a dry run, it
+// does everything up to and including packing the checkpoint but neither
+// stores any objects nor moves the head pointer. The ApplyResult describes
+// the checkpoint which would have been written.
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunCtxKey, true)
+}
+
+// DryRunFromContext returns true if ctx was marked with WithDryRun.
+func DryRunFromContext(ctx context.Context) bool {
+	if ctx != nil {
+		if dryRun, ok := ctx.Value(dryRunCtxKey).(bool); ok {
+			return dryRun
+		}
+	}
+	return false
+}
+
+// DryRun previews cmd, it is Apply with ctx marked as a dry run (see
+// WithDryRun). Validation errors of the would-be checkpoint are returned in
+// the Errors of the ApplyResult rather than as an error, errors resolving
+// or applying the command are returned as they are from Apply.
+//
+// Anonymous aggregates are named as they would be by Apply, but the names
+// are not reserved, applying the command for real may assign others.
+func (e *Engine) DryRun(ctx context.Context, w io.Writer, sid retro.SessionID, cmd []byte) (ApplyResult, error) {
+	return e.Apply(WithDryRun(ctx), w, sid, cmd)
+}
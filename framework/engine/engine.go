@@ -8,13 +8,18 @@ import (
 	"io"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gobuffalo/flect"
 	opentracing "github.com/opentracing/opentracing-go"
-	"github.com/pkg/errors"
 	"github.com/retro-framework/go-retro/framework/packing"
+	"github.com/retro-framework/go-retro/framework/ref"
+	"github.com/retro-framework/go-retro/framework/repository"
 	"github.com/retro-framework/go-retro/framework/retro"
+	"github.com/retro-framework/go-retro/framework/storage"
+	"golang.org/x/xerrors"
 )
 
 type Error struct {
@@ -27,19 +32,64 @@ func (e Error) Error() string {
 	return fmt.Sprintf("engine: op: %q err: %q msg: %q", e.Op, e.Err, e.Msg)
 }
 
-func New(d retro.Depot, r retro.Repo, resolver retro.Resolver, i retro.IDFn, c retro.Clock, a retro.AggregateManifest, e retro.EventManifest) Engine {
-	return Engine{
-		depot:        d,
-		repository:   r,
-		resolver:     resolver,
-		idFn:         i,
-		clock:        c,
-		aggm:         a,
-		evm:          e,
-		claimTimeout: 5 * time.Second,
+// Unwrap returns the underlying error, which for errors the caller can do
+// something about is one of the errors classified in retro (e.g
+// retro.ErrBadArgs).
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
+// Option configures optional behaviour of the Engine.
+type Option func(*Engine)
+
+// WithConflictRetries sets how many times a command is re-run when the
+// checkpoints which landed whilst it was being applied touched partitions
+// it read or wrote. Zero means give up on the first conflict.
+func WithConflictRetries(n int) Option {
+	return func(e *Engine) {
+		e.conflictRetries = n
+	}
+}
+
+// WithClaimTimeout sets how long Apply and StartSession wait to claim the
+// aggregates they operate on before giving up.
+func WithClaimTimeout(d time.Duration) Option {
+	return func(e *Engine) {
+		e.claimTimeout = d
 	}
 }
 
+// WithPacker sets the Packer used to pack the events, affixes, commands
+// and checkpoints the Engine writes, the default is a JSONPacker. The
+// Replayer should be given the same Packer.
+func WithPacker(p packing.Packer) Option {
+	return func(e *Engine) {
+		e.packer = p
+	}
+}
+
+func New(d retro.Depot, r retro.Repo, resolver retro.Resolver, i retro.IDFn, c retro.Clock, a retro.AggregateManifest, e retro.EventManifest, opts ...Option) Engine {
+	var eng = Engine{
+		depot:           d,
+		repository:      r,
+		resolver:        resolver,
+		idFn:            i,
+		clock:           c,
+		aggm:            a,
+		evm:             e,
+		claimTimeout:    5 * time.Second,
+		conflictRetries: DefaultConflictRetries,
+		redactedArgs:    DefaultRedactedArgs,
+		packer:          packing.NewJSONPacker(),
+
+		idempotencyWindow: DefaultIdempotencyWindow,
+	}
+	for _, opt := range opts {
+		opt(&eng)
+	}
+	return eng
+}
+
 type Engine struct {
 	depot      retro.Depot
 	repository retro.Repo
@@ -52,7 +102,13 @@ type Engine struct {
 	aggm retro.AggregateManifest
 	evm  retro.EventManifest
 
-	claimTimeout time.Duration
+	packer packing.Packer
+
+	claimTimeout      time.Duration
+	conflictRetries   int
+	redactedArgs      []string
+	idempotencyWindow time.Duration
+	middleware        []Middleware
 }
 
 // Apply takes a command and uses a Resolver to determine which aggregate
@@ -71,43 +127,238 @@ type Engine struct {
 //
 // Apply cannot write to an empty Depot, it will propagate depot.ErrUnknownRef
 // as a real error. SessionStart will allow writing to an empty depot however
-func (e *Engine) Apply(ctx context.Context, w io.Writer, sid retro.SessionID, cmd []byte) (string, error) {
-
-	var err error
+//
+// If ctx is marked with WithDryRun nothing is written, see DryRun.
+//
+// A command description may carry an "idempotencyKey", which is stored in
+// the checkpoint. If a checkpoint was stored with the same key within the
+// idempotency window (see WithIdempotencyWindow) the command is not applied
+// again, the ApplyResult of that checkpoint is returned instead. Keys are
+// looked up through the Depot, which must be a retro.KeyedDepot.
+func (e *Engine) Apply(ctx context.Context, w io.Writer, sid retro.SessionID, cmd []byte) (ApplyResult, error) {
 
 	// Tracing
 	spnApply, ctx := opentracing.StartSpanFromContext(ctx, "engine.Apply")
 	spnApply.SetTag("payload", string(cmd))
 	defer spnApply.Finish()
 
+	return e.applyAll(ctx, w, sid, [][]byte{cmd})
+}
+
+// applyAll applies the commands together, on top of the current head, as
+// a single checkpoint.
+func (e *Engine) applyAll(ctx context.Context, w io.Writer, sid retro.SessionID, cmds [][]byte) (ApplyResult, error) {
+
+	var spnApply = opentracing.SpanFromContext(ctx)
+
 	// Check we have a repository, aggm and etc, use them to give us
 	// back a rehydrated instance of the aggregate, and then we'll
 	// call the function on it.
 	if e.aggm == nil {
-		return "", Error{"agg-manifest-missing", nil, "aggregate manifest not available, please check config."}
+		return ApplyResult{}, Error{"agg-manifest-missing", nil, "aggregate manifest not available, please check config."}
 	}
 	if e.depot == nil {
-		return "", Error{"depot-missing", nil, "depot not available, please check config."}
+		return ApplyResult{}, Error{"depot-missing", nil, "depot not available, please check config."}
 	}
 	if e.resolver == nil {
-		return "", Error{"resolver-missing", nil, "resolver not available, please check config."}
+		return ApplyResult{}, Error{"resolver-missing", nil, "resolver not available, please check config."}
+	}
+
+	// Commands are always applied on top of the head, applying them to
+	// rehydrated past state would persist decisions made on stale data.
+	if !ref.PinFromContext(ctx).IsZero() {
+		return ApplyResult{}, Error{Op: "pinned-context", Err: retro.ErrBadArgs, Msg: "can't apply commands to a pinned context, use Query to run commands against past state"}
 	}
 
+	if len(e.middleware) > 0 {
+		var rewritten = make([][]byte, len(cmds))
+		for i, cmd := range cmds {
+			var err error
+			if rewritten[i], err = e.beforeResolve(ctx, sid, cmd); err != nil {
+				spnApply.SetTag("error", err.Error())
+				return ApplyResult{}, err
+			}
+		}
+		cmds = rewritten
+	}
+
+	key, err := idempotencyKey(cmds)
+	if err != nil {
+		return ApplyResult{}, err
+	}
+
+	// Claim the session and the target aggregates so that no other command
+	// is applied to them concurrently, the claims are held until the
+	// resulting events are persisted. Dry runs persist nothing and don't
+	// need to keep others waiting. The idempotency key is claimed too, so
+	// that a retry can't overtake the request it repeats.
+	var claims []string
+	if key != "" {
+		claims = append(claims, "idempotency-key/"+key)
+	}
+	for _, cmd := range cmds {
+		var target = struct {
+			Path string `json:"path"`
+		}{}
+		if err := json.Unmarshal(cmd, &target); err == nil && target.Path != "" {
+			claims = append(claims, target.Path)
+		}
+	}
+	if sid != "" {
+		claims = append(claims, filepath.Join("session", string(sid)))
+	}
+	if !DryRunFromContext(ctx) {
+		release, err := e.claim(ctx, claims...)
+		if err != nil {
+			spnApply.SetTag("error", err.Error())
+			return ApplyResult{}, err
+		}
+		defer release()
+	}
+
+	if key != "" {
+		res, repeated, err := e.repeated(ctx, sid, key, cmds)
+		if err != nil {
+			spnApply.SetTag("error", err.Error())
+			return ApplyResult{}, err
+		}
+		if repeated {
+			spnApply.SetTag("idempotency key repeated", key)
+			return res, nil
+		}
+	}
+
+	// The head pointer may move whilst the commands are being applied, if
+	// the checkpoints which landed in the meantime touched partitions the
+	// commands read or wrote the commands are applied again on top of the
+	// new head. Output is buffered so that abandoned attempts don't leak
+	// into w.
+	for attempt := 1; ; attempt++ {
+		var buf bytes.Buffer
+		res, err := e.apply(ctx, &buf, sid, cmds, key)
+		if cErr, isConflict := err.(ConflictError); isConflict {
+			if attempt <= e.conflictRetries {
+				spnApply.LogKV("event", "conflict", "attempt", attempt)
+				continue
+			}
+			cErr.Attempts = attempt
+			err = cErr
+		}
+		buf.WriteTo(w)
+		if err != nil {
+			spnApply.SetTag("error", err.Error())
+			return ApplyResult{}, err
+		}
+		if !res.DryRun {
+			if err := e.afterPersist(ctx, res); err != nil {
+				spnApply.SetTag("error", err.Error())
+				return res, err
+			}
+		}
+		return res, nil
+	}
+}
+
+// apply makes a single attempt at applying the commands on top of the
+// current head, a ConflictError means the attempt can be retried. Every
+// command sees the events yielded by the ones before it, if any command
+// fails nothing is persisted. The checkpoint is stored with the
+// idempotency key, if not empty.
+func (e *Engine) apply(ctx context.Context, w io.Writer, sid retro.SessionID, cmds [][]byte, key string) (ApplyResult, error) {
+
+	var (
+		tracking = newTrackingRepo(e.repository)
+		overlay  = repository.NewOverlay(tracking, e.evm, e.packer)
+		ws       = newWriteSet()
+		applied  []appliedCommand
+		spnApply = opentracing.SpanFromContext(ctx)
+		now      = e.clock.Now()
+	)
+
+	// Commands which read the time see the instant the checkpoint is dated
+	// with, which allows replaying them faithfully.
+	ctx = retro.WithClock(ctx, retro.FixedClock(now))
+	ws.key = key
+
 	headPtr, err := e.depot.HeadPointer(ctx)
 	if headPtr == nil && err == nil {
-		return "", Error{Op: "get-head-pointer", Msg: "depot is empty, start a session first"}
+		return ApplyResult{}, Error{Op: "get-head-pointer", Msg: "depot is empty, start a session first"}
 	}
 
 	if err != nil {
-		err = Error{"get-head-pointer", err, "could not get head pointer from depot"}
-		spnApply.SetTag("error", err.Error())
-		return "", err
+		return ApplyResult{}, Error{"get-head-pointer", err, "could not get head pointer from depot"}
 	}
 
 	if headPtr != nil {
 		spnApply.SetTag("head pointer", headPtr.String())
 	}
 
+	for _, cmd := range cmds {
+
+		// The session is rehydrated for every command, earlier commands
+		// may have changed it.
+		seshAgg, err := e.session(ctx, overlay, sid)
+		if err != nil {
+			return ApplyResult{}, err
+		}
+		var cmdCtx = retro.WithSession(ctx, seshAgg)
+
+		spnResolveCmd := opentracing.StartSpan("resolve command", opentracing.ChildOf(spnApply.Context()))
+		command, err := e.resolver.Resolve(cmdCtx, overlay, cmd)
+		if err != nil {
+			return ApplyResult{}, xerrors.Errorf("Couldn't resolve %s: %w", cmd, err)
+		}
+		spnResolveCmd.Finish()
+
+		if err := e.afterResolve(cmdCtx, command, seshAgg); err != nil {
+			return ApplyResult{}, err
+		}
+
+		spnApplyCmd := opentracing.StartSpan("apply command", opentracing.ChildOf(spnApply.Context()))
+		newEvs, err := command.Apply(cmdCtx, w, seshAgg, overlay)
+		if err != nil {
+			return ApplyResult{}, xerrors.Errorf("error applying command: %w", err)
+		}
+		spnApplyCmd.Finish()
+
+		if err := e.afterApply(cmdCtx, command, seshAgg, newEvs); err != nil {
+			return ApplyResult{}, err
+		}
+
+		if err := e.collect(ctx, ws, overlay, newEvs); err != nil {
+			return ApplyResult{}, err
+		}
+		applied = append(applied, appliedCommand{command, seshAgg, newEvs})
+	}
+
+	res, err := e.persistEvs(ctx, sid, cmds, now, headPtr, tracking.readSet(), ws)
+	if err != nil {
+		return ApplyResult{}, err // TODO: wrap me
+	}
+
+	for _, ac := range applied {
+		if err := render(ctx, w, ac.command, ac.session, ac.result); err != nil {
+			return res, err
+		}
+	}
+
+	return res, nil
+}
+
+// appliedCommand is a command which was applied, kept to render it once
+// its events are persisted.
+type appliedCommand struct {
+	command retro.Command
+	session retro.Aggregate
+	result  retro.CommandResult
+}
+
+// session returns the session aggregate rehydrated through repository, or
+// a fresh session aggregate if sid is empty.
+func (e *Engine) session(ctx context.Context, repository retro.Repo, sid retro.SessionID) (retro.Aggregate, error) {
+
+	var spnApply = opentracing.SpanFromContext(ctx)
+
 	// Check we have a "session" aggreate in the manifest, else we will struggle
 	// from here on out.
 	spnSeshAggLookup := opentracing.StartSpan("look up session aggregate", opentracing.ChildOf(spnApply.Context()))
@@ -116,7 +367,7 @@ func (e *Engine) Apply(ctx context.Context, w io.Writer, sid retro.SessionID, cm
 	if err != nil {
 		err = Error{"agg-lookup", err, "coult not look up session aggregate in manifest"}
 		spnSeshAggLookup.LogKV("event", "error", "error.object", err)
-		return "", err
+		return nil, err
 	}
 
 	// If a session ID was provided, look it up in the repository.  preliminary
@@ -126,51 +377,66 @@ func (e *Engine) Apply(ctx context.Context, w io.Writer, sid retro.SessionID, cm
 	// matching session IP address to the current client address from the ctxt?).
 	if sid == "" {
 		spnApply.LogEvent("no session found")
-	} else {
-		sessionPath := filepath.Join("session", string(sid))
-		spnRehydrateSesh := opentracing.StartSpan("rehydrating session", opentracing.ChildOf(spnApply.Context()))
-		defer spnRehydrateSesh.Finish()
-		if err := seshAgg.SetName(retro.PartitionName(sessionPath)); err != nil {
-			err := Error{"session-lookup", err, "aggregate name did not persist"}
-			return "", err
-		}
-		err := e.repository.Rehydrate(ctx, seshAgg, retro.PartitionName(sessionPath))
-		if err != nil {
-			err := Error{"session-lookup", err, "could not look up session"}
-			spnRehydrateSesh.LogKV("event", "error", "error.object", err)
-			return "", err
-		}
-		spnRehydrateSesh.Finish()
+		return seshAgg, nil
 	}
 
-	spnResolveCmd := opentracing.StartSpan("resolve command", opentracing.ChildOf(spnApply.Context()))
-	command, err := e.resolver.Resolve(ctx, e.repository, cmd)
+	sessionPath := filepath.Join("session", string(sid))
+	spnRehydrateSesh := opentracing.StartSpan("rehydrating session", opentracing.ChildOf(spnApply.Context()))
+	defer spnRehydrateSesh.Finish()
+	if err := seshAgg.SetName(retro.PartitionName(sessionPath)); err != nil {
+		err := Error{"session-lookup", err, "aggregate name did not persist"}
+		return nil, err
+	}
+	err = repository.Rehydrate(ctx, seshAgg, retro.PartitionName(sessionPath))
 	if err != nil {
-		return "", errors.Errorf("Couldn't resolve %s (%s)", cmd, err)
+		err := Error{"session-lookup", err, "could not look up session"}
+		spnRehydrateSesh.LogKV("event", "error", "error.object", err)
+		return nil, err
 	}
-	spnResolveCmd.Finish()
 
-	spnApplyCmd := opentracing.StartSpan("apply command", opentracing.ChildOf(spnApply.Context()))
-	newEvs, err := command.Apply(ctx, w, seshAgg, e.repository)
-	if err != nil {
-		return "", errors.Wrap(err, "error applying command")
+	return seshAgg, nil
+}
+
+// render renders the result of a command with the command's renderer, if
+// it has one.
+func render(ctx context.Context, w io.Writer, command retro.Command, seshAgg retro.Aggregate, cmdRes retro.CommandResult) error {
+	if commandWithRenderFn, hasRenderFn := command.(retro.CommandWithRenderFn); hasRenderFn {
+		return commandWithRenderFn.Render(ctx, w, seshAgg, cmdRes)
 	}
-	spnApplyCmd.Finish()
+	fmt.Fprintf(w, "no renderer defined: OK")
+	return nil
+}
+
+// claim claims all names through the repository, in a stable order to
+// avoid deadlocking with other callers, waiting at most claimTimeout. The
+// returned func releases the claims. If any claim can't be granted the
+// ones already granted are released and an error is returned.
+func (e *Engine) claim(ctx context.Context, names ...string) (func(), error) {
 
-	if err := e.persistEvs(ctx, sid, cmd, headPtr, newEvs); err != nil {
-		return "", err // TODO: wrap me
+	claimCtx, cancel := context.WithTimeout(ctx, e.claimTimeout)
+	defer cancel()
+
+	sort.Strings(names)
+
+	var claimed []string
+	var release = func() {
+		for i := len(claimed) - 1; i >= 0; i-- {
+			e.repository.Release(claimed[i])
+		}
 	}
 
-	if commandWithRenderFn, hasRenderFn := command.(retro.CommandWithRenderFn); hasRenderFn {
-		err := commandWithRenderFn.Render(ctx, w, seshAgg, newEvs)
-		if err != nil {
-			return "", err
+	for i, name := range names {
+		if i > 0 && names[i-1] == name {
+			continue
+		}
+		if !e.repository.Claim(claimCtx, name) {
+			release()
+			return nil, Error{"claim-aggregate", claimCtx.Err(), fmt.Sprintf("could not claim %q", name)}
 		}
-	} else {
-		fmt.Fprintf(w, "no renderer defined: OK")
+		claimed = append(claimed, name)
 	}
 
-	return "ok", nil
+	return release, nil
 }
 
 func dumpCommandResult(w io.Writer, cr retro.CommandResult) {
@@ -202,6 +468,9 @@ func (e *Engine) StartSession(ctx context.Context) (retro.SessionID, error) {
 	spnStartSession, ctx := opentracing.StartSpanFromContext(ctx, "engine.StartSession")
 	defer spnStartSession.Finish()
 
+	var now = e.clock.Now()
+	ctx = retro.WithClock(ctx, retro.FixedClock(now))
+
 	// Generate a session id using the provided factory
 	sidStr, err := e.idFn()
 	if err != nil {
@@ -238,7 +507,9 @@ func (e *Engine) StartSession(ctx context.Context) (retro.SessionID, error) {
 
 	// Try and get an exclusive claim on the resource, it will
 	// honor the timeout we have already set.
-	e.repository.Claim(claimCtx, path)
+	if !e.repository.Claim(claimCtx, path) {
+		return sid, Error{"claim-session", claimCtx.Err(), fmt.Sprintf("could not claim %q", path)}
+	}
 	defer e.repository.Release(path)
 
 	// Tracing
@@ -266,7 +537,15 @@ func (e *Engine) StartSession(ctx context.Context) (retro.SessionID, error) {
 		return sid, Error{"execute-session-start-cmd", err, "error calling session start command"}
 	}
 
-	return sid, e.persistEvs(ctx, sid, b, headPtr, sessionStartedEvents)
+	var (
+		read = map[retro.PartitionName]bool{retro.PartitionName(path): true}
+		ws   = newWriteSet()
+	)
+	if err := e.collect(ctx, ws, nil, sessionStartedEvents); err != nil {
+		return sid, err
+	}
+	_, err = e.persistEvs(ctx, sid, [][]byte{b}, now, headPtr, read, ws)
+	return sid, err
 
 	// Tracing
 	// spnAppendEvs, ctx := opentracing.StartSpanFromContext(ctx, "store generated events in depot")
@@ -279,11 +558,12 @@ func (e *Engine) StartSession(ctx context.Context) (retro.SessionID, error) {
 
 // guardHasID takes an aggregate, and checks if it has a name, if so then
 // it will pass-thru else it will leverage the ID generating function
-// to make one before persisting.
+// to make one before persisting. The bool reports whether a name was
+// assigned.
 //
 // TODO: could also check if an aggregate has a bogus name that doesn't
 // match its type.
-func (e *Engine) guardHasID(a retro.Aggregate) (retro.PartitionName, error) {
+func (e *Engine) guardHasID(a retro.Aggregate) (retro.PartitionName, bool, error) {
 
 	var (
 		name   retro.PartitionName = a.Name()
@@ -296,21 +576,26 @@ func (e *Engine) guardHasID(a retro.Aggregate) (retro.PartitionName, error) {
 			}
 			return v.Type()
 		}
-		err error
+		assigned bool
+		err      error
 	)
 	if len(name) == 0 {
 		var id, err = e.idFn()
 		if err != nil {
-			return "", err
+			return "", false, err
 		}
 		var n = filepath.Join(flect.Underscore(toType(a).Name()), id)
 		name = retro.PartitionName(n)
+		assigned = true
 	}
 	a.SetName(name)
-	return name, err
+	return name, assigned, err
 }
 
-func (e *Engine) nameAnonAggregates(ctx context.Context, cmdRes retro.CommandResult) error {
+// nameAnonAggregates names the anonymous aggregates referenced from the
+// events in cmdRes and returns the names it assigned.
+func (e *Engine) nameAnonAggregates(ctx context.Context, cmdRes retro.CommandResult) ([]retro.PartitionName, error) {
+	var named []retro.PartitionName
 	for _, evs := range cmdRes {
 		for _, ev := range evs {
 			var x reflect.Value
@@ -323,140 +608,245 @@ func (e *Engine) nameAnonAggregates(ctx context.Context, cmdRes retro.CommandRes
 				if x.Field(i).CanInterface() {
 					var y = x.Field(i).Interface()
 					if z, ok := y.(retro.Aggregate); ok {
-						_, err := e.guardHasID(z)
+						name, assigned, err := e.guardHasID(z)
 						if err != nil {
-							return err // TODO: test me
+							return nil, err // TODO: test me
+						}
+						if assigned {
+							named = append(named, name)
 						}
 					}
 				}
 			}
 		}
 	}
-	return nil
+	return named, nil
 }
 
-// TODO: Fix all the error messages (or Error types, etc, who knows.)
-//
-// TODO: extracting writing the checkpoint from here would be a good separation
-// of concerns.
+// writeSet collects the packed events yielded by the commands applied in
+// one attempt, to be persisted in a single checkpoint.
+type writeSet struct {
+	affix   packing.Affix
+	objs    []retro.HashedObject
+	events  map[retro.PartitionName][]WrittenEvent
+	named   map[retro.PartitionName]bool
+	written map[retro.PartitionName]bool
+
+	// key is the idempotency key to store the checkpoint with.
+	key string
+}
+
+func newWriteSet() *writeSet {
+	return &writeSet{
+		affix:   packing.Affix{},
+		events:  make(map[retro.PartitionName][]WrittenEvent),
+		named:   make(map[retro.PartitionName]bool),
+		written: make(map[retro.PartitionName]bool),
+	}
+}
+
+// collect names the anonymous aggregates in cmdRes, packs the events and
+// adds them to ws, and to the overlay (if any) so that commands applied
+// later in the same attempt see them.
 //
 // This currently mixes up some logic about naming aggregates.
-// persistEvs will range over the cmdResult itself, and will also
+// collect will range over the cmdResult itself, and will also
 // call e.nameAnonAggregates
-func (e *Engine) persistEvs(ctx context.Context, sid retro.SessionID, cmdDesc []byte, head retro.Hash, cmdRes retro.CommandResult) error {
+func (e *Engine) collect(ctx context.Context, ws *writeSet, overlay *repository.Overlay, cmdRes retro.CommandResult) error {
 
-	var (
-		jp          = packing.NewJSONPacker()
-		affix       = packing.Affix{}
-		packedeObjs []retro.HashedObject
-	)
-
-	if err := e.nameAnonAggregates(ctx, cmdRes); err != nil {
-		return err // TODO: wrap me
-	}
+	var jp = e.packer
 
-	currentHead, err := e.depot.HeadPointer(ctx)
+	namedInEvs, err := e.nameAnonAggregates(ctx, cmdRes)
 	if err != nil {
-		return err // TODO: Wrap me & test (?)
-	}
-
-	// parentHashes can be complicated to infer, so pull that logic out
-	// here to a variable and a set of conditional statements to make
-	// constructing the packing.Checkpoint easier below.
-	var parentHashes []retro.Hash
-
-	// There are possible cases here, that currentHead and head are both, or indiviudally
-	// nil. This can only happen incase the ctx carries a branch name which has no history
-	// (refs) or the depot is just empty (actually that's basically the same case)
-	if head != nil && currentHead != nil {
-		// TODO handle this case gracefully. It ought to be possible to check if any of the objects
-		// we had in our cmdRes conflict with changes that landed between head and currentHead. This
-		// ventures into the territory of merge conflict resolution/etc. Safer might be to return
-		// a sentinel error which the Engine can use to retry the entire transaction on top of the
-		// new head.
-		//
-		// This case should be safe as the early returns incase either the left or right hand sides
-		// are nil will ensure we never memory fault here.
-		if currentHead.String() != head.String() {
-			return fmt.Errorf("concurrent write, head pointer moved since we claimed it")
-		}
-		parentHashes = append(parentHashes, head)
+		return err // TODO: wrap me
 	}
-
-	if head == nil && currentHead != nil {
-		// This case means head was nil when Apply was called, but by the time we got to
-		// here someo
-		return fmt.Errorf("concurrent write, branch created since operation started")
-	}
-
-	if head != nil && currentHead == nil {
-		// This means someone has deleted our branch since we started, and we're about
-		// to recreate it if we continue.
-		return fmt.Errorf("concurrent write, branch deleted since operation started")
+	for _, pn := range namedInEvs {
+		ws.named[pn] = true
 	}
 
 	for agg, evs := range cmdRes {
-		aggPath, err := e.guardHasID(agg)
+		aggPath, assigned, err := e.guardHasID(agg)
 		if err != nil {
 			return Error{"persist-evs", err, "could not generate id for anonymous aggregate"}
 		}
+		if assigned {
+			ws.named[aggPath] = true
+		}
 		for _, ev := range evs {
 			name, err := e.evm.KeyFor(ev)
 			if err != nil {
 				return Error{"persist-evs", err, "error looking up event"}
 			}
-			packedEv, err := jp.PackEvent(name, ev)
+			packedEv, err := packing.PackPartitionEvent(jp, aggPath, name, retro.EventVersion(e.evm, name), ev)
 			if err != nil {
 				return Error{"persist-evs", err, "error packing event"}
 			}
-			packedeObjs = append(packedeObjs, packedEv)
-			affix[aggPath] = append(affix[aggPath], packedEv.Hash())
+			ws.objs = append(ws.objs, packedEv)
+			ws.affix[aggPath] = append(ws.affix[aggPath], packedEv.Hash())
+			ws.events[aggPath] = append(ws.events[aggPath], WrittenEvent{name, packedEv.Hash()})
+			if overlay != nil {
+				overlay.Add(aggPath, packedEv)
+			}
 		}
+		ws.written[aggPath] = true
 	}
 
-	packedAffix, err := jp.PackAffix(affix)
-	if err != nil {
-		return Error{"persist-evs", err, "error packing affix in NewSimpleStub: %s"}
-	}
-	packedeObjs = append(packedeObjs, packedAffix)
+	return nil
+}
 
-	var nameStruct = struct {
-		Path string `json:"path"`
-		Name string `json:"name"`
-	}{}
+// TODO: Fix all the error messages (or Error types, etc, who knows.)
+//
+// TODO: extracting writing the checkpoint from here would be a good separation
+// of concerns.
+//
+// persistEvs writes the events collected in ws as a single checkpoint
+// referencing the commands in cmdDescs.
+//
+// If the head pointer moved since head was read persistEvs checks whether
+// the checkpoints which landed in the meantime touched any partition in
+// read, or any partition written. If not, the new checkpoint is
+// re-parented onto the current head, otherwise a ConflictError is returned
+// and the caller may apply the commands again.
+func (e *Engine) persistEvs(ctx context.Context, sid retro.SessionID, cmdDescs [][]byte, now time.Time, head retro.Hash, read map[retro.PartitionName]bool, ws *writeSet) (ApplyResult, error) {
 
-	json.Unmarshal(cmdDesc, &nameStruct)
+	var (
+		jp          = e.packer
+		packedeObjs = append([]retro.HashedObject(nil), ws.objs...)
+		rw          = make(map[retro.PartitionName]bool, len(read)+len(ws.written))
+		res         = ApplyResult{Events: ws.events}
+	)
 
-	checkpoint := packing.Checkpoint{
-		AffixHash:   packedAffix.Hash(),
-		CommandDesc: []byte(filepath.Join(nameStruct.Path, nameStruct.Name)),
-		Fields: map[string]string{
-			"session": string(sid),
-			"date":    e.clock.Now().Format(time.RFC3339),
-		},
-		ParentHashes: parentHashes,
+	for pn := range ws.written {
+		rw[pn] = true
 	}
-
-	if _, err := checkpoint.HasErrors(); len(err) > 0 {
-		// TODO: HasErrors can return a bunch of errors
-		// we should do something smarter here.
-		return Error{"persist-evs", err[0], "error with the checkpoint validations"}
+	for pn := range read {
+		rw[pn] = true
 	}
+	for pn := range res.Events {
+		res.Partitions = append(res.Partitions, pn)
+	}
+	sort.Slice(res.Partitions, func(i, j int) bool { return res.Partitions[i] < res.Partitions[j] })
+	for pn := range ws.named {
+		res.Named = append(res.Named, pn)
+	}
+	sort.Slice(res.Named, func(i, j int) bool { return res.Named[i] < res.Named[j] })
 
-	packedCheckpoint, err := jp.PackCheckpoint(checkpoint)
+	packedAffix, err := jp.PackAffix(ws.affix)
 	if err != nil {
-		return Error{"persist-evs", err, "error packing checkpoint in NewSimpleStub: %s"}
+		return ApplyResult{}, Error{"persist-evs", err, "error packing affix in NewSimpleStub: %s"}
 	}
-	packedeObjs = append(packedeObjs, packedCheckpoint)
+	packedeObjs = append(packedeObjs, packedAffix)
 
-	if err := e.depot.StorePacked(packedeObjs...); err != nil {
-		return Error{"persist-evs", err, "error writing packedAffix to odb in NewSimpleStub"}
+	// The command is stored as it was requested (minus redacted args) so
+	// that it can be audited and replayed, CommandDesc only summarizes it.
+	packedCommand, err := e.packCommand(cmdDescs...)
+	if err != nil {
+		return ApplyResult{}, Error{"persist-evs", err, "error packing command"}
 	}
+	packedeObjs = append(packedeObjs, packedCommand)
 
-	if err := e.depot.MoveHeadPointer(nil, packedCheckpoint.Hash()); err != nil {
-		return Error{"persist-evs", err, "moving head pointer"}
+	command, err := jp.UnpackCommand(packedCommand.Contents())
+	if err != nil {
+		return ApplyResult{}, Error{"persist-evs", err, "error unpacking command"}
 	}
 
-	return nil
+	for {
+		if err := ctx.Err(); err != nil {
+			return ApplyResult{}, Error{"persist-evs", err, "context done whilst moving head pointer"}
+		}
+
+		currentHead, err := e.depot.HeadPointer(ctx)
+		if err != nil {
+			return ApplyResult{}, err // TODO: Wrap me & test (?)
+		}
+
+		// This means someone has deleted our branch since we started, and we're about
+		// to recreate it if we continue.
+		if head != nil && currentHead == nil {
+			return ApplyResult{}, fmt.Errorf("concurrent write, branch deleted since operation started")
+		}
 
+		// parentHashes can be complicated to infer, so pull that logic out
+		// here to a variable and a set of conditional statements to make
+		// constructing the packing.Checkpoint easier below.
+		//
+		// If head was nil when Apply was called someone may have created the
+		// branch by now, that is treated like any other move of the head.
+		var parentHashes []retro.Hash
+		if currentHead != nil {
+			if head == nil || currentHead.String() != head.String() {
+				conflict, partitions, err := e.conflicts(ctx, head, currentHead, rw)
+				if err != nil {
+					return ApplyResult{}, Error{"persist-evs", err, "could not check concurrent writes for conflicts"}
+				}
+				if conflict {
+					return ApplyResult{}, ConflictError{Partitions: partitions}
+				}
+			}
+			parentHashes = append(parentHashes, currentHead)
+		}
+
+		checkpoint := packing.Checkpoint{
+			AffixHash:   packedAffix.Hash(),
+			CommandDesc: []byte(commandDesc(command)),
+			CommandHash: packedCommand.Hash(),
+			Fields: map[string]string{
+				"session": string(sid),
+				"date":    now.Format(time.RFC3339),
+			},
+			ParentHashes: parentHashes,
+		}
+		if ws.key != "" {
+			checkpoint.Fields[packing.FieldIdempotencyKey] = ws.key
+			if len(res.Named) > 0 {
+				var named = make([]string, len(res.Named))
+				for i, pn := range res.Named {
+					named[i] = string(pn)
+				}
+				checkpoint.Fields[packing.FieldNamed] = strings.Join(named, " ")
+			}
+		}
+
+		var dryRun = DryRunFromContext(ctx)
+		if _, err := checkpoint.HasErrors(); len(err) > 0 {
+			// TODO: HasErrors can return a bunch of errors
+			// we should do something smarter here.
+			if !dryRun {
+				return ApplyResult{}, Error{"persist-evs", err[0], "error with the checkpoint validations"}
+			}
+			for _, e := range err {
+				res.Errors = append(res.Errors, e.Error())
+			}
+		}
+
+		packedCheckpoint, err := jp.PackCheckpoint(checkpoint)
+		if err != nil {
+			return ApplyResult{}, Error{"persist-evs", err, "error packing checkpoint in NewSimpleStub: %s"}
+		}
+
+		if dryRun {
+			res.Checkpoint = packedCheckpoint.Hash()
+			res.PreviousHead = currentHead
+			res.DryRun = true
+			return res, nil
+		}
+
+		if err := e.depot.StorePacked(append(packedeObjs, packedCheckpoint)...); err != nil {
+			return ApplyResult{}, Error{"persist-evs", err, "error writing packedAffix to odb in NewSimpleStub"}
+		}
+
+		// Someone moved the head pointer between reading and moving it,
+		// go around again to check what landed.
+		err = e.depot.MoveHeadPointer(ctx, currentHead, packedCheckpoint.Hash())
+		if err == storage.ErrRefChanged {
+			continue
+		}
+		if err != nil {
+			return ApplyResult{}, Error{"persist-evs", err, "moving head pointer"}
+		}
+
+		res.Checkpoint = packedCheckpoint.Hash()
+		res.PreviousHead = currentHead
+		return res, nil
+	}
 }
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"testing"
 	"time"
@@ -18,11 +19,15 @@ import (
 	"github.com/retro-framework/go-retro/commands"
 	"github.com/retro-framework/go-retro/events"
 	"github.com/retro-framework/go-retro/framework/depot"
+	"github.com/retro-framework/go-retro/framework/index"
+	"github.com/retro-framework/go-retro/framework/packing"
+	"github.com/retro-framework/go-retro/framework/ref"
 	"github.com/retro-framework/go-retro/framework/repository"
 	"github.com/retro-framework/go-retro/framework/resolver"
 	"github.com/retro-framework/go-retro/framework/retro"
 	"github.com/retro-framework/go-retro/framework/storage/memory"
 	test "github.com/retro-framework/go-retro/framework/test_helper"
+	"golang.org/x/xerrors"
 )
 
 type Predictable5sJumpClock struct {
@@ -123,6 +128,86 @@ func (dc *dummyCmd) Render(_ context.Context, w io.Writer, _ retro.Session, _ re
 	return nil
 }
 
+// loginCmd takes args, some of which must never be stored.
+type loginCmd struct {
+	dummyCmd
+	args *loginArgs
+}
+
+type loginArgs struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+func (lc *loginCmd) SetArgs(args retro.CommandArgs) error {
+	if typedArgs, ok := args.(*loginArgs); ok {
+		lc.args = typedArgs
+		return nil
+	}
+	return errors.New("can't cast args")
+}
+
+// countingCmd counts how often it was applied, it is registered as a
+// single instance so the count survives the resolver.
+type countingCmd struct {
+	s       *dummyAggregate
+	applied int
+}
+
+func (cc *countingCmd) SetState(s retro.Aggregate) error {
+	if agg, ok := s.(*dummyAggregate); ok {
+		cc.s = agg
+		return nil
+	}
+	return errors.New("can't cast aggregate state")
+}
+
+func (cc *countingCmd) Apply(_ context.Context, _ io.Writer, _ retro.Session, _ retro.Repo) (retro.CommandResult, error) {
+	cc.applied++
+	return retro.CommandResult{cc.s: []retro.Event{DummyEvent{}}}, nil
+}
+
+// inspectCmd renders the number of events its aggregate has seen without
+// yielding any events itself.
+type inspectCmd struct {
+	s *dummyAggregate
+}
+
+func (ic *inspectCmd) SetState(s retro.Aggregate) error {
+	if agg, ok := s.(*dummyAggregate); ok {
+		ic.s = agg
+		return nil
+	}
+	return errors.New("can't cast aggregate state")
+}
+
+func (ic *inspectCmd) Apply(_ context.Context, _ io.Writer, _ retro.Session, _ retro.Repo) (retro.CommandResult, error) {
+	return nil, nil
+}
+
+func (ic *inspectCmd) Render(_ context.Context, w io.Writer, _ retro.Session, _ retro.CommandResult) error {
+	fmt.Fprintf(w, "%d events", len(ic.s.seenEvents))
+	return nil
+}
+
+// interferingDepot calls interfere before storing objects, this lands in
+// the window between the Engine reading the head pointer and moving it.
+type interferingDepot struct {
+	retro.Depot
+	interfere func()
+}
+
+func (d *interferingDepot) StorePacked(objs ...retro.HashedObject) error {
+	if d.interfere != nil {
+		d.interfere()
+	}
+	return d.Depot.StorePacked(objs...)
+}
+
+func (d *interferingDepot) PartitionsTouched(ctx context.Context, since, head retro.Hash) ([]retro.PartitionName, error) {
+	return d.Depot.(retro.DiffableDepot).PartitionsTouched(ctx, since, head)
+}
+
 func ResolverDouble(resolverFn retro.ResolveFn) retro.Resolver {
 	return resolverDouble{resolverFn}
 }
@@ -417,11 +502,13 @@ func Test_Engine_Apply(t *testing.T) {
 			test.H(t).IsNil(err)
 
 			var b bytes.Buffer
-			resStr, err := e.Apply(ctx, &b, sid, []byte(`{"path":"	/123", "name":"dummyCmd"}`))
+			res, err := e.Apply(ctx, &b, sid, []byte(`{"path":"	/123", "name":"dummyCmd"}`))
 
 			// Assert
 			test.H(t).NotNil(err)
-			test.H(t).StringEql("", resStr)
+			if res.Checkpoint != nil {
+				t.Errorf("expected no checkpoint, got %s", res.Checkpoint)
+			}
 		})
 
 		t.Run("sucessfully routes registered command to correct entity with ID", func(t *testing.T) {
@@ -461,11 +548,12 @@ func Test_Engine_Apply(t *testing.T) {
 			test.H(t).IsNil(err)
 
 			var b bytes.Buffer
-			resStr, err := e.Apply(ctx, &b, sid, []byte(`{"path":"agg/123", "name":"dummyCmd"}`))
+			res, err := e.Apply(ctx, &b, sid, []byte(`{"path":"agg/123", "name":"dummyCmd"}`))
 			test.H(t).IsNil(err)
 
 			// Assert
-			test.H(t).StringEql("ok", resStr)
+			test.H(t).NotNil(res.Checkpoint)
+			test.H(t).StringEql("ok", b.String())
 			return
 
 			// TODO: Address this case. With the current code in place, the path agg/123
@@ -538,90 +626,936 @@ func Test_Engine_Apply(t *testing.T) {
 
 	})
 
-	t.Run("storage", func(t *testing.T) {
-		t.Run("applies commands and stores resulting events in case of success", func(t *testing.T) {
+	t.Run("applies commands to the branch named in the context", func(t *testing.T) {
 
-			// Arrange
-			var (
-				objdb      = &memory.ObjectStore{}
-				refdb      = &memory.RefStore{}
-				depot      = depot.NewSimple(objdb, refdb)
-				idFn       = func() (string, error) { return fmt.Sprintf("%x", []byte("hello")), nil }
-				clock      = &Predictable5sJumpClock{}
-				aggM       = aggregates.NewManifest()
-				cmdM       = commands.NewManifest()
-				evM        = events.NewManifest()
-				repository = repository.NewSimpleRepository(objdb, refdb, evM)
+		// Arrange
+		var (
+			objdb      = &memory.ObjectStore{}
+			refdb      = &memory.RefStore{}
+			d          = depot.NewSimple(objdb, refdb)
+			idFn       = func() (string, error) { return fmt.Sprintf("%x", []byte("hello")), nil }
+			clock      = &Predictable5sJumpClock{}
+			aggM       = aggregates.NewManifest()
+			cmdM       = commands.NewManifest()
+			evM        = events.NewManifest()
+			repository = repository.NewSimpleRepository(objdb, refdb, evM)
 
-				err error
-			)
+			err error
+		)
 
-			aggM.Register("agg", &dummyAggregate{})
-			cmdM.Register(&dummyAggregate{}, &dummyCmd{})
+		aggM.Register("agg", &dummyAggregate{})
+		cmdM.Register(&dummyAggregate{}, &dummyCmd{})
 
-			aggM.Register("session", &dummySession{})
-			cmdM.Register(&dummySession{}, &Start{})
+		aggM.Register("session", &dummySession{})
+		cmdM.Register(&dummySession{}, &Start{})
 
-			evM.Register(&DummyEvent{})
-			evM.Register(&DummyStartSessionEvent{})
+		evM.Register(&DummyEvent{})
+		evM.Register(&DummyStartSessionEvent{})
 
-			var (
-				r   = resolver.New(aggM, cmdM)
-				e   = New(depot, repository, r, idFn, clock, aggM, evM)
-				ctx = context.Background()
-			)
+		var (
+			r      = resolver.New(aggM, cmdM)
+			e      = New(d, repository, r, idFn, clock, aggM, evM)
+			ctx    = context.Background()
+			qaCtx  = ref.WithBranch(ctx, "qa-1")
+			aggPn  = retro.PartitionName("dummy_aggregate/68656c6c6f")
+			seshPn = retro.PartitionName("dummy_session/68656c6c6f")
+		)
+
+		sid, err := e.StartSession(ctx)
+		test.H(t).IsNil(err)
+
+		masterHead, err := d.HeadPointer(ctx)
+		test.H(t).IsNil(err)
+		test.H(t).IsNil(d.(retro.BranchableDepot).CreateBranch(ctx, "qa-1", masterHead))
+
+		// Act
+		var b bytes.Buffer
+		_, err = e.Apply(qaCtx, &b, sid, []byte(`{"path":"agg/123", "name":"dummyCmd"}`))
+		test.H(t).IsNil(err)
+
+		// Assert
+		newMasterHead, err := d.HeadPointer(ctx)
+		test.H(t).IsNil(err)
+		test.H(t).StringEql(newMasterHead.String(), masterHead.String())
+
+		qaHead, err := d.HeadPointer(qaCtx)
+		test.H(t).IsNil(err)
+		test.H(t).BoolEql(qaHead.String() != masterHead.String(), true)
+
+		test.H(t).BoolEql(repository.Exists(qaCtx, aggPn), true)
+		test.H(t).BoolEql(repository.Exists(ctx, aggPn), false)
+		test.H(t).BoolEql(repository.Exists(qaCtx, seshPn), true)
+	})
+
+	t.Run("rehydrates aggregates through the partition index", func(t *testing.T) {
+
+		// Arrange
+		var (
+			objdb = &memory.ObjectStore{}
+			refdb = &memory.RefStore{}
+			idx   = index.New(objdb)
+			d     = depot.NewSimple(objdb, refdb, depot.WithIndex(idx))
+			idFn  = func() (string, error) { return fmt.Sprintf("%x", []byte("hello")), nil }
+			clock = &Predictable5sJumpClock{}
+			aggM  = aggregates.NewManifest()
+			cmdM  = commands.NewManifest()
+			evM   = events.NewManifest()
+			repo  = repository.NewSimpleRepository(objdb, refdb, evM, repository.WithIndex(idx))
+			cc    = &countingCmd{}
+		)
+
+		aggM.Register("dummy_aggregate", &dummyAggregate{})
+		cmdM.Register(&dummyAggregate{}, cc)
+
+		aggM.Register("session", &dummySession{})
+		cmdM.Register(&dummySession{}, &Start{})
+
+		evM.Register(&DummyEvent{})
+		evM.Register(&DummyStartSessionEvent{})
+
+		var (
+			r   = resolver.New(aggM, cmdM)
+			e   = New(d, repo, r, idFn, clock, aggM, evM)
+			ctx = context.Background()
+		)
+
+		sid, err := e.StartSession(ctx)
+		test.H(t).IsNil(err)
+
+		// Act
+		for i := 0; i < 2; i++ {
+			_, err = e.Apply(ctx, ioutil.Discard, sid, []byte(`{"path":"dummy_aggregate/68656c6c6f", "name":"countingCmd"}`))
+			test.H(t).IsNil(err)
+		}
+
+		// Assert
+		test.H(t).BoolEql(repo.Exists(ctx, "dummy_aggregate/68656c6c6f"), true)
+		test.H(t).BoolEql(repo.Exists(ctx, "dummy_aggregate/*"), true)
+		test.H(t).BoolEql(repo.Exists(ctx, "dummy_aggregate/nope"), false)
+		test.H(t).IntEql(len(cc.s.seenEvents), 1)
+	})
+
+	t.Run("queries aggregates as they were in the past", func(t *testing.T) {
+
+		// Arrange
+		var (
+			objdb = &memory.ObjectStore{}
+			refdb = &memory.RefStore{}
+			d     = depot.NewSimple(objdb, refdb)
+			idFn  = func() (string, error) { return fmt.Sprintf("%x", []byte("hello")), nil }
+			clock = &Predictable5sJumpClock{}
+			aggM  = aggregates.NewManifest()
+			cmdM  = commands.NewManifest()
+			evM   = events.NewManifest()
+			repo  = repository.NewSimpleRepository(objdb, refdb, evM)
+			jp    = packing.NewJSONPacker()
+		)
+
+		aggM.Register("dummy_aggregate", &dummyAggregate{})
+		cmdM.Register(&dummyAggregate{}, &countingCmd{})
+		cmdM.Register(&dummyAggregate{}, &inspectCmd{})
+
+		aggM.Register("session", &dummySession{})
+		cmdM.Register(&dummySession{}, &Start{})
+
+		evM.Register(&DummyEvent{})
+		evM.Register(&DummyStartSessionEvent{})
+
+		var (
+			r       = resolver.New(aggM, cmdM)
+			e       = New(d, repo, r, idFn, clock, aggM, evM)
+			ctx     = context.Background()
+			count   = []byte(`{"path":"dummy_aggregate/68656c6c6f", "name":"countingCmd"}`)
+			inspect = []byte(`{"path":"dummy_aggregate/68656c6c6f", "name":"inspectCmd"}`)
+			heads   []retro.Hash
+		)
+
+		sid, err := e.StartSession(ctx)
+		test.H(t).IsNil(err)
+
+		for i := 0; i < 3; i++ {
+			_, err = e.Apply(ctx, ioutil.Discard, sid, count)
+			test.H(t).IsNil(err)
+			head, err := d.HeadPointer(ctx)
+			test.H(t).IsNil(err)
+			heads = append(heads, head)
+		}
+
+		var query = func(t *testing.T, ctx context.Context) string {
+			var buf bytes.Buffer
+			err := e.Query(ctx, &buf, sid, inspect)
+			test.H(t).IsNil(err)
+			return buf.String()
+		}
+
+		t.Run("at the head", func(t *testing.T) {
+			test.H(t).StringEql(query(t, ctx), "3 events")
+		})
+
+		t.Run("as of a checkpoint", func(t *testing.T) {
+			test.H(t).StringEql(query(t, ref.WithPin(ctx, ref.Pin{Checkpoint: heads[0]})), "1 events")
+		})
+
+		t.Run("as of a time", func(t *testing.T) {
+			packedCheckpoint, err := objdb.RetrievePacked(heads[1].String())
+			test.H(t).IsNil(err)
+			checkpoint, err := jp.UnpackCheckpoint(packedCheckpoint.Contents())
+			test.H(t).IsNil(err)
+			at, err := time.Parse(time.RFC3339, checkpoint.Fields["date"])
+			test.H(t).IsNil(err)
+
+			test.H(t).StringEql(query(t, ref.WithPin(ctx, ref.Pin{Time: at})), "2 events")
+			test.H(t).StringEql(query(t, ref.WithPin(ctx, ref.Pin{Time: at.Add(-time.Second)})), "1 events")
+		})
+
+		t.Run("refuses commands which yield events", func(t *testing.T) {
+			err := e.Query(ref.WithPin(ctx, ref.Pin{Checkpoint: heads[0]}), ioutil.Discard, sid, count)
+			if engineErr, ok := err.(Error); !ok || engineErr.Op != "read-only-command" {
+				t.Fatalf("expected a read-only-command error, got %v", err)
+			}
+			head, err := d.HeadPointer(ctx)
+			test.H(t).IsNil(err)
+			test.H(t).StringEql(head.String(), heads[2].String())
+		})
+
+		t.Run("refuses to apply commands to a pinned context", func(t *testing.T) {
+			_, err := e.Apply(ref.WithPin(ctx, ref.Pin{Checkpoint: heads[0]}), ioutil.Discard, sid, count)
+			if engineErr, ok := err.(Error); !ok || engineErr.Op != "pinned-context" {
+				t.Fatalf("expected a pinned-context error, got %v", err)
+			}
+			test.H(t).BoolEql(xerrors.Is(err, retro.ErrBadArgs), true)
+		})
+	})
+
+	t.Run("batch", func(t *testing.T) {
+
+		// Arrange
+		var (
+			objdb = &memory.ObjectStore{}
+			refdb = &memory.RefStore{}
+			d     = depot.NewSimple(objdb, refdb)
+			idFn  = func() (string, error) { return fmt.Sprintf("%x", []byte("hello")), nil }
+			clock = &Predictable5sJumpClock{}
+			aggM  = aggregates.NewManifest()
+			cmdM  = commands.NewManifest()
+			evM   = events.NewManifest()
+			repo  = repository.NewSimpleRepository(objdb, refdb, evM)
+			jp    = packing.NewJSONPacker()
+		)
+
+		aggM.Register("dummy_aggregate", &dummyAggregate{})
+		cmdM.Register(&dummyAggregate{}, &countingCmd{})
+		cmdM.Register(&dummyAggregate{}, &inspectCmd{})
+
+		aggM.Register("session", &dummySession{})
+		cmdM.Register(&dummySession{}, &Start{})
+
+		evM.Register(&DummyEvent{})
+		evM.Register(&DummyStartSessionEvent{})
+
+		var (
+			r       = resolver.New(aggM, cmdM)
+			e       = New(d, repo, r, idFn, clock, aggM, evM)
+			ctx     = context.Background()
+			count   = []byte(`{"path":"dummy_aggregate/68656c6c6f", "name":"countingCmd"}`)
+			inspect = []byte(`{"path":"dummy_aggregate/68656c6c6f", "name":"inspectCmd"}`)
+			unknown = []byte(`{"path":"dummy_aggregate/68656c6c6f", "name":"unknownCmd"}`)
+		)
+
+		sid, err := e.StartSession(ctx)
+		test.H(t).IsNil(err)
+
+		t.Run("applies every command on top of the ones before it in a single checkpoint", func(t *testing.T) {
+
+			head, err := d.HeadPointer(ctx)
+			test.H(t).IsNil(err)
 
 			// Act
-			sid, err := e.StartSession(ctx)
+			var b bytes.Buffer
+			res, err := e.ApplyBatch(ctx, &b, sid, [][]byte{count, count, inspect})
 			test.H(t).IsNil(err)
 
+			// Assert
+			test.H(t).StringEql(b.String(), "no renderer defined: OKno renderer defined: OK2 events")
+			test.H(t).StringEql(res.PreviousHead.String(), head.String())
+			test.H(t).IntEql(len(res.Events["dummy_aggregate/68656c6c6f"]), 2)
+
+			newHead, err := d.HeadPointer(ctx)
+			test.H(t).IsNil(err)
+			test.H(t).StringEql(newHead.String(), res.Checkpoint.String())
+
+			packedCheckpoint, err := objdb.RetrievePacked(res.Checkpoint.String())
+			test.H(t).IsNil(err)
+			checkpoint, err := jp.UnpackCheckpoint(packedCheckpoint.Contents())
+			test.H(t).IsNil(err)
+			test.H(t).IntEql(len(checkpoint.ParentHashes), 1)
+			test.H(t).StringEql(checkpoint.ParentHashes[0].String(), head.String())
+			test.H(t).StringEql(string(checkpoint.CommandDesc), "dummy_aggregate/68656c6c6f/countingCmd, dummy_aggregate/68656c6c6f/countingCmd, dummy_aggregate/68656c6c6f/inspectCmd")
+
+			packedCommand, err := objdb.RetrievePacked(checkpoint.CommandHash.String())
+			test.H(t).IsNil(err)
+			command, err := jp.UnpackCommand(packedCommand.Contents())
+			test.H(t).IsNil(err)
+			test.H(t).IntEql(len(command.Batch), 3)
+			test.H(t).StringEql(command.Batch[2].Name, "inspectCmd")
+		})
+
+		t.Run("writes nothing if any command fails", func(t *testing.T) {
+
+			head, err := d.HeadPointer(ctx)
+			test.H(t).IsNil(err)
+			var objects = len(objdb.Ls())
+
+			// Act
+			_, err = e.ApplyBatch(ctx, ioutil.Discard, sid, [][]byte{count, unknown})
+
+			// Assert
+			test.H(t).NotNil(err)
+			test.H(t).IntEql(len(objdb.Ls()), objects)
+			newHead, err := d.HeadPointer(ctx)
+			test.H(t).IsNil(err)
+			test.H(t).StringEql(newHead.String(), head.String())
+
 			var b bytes.Buffer
-			resStr, err := e.Apply(ctx, &b, sid, []byte(`{"path":"agg/123", "name":"dummyCmd"}`))
+			_, err = e.Apply(ctx, &b, sid, inspect)
 			test.H(t).IsNil(err)
+			test.H(t).StringEql(b.String(), "2 events")
+		})
 
-			if resStr != "ok" {
-				t.Fatal("apply failed, we wanted 'ok', got ", resStr)
+		t.Run("refuses an empty batch", func(t *testing.T) {
+			_, err := e.ApplyBatch(ctx, ioutil.Discard, sid, nil)
+			if engineErr, ok := err.(Error); !ok || engineErr.Op != "empty-batch" {
+				t.Fatalf("expected an empty-batch error, got %v", err)
 			}
+		})
+	})
 
-			var expected = `checkpoint:sha256:0fbfebc09b8d49bb22618b2025e923efa801e69366c8ca781f7901930901b3ba
-checkpoint 220\u0000affix sha256:d9d06f42ded214cc216cfa218110bdba475bb5383acd97df8ddd957455592095
-parent sha256:4a99bc815b06677c29a79db0cdcd6f6a3faabb2851b04397a1a13a2c39e4ff8f
-date 0001-01-01T00:00:05Z
-session 68656c6c6f
+	t.Run("idempotency keys", func(t *testing.T) {
 
-agg/123/dummyCmd
+		var setup = func(t *testing.T, withIndex bool, opts ...Option) (Engine, retro.Depot, *countingCmd, retro.SessionID) {
+			var (
+				objdb = &memory.ObjectStore{}
+				refdb = &memory.RefStore{}
+				d     = depot.NewSimple(objdb, refdb)
+				n     int
+				idFn  = func() (string, error) { n++; return fmt.Sprintf("%d", n), nil }
+				clock = &Predictable5sJumpClock{}
+				aggM  = aggregates.NewManifest()
+				cmdM  = commands.NewManifest()
+				evM   = events.NewManifest()
+				repo  = repository.NewSimpleRepository(objdb, refdb, evM)
+				cc    = &countingCmd{}
+				ctx   = context.Background()
+			)
+			if withIndex {
+				d = depot.NewSimple(objdb, refdb, depot.WithIndex(index.New(objdb)))
+			}
 
+			aggM.Register("_", &dummyAggregate{})
+			aggM.Register("dummy_aggregate", &dummyAggregate{})
+			cmdM.Register(&dummyAggregate{}, cc)
+			cmdM.Register(&dummyAggregate{}, &dummyRelationCmd{})
 
-checkpoint:sha256:4a99bc815b06677c29a79db0cdcd6f6a3faabb2851b04397a1a13a2c39e4ff8f
-checkpoint 149\u0000affix sha256:d45315bfa144411d8362ab68c56808ae92c20cfcbbf3abc35c57db5d08c871d5
-date 0001-01-01T00:00:00Z
-session 68656c6c6f
+			aggM.Register("session", &dummySession{})
+			cmdM.Register(&dummySession{}, &Start{})
 
-session/68656c6c6f/Start
+			evM.Register(&DummyEvent{})
+			evM.Register(&DummyStartSessionEvent{})
+			evM.Register(&dummyAssociationEvent{})
 
+			var e = New(d, repo, resolver.New(aggM, cmdM), idFn, clock, aggM, evM, opts...)
+			sid, err := e.StartSession(ctx)
+			test.H(t).IsNil(err)
+			return e, d, cc, sid
+		}
 
-event:sha256:70df53d19786d92d1bfa4c2527bb819054495ea3756fcfd7d88e3d4c8fae3172
-event json dummy_event 2\u0000{}
+		var (
+			ctx      = context.Background()
+			count    = []byte(`{"path":"dummy_aggregate/1", "name":"countingCmd", "idempotencyKey":"count-1"}`)
+			relation = []byte(`{"name":"dummyRelationCmd", "idempotencyKey":"relation-1"}`)
+		)
 
-affix:sha256:d45315bfa144411d8362ab68c56808ae92c20cfcbbf3abc35c57db5d08c871d5
-affix 99\u00000 dummy_session/68656c6c6f sha256:dd176fd38eaf032d39e35e39f04de8f30406bb0eaea55affe847f91cc923f69f
+		t.Run("returns the original result when a key is repeated", func(t *testing.T) {
+			e, d, cc, sid := setup(t, true)
 
+			first, err := e.Apply(ctx, ioutil.Discard, sid, count)
+			test.H(t).IsNil(err)
+			head, err := d.HeadPointer(ctx)
+			test.H(t).IsNil(err)
 
-affix:sha256:d9d06f42ded214cc216cfa218110bdba475bb5383acd97df8ddd957455592095
-affix 101\u00000 dummy_aggregate/68656c6c6f sha256:70df53d19786d92d1bfa4c2527bb819054495ea3756fcfd7d88e3d4c8fae3172
+			// Act
+			repeated, err := e.Apply(ctx, ioutil.Discard, sid, count)
+			test.H(t).IsNil(err)
 
+			// Assert
+			test.H(t).IntEql(cc.applied, 1)
+			test.H(t).BoolEql(repeated.Repeated, true)
+			repeated.Repeated = false
+			if diff := cmp.Diff(repeated, first); diff != "" {
+				t.Errorf("results differ: (-got +want)\n%s", diff)
+			}
+			newHead, err := d.HeadPointer(ctx)
+			test.H(t).IsNil(err)
+			test.H(t).StringEql(newHead.String(), head.String())
+		})
 
-event:sha256:dd176fd38eaf032d39e35e39f04de8f30406bb0eaea55affe847f91cc923f69f
-event json dummy_start_session_event 26\u0000{"Greeting":"hello world"}
+		t.Run("returns the names assigned to anonymous aggregates", func(t *testing.T) {
+			e, _, _, sid := setup(t, true)
 
-refs/heads/master -> sha256:0fbfebc09b8d49bb22618b2025e923efa801e69366c8ca781f7901930901b3ba
-`
-			if dd, ok := depot.(retro.DumpableDepot); !ok {
-				t.Fatal("could not upgrade depot to diff it")
-			} else {
-				var b bytes.Buffer
-				dd.DumpAll(&b)
-				var s = b.String()
+			first, err := e.Apply(ctx, ioutil.Discard, sid, relation)
+			test.H(t).IsNil(err)
+			test.H(t).IntEql(len(first.Named), 3)
+
+			// Act
+			repeated, err := e.Apply(ctx, ioutil.Discard, sid, relation)
+			test.H(t).IsNil(err)
+
+			// Assert
+			test.H(t).BoolEql(repeated.Repeated, true)
+			if diff := cmp.Diff(repeated.Named, first.Named); diff != "" {
+				t.Errorf("named aggregates differ: (-got +want)\n%s", diff)
+			}
+		})
+
+		t.Run("applies the command again once the window passed", func(t *testing.T) {
+			e, _, cc, sid := setup(t, true, WithIdempotencyWindow(time.Second))
+
+			_, err := e.Apply(ctx, ioutil.Discard, sid, count)
+			test.H(t).IsNil(err)
+
+			// Act
+			res, err := e.Apply(ctx, ioutil.Discard, sid, count)
+			test.H(t).IsNil(err)
+
+			// Assert
+			test.H(t).IntEql(cc.applied, 2)
+			test.H(t).BoolEql(res.Repeated, false)
+		})
+
+		t.Run("refuses a key repeated with another command", func(t *testing.T) {
+			e, _, _, sid := setup(t, true)
+
+			_, err := e.Apply(ctx, ioutil.Discard, sid, count)
+			test.H(t).IsNil(err)
+
+			// Act
+			_, err = e.Apply(ctx, ioutil.Discard, sid, []byte(`{"path":"dummy_aggregate/1", "name":"countingCmd", "args":{"n":2}, "idempotencyKey":"count-1"}`))
+
+			// Assert
+			if engineErr, ok := err.(Error); !ok || engineErr.Op != "idempotency-key-reused" {
+				t.Fatalf("expected an idempotency-key-reused error, got %v", err)
+			}
+		})
+
+		t.Run("refuses a key repeated by another session", func(t *testing.T) {
+			e, d, cc, sid := setup(t, true)
+
+			_, err := e.Apply(ctx, ioutil.Discard, sid, count)
+			test.H(t).IsNil(err)
+			otherSid, err := e.StartSession(ctx)
+			test.H(t).IsNil(err)
+			head, err := d.HeadPointer(ctx)
+			test.H(t).IsNil(err)
+
+			// Act
+			res, err := e.Apply(ctx, ioutil.Discard, otherSid, count)
+
+			// Assert
+			if engineErr, ok := err.(Error); !ok || engineErr.Op != "idempotency-key-reused" || !xerrors.Is(engineErr.Err, retro.ErrConflict) {
+				t.Fatalf("expected an idempotency-key-reused conflict, got %v", err)
+			}
+			test.H(t).BoolEql(res.Repeated, false)
+			test.H(t).IntEql(cc.applied, 1)
+			newHead, err := d.HeadPointer(ctx)
+			test.H(t).IsNil(err)
+			test.H(t).StringEql(newHead.String(), head.String())
+		})
+
+		t.Run("refuses keys without an index to look them up", func(t *testing.T) {
+			e, _, cc, sid := setup(t, false)
+
+			// Act
+			_, err := e.Apply(ctx, ioutil.Discard, sid, count)
+
+			// Assert
+			if engineErr, ok := err.(Error); !ok || !xerrors.Is(engineErr.Err, depot.ErrNoIndex) {
+				t.Fatalf("expected an error wrapping depot.ErrNoIndex, got %v", err)
+			}
+			test.H(t).IntEql(cc.applied, 0)
+		})
+	})
+
+	t.Run("middleware", func(t *testing.T) {
+
+		type rejected struct{ error }
+
+		var setup = func(t *testing.T, mws ...Middleware) (Engine, *memory.ObjectStore, retro.Depot, retro.SessionID) {
+			var (
+				objdb = &memory.ObjectStore{}
+				refdb = &memory.RefStore{}
+				d     = depot.NewSimple(objdb, refdb)
+				idFn  = func() (string, error) { return fmt.Sprintf("%x", []byte("hello")), nil }
+				clock = &Predictable5sJumpClock{}
+				aggM  = aggregates.NewManifest()
+				cmdM  = commands.NewManifest()
+				evM   = events.NewManifest()
+				repo  = repository.NewSimpleRepository(objdb, refdb, evM)
+			)
+
+			aggM.Register("agg", &dummyAggregate{})
+			cmdM.Register(&dummyAggregate{}, &dummyCmd{})
+			cmdM.Register(&dummyAggregate{}, &inspectCmd{})
+
+			aggM.Register("session", &dummySession{})
+			cmdM.Register(&dummySession{}, &Start{})
+
+			evM.Register(&DummyEvent{})
+			evM.Register(&DummyStartSessionEvent{})
+
+			var e = New(d, repo, resolver.New(aggM, cmdM), idFn, clock, aggM, evM, WithMiddleware(mws...))
+			sid, err := e.StartSession(context.Background())
+			test.H(t).IsNil(err)
+			return e, objdb, d, sid
+		}
+
+		var (
+			ctx = context.Background()
+			cmd = []byte(`{"path":"agg/123", "name":"dummyCmd"}`)
+		)
+
+		t.Run("runs the hooks in order", func(t *testing.T) {
+			var (
+				calls     []string
+				persisted ApplyResult
+				record    = func(name string) Middleware {
+					return Middleware{
+						BeforeResolve: func(_ context.Context, _ retro.SessionID, cmdDesc []byte) ([]byte, error) {
+							calls = append(calls, name+" before-resolve")
+							return cmdDesc, nil
+						},
+						AfterResolve: func(_ context.Context, cmd retro.Command, session retro.Session) error {
+							test.H(t).NotNil(cmd)
+							test.H(t).NotNil(session)
+							calls = append(calls, name+" after-resolve")
+							return nil
+						},
+						AfterApply: func(_ context.Context, _ retro.Command, _ retro.Session, cmdRes retro.CommandResult) error {
+							test.H(t).IntEql(len(cmdRes), 1)
+							calls = append(calls, name+" after-apply")
+							return nil
+						},
+						AfterPersist: func(_ context.Context, res ApplyResult) error {
+							persisted = res
+							calls = append(calls, name+" after-persist")
+							return nil
+						},
+					}
+				}
+				e, _, d, sid = setup(t, record("a"), record("b"))
+			)
+
+			// Act
+			res, err := e.Apply(ctx, ioutil.Discard, sid, cmd)
+			test.H(t).IsNil(err)
+
+			// Assert
+			var want = []string{
+				"a before-resolve", "b before-resolve",
+				"a after-resolve", "b after-resolve",
+				"a after-apply", "b after-apply",
+				"a after-persist", "b after-persist",
+			}
+			if diff := cmp.Diff(calls, want); diff != "" {
+				t.Errorf("calls differ: (-got +want)\n%s", diff)
+			}
+			head, err := d.HeadPointer(ctx)
+			test.H(t).IsNil(err)
+			test.H(t).StringEql(persisted.Checkpoint.String(), head.String())
+			test.H(t).StringEql(res.Checkpoint.String(), head.String())
+		})
+
+		t.Run("resolves the command description rewritten before resolve", func(t *testing.T) {
+			var e, _, _, sid = setup(t, Middleware{
+				BeforeResolve: func(_ context.Context, _ retro.SessionID, cmdDesc []byte) ([]byte, error) {
+					return bytes.Replace(cmdDesc, []byte("dummyCmd"), []byte("inspectCmd"), 1), nil
+				},
+			})
+
+			// Act
+			var b bytes.Buffer
+			res, err := e.Apply(ctx, &b, sid, cmd)
+
+			// Assert
+			test.H(t).IsNil(err)
+			test.H(t).StringEql(b.String(), "0 events")
+			test.H(t).IntEql(len(res.Partitions), 0)
+		})
+
+		for _, hook := range []string{"before-resolve", "after-resolve", "after-apply"} {
+			t.Run(fmt.Sprintf("short-circuits %s without writing anything", hook), func(t *testing.T) {
+				var (
+					reject = func(name string) error {
+						if name == hook {
+							return rejected{errors.New("no")}
+						}
+						return nil
+					}
+					e, objdb, d, sid = setup(t, Middleware{
+						BeforeResolve: func(_ context.Context, _ retro.SessionID, cmdDesc []byte) ([]byte, error) {
+							return cmdDesc, reject("before-resolve")
+						},
+						AfterResolve: func(context.Context, retro.Command, retro.Session) error {
+							return reject("after-resolve")
+						},
+						AfterApply: func(context.Context, retro.Command, retro.Session, retro.CommandResult) error {
+							return reject("after-apply")
+						},
+					})
+				)
+				head, err := d.HeadPointer(ctx)
+				test.H(t).IsNil(err)
+				var objects = len(objdb.Ls())
+
+				// Act
+				_, err = e.Apply(ctx, ioutil.Discard, sid, cmd)
+
+				// Assert
+				var hookErr HookError
+				if !xerrors.As(err, &hookErr) || hookErr.Hook != hook {
+					t.Fatalf("expected a HookError from %s, got %v", hook, err)
+				}
+				var rejectedErr rejected
+				test.H(t).BoolEql(xerrors.As(err, &rejectedErr), true)
+				test.H(t).IntEql(len(objdb.Ls()), objects)
+				newHead, err := d.HeadPointer(ctx)
+				test.H(t).IsNil(err)
+				test.H(t).StringEql(newHead.String(), head.String())
+			})
+		}
+
+		t.Run("returns the result alongside errors after persisting", func(t *testing.T) {
+			var e, _, d, sid = setup(t, Middleware{
+				AfterPersist: func(context.Context, ApplyResult) error { return rejected{errors.New("too late")} },
+			})
+
+			// Act
+			res, err := e.Apply(ctx, ioutil.Discard, sid, cmd)
+
+			// Assert
+			var hookErr HookError
+			if !xerrors.As(err, &hookErr) || hookErr.Hook != "after-persist" {
+				t.Fatalf("expected a HookError from after-persist, got %v", err)
+			}
+			head, err := d.HeadPointer(ctx)
+			test.H(t).IsNil(err)
+			test.H(t).StringEql(res.Checkpoint.String(), head.String())
+		})
+
+		t.Run("runs the hooks up to after-apply for queries", func(t *testing.T) {
+			var (
+				calls        []string
+				e, _, _, sid = setup(t, Middleware{
+					BeforeResolve: func(_ context.Context, _ retro.SessionID, cmdDesc []byte) ([]byte, error) {
+						calls = append(calls, "before-resolve")
+						return cmdDesc, nil
+					},
+					AfterResolve: func(context.Context, retro.Command, retro.Session) error {
+						calls = append(calls, "after-resolve")
+						return nil
+					},
+					AfterApply: func(context.Context, retro.Command, retro.Session, retro.CommandResult) error {
+						calls = append(calls, "after-apply")
+						return nil
+					},
+					AfterPersist: func(context.Context, ApplyResult) error {
+						calls = append(calls, "after-persist")
+						return nil
+					},
+				})
+			)
+
+			// Act
+			err := e.Query(ctx, ioutil.Discard, sid, []byte(`{"path":"agg/123", "name":"inspectCmd"}`))
+
+			// Assert
+			test.H(t).IsNil(err)
+			if diff := cmp.Diff(calls, []string{"before-resolve", "after-resolve", "after-apply"}); diff != "" {
+				t.Errorf("calls differ: (-got +want)\n%s", diff)
+			}
+		})
+	})
+
+	t.Run("concurrent writes", func(t *testing.T) {
+
+		type fixture struct {
+			objdb *memory.ObjectStore
+			d     retro.Depot
+			id    *interferingDepot
+			cc    *countingCmd
+			repo  retro.Repo
+			sid   retro.SessionID
+			// e applies commands through id, other stands in for
+			// another process, it applies commands straight to d with
+			// its own repository (and so its own claims) using an
+			// IDFn which yields other names for new aggregates unless
+			// it is told to collide.
+			e, other Engine
+		}
+
+		var setup = func(t *testing.T, collide bool, opts ...Option) fixture {
+			var (
+				objdb           = &memory.ObjectStore{}
+				refdb           = &memory.RefStore{}
+				d               = depot.NewSimple(objdb, refdb)
+				id              = &interferingDepot{Depot: d}
+				idFn            = func() (string, error) { return fmt.Sprintf("%x", []byte("hello")), nil }
+				otherIDFn       = func() (string, error) { return fmt.Sprintf("%x", []byte("world")), nil }
+				clock           = &Predictable5sJumpClock{}
+				aggM            = aggregates.NewManifest()
+				cmdM            = commands.NewManifest()
+				evM             = events.NewManifest()
+				otherRepository = repository.NewSimpleRepository(objdb, refdb, evM)
+				repository      = repository.NewSimpleRepository(objdb, refdb, evM)
+				cc              = &countingCmd{}
+			)
+
+			aggM.Register("agg", &dummyAggregate{})
+			cmdM.Register(&dummyAggregate{}, &dummyCmd{})
+			cmdM.Register(&dummyAggregate{}, cc)
+
+			aggM.Register("session", &dummySession{})
+			cmdM.Register(&dummySession{}, &Start{})
+
+			evM.Register(&DummyEvent{})
+			evM.Register(&DummyStartSessionEvent{})
+
+			if collide {
+				otherIDFn = idFn
+			}
+
+			var (
+				r     = resolver.New(aggM, cmdM)
+				e     = New(id, repository, r, idFn, clock, aggM, evM, opts...)
+				other = New(d, otherRepository, r, otherIDFn, clock, aggM, evM)
+			)
+
+			sid, err := e.StartSession(context.Background())
+			test.H(t).IsNil(err)
+
+			return fixture{objdb, d, id, cc, repository, sid, e, other}
+		}
+
+		var parentsOfHead = func(t *testing.T, f fixture) []string {
+			head, err := f.d.HeadPointer(context.Background())
+			test.H(t).IsNil(err)
+			packedCheckpoint, err := f.objdb.RetrievePacked(head.String())
+			test.H(t).IsNil(err)
+			checkpoint, err := packing.NewJSONPacker().UnpackCheckpoint(packedCheckpoint.Contents())
+			test.H(t).IsNil(err)
+			var res []string
+			for _, p := range checkpoint.ParentHashes {
+				res = append(res, p.String())
+			}
+			return res
+		}
+
+		t.Run("waits for claims on the target aggregate and the session", func(t *testing.T) {
+			for _, claimed := range []string{"agg/123", "session/68656c6c6f"} {
+				var (
+					ctx = context.Background()
+					f   = setup(t, false, WithClaimTimeout(20*time.Millisecond))
+				)
+
+				test.H(t).BoolEql(f.repo.Claim(ctx, claimed), true)
+
+				_, err := f.e.Apply(ctx, ioutil.Discard, f.sid, []byte(`{"path":"agg/123", "name":"countingCmd"}`))
+				test.H(t).NotNil(err)
+				test.H(t).IntEql(f.cc.applied, 0)
+
+				f.repo.Release(claimed)
+
+				_, err = f.e.Apply(ctx, ioutil.Discard, f.sid, []byte(`{"path":"agg/123", "name":"countingCmd"}`))
+				test.H(t).IsNil(err)
+				test.H(t).IntEql(f.cc.applied, 1)
+			}
+		})
+
+		t.Run("re-parents the checkpoint if unrelated partitions were written", func(t *testing.T) {
+			var (
+				ctx       = context.Background()
+				f         = setup(t, false)
+				otherHead retro.Hash
+			)
+
+			f.id.interfere = func() {
+				f.id.interfere = nil
+				_, err := f.other.Apply(ctx, ioutil.Discard, f.sid, []byte(`{"path":"agg/456", "name":"dummyCmd"}`))
+				test.H(t).IsNil(err)
+				otherHead, err = f.d.HeadPointer(ctx)
+				test.H(t).IsNil(err)
+			}
+
+			_, err := f.e.Apply(ctx, ioutil.Discard, f.sid, []byte(`{"path":"agg/123", "name":"countingCmd"}`))
+			test.H(t).IsNil(err)
+
+			test.H(t).IntEql(f.cc.applied, 1)
+			if diff := cmp.Diff(parentsOfHead(t, f), []string{otherHead.String()}); diff != "" {
+				t.Errorf("expected checkpoint to be re-parented: (-got +want)\n%s", diff)
+			}
+		})
+
+		t.Run("re-runs the command if the partitions it wrote were written", func(t *testing.T) {
+			var (
+				ctx       = context.Background()
+				f         = setup(t, true)
+				otherHead retro.Hash
+			)
+
+			f.id.interfere = func() {
+				f.id.interfere = nil
+				_, err := f.other.Apply(ctx, ioutil.Discard, f.sid, []byte(`{"path":"agg/456", "name":"dummyCmd"}`))
+				test.H(t).IsNil(err)
+				otherHead, err = f.d.HeadPointer(ctx)
+				test.H(t).IsNil(err)
+			}
+
+			_, err := f.e.Apply(ctx, ioutil.Discard, f.sid, []byte(`{"path":"agg/123", "name":"countingCmd"}`))
+			test.H(t).IsNil(err)
+
+			test.H(t).IntEql(f.cc.applied, 2)
+			if diff := cmp.Diff(parentsOfHead(t, f), []string{otherHead.String()}); diff != "" {
+				t.Errorf("expected checkpoint to follow the concurrent write: (-got +want)\n%s", diff)
+			}
+		})
+
+		t.Run("gives up with a ConflictError after the configured retries", func(t *testing.T) {
+			var (
+				ctx = context.Background()
+				f   = setup(t, true, WithConflictRetries(2))
+			)
+
+			f.id.interfere = func() {
+				_, err := f.other.Apply(ctx, ioutil.Discard, f.sid, []byte(`{"path":"agg/456", "name":"dummyCmd"}`))
+				test.H(t).IsNil(err)
+			}
+
+			_, err := f.e.Apply(ctx, ioutil.Discard, f.sid, []byte(`{"path":"agg/123", "name":"countingCmd"}`))
+			if !xerrors.Is(err, ErrConcurrentWrite) {
+				t.Fatalf("expected a concurrent write error, got %v", err)
+			}
+			var cErr ConflictError
+			if !xerrors.As(err, &cErr) {
+				t.Fatalf("expected error to be a ConflictError, got %T", err)
+			}
+			test.H(t).IntEql(cErr.Attempts, 3)
+			test.H(t).IntEql(f.cc.applied, 3)
+			if diff := cmp.Diff(cErr.Partitions, []retro.PartitionName{"dummy_aggregate/68656c6c6f"}); diff != "" {
+				t.Errorf("conflicting partitions differ: (-got +want)\n%s", diff)
+			}
+		})
+	})
+
+	t.Run("storage", func(t *testing.T) {
+		t.Run("applies commands and stores resulting events in case of success", func(t *testing.T) {
+
+			// Arrange
+			var (
+				objdb      = &memory.ObjectStore{}
+				refdb      = &memory.RefStore{}
+				depot      = depot.NewSimple(objdb, refdb)
+				idFn       = func() (string, error) { return fmt.Sprintf("%x", []byte("hello")), nil }
+				clock      = &Predictable5sJumpClock{}
+				aggM       = aggregates.NewManifest()
+				cmdM       = commands.NewManifest()
+				evM        = events.NewManifest()
+				repository = repository.NewSimpleRepository(objdb, refdb, evM)
+
+				err error
+			)
+
+			aggM.Register("agg", &dummyAggregate{})
+			cmdM.Register(&dummyAggregate{}, &dummyCmd{})
+
+			aggM.Register("session", &dummySession{})
+			cmdM.Register(&dummySession{}, &Start{})
+
+			evM.Register(&DummyEvent{})
+			evM.Register(&DummyStartSessionEvent{})
+
+			var (
+				r   = resolver.New(aggM, cmdM)
+				e   = New(depot, repository, r, idFn, clock, aggM, evM)
+				ctx = context.Background()
+			)
+
+			// Act
+			sid, err := e.StartSession(ctx)
+			test.H(t).IsNil(err)
+
+			var b bytes.Buffer
+			res, err := e.Apply(ctx, &b, sid, []byte(`{"path":"agg/123", "name":"dummyCmd"}`))
+			test.H(t).IsNil(err)
+
+			test.H(t).StringEql(res.Checkpoint.String(), "sha256:4d3e074fe328352792761202b15bbd61c2be1bd22f9c7048c9439859513021e2")
+			test.H(t).StringEql(res.PreviousHead.String(), "sha256:01c99ba2c37b4dbaa7dc46bbf899d28b686a84bc04694c06453b7f977f4f8578")
+			if diff := cmp.Diff(res.Partitions, []retro.PartitionName{"dummy_aggregate/68656c6c6f"}); diff != "" {
+				t.Errorf("partitions differ: (-got +want)\n%s", diff)
+			}
+			if diff := cmp.Diff(res.Named, []retro.PartitionName{"dummy_aggregate/68656c6c6f"}); diff != "" {
+				t.Errorf("named aggregates differ: (-got +want)\n%s", diff)
+			}
+			var evs = res.Events["dummy_aggregate/68656c6c6f"]
+			test.H(t).IntEql(len(evs), 1)
+			test.H(t).StringEql(evs[0].Name, "dummy_event")
+			test.H(t).StringEql(evs[0].Hash.String(), "sha256:70df53d19786d92d1bfa4c2527bb819054495ea3756fcfd7d88e3d4c8fae3172")
+
+			var expected = `checkpoint:sha256:01c99ba2c37b4dbaa7dc46bbf899d28b686a84bc04694c06453b7f977f4f8578
+checkpoint 229\u0000affix sha256:d45315bfa144411d8362ab68c56808ae92c20cfcbbf3abc35c57db5d08c871d5
+command sha256:23ae4a068c221d877836ce00655cc4a82c929742b2229b39d096cb7ddf5632c8
+date 0001-01-01T00:00:00Z
+session 68656c6c6f
+
+session/68656c6c6f/Start
+
+
+command:sha256:23ae4a068c221d877836ce00655cc4a82c929742b2229b39d096cb7ddf5632c8
+command json 44\u0000{"path":"session/68656c6c6f","name":"Start"}
+
+command:sha256:42829bd8b5faa919ff4845d08bafb9ed8442f047cf304cec114523c2e3eafc62
+command json 36\u0000{"path":"agg/123","name":"dummyCmd"}
+
+checkpoint:sha256:4d3e074fe328352792761202b15bbd61c2be1bd22f9c7048c9439859513021e2
+checkpoint 300\u0000affix sha256:d9d06f42ded214cc216cfa218110bdba475bb5383acd97df8ddd957455592095
+parent sha256:01c99ba2c37b4dbaa7dc46bbf899d28b686a84bc04694c06453b7f977f4f8578
+command sha256:42829bd8b5faa919ff4845d08bafb9ed8442f047cf304cec114523c2e3eafc62
+date 0001-01-01T00:00:05Z
+session 68656c6c6f
+
+agg/123/dummyCmd
+
+
+event:sha256:70df53d19786d92d1bfa4c2527bb819054495ea3756fcfd7d88e3d4c8fae3172
+event json dummy_event 2\u0000{}
+
+affix:sha256:d45315bfa144411d8362ab68c56808ae92c20cfcbbf3abc35c57db5d08c871d5
+affix 99\u00000 dummy_session/68656c6c6f sha256:dd176fd38eaf032d39e35e39f04de8f30406bb0eaea55affe847f91cc923f69f
+
+
+affix:sha256:d9d06f42ded214cc216cfa218110bdba475bb5383acd97df8ddd957455592095
+affix 101\u00000 dummy_aggregate/68656c6c6f sha256:70df53d19786d92d1bfa4c2527bb819054495ea3756fcfd7d88e3d4c8fae3172
+
+
+event:sha256:dd176fd38eaf032d39e35e39f04de8f30406bb0eaea55affe847f91cc923f69f
+event json dummy_start_session_event 26\u0000{"Greeting":"hello world"}
+
+refs/heads/master -> sha256:4d3e074fe328352792761202b15bbd61c2be1bd22f9c7048c9439859513021e2
+`
+			if dd, ok := depot.(retro.DumpableDepot); !ok {
+				t.Fatal("could not upgrade depot to diff it")
+			} else {
+				var b bytes.Buffer
+				dd.DumpAll(&b)
+				var s = b.String()
 				var diff = cmp.Diff(s, expected)
 				if diff != "" {
 					fmt.Println(s)
@@ -630,6 +1564,123 @@ refs/heads/master -> sha256:0fbfebc09b8d49bb22618b2025e923efa801e69366c8ca781f79
 			}
 		})
 
+		t.Run("stores the command with redacted args alongside the checkpoint", func(t *testing.T) {
+
+			// Arrange
+			var (
+				objdb      = &memory.ObjectStore{}
+				refdb      = &memory.RefStore{}
+				depot      = depot.NewSimple(objdb, refdb)
+				idFn       = func() (string, error) { return fmt.Sprintf("%x", []byte("hello")), nil }
+				clock      = &Predictable5sJumpClock{}
+				aggM       = aggregates.NewManifest()
+				cmdM       = commands.NewManifest()
+				evM        = events.NewManifest()
+				repository = repository.NewSimpleRepository(objdb, refdb, evM)
+				jp         = packing.NewJSONPacker()
+			)
+
+			aggM.Register("agg", &dummyAggregate{})
+			cmdM.RegisterWithArgs(&dummyAggregate{}, &loginCmd{}, &loginArgs{})
+
+			aggM.Register("session", &dummySession{})
+			cmdM.Register(&dummySession{}, &Start{})
+
+			evM.Register(&DummyEvent{})
+			evM.Register(&DummyStartSessionEvent{})
+
+			var (
+				r   = resolver.New(aggM, cmdM)
+				e   = New(depot, repository, r, idFn, clock, aggM, evM)
+				ctx = context.Background()
+			)
+
+			// Act
+			sid, err := e.StartSession(ctx)
+			test.H(t).IsNil(err)
+
+			_, err = e.Apply(ctx, ioutil.Discard, sid, []byte(`{"path":"agg/123","name":"loginCmd","args":{"password":"hunter2","name":"alice"}}`))
+			test.H(t).IsNil(err)
+
+			// Assert
+			head, err := refdb.Retrieve(ref.DefaultBranch)
+			test.H(t).IsNil(err)
+			packedCheckpoint, err := objdb.RetrievePacked(head.String())
+			test.H(t).IsNil(err)
+			checkpoint, err := jp.UnpackCheckpoint(packedCheckpoint.Contents())
+			test.H(t).IsNil(err)
+			test.H(t).NotNil(checkpoint.CommandHash)
+
+			packedCommand, err := objdb.RetrievePacked(checkpoint.CommandHash.String())
+			test.H(t).IsNil(err)
+			test.H(t).StringEql(string(packedCommand.Type()), string(packing.ObjectTypeCommand))
+
+			command, err := jp.UnpackCommand(packedCommand.Contents())
+			test.H(t).IsNil(err)
+			test.H(t).StringEql(command.Path, "agg/123")
+			test.H(t).StringEql(command.Name, "loginCmd")
+			test.H(t).StringEql(string(command.Args), `{"name":"alice"}`)
+			if diff := cmp.Diff(command.Redacted, []string{"args.password"}); diff != "" {
+				t.Errorf("redacted args differ: (-got +want)\n%s", diff)
+			}
+		})
+
+		t.Run("stores nothing on a dry run", func(t *testing.T) {
+
+			// Arrange
+			var (
+				objdb      = &memory.ObjectStore{}
+				refdb      = &memory.RefStore{}
+				depot      = depot.NewSimple(objdb, refdb)
+				idFn       = func() (string, error) { return fmt.Sprintf("%x", []byte("hello")), nil }
+				clock      = &Predictable5sJumpClock{}
+				aggM       = aggregates.NewManifest()
+				cmdM       = commands.NewManifest()
+				evM        = events.NewManifest()
+				repository = repository.NewSimpleRepository(objdb, refdb, evM)
+			)
+
+			aggM.Register("agg", &dummyAggregate{})
+			cmdM.Register(&dummyAggregate{}, &dummyCmd{})
+
+			aggM.Register("session", &dummySession{})
+			cmdM.Register(&dummySession{}, &Start{})
+
+			evM.Register(&DummyEvent{})
+			evM.Register(&DummyStartSessionEvent{})
+
+			var (
+				r   = resolver.New(aggM, cmdM)
+				e   = New(depot, repository, r, idFn, clock, aggM, evM)
+				ctx = context.Background()
+			)
+
+			sid, err := e.StartSession(ctx)
+			test.H(t).IsNil(err)
+			head, err := refdb.Retrieve(ref.DefaultBranch)
+			test.H(t).IsNil(err)
+			var objects = len(objdb.Ls())
+
+			// Act
+			var b bytes.Buffer
+			res, err := e.DryRun(ctx, &b, sid, []byte(`{"path":"agg/123", "name":"dummyCmd"}`))
+			test.H(t).IsNil(err)
+
+			// Assert
+			test.H(t).BoolEql(res.DryRun, true)
+			test.H(t).StringEql(res.PreviousHead.String(), head.String())
+			test.H(t).IntEql(len(res.Events["dummy_aggregate/68656c6c6f"]), 1)
+			test.H(t).IntEql(len(res.Errors), 0)
+			test.H(t).StringEql("ok", b.String())
+
+			test.H(t).IntEql(len(objdb.Ls()), objects)
+			_, err = objdb.RetrievePacked(res.Checkpoint.String())
+			test.H(t).NotNil(err)
+			newHead, err := refdb.Retrieve(ref.DefaultBranch)
+			test.H(t).IsNil(err)
+			test.H(t).StringEql(newHead.String(), head.String())
+		})
+
 		t.Run("moves the headpointer (ff) incase of success", func(t *testing.T) {
 
 		})
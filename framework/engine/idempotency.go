@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/retro-framework/go-retro/framework/packing"
+	"github.com/retro-framework/go-retro/framework/retro"
+)
+
+// DefaultIdempotencyWindow is how long an idempotency key is remembered
+// unless WithIdempotencyWindow says otherwise.
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+// WithIdempotencyWindow sets how long after a checkpoint was stored with an
+// idempotency key a command carrying the same key is answered with the
+// result of that checkpoint rather than being applied again.
+func WithIdempotencyWindow(d time.Duration) Option {
+	return func(e *Engine) {
+		e.idempotencyWindow = d
+	}
+}
+
+// idempotencyKey returns the idempotency key given in the command
+// descriptions, e.g:
+//
+//	{"path":"listing/123", "name":"publish", "idempotencyKey":"7f3c..."}
+//
+// The commands of a batch share the checkpoint and thus the key, it may be
+// given on any of them but they mustn't disagree.
+func idempotencyKey(cmds [][]byte) (string, error) {
+	var key string
+	for _, cmd := range cmds {
+		var desc = struct {
+			IdempotencyKey string `json:"idempotencyKey"`
+		}{}
+		// Malformed descriptions are reported by the resolver.
+		if err := json.Unmarshal(cmd, &desc); err != nil || desc.IdempotencyKey == "" {
+			continue
+		}
+		if strings.ContainsAny(desc.IdempotencyKey, "\r\n") {
+			return "", Error{Op: "idempotency-key", Err: retro.ErrBadArgs, Msg: "idempotency keys must not contain line breaks"}
+		}
+		if key != "" && key != desc.IdempotencyKey {
+			return "", Error{Op: "idempotency-key", Err: retro.ErrBadArgs, Msg: fmt.Sprintf("commands carry different idempotency keys %q and %q", key, desc.IdempotencyKey)}
+		}
+		key = desc.IdempotencyKey
+	}
+	return key, nil
+}
+
+// repeated looks up the checkpoint stored with key within the idempotency
+// window and describes it, if there is one. Keys are scoped to the session
+// which first used them, repeating a key from another session or with
+// other commands than it was first used with is an error.
+func (e *Engine) repeated(ctx context.Context, sid retro.SessionID, key string, cmds [][]byte) (ApplyResult, bool, error) {
+
+	kd, ok := e.depot.(retro.KeyedDepot)
+	if !ok {
+		return ApplyResult{}, false, Error{Op: "idempotency-key", Msg: "depot can't look up idempotency keys"}
+	}
+
+	checkpointHash, at, err := kd.Keyed(ctx, key)
+	if err != nil {
+		return ApplyResult{}, false, Error{"idempotency-key", err, fmt.Sprintf("could not look up idempotency key %q", key)}
+	}
+	if checkpointHash == nil || e.clock.Now().Sub(at) > e.idempotencyWindow {
+		return ApplyResult{}, false, nil
+	}
+
+	res, checkpoint, err := describe(e.packer, kd, checkpointHash)
+	if err != nil {
+		return ApplyResult{}, false, Error{"idempotency-key", err, fmt.Sprintf("could not read checkpoint %s", checkpointHash)}
+	}
+
+	// The result of another session's command must neither be revealed
+	// nor stand in for a command which was never authorized.
+	if checkpoint.Fields["session"] != string(sid) {
+		return ApplyResult{}, false, Error{Op: "idempotency-key-reused", Err: retro.ErrConflict, Msg: fmt.Sprintf("idempotency key %q was used by another session", key)}
+	}
+
+	packedCommand, err := e.packCommand(cmds...)
+	if err != nil {
+		return ApplyResult{}, false, Error{"idempotency-key", err, "could not pack command"}
+	}
+	if checkpoint.CommandHash == nil || checkpoint.CommandHash.String() != packedCommand.Hash().String() {
+		return ApplyResult{}, false, Error{Op: "idempotency-key-reused", Err: retro.ErrConflict, Msg: fmt.Sprintf("idempotency key %q was used for another command in %s", key, checkpointHash)}
+	}
+
+	res.Repeated = true
+	return res, true, nil
+}
+
+// describe reads a stored checkpoint back into the ApplyResult which was
+// returned when it was written.
+func describe(jp packing.Packer, src retro.KeyedDepot, checkpointHash retro.Hash) (ApplyResult, packing.Checkpoint, error) {
+
+	var (
+		res = ApplyResult{
+			Checkpoint: checkpointHash,
+			Events:     make(map[retro.PartitionName][]WrittenEvent),
+		}
+	)
+
+	packedCheckpoint, err := src.RetrievePacked(checkpointHash.String())
+	if err != nil {
+		return res, packing.Checkpoint{}, err
+	}
+	checkpoint, err := jp.UnpackCheckpoint(packedCheckpoint.Contents())
+	if err != nil {
+		return res, checkpoint, err
+	}
+	if len(checkpoint.ParentHashes) > 0 {
+		res.PreviousHead = checkpoint.ParentHashes[0]
+	}
+	for _, name := range strings.Fields(checkpoint.Fields[packing.FieldNamed]) {
+		res.Named = append(res.Named, retro.PartitionName(name))
+	}
+
+	packedAffix, err := src.RetrievePacked(checkpoint.AffixHash.String())
+	if err != nil {
+		return res, checkpoint, err
+	}
+	affix, err := jp.UnpackAffix(packedAffix.Contents())
+	if err != nil {
+		return res, checkpoint, err
+	}
+
+	for pn, evHashes := range affix {
+		res.Partitions = append(res.Partitions, pn)
+		for _, evHash := range evHashes {
+			packedEv, err := src.RetrievePacked(evHash.String())
+			if err != nil {
+				return res, checkpoint, err
+			}
+			name, _, err := jp.UnpackEvent(packedEv.Contents())
+			if err != nil {
+				return res, checkpoint, err
+			}
+			res.Events[pn] = append(res.Events[pn], WrittenEvent{name, evHash})
+		}
+	}
+	sort.Slice(res.Partitions, func(i, j int) bool { return res.Partitions[i] < res.Partitions[j] })
+
+	return res, checkpoint, nil
+}
@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/retro-framework/go-retro/framework/retro"
+)
+
+// Middleware hooks into the application of every command, e.g to
+// authorize, rate limit, audit or sanitize commands without touching the
+// Engine. Any hook may be nil. A hook returning an error short-circuits
+// the command, the error is returned wrapped in a HookError which names
+// the hook, use xerrors.As to get at the middleware's own error types.
+//
+// Commands of a batch are each passed through the resolve and apply
+// hooks, AfterPersist sees the single checkpoint written for the batch.
+// Query runs the hooks up to AfterApply, it persists nothing.
+type Middleware struct {
+
+	// BeforeResolve sees the command description as sent by the client
+	// and returns the description to resolve, which may be rewritten
+	// (e.g to sanitize args). It runs once, before any aggregate is
+	// claimed or rehydrated.
+	BeforeResolve func(ctx context.Context, sid retro.SessionID, cmdDesc []byte) ([]byte, error)
+
+	// AfterResolve sees the resolved command, with its aggregate, and
+	// the rehydrated session (nil if there is none) before the command
+	// is applied.
+	AfterResolve func(ctx context.Context, cmd retro.Command, session retro.Session) error
+
+	// AfterApply sees the events yielded by the command before they are
+	// persisted.
+	AfterApply func(ctx context.Context, cmd retro.Command, session retro.Session, cmdRes retro.CommandResult) error
+
+	// AfterPersist sees the checkpoint once it is written and the head
+	// pointer moved, an error can't undo the write, Apply returns it
+	// alongside the ApplyResult. It doesn't run for dry runs or repeated
+	// idempotency keys, which write nothing.
+	AfterPersist func(ctx context.Context, res ApplyResult) error
+}
+
+// WithMiddleware adds middleware to the Engine, hooks run in the order
+// the middleware was added.
+//
+// AfterResolve and AfterApply run again whenever a command is re-run
+// because of a conflicting concurrent write (see WithConflictRetries),
+// they should not count or record anything which must happen once.
+func WithMiddleware(mws ...Middleware) Option {
+	return func(e *Engine) {
+		e.middleware = append(e.middleware, mws...)
+	}
+}
+
+// HookError is returned when a Middleware hook short-circuits a command.
+type HookError struct {
+	Hook string
+	Err  error
+}
+
+func (e HookError) Error() string {
+	return fmt.Sprintf("engine: %s hook: %s", e.Hook, e.Err)
+}
+
+// Unwrap returns the error returned by the hook.
+func (e HookError) Unwrap() error {
+	return e.Err
+}
+
+func (e *Engine) beforeResolve(ctx context.Context, sid retro.SessionID, cmdDesc []byte) ([]byte, error) {
+	for _, mw := range e.middleware {
+		if mw.BeforeResolve == nil {
+			continue
+		}
+		var err error
+		if cmdDesc, err = mw.BeforeResolve(ctx, sid, cmdDesc); err != nil {
+			return nil, HookError{"before-resolve", err}
+		}
+	}
+	return cmdDesc, nil
+}
+
+func (e *Engine) afterResolve(ctx context.Context, cmd retro.Command, session retro.Session) error {
+	for _, mw := range e.middleware {
+		if mw.AfterResolve == nil {
+			continue
+		}
+		if err := mw.AfterResolve(ctx, cmd, session); err != nil {
+			return HookError{"after-resolve", err}
+		}
+	}
+	return nil
+}
+
+func (e *Engine) afterApply(ctx context.Context, cmd retro.Command, session retro.Session, cmdRes retro.CommandResult) error {
+	for _, mw := range e.middleware {
+		if mw.AfterApply == nil {
+			continue
+		}
+		if err := mw.AfterApply(ctx, cmd, session, cmdRes); err != nil {
+			return HookError{"after-apply", err}
+		}
+	}
+	return nil
+}
+
+func (e *Engine) afterPersist(ctx context.Context, res ApplyResult) error {
+	for _, mw := range e.middleware {
+		if mw.AfterPersist == nil {
+			continue
+		}
+		if err := mw.AfterPersist(ctx, res); err != nil {
+			return HookError{"after-persist", err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/retro-framework/go-retro/framework/ref"
+	"github.com/retro-framework/go-retro/framework/retro"
+	"golang.org/x/xerrors"
+)
+
+// Query resolves and applies a read-only command, one which renders state
+// but yields no events, nothing is ever persisted. Commands which yield
+// events fail with an Error with Op "read-only-command". What the command
+// renders is written to w, there is no result beyond that. sid may be
+// empty, the command then sees a fresh session.
+//
+// If ctx is pinned (see ref.WithPin) the command's aggregate and any
+// aggregates it rehydrates are seen as they were at that point, which
+// allows inspecting past state, e.g "what did identity/abc look like last
+// Tuesday?". The session is always rehydrated from the head, the session
+// doing the inspecting may not have existed at that point.
+func (e *Engine) Query(ctx context.Context, w io.Writer, sid retro.SessionID, cmd []byte) error {
+
+	spnQuery, ctx := opentracing.StartSpanFromContext(ctx, "engine.Query")
+	ctx = retro.WithClock(ctx, e.clock)
+	spnQuery.SetTag("payload", string(cmd))
+	defer spnQuery.Finish()
+
+	if e.aggm == nil {
+		return Error{"agg-manifest-missing", nil, "aggregate manifest not available, please check config."}
+	}
+	if e.resolver == nil {
+		return Error{"resolver-missing", nil, "resolver not available, please check config."}
+	}
+
+	cmd, err := e.beforeResolve(ctx, sid, cmd)
+	if err != nil {
+		spnQuery.SetTag("error", err.Error())
+		return err
+	}
+
+	seshAgg, err := e.session(ref.WithPin(ctx, ref.Pin{}), e.repository, sid)
+	if err != nil {
+		spnQuery.SetTag("error", err.Error())
+		return err
+	}
+	ctx = retro.WithSession(ctx, seshAgg)
+
+	command, err := e.resolver.Resolve(ctx, e.repository, cmd)
+	if err != nil {
+		return xerrors.Errorf("Couldn't resolve %s: %w", cmd, err)
+	}
+
+	if err := e.afterResolve(ctx, command, seshAgg); err != nil {
+		spnQuery.SetTag("error", err.Error())
+		return err
+	}
+
+	newEvs, err := command.Apply(ctx, w, seshAgg, e.repository)
+	if err != nil {
+		return xerrors.Errorf("error applying command: %w", err)
+	}
+
+	if err := e.afterApply(ctx, command, seshAgg, newEvs); err != nil {
+		spnQuery.SetTag("error", err.Error())
+		return err
+	}
+
+	for _, evs := range newEvs {
+		if len(evs) == 0 {
+			continue
+		}
+		err := Error{Op: "read-only-command", Msg: fmt.Sprintf("command %s yielded events, it can't be queried", cmd)}
+		spnQuery.SetTag("error", err.Error())
+		return err
+	}
+
+	if err := render(ctx, w, command, seshAgg, newEvs); err != nil {
+		return err
+	}
+
+	return nil
+}
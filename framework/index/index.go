@@ -0,0 +1,404 @@
+// Package index maintains a secondary index from partition names to the
+// checkpoints (and the events therein) which touched them. Without it,
+// finding the events of a single partition means walking every checkpoint
+// back from the head and unpacking every affix.
+//
+// Checkpoints stored with an idempotency key (see
+// packing.FieldIdempotencyKey) are indexed by their key too, so that a
+// repeated key can be detected without walking the history.
+//
+// The index is kept in memory and is derived entirely from the object
+// graph, it can be rebuilt at any time. Checkpoints are added as they are
+// stored, anything missed (e.g written by another process) is picked up
+// lazily the first time a head which can reach it is looked up.
+package index
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/retro-framework/go-retro/framework/object"
+	"github.com/retro-framework/go-retro/framework/packing"
+	"github.com/retro-framework/go-retro/framework/retro"
+	"github.com/retro-framework/go-retro/framework/storage"
+)
+
+// Entry records the events a single checkpoint holds for a single
+// partition.
+type Entry struct {
+	Partition  retro.PartitionName
+	Checkpoint retro.Hash
+	Time       time.Time
+	Events     []retro.Hash
+
+	generation int
+}
+
+// KeyEntry records a checkpoint which was stored with an idempotency key.
+type KeyEntry struct {
+	Key        string
+	Checkpoint retro.Hash
+	Time       time.Time
+
+	generation int
+}
+
+type node struct {
+	parents []retro.Hash
+
+	// generation is one more than the highest generation of any parent,
+	// ordering by generation never puts a checkpoint before its parents.
+	generation int
+}
+
+// reach caches the set of checkpoints reachable from the head of a branch
+// so that it can be extended cheaply as the branch moves forward.
+type reach struct {
+	head string
+	set  map[string]bool
+}
+
+// maxPinned is how many sets of checkpoints reachable from pinned heads
+// (lookups without a branch) are cached, see reachable.
+const maxPinned = 16
+
+// Index maps partition names to the checkpoints which touched them, it is
+// safe for concurrent use and is typically shared by a Depot and a Repo
+// reading from the same object store.
+type Index struct {
+	objdb  object.Source
+	packer packing.Packer
+
+	mu          sync.Mutex
+	checkpoints map[string]node
+	partitions  map[retro.PartitionName][]Entry
+	keys        map[string][]KeyEntry
+	branches    map[string]*reach
+	pinned      map[string]map[string]bool
+}
+
+// Option configures an Index, see New.
+type Option func(*Index)
+
+// WithPacker makes the Index unpack checkpoints and affixes with p, the
+// default is a packing.JSONPacker.
+func WithPacker(p packing.Packer) Option {
+	return func(i *Index) {
+		i.packer = p
+	}
+}
+
+// New returns an empty index reading objects from objdb.
+func New(objdb object.Source, opts ...Option) *Index {
+	var i = &Index{objdb: objdb, packer: packing.NewJSONPacker()}
+	for _, opt := range opts {
+		opt(i)
+	}
+	i.reset()
+	return i
+}
+
+func (i *Index) reset() {
+	i.checkpoints = make(map[string]node)
+	i.partitions = make(map[retro.PartitionName][]Entry)
+	i.keys = make(map[string][]KeyEntry)
+	i.branches = make(map[string]*reach)
+	i.pinned = make(map[string]map[string]bool)
+}
+
+// Add indexes the checkpoint and any of its ancestors which are not
+// indexed yet. Adding a checkpoint twice is a no-op.
+func (i *Index) Add(checkpointHash retro.Hash) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.add(checkpointHash)
+}
+
+// Rebuild throws away everything indexed and indexes the history of all
+// heads, typically the heads of all refs.
+func (i *Index) Rebuild(heads ...retro.Hash) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.reset()
+	for _, head := range heads {
+		if err := i.add(head); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Entries returns the entries for the partition which are reachable from
+// head, oldest first. branch is used only to cache reachability, it may
+// be empty.
+func (i *Index) Entries(branch string, head retro.Hash, pn retro.PartitionName) ([]Entry, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.add(head); err != nil {
+		return nil, err
+	}
+	var (
+		reachable = i.reachable(branch, head)
+		res       []Entry
+	)
+	for _, e := range i.partitions[pn] {
+		if reachable[e.Checkpoint.String()] {
+			res = append(res, e)
+		}
+	}
+	sortEntries(res)
+	return res, nil
+}
+
+// Matching returns the entries for all partitions matched by m which are
+// reachable from head, oldest first.
+func (i *Index) Matching(branch string, head retro.Hash, m retro.Matcher) ([]Entry, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.add(head); err != nil {
+		return nil, err
+	}
+	var (
+		reachable = i.reachable(branch, head)
+		res       []Entry
+	)
+	for pn, entries := range i.partitions {
+		matched, err := m.DoesMatch(string(pn))
+		if err != nil {
+			return nil, errors.Wrapf(err, "error checking partition name %s for match", pn)
+		}
+		if !matched {
+			continue
+		}
+		for _, e := range entries {
+			if reachable[e.Checkpoint.String()] {
+				res = append(res, e)
+			}
+		}
+	}
+	sortEntries(res)
+	return res, nil
+}
+
+// Keyed returns the entries for the checkpoints stored with the
+// idempotency key which are reachable from head, oldest first.
+func (i *Index) Keyed(branch string, head retro.Hash, key string) ([]KeyEntry, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.add(head); err != nil {
+		return nil, err
+	}
+	var (
+		reachable = i.reachable(branch, head)
+		res       []KeyEntry
+	)
+	for _, e := range i.keys[key] {
+		if reachable[e.Checkpoint.String()] {
+			res = append(res, e)
+		}
+	}
+	sort.Slice(res, func(a, b int) bool {
+		if res[a].generation != res[b].generation {
+			return res[a].generation < res[b].generation
+		}
+		return res[a].Time.Before(res[b].Time)
+	})
+	return res, nil
+}
+
+// Stack turns entries (oldest first) into an AffixStack which pops the
+// oldest checkpoint first, as the depot's iterators expect.
+func Stack(entries []Entry) storage.AffixStack {
+	var ordered []storage.RelevantCheckpoint
+	for _, e := range entries {
+		var last = len(ordered) - 1
+		if last < 0 || ordered[last].CheckpointHash.String() != e.Checkpoint.String() {
+			ordered = append(ordered, storage.RelevantCheckpoint{
+				Time:           e.Time,
+				CheckpointHash: e.Checkpoint,
+				Affix:          packing.Affix{},
+			})
+			last++
+		}
+		ordered[last].Affix[e.Partition] = e.Events
+	}
+	return storage.NewAffixStack(ordered)
+}
+
+// add indexes head and its unindexed ancestors, parents are always
+// indexed before their children so generations can be computed.
+func (i *Index) add(head retro.Hash) error {
+
+	var (
+		stack   = []retro.Hash{head}
+		pending = make(map[string]packing.Checkpoint)
+	)
+
+	for len(stack) > 0 {
+		var h = stack[len(stack)-1]
+
+		if _, indexed := i.checkpoints[h.String()]; indexed {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		checkpoint, read := pending[h.String()]
+		if !read {
+			packedCheckpoint, err := i.objdb.RetrievePacked(h.String())
+			if err != nil {
+				return errors.Wrapf(err, "can't retrieve checkpoint %s", h)
+			}
+			if packedCheckpoint.Type() != packing.ObjectTypeCheckpoint {
+				return errors.Errorf("object %s was not a %s but a %s", h, packing.ObjectTypeCheckpoint, packedCheckpoint.Type())
+			}
+			checkpoint, err = i.packer.UnpackCheckpoint(packedCheckpoint.Contents())
+			if err != nil {
+				return errors.Wrapf(err, "can't unpack checkpoint %s", h)
+			}
+			pending[h.String()] = checkpoint
+		}
+
+		var (
+			generation int
+			missing    bool
+		)
+		for _, p := range checkpoint.ParentHashes {
+			parent, indexed := i.checkpoints[p.String()]
+			if !indexed {
+				stack = append(stack, p)
+				missing = true
+				continue
+			}
+			if parent.generation >= generation {
+				generation = parent.generation + 1
+			}
+		}
+		if missing {
+			continue
+		}
+
+		packedAffix, err := i.objdb.RetrievePacked(checkpoint.AffixHash.String())
+		if err != nil {
+			return errors.Wrapf(err, "can't retrieve affix for checkpoint %s", h)
+		}
+		affix, err := i.packer.UnpackAffix(packedAffix.Contents())
+		if err != nil {
+			return errors.Wrapf(err, "can't unpack affix for checkpoint %s", h)
+		}
+		t, err := storage.CheckpointTime(checkpoint.Fields)
+		if err != nil {
+			return errors.Wrapf(err, "parsing date of checkpoint %s as rfc3339", h)
+		}
+
+		for pn, evHashes := range affix {
+			i.partitions[pn] = append(i.partitions[pn], Entry{
+				Partition:  pn,
+				Checkpoint: h,
+				Time:       t,
+				Events:     evHashes,
+				generation: generation,
+			})
+		}
+		if key := checkpoint.Fields[packing.FieldIdempotencyKey]; key != "" {
+			i.keys[key] = append(i.keys[key], KeyEntry{
+				Key:        key,
+				Checkpoint: h,
+				Time:       t,
+				generation: generation,
+			})
+		}
+		i.checkpoints[h.String()] = node{checkpoint.ParentHashes, generation}
+		delete(pending, h.String())
+		stack = stack[:len(stack)-1]
+	}
+
+	return nil
+}
+
+// reachable returns the set of checkpoints reachable from head, which
+// must be indexed. If the branch moved forward since the last call only
+// the new checkpoints are walked.
+//
+// Without a branch (e.g for reads pinned to a checkpoint) the set is
+// cached by head, what is reachable from a checkpoint never changes. At
+// most maxPinned heads are cached, an arbitrary one is dropped to make
+// room, so alternating between more pinned heads than that walks their
+// histories again.
+func (i *Index) reachable(branch string, head retro.Hash) map[string]bool {
+
+	if branch == "" {
+		if set, cached := i.pinned[head.String()]; cached {
+			return set
+		}
+		var set, _ = i.walk(head, nil, "")
+		if len(i.pinned) >= maxPinned {
+			for h := range i.pinned {
+				delete(i.pinned, h)
+				break
+			}
+		}
+		i.pinned[head.String()] = set
+		return set
+	}
+
+	r, cached := i.branches[branch]
+	if cached && r.head == head.String() {
+		return r.set
+	}
+
+	if cached {
+		var visited, reachedOldHead = i.walk(head, r.set, r.head)
+		if reachedOldHead {
+			for h := range visited {
+				r.set[h] = true
+			}
+			r.head = head.String()
+			return r.set
+		}
+	}
+
+	var set, _ = i.walk(head, nil, "")
+	i.branches[branch] = &reach{head.String(), set}
+	return set
+}
+
+// walk returns the checkpoints reachable from head without passing
+// through any checkpoint in stop, and whether target was encountered.
+func (i *Index) walk(head retro.Hash, stop map[string]bool, target string) (map[string]bool, bool) {
+	var (
+		queue   = []retro.Hash{head}
+		visited = make(map[string]bool)
+		found   bool
+	)
+	for len(queue) > 0 {
+		var h = queue[0].String()
+		queue = queue[1:]
+		if h == target {
+			found = true
+		}
+		if visited[h] || stop[h] {
+			continue
+		}
+		visited[h] = true
+		queue = append(queue, i.checkpoints[h].parents...)
+	}
+	return visited, found
+}
+
+func sortEntries(entries []Entry) {
+	sort.Slice(entries, func(a, b int) bool {
+		var x, y = entries[a], entries[b]
+		if x.generation != y.generation {
+			return x.generation < y.generation
+		}
+		if !x.Time.Equal(y.Time) {
+			return x.Time.Before(y.Time)
+		}
+		if x.Checkpoint.String() != y.Checkpoint.String() {
+			return x.Checkpoint.String() < y.Checkpoint.String()
+		}
+		return x.Partition < y.Partition
+	})
+}
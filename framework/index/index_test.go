@@ -0,0 +1,237 @@
+// +build unit
+
+package index
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/retro-framework/go-retro/framework/matcher"
+	"github.com/retro-framework/go-retro/framework/packing"
+	"github.com/retro-framework/go-retro/framework/retro"
+	"github.com/retro-framework/go-retro/framework/storage/memory"
+	test "github.com/retro-framework/go-retro/framework/test_helper"
+)
+
+// countingPacker counts the checkpoints it unpacks.
+type countingPacker struct {
+	packing.Packer
+	unpacked *int
+}
+
+func (p countingPacker) UnpackCheckpoint(b []byte) (packing.Checkpoint, error) {
+	*p.unpacked++
+	return p.Packer.UnpackCheckpoint(b)
+}
+
+func Test_Index(t *testing.T) {
+
+	var (
+		jp    = packing.NewJSONPacker()
+		objdb = &memory.ObjectStore{}
+		start = time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC)
+	)
+
+	var event = func(t *testing.T, n int) retro.HashedObject {
+		ev, err := jp.PackEvent("ev", struct{ N int }{n})
+		test.H(t).IsNil(err)
+		_, err = objdb.WritePacked(ev)
+		test.H(t).IsNil(err)
+		return ev
+	}
+
+	// keyedCheckpoint writes a checkpoint (and its affix) straight to the
+	// object store without telling the index, key is stored as the
+	// idempotency key if not empty.
+	var keyedCheckpoint = func(t *testing.T, seconds int, key string, affix packing.Affix, parents ...retro.HashedObject) retro.HashedObject {
+		packedAffix, err := jp.PackAffix(affix)
+		test.H(t).IsNil(err)
+		var parentHashes []retro.Hash
+		for _, p := range parents {
+			parentHashes = append(parentHashes, p.Hash())
+		}
+		var fields = map[string]string{"date": start.Add(time.Duration(seconds) * time.Second).Format(time.RFC3339)}
+		if key != "" {
+			fields[packing.FieldIdempotencyKey] = key
+		}
+		packedCheckpoint, err := jp.PackCheckpoint(packing.Checkpoint{
+			AffixHash:    packedAffix.Hash(),
+			CommandDesc:  []byte(`{"test":"index"}`),
+			Fields:       fields,
+			ParentHashes: parentHashes,
+		})
+		test.H(t).IsNil(err)
+		_, err = objdb.WritePacked(packedAffix)
+		test.H(t).IsNil(err)
+		_, err = objdb.WritePacked(packedCheckpoint)
+		test.H(t).IsNil(err)
+		return packedCheckpoint
+	}
+
+	var checkpoint = func(t *testing.T, seconds int, affix packing.Affix, parents ...retro.HashedObject) retro.HashedObject {
+		return keyedCheckpoint(t, seconds, "", affix, parents...)
+	}
+
+	var events = func(entries []Entry) []string {
+		var res []string
+		for _, e := range entries {
+			for _, evHash := range e.Events {
+				res = append(res, evHash.String())
+			}
+		}
+		return res
+	}
+
+	var hashes = func(objs ...retro.HashedObject) []string {
+		var res []string
+		for _, o := range objs {
+			res = append(res, o.Hash().String())
+		}
+		return res
+	}
+
+	var (
+		e1, e2, e3, e4, e5, e6 = event(t, 1), event(t, 2), event(t, 3), event(t, 4), event(t, 5), event(t, 6)
+
+		c1 = checkpoint(t, 1, packing.Affix{"author/1": {e1.Hash()}})
+		c2 = checkpoint(t, 2, packing.Affix{"article/1": {e2.Hash()}}, c1)
+
+		// c3 (master) and c4 (feature) both follow c2
+		c3 = checkpoint(t, 3, packing.Affix{"author/1": {e3.Hash()}}, c2)
+		c4 = checkpoint(t, 3, packing.Affix{"author/1": {e4.Hash()}}, c2)
+
+		// c5 follows c3 but is dated before it
+		c5 = checkpoint(t, 0, packing.Affix{"author/1": {e5.Hash()}, "article/1": {e6.Hash()}}, c3)
+	)
+
+	t.Run("finds the entries reachable from a head it was never told about", func(t *testing.T) {
+		var idx = New(objdb)
+
+		entries, err := idx.Entries("refs/heads/master", c3.Hash(), "author/1")
+		test.H(t).IsNil(err)
+		if diff := cmp.Diff(events(entries), hashes(e1, e3)); diff != "" {
+			t.Errorf("events differ: (-got +want)\n%s", diff)
+		}
+
+		entries, err = idx.Entries("refs/heads/feature", c4.Hash(), "author/1")
+		test.H(t).IsNil(err)
+		if diff := cmp.Diff(events(entries), hashes(e1, e4)); diff != "" {
+			t.Errorf("events differ: (-got +want)\n%s", diff)
+		}
+	})
+
+	t.Run("follows a branch as it moves", func(t *testing.T) {
+		var idx = New(objdb)
+		test.H(t).IsNil(idx.Add(c4.Hash()))
+
+		for _, tc := range []struct {
+			head retro.HashedObject
+			want []string
+		}{
+			{c3, hashes(e1, e3)},
+			{c5, hashes(e1, e3, e5)},
+			{c4, hashes(e1, e4)},
+		} {
+			entries, err := idx.Entries("refs/heads/master", tc.head.Hash(), "author/1")
+			test.H(t).IsNil(err)
+			if diff := cmp.Diff(events(entries), tc.want); diff != "" {
+				t.Errorf("events for head %s differ: (-got +want)\n%s", tc.head.Hash(), diff)
+			}
+		}
+	})
+
+	t.Run("finds entries for all partitions matching a pattern", func(t *testing.T) {
+		var idx = New(objdb)
+
+		entries, err := idx.Matching("", c5.Hash(), matcher.NewGlobPattern("*/1"))
+		test.H(t).IsNil(err)
+		if diff := cmp.Diff(events(entries), hashes(e1, e2, e3, e6, e5)); diff != "" {
+			t.Errorf("events differ: (-got +want)\n%s", diff)
+		}
+
+		t.Run("which stack up oldest checkpoint first", func(t *testing.T) {
+			var (
+				st  = Stack(entries)
+				got []string
+			)
+			test.H(t).IntEql(st.Len(), 4)
+			for rc := st.Pop(); rc != nil; rc = st.Pop() {
+				got = append(got, rc.CheckpointHash.String())
+			}
+			if diff := cmp.Diff(got, hashes(c1, c2, c3, c5)); diff != "" {
+				t.Errorf("checkpoints differ: (-got +want)\n%s", diff)
+			}
+		})
+	})
+
+	t.Run("can be rebuilt from the object graph", func(t *testing.T) {
+		var idx = New(objdb)
+		test.H(t).IsNil(idx.Rebuild(c5.Hash(), c4.Hash()))
+		test.H(t).IntEql(len(idx.checkpoints), 5)
+
+		entries, err := idx.Entries("", c4.Hash(), "article/1")
+		test.H(t).IsNil(err)
+		if diff := cmp.Diff(events(entries), hashes(e2)); diff != "" {
+			t.Errorf("events differ: (-got +want)\n%s", diff)
+		}
+	})
+
+	t.Run("finds the checkpoints stored with an idempotency key", func(t *testing.T) {
+		var (
+			idx = New(objdb)
+			k1  = keyedCheckpoint(t, 4, "k", packing.Affix{"author/2": {e1.Hash()}}, c4)
+			k2  = keyedCheckpoint(t, 5, "k", packing.Affix{"author/2": {e2.Hash()}}, k1)
+		)
+
+		var keyed = func(head retro.HashedObject, key string) []string {
+			entries, err := idx.Keyed("", head.Hash(), key)
+			test.H(t).IsNil(err)
+			var res []string
+			for _, e := range entries {
+				test.H(t).StringEql(e.Key, key)
+				res = append(res, e.Checkpoint.String())
+			}
+			return res
+		}
+
+		if diff := cmp.Diff(keyed(k2, "k"), hashes(k1, k2)); diff != "" {
+			t.Errorf("checkpoints differ: (-got +want)\n%s", diff)
+		}
+		if diff := cmp.Diff(keyed(k1, "k"), hashes(k1)); diff != "" {
+			t.Errorf("checkpoints differ: (-got +want)\n%s", diff)
+		}
+		test.H(t).IntEql(len(keyed(c5, "k")), 0)
+		test.H(t).IntEql(len(keyed(k2, "other")), 0)
+	})
+
+	t.Run("caches what is reachable from pinned heads", func(t *testing.T) {
+		var idx = New(objdb)
+		for _, head := range []retro.HashedObject{c3, c4, c3} {
+			_, err := idx.Entries("", head.Hash(), "author/1")
+			test.H(t).IsNil(err)
+		}
+		test.H(t).IntEql(len(idx.pinned), 2)
+		test.H(t).IntEql(len(idx.pinned[c3.Hash().String()]), 3)
+
+		for n := 0; n < maxPinned+1; n++ {
+			var head = checkpoint(t, 10+n, packing.Affix{}, c5)
+			_, err := idx.Entries("", head.Hash(), "author/1")
+			test.H(t).IsNil(err)
+		}
+		test.H(t).IntEql(len(idx.pinned), maxPinned)
+	})
+
+	t.Run("unpacks with the given packer", func(t *testing.T) {
+		var unpacked int
+		err := New(objdb, WithPacker(countingPacker{jp, &unpacked})).Add(c5.Hash())
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(unpacked, 4)
+	})
+
+	t.Run("errors for heads which are not stored", func(t *testing.T) {
+		var idx = New(objdb)
+		_, err := idx.Entries("", packing.NewPackedObject("nope").Hash(), "author/1")
+		test.H(t).NotNil(err)
+	})
+}
@@ -0,0 +1,121 @@
+// +build unit
+
+package lock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/retro-framework/go-retro/framework/retro"
+	test "github.com/retro-framework/go-retro/framework/test_helper"
+)
+
+// redisStandIn stands in for a Redis server, it understands SETNX and
+// the release script, expiry is not implemented.
+type redisStandIn struct {
+	mu sync.Mutex
+	kv map[string]string
+}
+
+func (r *redisStandIn) SetNX(key string, value interface{}, _ time.Duration) *redis.BoolCmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.kv == nil {
+		r.kv = make(map[string]string)
+	}
+	if _, exists := r.kv[key]; exists {
+		return redis.NewBoolResult(false, nil)
+	}
+	r.kv[key] = value.(string)
+	return redis.NewBoolResult(true, nil)
+}
+
+func (r *redisStandIn) Eval(script string, keys []string, args ...interface{}) *redis.Cmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if script != releaseScript {
+		return redis.NewCmdResult(nil, errors.New("unknown script"))
+	}
+	if r.kv[keys[0]] != args[0].(string) {
+		return redis.NewCmdResult(int64(0), nil)
+	}
+	delete(r.kv, keys[0])
+	return redis.NewCmdResult(int64(1), nil)
+}
+
+func Test_LockManager(t *testing.T) {
+
+	var standIn = &redisStandIn{}
+
+	// Each pair of LockManagers share their claims, like two engine
+	// processes would.
+	var pairs = map[string]func() (retro.LockManager, retro.LockManager){
+		"memory": func() (retro.LockManager, retro.LockManager) {
+			var m = NewMemory()
+			return m, m
+		},
+		"redis": func() (retro.LockManager, retro.LockManager) {
+			return NewRedis(standIn, time.Minute), NewRedis(standIn, time.Minute)
+		},
+	}
+
+	for name, pairFn := range pairs {
+		t.Run(name, func(t *testing.T) {
+
+			t.Run("grants a claim on a free name", func(t *testing.T) {
+				var a, _ = pairFn()
+				test.H(t).BoolEql(a.Claim(context.Background(), "agg/1"), true)
+				a.Release("agg/1")
+			})
+
+			t.Run("refuses a held name once the context is done", func(t *testing.T) {
+				var a, b = pairFn()
+				test.H(t).BoolEql(a.Claim(context.Background(), "agg/2"), true)
+				defer a.Release("agg/2")
+
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				defer cancel()
+				test.H(t).BoolEql(b.Claim(ctx, "agg/2"), false)
+
+				test.H(t).BoolEql(b.Claim(context.Background(), "agg/3"), true)
+				b.Release("agg/3")
+			})
+
+			t.Run("grants a waiting claim once the name is released", func(t *testing.T) {
+				var (
+					a, b    = pairFn()
+					claimed = make(chan bool)
+				)
+				test.H(t).BoolEql(a.Claim(context.Background(), "agg/4"), true)
+
+				go func() {
+					ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+					defer cancel()
+					claimed <- b.Claim(ctx, "agg/4")
+				}()
+
+				time.Sleep(30 * time.Millisecond)
+				a.Release("agg/4")
+				test.H(t).BoolEql(<-claimed, true)
+				b.Release("agg/4")
+			})
+		})
+	}
+
+	t.Run("redis", func(t *testing.T) {
+		t.Run("does not release claims granted to someone else", func(t *testing.T) {
+			var a, b = NewRedis(standIn, time.Minute), NewRedis(standIn, time.Minute)
+			test.H(t).BoolEql(a.Claim(context.Background(), "agg/5"), true)
+			b.Release("agg/5")
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			test.H(t).BoolEql(b.Claim(ctx, "agg/5"), false)
+			a.Release("agg/5")
+		})
+	})
+}
@@ -0,0 +1,50 @@
+package lock
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory is an in-process LockManager, claims are only exclusive amongst
+// users of the same Memory instance.
+type Memory struct {
+	mu   sync.Mutex
+	held map[string]chan struct{}
+}
+
+// NewMemory returns an empty in-process LockManager.
+func NewMemory() *Memory {
+	return &Memory{held: make(map[string]chan struct{})}
+}
+
+// Claim blocks until name is free or ctx is done, it returns true if the
+// claim was granted.
+func (m *Memory) Claim(ctx context.Context, name string) bool {
+	for {
+		m.mu.Lock()
+		released, held := m.held[name]
+		if !held {
+			m.held[name] = make(chan struct{})
+			m.mu.Unlock()
+			return true
+		}
+		m.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// Release gives up the claim on name and wakes anyone waiting for it,
+// releasing a name which is not claimed is a no-op.
+func (m *Memory) Release(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if released, held := m.held[name]; held {
+		close(released)
+		delete(m.held, name)
+	}
+}
@@ -0,0 +1,95 @@
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// KeyPrefix is prepended to claimed names to make the Redis keys.
+const KeyPrefix = "retro:lock:"
+
+// retryInterval is how long Claim waits before trying to claim a name
+// held by someone else again.
+const retryInterval = 20 * time.Millisecond
+
+// releaseScript deletes the key only if it still holds our token, so a
+// claim which expired and was granted to someone else is not released.
+const releaseScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+
+// RedisClient is the subset of the go-redis client used by Redis,
+// *redis.Client satisfies it.
+type RedisClient interface {
+	SetNX(key string, value interface{}, expiration time.Duration) *redis.BoolCmd
+	Eval(script string, keys []string, args ...interface{}) *redis.Cmd
+}
+
+// Redis is a LockManager which stores claims in Redis, it allows several
+// engine processes to share one depot. Claims expire after ttl to avoid
+// crashed processes holding them forever, ttl must comfortably exceed the
+// time taken to apply a command.
+type Redis struct {
+	client RedisClient
+	ttl    time.Duration
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+// NewRedis returns a Redis backed LockManager.
+func NewRedis(client RedisClient, ttl time.Duration) *Redis {
+	return &Redis{
+		client: client,
+		ttl:    ttl,
+		tokens: make(map[string]string),
+	}
+}
+
+// Claim blocks until name could be claimed or ctx is done, it returns
+// true if the claim was granted. Errors talking to Redis are treated as
+// the name being held by someone else.
+func (r *Redis) Claim(ctx context.Context, name string) bool {
+	token, err := newToken()
+	if err != nil {
+		return false
+	}
+	for {
+		ok, err := r.client.SetNX(KeyPrefix+name, token, r.ttl).Result()
+		if err == nil && ok {
+			r.mu.Lock()
+			r.tokens[name] = token
+			r.mu.Unlock()
+			return true
+		}
+		select {
+		case <-time.After(retryInterval):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// Release gives up a claim granted by this instance, releasing a name
+// which is not claimed is a no-op.
+func (r *Redis) Release(name string) {
+	r.mu.Lock()
+	token, held := r.tokens[name]
+	delete(r.tokens, name)
+	r.mu.Unlock()
+	if !held {
+		return
+	}
+	r.client.Eval(releaseScript, []string{KeyPrefix + name}, token)
+}
+
+func newToken() (string, error) {
+	var b = make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
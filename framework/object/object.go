@@ -1,6 +1,8 @@
 package object
 
 import (
+	"io"
+
 	"github.com/retro-framework/go-retro/framework/retro"
 )
 
@@ -20,6 +22,26 @@ type Source interface {
 	RetrievePacked(string) (retro.HashedObject, error)
 }
 
+// BlobStore writes the blob read from r, streaming it rather than holding
+// it in memory where the store allows. It returns a reference to the blob,
+// writing a blob which is already stored is not an error.
+type BlobStore interface {
+	WriteBlob(r io.Reader) (retro.BlobRef, error)
+}
+
+// BlobSource opens the blob with the given hash for reading, the caller
+// must close it. The size of the blob is returned with it.
+type BlobSource interface {
+	OpenBlob(string) (io.ReadCloser, int64, error)
+}
+
+// BlobDeleter deletes the blob with the given hash, e.g because the
+// partition whose events referenced it was erased. Only blobs can be
+// deleted, the rest of the object graph is immutable.
+type BlobDeleter interface {
+	DeleteBlob(string) error
+}
+
 type ListableSource interface {
 	Ls() []retro.Hash
 }
@@ -27,4 +49,6 @@ type ListableSource interface {
 type DB interface {
 	Store
 	Source
+	BlobStore
+	BlobSource
 }
@@ -3,6 +3,7 @@
 package object
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -55,3 +56,56 @@ func Test_DB(t *testing.T) {
 	}
 
 }
+
+func Test_DB_Blobs(t *testing.T) {
+
+	tmpdir, err := ioutil.TempDir("", "retro_framework_object_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	dbs := map[string]DB{
+		"memory": &memory.ObjectStore{},
+		"fs":     &fs.ObjectStore{BasePath: tmpdir},
+	}
+
+	var data = bytes.Repeat([]byte("\x89PNG\r\n\x1a\n"), 1<<14)
+
+	for name, db := range dbs {
+		t.Run(name, func(t *testing.T) {
+			t.Run("writes a blob from a stream, hashed as it would be packed", func(t *testing.T) {
+				ref, err := db.WriteBlob(bytes.NewReader(data))
+				test.H(t).IsNil(err)
+				test.H(t).StringEql(ref.Hash, packing.PackBlob(data).Hash().String())
+				test.H(t).IntEql(int(ref.Size), len(data))
+			})
+			t.Run("writes a blob which is already stored", func(t *testing.T) {
+				ref, err := db.WriteBlob(bytes.NewReader(data))
+				test.H(t).IsNil(err)
+				test.H(t).StringEql(ref.Hash, packing.PackBlob(data).Hash().String())
+			})
+			t.Run("opens a blob for reading", func(t *testing.T) {
+				rc, size, err := db.OpenBlob(packing.PackBlob(data).Hash().String())
+				test.H(t).IsNil(err)
+				defer rc.Close()
+				test.H(t).IntEql(int(size), len(data))
+				b, err := ioutil.ReadAll(rc)
+				test.H(t).IsNil(err)
+				test.H(t).BoolEql(bytes.Equal(b, data), true)
+			})
+			t.Run("retrieves a blob as a packed object", func(t *testing.T) {
+				po, err := db.RetrievePacked(packing.PackBlob(data).Hash().String())
+				test.H(t).IsNil(err)
+				test.H(t).StringEql(string(po.Type()), string(packing.ObjectTypeBlob))
+			})
+			t.Run("errors when opening an object which is not a blob", func(t *testing.T) {
+				var packedObj = packing.NewPackedObject("hello blobs")
+				_, err := db.WritePacked(packedObj)
+				test.H(t).IsNil(err)
+				_, _, err = db.OpenBlob(packedObj.Hash().String())
+				test.H(t).NotNil(err)
+			})
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package packing
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/retro-framework/go-retro/framework/retro"
+)
+
+// Blobs hold large binary data, e.g images, which events reference by hash
+// (see retro.BlobRef) rather than carry themselves. A blob is packed with a
+// header giving its length, followed by the bytes as they are:
+//
+//	blob 4096\x00<4096 bytes>
+//
+// Blobs may be too large to hold in memory, so besides PackBlob and
+// UnpackBlob the header is available on its own for stores which write and
+// read blobs as streams.
+
+// BlobHeader returns the header of a blob of size bytes, including the
+// separator.
+func BlobHeader(size int64) []byte {
+	return []byte(fmt.Sprintf("%s %d%s", ObjectTypeBlob, size, HeaderContentSepRune))
+}
+
+// ReadBlobHeader reads the header of a blob from r, leaving r at the first
+// byte of the blob, and returns the size of the blob.
+func ReadBlobHeader(r *bufio.Reader) (int64, error) {
+	header, err := r.ReadString(HeaderContentSepRune[0])
+	if err != nil {
+		return 0, xerrors.Errorf("blob header: %s: %w", err, ErrBlobScan)
+	}
+	var parts = strings.Split(strings.TrimSuffix(header, HeaderContentSepRune), " ")
+	if len(parts) != 2 || parts[0] != string(ObjectTypeBlob) {
+		return 0, xerrors.Errorf("blob header %q: %w", header, ErrBlobScan)
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, xerrors.Errorf("blob size %q: %w", parts[1], ErrBlobScan)
+	}
+	return size, nil
+}
+
+// PackBlob packs b as a blob, it hashes the same as the blob would when
+// written as a stream.
+func PackBlob(b []byte) retro.HashedObject {
+	var payload = append(BlobHeader(int64(len(b))), b...)
+	var hash = sha256.Sum256(payload)
+	return PackedBlob{
+		po{
+			hash:    Hash{HashAlgoNameSHA256, hash[:]},
+			payload: payload,
+		},
+	}
+}
+
+// UnpackBlob returns the bytes of a packed blob.
+func UnpackBlob(b []byte) ([]byte, error) {
+	var (
+		br = bytes.NewReader(b)
+		r  = bufio.NewReader(br)
+	)
+	size, err := ReadBlobHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	var data = b[len(b)-br.Len()-r.Buffered():]
+	if int64(len(data)) != size {
+		return nil, xerrors.Errorf("blob of %d bytes has %d: %w", size, len(data), ErrBlobScan)
+	}
+	return data, nil
+}
+
+// BlobRefs returns the hashes of the blobs referenced by an event given its
+// JSON payload (as unpacked). Any object with just a string "hash" and a
+// numeric "size" is taken to be a retro.BlobRef, whatever event it is in,
+// so the event manifest isn't needed.
+func BlobRefs(payload []byte) ([]string, error) {
+	var v interface{}
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return nil, xerrors.Errorf("packing: can't find blob refs: %s: %w", err, ErrEventScan)
+	}
+	var (
+		hashes []string
+		stack  = []interface{}{v}
+	)
+	for len(stack) > 0 {
+		var next = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		switch t := next.(type) {
+		case []interface{}:
+			stack = append(stack, t...)
+		case map[string]interface{}:
+			hash, isStr := t["hash"].(string)
+			_, isNum := t["size"].(float64)
+			if len(t) == 2 && isStr && isNum {
+				hashes = append(hashes, hash)
+				continue
+			}
+			for _, v := range t {
+				stack = append(stack, v)
+			}
+		}
+	}
+	return hashes, nil
+}
@@ -0,0 +1,60 @@
+// +build unit
+
+package packing
+
+import (
+	"bufio"
+	"bytes"
+	"sort"
+	"testing"
+
+	test "github.com/retro-framework/go-retro/framework/test_helper"
+)
+
+func Test_Blob(t *testing.T) {
+
+	var data = bytes.Repeat([]byte{0, 1, 2, 255}, 4096)
+
+	t.Run("packs a blob with a header giving its size", func(t *testing.T) {
+		var pb = PackBlob([]byte("hello"))
+		test.H(t).StringEql(string(pb.Contents()), "blob 5\u0000hello")
+		test.H(t).StringEql(string(pb.Type()), string(ObjectTypeBlob))
+	})
+
+	t.Run("unpacks a blob", func(t *testing.T) {
+		b, err := UnpackBlob(PackBlob(data).Contents())
+		test.H(t).IsNil(err)
+		test.H(t).BoolEql(bytes.Equal(b, data), true)
+	})
+
+	t.Run("reads the header leaving the reader at the blob", func(t *testing.T) {
+		var r = bufio.NewReader(bytes.NewReader(PackBlob([]byte("hello")).Contents()))
+		size, err := ReadBlobHeader(r)
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(int(size), 5)
+		rest, _ := r.ReadString(0)
+		test.H(t).StringEql(rest, "hello")
+	})
+
+	t.Run("errors for objects which are not blobs", func(t *testing.T) {
+		_, err := UnpackBlob([]byte("event json dummy 2\u0000{}"))
+		test.H(t).NotNil(err)
+	})
+
+	t.Run("errors for truncated blobs", func(t *testing.T) {
+		_, err := UnpackBlob([]byte("blob 6\u0000hello"))
+		test.H(t).NotNil(err)
+	})
+
+	t.Run("finds blob refs in event payloads", func(t *testing.T) {
+		hashes, err := BlobRefs([]byte(`{"img":{"hash":"sha256:01","size":3},"images":[{"hash":"sha256:02","size":4}],"other":{"hash":"sha256:03"}}`))
+		test.H(t).IsNil(err)
+		sort.Strings(hashes)
+		test.H(t).IntEql(len(hashes), 2)
+		test.H(t).StringEql(hashes[0], "sha256:01")
+		test.H(t).StringEql(hashes[1], "sha256:02")
+
+		_, err = BlobRefs([]byte(`{`))
+		test.H(t).NotNil(err)
+	})
+}
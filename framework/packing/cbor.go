@@ -0,0 +1,423 @@
+package packing
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// Events are encoded in CBOR (RFC 7049) following the rules of
+// encoding/json (field names and omitempty from json tags, embedded structs
+// flattened, map keys sorted) so that a CBOR payload reads back as the
+// JSON the event would have been packed as. Byte slices are stored as
+// byte strings rather than base64, which is the point of the exercise for
+// events carrying images.
+//
+// Values implementing json.Marshaler are stored as their JSON wrapped in
+// the "embedded JSON" tag.
+
+const (
+	cborMajorUint  = 0
+	cborMajorNeg   = 1
+	cborMajorBytes = 2
+	cborMajorText  = 3
+	cborMajorArray = 4
+	cborMajorMap   = 5
+	cborMajorTag   = 6
+	cborMajorOther = 7
+
+	cborFalse   = 20
+	cborTrue    = 21
+	cborNull    = 22
+	cborFloat64 = 27
+
+	cborTagEmbeddedJSON = 262
+
+	// cborMaxDepth limits how deeply arrays and maps may be nested in
+	// payloads being transcoded, as transcoding recurses.
+	cborMaxDepth = 512
+)
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// marshalCBOR encodes v as CBOR.
+func marshalCBOR(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeCBOR(&buf, reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCBORHead(buf *bytes.Buffer, major byte, n uint64) {
+	switch {
+	case n < 24:
+		buf.WriteByte(major<<5 | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(major<<5 | 24)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(major<<5 | 25)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		buf.WriteByte(major<<5 | 26)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(major<<5 | 27)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+func writeCBORText(buf *bytes.Buffer, s string) {
+	writeCBORHead(buf, cborMajorText, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+func encodeCBOR(buf *bytes.Buffer, v reflect.Value) error {
+	if !v.IsValid() {
+		buf.WriteByte(cborMajorOther<<5 | cborNull)
+		return nil
+	}
+
+	if v.Type().Implements(jsonMarshalerType) && !(v.Kind() == reflect.Ptr && v.IsNil()) {
+		b, err := v.Interface().(json.Marshaler).MarshalJSON()
+		if err != nil {
+			return err
+		}
+		writeCBORHead(buf, cborMajorTag, cborTagEmbeddedJSON)
+		writeCBORHead(buf, cborMajorBytes, uint64(len(b)))
+		buf.Write(b)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteByte(cborMajorOther<<5 | cborTrue)
+		} else {
+			buf.WriteByte(cborMajorOther<<5 | cborFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i := v.Int(); i < 0 {
+			writeCBORHead(buf, cborMajorNeg, uint64(-1-i))
+		} else {
+			writeCBORHead(buf, cborMajorUint, uint64(i))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeCBORHead(buf, cborMajorUint, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		buf.WriteByte(cborMajorOther<<5 | cborFloat64)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v.Float()))
+	case reflect.String:
+		writeCBORText(buf, v.String())
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			buf.WriteByte(cborMajorOther<<5 | cborNull)
+			return nil
+		}
+		return encodeCBOR(buf, v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			buf.WriteByte(cborMajorOther<<5 | cborNull)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			writeCBORHead(buf, cborMajorBytes, uint64(v.Len()))
+			buf.Write(v.Bytes())
+			return nil
+		}
+		fallthrough
+	case reflect.Array:
+		writeCBORHead(buf, cborMajorArray, uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeCBOR(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			buf.WriteByte(cborMajorOther<<5 | cborNull)
+			return nil
+		}
+		var keys = make([]string, 0, v.Len())
+		var byKey = make(map[string]reflect.Value, v.Len())
+		for _, k := range v.MapKeys() {
+			var s string
+			switch k.Kind() {
+			case reflect.String:
+				s = k.String()
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				s = strconv.FormatInt(k.Int(), 10)
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				s = strconv.FormatUint(k.Uint(), 10)
+			default:
+				return fmt.Errorf("cbor: unsupported map key type %s", k.Type())
+			}
+			keys = append(keys, s)
+			byKey[s] = v.MapIndex(k)
+		}
+		sort.Strings(keys)
+		writeCBORHead(buf, cborMajorMap, uint64(len(keys)))
+		for _, k := range keys {
+			writeCBORText(buf, k)
+			if err := encodeCBOR(buf, byKey[k]); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		var fields []cborField
+		collectCBORFields(v, &fields)
+		writeCBORHead(buf, cborMajorMap, uint64(len(fields)))
+		for _, f := range fields {
+			writeCBORText(buf, f.name)
+			if err := encodeCBOR(buf, f.v); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cbor: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+type cborField struct {
+	name string
+	v    reflect.Value
+}
+
+// collectCBORFields appends the fields encoding/json would marshal.
+func collectCBORFields(v reflect.Value, fields *[]cborField) {
+	for i := 0; i < v.NumField(); i++ {
+		var (
+			sf       = v.Type().Field(i)
+			fv       = v.Field(i)
+			tag      = sf.Tag.Get("json")
+			name     = strings.Split(tag, ",")[0]
+			embedded = sf.Anonymous && name == ""
+		)
+		if tag == "-" || (sf.PkgPath != "" && !embedded) {
+			continue
+		}
+		if embedded {
+			var t = sf.Type
+			if t.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue
+				}
+				t, fv = t.Elem(), fv.Elem()
+			}
+			if t.Kind() == reflect.Struct {
+				collectCBORFields(fv, fields)
+				continue
+			}
+			if sf.PkgPath != "" {
+				continue
+			}
+		}
+		if strings.Contains(tag, ",omitempty") && isEmptyValue(fv) {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		*fields = append(*fields, cborField{name, fv})
+	}
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// cborToJSON transcodes a CBOR payload written by marshalCBOR to JSON,
+// byte strings become base64 strings as encoding/json expects.
+func cborToJSON(b []byte) ([]byte, error) {
+	var (
+		d   = cborDecoder{b: b}
+		buf bytes.Buffer
+	)
+	if err := d.transcode(&buf, 0); err != nil {
+		return nil, err
+	}
+	if d.off != len(b) {
+		return nil, xerrors.Errorf("cbor: %d trailing bytes: %w", len(b)-d.off, ErrEventScan)
+	}
+	return buf.Bytes(), nil
+}
+
+type cborDecoder struct {
+	b   []byte
+	off int
+}
+
+func (d *cborDecoder) next(n int) ([]byte, error) {
+	if n < 0 || n > len(d.b)-d.off {
+		return nil, xerrors.Errorf("cbor: unexpected end of payload: %w", ErrEventScan)
+	}
+	var res = d.b[d.off : d.off+n]
+	d.off += n
+	return res, nil
+}
+
+func (d *cborDecoder) head() (byte, byte, uint64, error) {
+	h, err := d.next(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	var major, info = h[0] >> 5, h[0] & 0x1f
+	if major == cborMajorOther {
+		return major, info, 0, nil
+	}
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info == 24:
+		b, err := d.next(1)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		n = uint64(b[0])
+	case info == 25:
+		b, err := d.next(2)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		n = uint64(binary.BigEndian.Uint16(b))
+	case info == 26:
+		b, err := d.next(4)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		n = uint64(binary.BigEndian.Uint32(b))
+	case info == 27:
+		b, err := d.next(8)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		n = binary.BigEndian.Uint64(b)
+	default:
+		return 0, 0, 0, xerrors.Errorf("cbor: unsupported additional info %d: %w", info, ErrEventScan)
+	}
+	return major, info, n, nil
+}
+
+func (d *cborDecoder) transcode(buf *bytes.Buffer, depth int) error {
+	if depth > cborMaxDepth {
+		return xerrors.Errorf("cbor: nested more than %d deep: %w", cborMaxDepth, ErrEventScan)
+	}
+	major, info, n, err := d.head()
+	if err != nil {
+		return err
+	}
+	switch major {
+	case cborMajorUint:
+		buf.WriteString(strconv.FormatUint(n, 10))
+	case cborMajorNeg:
+		buf.WriteString("-" + strconv.FormatUint(n+1, 10))
+	case cborMajorBytes:
+		b, err := d.next(int(n))
+		if err != nil {
+			return err
+		}
+		buf.WriteByte('"')
+		buf.WriteString(base64.StdEncoding.EncodeToString(b))
+		buf.WriteByte('"')
+	case cborMajorText:
+		b, err := d.next(int(n))
+		if err != nil {
+			return err
+		}
+		s, err := json.Marshal(string(b))
+		if err != nil {
+			return err
+		}
+		buf.Write(s)
+	case cborMajorArray:
+		buf.WriteByte('[')
+		for i := uint64(0); i < n; i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := d.transcode(buf, depth+1); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case cborMajorMap:
+		buf.WriteByte('{')
+		for i := uint64(0); i < n; i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := d.transcode(buf, depth+1); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := d.transcode(buf, depth+1); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case cborMajorTag:
+		if n != cborTagEmbeddedJSON {
+			return xerrors.Errorf("cbor: unsupported tag %d: %w", n, ErrEventScan)
+		}
+		major, _, n, err := d.head()
+		if err != nil {
+			return err
+		}
+		if major != cborMajorBytes {
+			return xerrors.Errorf("cbor: embedded JSON is not a byte string: %w", ErrEventScan)
+		}
+		b, err := d.next(int(n))
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+	case cborMajorOther:
+		switch info {
+		case cborFalse:
+			buf.WriteString("false")
+		case cborTrue:
+			buf.WriteString("true")
+		case cborNull:
+			buf.WriteString("null")
+		case cborFloat64:
+			b, err := d.next(8)
+			if err != nil {
+				return err
+			}
+			f, err := json.Marshal(math.Float64frombits(binary.BigEndian.Uint64(b)))
+			if err != nil {
+				return err
+			}
+			buf.Write(f)
+		default:
+			return xerrors.Errorf("cbor: unsupported simple value %d: %w", info, ErrEventScan)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package packing
+
+import (
+	"github.com/pkg/errors"
+	"github.com/retro-framework/go-retro/framework/retro"
+)
+
+// NewCBORPacker returns a Packer which packs the events registered with
+// the given names in CBOR, and every other event as JSON. With no names
+// every event is packed in CBOR.
+//
+// CBOR stores byte slices as they are rather than as base64, which makes
+// it a good fit for events carrying images or other uploads.
+func NewCBORPacker(evNames ...string) *CBORPacker {
+	var cp = &CBORPacker{JSONPacker: NewJSONPacker()}
+	if len(evNames) > 0 {
+		cp.only = make(map[string]bool, len(evNames))
+		for _, name := range evNames {
+			cp.only[name] = true
+		}
+	}
+	return cp
+}
+
+// CBORPacker packs events in CBOR, everything else is packed by the
+// embedded JSONPacker. The encoding is recorded in the header of each
+// event so depots may hold events packed by either.
+type CBORPacker struct {
+	*JSONPacker
+
+	// only lists the names of the events packed in CBOR, nil means all.
+	only map[string]bool
+}
+
+// PackEvent packs ev as the first version of its schema, see
+// PackVersionedEvent.
+func (cp *CBORPacker) PackEvent(evName string, ev retro.Event) (retro.HashedObject, error) {
+	return cp.PackVersionedEvent(evName, 1, ev)
+}
+
+// PackVersionedEvent packs ev in CBOR if it was chosen to be, as JSON
+// otherwise.
+func (cp *CBORPacker) PackVersionedEvent(evName string, version int, ev retro.Event) (retro.HashedObject, error) {
+	if cp.only != nil && !cp.only[evName] {
+		return cp.JSONPacker.PackVersionedEvent(evName, version, ev)
+	}
+	evB, err := marshalCBOR(ev)
+	if err != nil {
+		return nil, errors.WithMessage(err, "retro-cbor-pack: can't marshal ev as cbor")
+	}
+	return cp.packEvent(EncodingCBOR, evName, version, evB), nil
+}
@@ -0,0 +1,135 @@
+// +build unit
+
+package packing
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/xerrors"
+
+	test "github.com/retro-framework/go-retro/framework/test_helper"
+)
+
+type embeddedFields struct {
+	ID string `json:"id"`
+}
+
+type urn string
+
+func (u urn) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"urn": string(u)})
+}
+
+type binaryEvent struct {
+	embeddedFields
+	Name     string            `json:"name"`
+	Skipped  string            `json:"-"`
+	Empty    string            `json:"empty,omitempty"`
+	Count    int               `json:"count"`
+	Negative int64             `json:"negative"`
+	Ratio    float64           `json:"ratio"`
+	Public   bool              `json:"public"`
+	Img      []byte            `json:"img"`
+	Imgs     [][]byte          `json:"imgs"`
+	Tags     map[string]string `json:"tags"`
+	Owner    *urn              `json:"owner"`
+	Nothing  *urn              `json:"nothing"`
+	Any      interface{}       `json:"any"`
+	unexported int
+}
+
+func Test_CBORPacker(t *testing.T) {
+
+	var (
+		owner = urn("identity/1")
+		ev    = binaryEvent{
+			embeddedFields: embeddedFields{"abc"},
+			Name:           "héllo \"world\"",
+			Skipped:        "skipped",
+			Count:          1 << 20,
+			Negative:       -300,
+			Ratio:          0.25,
+			Public:         true,
+			Img:            []byte{0, 1, 2, 255},
+			Imgs:           [][]byte{{1}, {2, 3}},
+			Tags:           map[string]string{"b": "2", "a": "1"},
+			Owner:          &owner,
+			Any:            []interface{}{"x", 1.5, nil},
+		}
+	)
+
+	t.Run("unpacks events as the JSON they would have been packed as", func(t *testing.T) {
+		packed, err := NewCBORPacker().PackEvent("binary", ev)
+		test.H(t).IsNil(err)
+
+		name, payload, err := NewJSONPacker().UnpackEvent(packed.Contents())
+		test.H(t).IsNil(err)
+		test.H(t).StringEql(name, "binary")
+
+		want, err := json.Marshal(ev)
+		test.H(t).IsNil(err)
+		var got, wanted map[string]interface{}
+		test.H(t).IsNil(json.Unmarshal(payload, &got))
+		test.H(t).IsNil(json.Unmarshal(want, &wanted))
+		if diff := cmp.Diff(got, wanted); diff != "" {
+			t.Errorf("payloads differ: (-got +want)\n%s", diff)
+		}
+	})
+
+	t.Run("records the encoding and version in the header", func(t *testing.T) {
+		packed, err := NewCBORPacker().PackVersionedEvent("binary", 3, ev)
+		test.H(t).IsNil(err)
+		name, version, _, err := NewCBORPacker().UnpackVersionedEvent(packed.Contents())
+		test.H(t).IsNil(err)
+		test.H(t).StringEql(name, "binary")
+		test.H(t).IntEql(version, 3)
+		test.H(t).StringEql(string(packed.Contents()[:12]), "event cbor b")
+	})
+
+	t.Run("packs the same event to the same hash", func(t *testing.T) {
+		a, _ := NewCBORPacker().PackEvent("binary", ev)
+		b, _ := NewCBORPacker().PackEvent("binary", ev)
+		test.H(t).StringEql(a.Hash().String(), b.Hash().String())
+	})
+
+	t.Run("packs only the chosen events in CBOR", func(t *testing.T) {
+		var cp = NewCBORPacker("binary")
+		chosen, _ := cp.PackEvent("binary", ev)
+		other, _ := cp.PackEvent("dummy", DummyEvent{"hello", "world"})
+		plain, _ := NewJSONPacker().PackEvent("dummy", DummyEvent{"hello", "world"})
+		test.H(t).StringEql(string(chosen.Contents()[:10]), "event cbor")
+		test.H(t).StringEql(other.Hash().String(), plain.Hash().String())
+	})
+
+	t.Run("stores byte slices more compactly than JSON", func(t *testing.T) {
+		var img = DummyBlobEvent{make([]byte, 4096)}
+		asCBOR, _ := NewCBORPacker().PackEvent("img", img)
+		asJSON, _ := NewJSONPacker().PackEvent("img", img)
+		test.H(t).BoolEql(len(asCBOR.Contents()) < len(asJSON.Contents())*4/5, true)
+	})
+
+	t.Run("rejects malformed payloads", func(t *testing.T) {
+		var huge = append([]byte{0x5b}, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+		_, err := cborToJSON(huge)
+		test.H(t).BoolEql(xerrors.Is(err, ErrEventScan), true)
+
+		var deep = append(bytes.Repeat([]byte{0x81}, cborMaxDepth+1), 0xf6)
+		_, err = cborToJSON(deep)
+		test.H(t).BoolEql(xerrors.Is(err, ErrEventScan), true)
+
+		_, err = cborToJSON(deep[1:])
+		test.H(t).IsNil(err)
+	})
+
+	t.Run("errors for encodings it does not know", func(t *testing.T) {
+		_, _, err := NewJSONPacker().UnpackEvent([]byte("event xml dummy 2\u0000<>"))
+		test.H(t).NotNil(err)
+	})
+}
+
+type DummyBlobEvent struct {
+	Data []byte `json:"data"`
+}
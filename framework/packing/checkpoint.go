@@ -15,6 +15,18 @@ var (
 	ErrCheckpointDateFieldAbsent      = errors.New("checkpoint has no `date' field, cannot be saved")
 )
 
+const (
+	// FieldIdempotencyKey is the checkpoint field holding the idempotency
+	// key the command was applied with, if any.
+	FieldIdempotencyKey = "idempotency-key"
+
+	// FieldNamed is the checkpoint field listing (space separated) the
+	// names assigned to aggregates the command created without naming
+	// them. It is only recorded alongside an idempotency key, to describe
+	// the checkpoint again when the key is repeated.
+	FieldNamed = "named"
+)
+
 // Checkpoint represents a DDD command object execution
 // and persistence of the resulting events. It stores
 // an error incase the command failed.
@@ -24,6 +36,10 @@ type Checkpoint struct {
 	Fields       map[string]string `json:"fields"`
 	Summary      string            `json:"summary"`
 	CommandDesc  []byte            `json:"commandDesc"`
+
+	// CommandHash references the Command object which yielded the
+	// checkpoint, CommandDesc only summarizes it.
+	CommandHash retro.Hash `json:"commandHash,omitempty"`
 }
 
 // HasErrors is used for example to determine if a Checkpoint
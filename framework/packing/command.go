@@ -0,0 +1,32 @@
+package packing
+
+import "encoding/json"
+
+// Command records a command as it was requested, the path of the aggregate
+// it targeted, its name and its args. Checkpoints reference the command
+// which yielded them so that it can be audited and replayed.
+//
+// Redacted lists the args (as dotted paths, e.g "args.password") which
+// were removed before the command was stored, a command with redactions
+// can't be replayed faithfully.
+//
+// Commands applied together as a batch are recorded as a single Command
+// without path and name listing them in Batch, in the order they were
+// applied.
+type Command struct {
+	Path     string          `json:"path,omitempty"`
+	Name     string          `json:"name,omitempty"`
+	Args     json.RawMessage `json:"args,omitempty"`
+	Redacted []string        `json:"redacted,omitempty"`
+	Batch    []Command       `json:"batch,omitempty"`
+}
+
+// Desc returns the command as a command description which can be given to
+// a resolver again.
+func (c Command) Desc() ([]byte, error) {
+	return json.Marshal(struct {
+		Path string          `json:"path,omitempty"`
+		Name string          `json:"name"`
+		Args json.RawMessage `json:"args,omitempty"`
+	}{c.Path, c.Name, c.Args})
+}
@@ -0,0 +1,247 @@
+package packing
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/retro-framework/go-retro/framework/matcher"
+	"github.com/retro-framework/go-retro/framework/retro"
+)
+
+// EncryptedSuffix is appended to the encoding of events encrypted by an
+// EncryptingPacker, e.g "json+aes256gcm".
+const EncryptedSuffix = "+aes256gcm"
+
+// encryptedState prefixes the state of snapshots encrypted by an
+// EncryptingPacker, serialized state never starts with a null byte.
+var encryptedState = []byte("\u0000aes256gcm\u0000")
+
+// PartitionEventPacker is implemented by Packers which pack events
+// depending on the partition they belong to, see EncryptingPacker.
+type PartitionEventPacker interface {
+	PackPartitionEvent(pn retro.PartitionName, evName string, version int, ev retro.Event) (retro.HashedObject, error)
+}
+
+// PackPartitionEvent packs an event of the partition with p, the event is
+// packed with PackVersionedEvent unless p is a PartitionEventPacker.
+func PackPartitionEvent(p Packer, pn retro.PartitionName, evName string, version int, ev retro.Event) (retro.HashedObject, error) {
+	if pp, ok := p.(PartitionEventPacker); ok {
+		return pp.PackPartitionEvent(pn, evName, version, ev)
+	}
+	return p.PackVersionedEvent(evName, version, ev)
+}
+
+// NewEncryptingPacker returns an EncryptingPacker which encrypts the events
+// of partitions matching any of the glob patterns (e.g "identity/*") with
+// keys from ks, after packing them with p.
+func NewEncryptingPacker(p Packer, ks retro.KeyStore, patterns ...string) *EncryptingPacker {
+	var ep = &EncryptingPacker{Packer: p, keys: ks}
+	for _, pattern := range patterns {
+		ep.matchers = append(ep.matchers, matcher.NewGlobPattern(pattern))
+	}
+	return ep
+}
+
+// EncryptingPacker encrypts the events, and snapshots, of chosen partitions
+// with a key per partition held in a retro.KeyStore. Deleting the key of a
+// partition crypto-shreds it, its events stay where they are and history
+// keeps their hashes, but reading them returns a retro.ErasedError. Blobs
+// are not encrypted, see depot.Simple.Erase.
+//
+// Events are encrypted only when packed with PackPartitionEvent, the
+// partition is unknown to PackEvent and PackVersionedEvent. Encrypted
+// events are unpacked by any EncryptingPacker with the keys, whichever
+// partitions it encrypts.
+//
+// Payloads are sealed with AES-256-GCM, the nonce is derived from the key
+// and the payload so that the same event packed twice has the same hash,
+// as it would unencrypted (replays depend on this). That reveals which
+// events of a partition are equal, and the names and versions of events
+// are left in the clear, nothing more.
+//
+// Commands are not encrypted, engine.WithRedactedArgs keeps personal data
+// out of them.
+type EncryptingPacker struct {
+	Packer
+
+	keys     retro.KeyStore
+	matchers []retro.Matcher
+}
+
+func (ep *EncryptingPacker) encrypts(pn retro.PartitionName) bool {
+	for _, m := range ep.matchers {
+		if match, _ := m.DoesMatch(pn); match {
+			return true
+		}
+	}
+	return false
+}
+
+// PackPartitionEvent packs the event with the underlying Packer, and
+// encrypts its payload if the partition is one of those encrypted. The
+// partition's key is created when its first event is packed.
+func (ep *EncryptingPacker) PackPartitionEvent(pn retro.PartitionName, evName string, version int, ev retro.Event) (retro.HashedObject, error) {
+
+	packedEv, err := ep.Packer.PackVersionedEvent(evName, version, ev)
+	if err != nil || !ep.encrypts(pn) {
+		return packedEv, err
+	}
+
+	eh, payload, err := parseEvent(packedEv.Contents())
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ep.keys.Key(pn, true)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := seal(key, eventAD(pn, eh), payload)
+	if err != nil {
+		return nil, xerrors.Errorf("encrypting-packer: can't encrypt %s event %s: %w", pn, evName, err)
+	}
+
+	var encrypted bytes.Buffer
+	encrypted.WriteString(string(pn))
+	encrypted.WriteString(HeaderContentSepRune)
+	encrypted.Write(sealed)
+
+	return &PackedEvent{
+		NewPackedObject(string(eventEnvelope(eh.encoding+EncryptedSuffix, evName, eh.version, encrypted.Bytes()))),
+	}, nil
+}
+
+func (ep *EncryptingPacker) UnpackEvent(b []byte) (string, []byte, error) {
+	name, _, payload, err := ep.UnpackVersionedEvent(b)
+	return name, payload, err
+}
+
+// UnpackVersionedEvent decrypts encrypted events and unpacks them with the
+// underlying Packer. A retro.ErasedError is returned for events of erased
+// partitions.
+func (ep *EncryptingPacker) UnpackVersionedEvent(b []byte) (string, int, []byte, error) {
+
+	eh, payload, err := parseEvent(b)
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	if !strings.HasSuffix(eh.encoding, EncryptedSuffix) {
+		return ep.Packer.UnpackVersionedEvent(b)
+	}
+
+	var chunks = bytes.SplitN(payload, []byte(HeaderContentSepRune), 2)
+	if len(chunks) != 2 {
+		return "", 0, nil, xerrors.Errorf("encrypting-packer: no partition in encrypted event: %w", ErrEventScan)
+	}
+	var pn = retro.PartitionName(chunks[0])
+
+	key, err := ep.keys.Key(pn, false)
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	eh.encoding = strings.TrimSuffix(eh.encoding, EncryptedSuffix)
+	plain, err := unseal(key, eventAD(pn, eh), chunks[1])
+	if err != nil {
+		return "", 0, nil, xerrors.Errorf("encrypting-packer: can't decrypt %s event %s: %s: %w", pn, eh.name, err, ErrEventScan)
+	}
+
+	return ep.Packer.UnpackVersionedEvent(eventEnvelope(eh.encoding, eh.name, eh.version, plain))
+}
+
+// PackSnapshot encrypts the state of snapshots of partitions which are
+// encrypted, the headers are left in the clear.
+func (ep *EncryptingPacker) PackSnapshot(s Snapshot) (retro.HashedObject, error) {
+	if !ep.encrypts(s.Partition) {
+		return ep.Packer.PackSnapshot(s)
+	}
+	key, err := ep.keys.Key(s.Partition, true)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := seal(key, snapshotAD(s.Partition), s.State)
+	if err != nil {
+		return nil, xerrors.Errorf("encrypting-packer: can't encrypt snapshot of %s: %w", s.Partition, err)
+	}
+	s.State = append(append([]byte(nil), encryptedState...), sealed...)
+	return ep.Packer.PackSnapshot(s)
+}
+
+// UnpackSnapshot decrypts the state of encrypted snapshots, a
+// retro.ErasedError is returned for snapshots of erased partitions.
+func (ep *EncryptingPacker) UnpackSnapshot(b []byte) (Snapshot, error) {
+	s, err := ep.Packer.UnpackSnapshot(b)
+	if err != nil || !bytes.HasPrefix(s.State, encryptedState) {
+		return s, err
+	}
+	key, err := ep.keys.Key(s.Partition, false)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	s.State, err = unseal(key, snapshotAD(s.Partition), s.State[len(encryptedState):])
+	if err != nil {
+		return Snapshot{}, xerrors.Errorf("encrypting-packer: can't decrypt snapshot of %s: %s: %w", s.Partition, err, ErrSnapshotScan)
+	}
+	return s, nil
+}
+
+// eventAD is the additional data sealed with an event, tying the payload
+// to its partition and header.
+func eventAD(pn retro.PartitionName, eh eventHeader) []byte {
+	return []byte(fmt.Sprintf("%s\u0000%s\u0000%s\u0000%s\u0000%d", ObjectTypeEvent, pn, eh.encoding, eh.name, eh.version))
+}
+
+func snapshotAD(pn retro.PartitionName) []byte {
+	return []byte(fmt.Sprintf("%s\u0000%s", ObjectTypeSnapshot, pn))
+}
+
+// seal encrypts plaintext with AES-256-GCM, returning the nonce followed
+// by the ciphertext. The key of the partition is not used directly,
+// separate keys for encryption and deriving nonces are derived from it.
+func seal(key, ad, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	var mac = hmac.New(sha256.New, subkey(key, "nonce"))
+	fmt.Fprintf(mac, "%d:", len(ad))
+	mac.Write(ad)
+	mac.Write(plaintext)
+	var nonce = mac.Sum(nil)[:aead.NonceSize()]
+	return aead.Seal(nonce, nonce, plaintext, ad), nil
+}
+
+func unseal(key, ad, sealed []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, xerrors.New("too short")
+	}
+	var nonce = sealed[:aead.NonceSize()]
+	return aead.Open(nil, nonce, sealed[len(nonce):], ad)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(subkey(key, "encryption"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func subkey(key []byte, purpose string) []byte {
+	var mac = hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,129 @@
+// +build unit
+
+package packing
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/xerrors"
+
+	"github.com/retro-framework/go-retro/framework/retro"
+	test "github.com/retro-framework/go-retro/framework/test_helper"
+)
+
+// keyStore is a retro.KeyStore with fixed keys, the storage packages
+// implement real ones but import this package.
+type keyStore map[retro.PartitionName][]byte
+
+func (ks keyStore) Key(pn retro.PartitionName, create bool) ([]byte, error) {
+	key, found := ks[pn]
+	if found && key == nil || !found && !create {
+		return nil, retro.ErasedError{Partition: pn}
+	}
+	if !found {
+		key = bytes.Repeat([]byte{byte(len(ks) + 1)}, 32)
+		ks[pn] = key
+	}
+	return key, nil
+}
+
+func (ks keyStore) Delete(pn retro.PartitionName) error {
+	ks[pn] = nil
+	return nil
+}
+
+func Test_EncryptingPacker(t *testing.T) {
+
+	var ev = DummyEvent{"hello", "secret world"}
+
+	t.Run("round-trips events of encrypted partitions", func(t *testing.T) {
+		var ep = NewEncryptingPacker(NewJSONPacker(), keyStore{}, "identity/*")
+
+		packed, err := PackPartitionEvent(ep, "identity/alice", "dummy", 2, ev)
+		test.H(t).IsNil(err)
+		test.H(t).BoolEql(bytes.Contains(packed.Contents(), []byte("secret")), false)
+		test.H(t).StringEql(string(packed.Contents()[:26]), "event json+aes256gcm dummy")
+
+		name, version, payload, err := ep.UnpackVersionedEvent(packed.Contents())
+		test.H(t).IsNil(err)
+		test.H(t).StringEql(name, "dummy")
+		test.H(t).IntEql(version, 2)
+		test.H(t).StringEql(string(payload), `{"foo":"hello","bar":"secret world"}`)
+	})
+
+	t.Run("packs the same event to the same hash", func(t *testing.T) {
+		var ep = NewEncryptingPacker(NewJSONPacker(), keyStore{}, "identity/*")
+		a, _ := PackPartitionEvent(ep, "identity/alice", "dummy", 0, ev)
+		b, _ := PackPartitionEvent(ep, "identity/alice", "dummy", 0, ev)
+		c, _ := PackPartitionEvent(ep, "identity/bob", "dummy", 0, ev)
+		test.H(t).StringEql(a.Hash().String(), b.Hash().String())
+		test.H(t).BoolEql(a.Hash().String() == c.Hash().String(), false)
+	})
+
+	t.Run("leaves other partitions in the clear", func(t *testing.T) {
+		var ep = NewEncryptingPacker(NewJSONPacker(), keyStore{}, "identity/*")
+		packed, _ := PackPartitionEvent(ep, "listing/1", "dummy", 0, ev)
+		plain, _ := NewJSONPacker().PackEvent("dummy", ev)
+		test.H(t).StringEql(packed.Hash().String(), plain.Hash().String())
+
+		packed, _ = ep.PackEvent("dummy", ev)
+		test.H(t).StringEql(packed.Hash().String(), plain.Hash().String())
+	})
+
+	t.Run("errors with ErasedError once the key is deleted", func(t *testing.T) {
+		var (
+			ks = keyStore{}
+			ep = NewEncryptingPacker(NewJSONPacker(), ks, "identity/*")
+		)
+		packed, _ := PackPartitionEvent(ep, "identity/alice", "dummy", 0, ev)
+		test.H(t).IsNil(ks.Delete("identity/alice"))
+
+		_, _, err := ep.UnpackEvent(packed.Contents())
+		test.H(t).BoolEql(xerrors.Is(err, retro.ErrErased), true)
+
+		_, err = PackPartitionEvent(ep, "identity/alice", "dummy", 0, ev)
+		test.H(t).BoolEql(xerrors.Is(err, retro.ErrErased), true)
+	})
+
+	t.Run("errors when the payload was tampered with", func(t *testing.T) {
+		var ep = NewEncryptingPacker(NewJSONPacker(), keyStore{}, "identity/*")
+		packed, _ := PackPartitionEvent(ep, "identity/alice", "dummy", 0, ev)
+		var b = append([]byte(nil), packed.Contents()...)
+		b[len(b)-1] ^= 1
+		_, _, err := ep.UnpackEvent(b)
+		test.H(t).BoolEql(xerrors.Is(err, ErrEventScan), true)
+	})
+
+	t.Run("JSONPacker refuses encrypted events", func(t *testing.T) {
+		var ep = NewEncryptingPacker(NewJSONPacker(), keyStore{}, "identity/*")
+		packed, _ := PackPartitionEvent(ep, "identity/alice", "dummy", 0, ev)
+		_, _, err := NewJSONPacker().UnpackEvent(packed.Contents())
+		test.H(t).NotNil(err)
+	})
+
+	t.Run("encrypts the state of snapshots", func(t *testing.T) {
+		var (
+			ks = keyStore{}
+			ep = NewEncryptingPacker(NewJSONPacker(), ks, "identity/*")
+			s  = Snapshot{
+				Partition:      "identity/alice",
+				CheckpointHash: hashStr("checkpoint"),
+				AggregateType:  "identity",
+				Events:         1,
+				State:          []byte(`{"name":"secret"}`),
+			}
+		)
+		packed, err := ep.PackSnapshot(s)
+		test.H(t).IsNil(err)
+		test.H(t).BoolEql(bytes.Contains(packed.Contents(), []byte("secret")), false)
+
+		got, err := ep.UnpackSnapshot(packed.Contents())
+		test.H(t).IsNil(err)
+		test.H(t).StringEql(string(got.State), `{"name":"secret"}`)
+
+		test.H(t).IsNil(ks.Delete("identity/alice"))
+		_, err = ep.UnpackSnapshot(packed.Contents())
+		test.H(t).BoolEql(xerrors.Is(err, retro.ErrErased), true)
+	})
+}
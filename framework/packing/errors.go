@@ -3,8 +3,17 @@ package packing
 import "golang.org/x/xerrors"
 
 var (
+	ErrEventScan      = xerrors.New("packing: err scanning event")
 	ErrAffixScan      = xerrors.New("packing: err scanning affix")
 	ErrCheckpointScan = xerrors.New("packing: err scanning checkpoint")
+	ErrSnapshotScan   = xerrors.New("packing: err scanning snapshot")
+	ErrCommandScan    = xerrors.New("packing: err scanning command")
+	ErrBlobScan       = xerrors.New("packing: err scanning blob")
 
 	ErrInvalidPartitioName = xerrors.New("packing: invalid partition name")
+
+	ErrUnsigned        = xerrors.New("packing: checkpoint is not signed")
+	ErrBadSignature    = xerrors.New("packing: checkpoint signature does not verify")
+	ErrUnknownSigner   = xerrors.New("packing: checkpoint signed with an unknown key")
+	ErrSignatureFormat = xerrors.New("packing: checkpoint signature field malformed")
 )
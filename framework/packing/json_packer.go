@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"hash"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -37,47 +38,131 @@ type JSONPacker struct {
 // it's type and encoding, followed by the raw bytes, terminated with a null
 // byte.
 //
+// The event is packed as the first version of its schema, see
+// PackVersionedEvent.
+//
 // See the tests for an example of how the on-disk format looks.
 func (jp *JSONPacker) PackEvent(evName string, ev retro.Event) (retro.HashedObject, error) {
+	return jp.PackVersionedEvent(evName, 1, ev)
+}
 
-	var payload bytes.Buffer
-
+// PackVersionedEvent packs an event as PackEvent does, recording the version
+// of the event's schema in the header so that it can be upcast when read
+// (see retro.VersionedEventManifest). The first version is not recorded,
+// events packed before events were versioned are version 1.
+func (jp *JSONPacker) PackVersionedEvent(evName string, version int, ev retro.Event) (retro.HashedObject, error) {
 	evB, err := json.Marshal(ev)
 	if err != nil {
 		return nil, errors.WithMessage(err, "retro-json-pack: can't marshal ev as json")
 	}
+	return jp.packEvent(EncodingJSON, evName, version, evB), nil
+}
 
-	payload.WriteString(fmt.Sprintf("%s json %s %d", ObjectTypeEvent, evName, len(evB)))
-	payload.WriteString(HeaderContentSepRune)
-	payload.Write(evB)
+// packEvent wraps the encoded event in an envelope naming the encoding.
+func (jp *JSONPacker) packEvent(encoding, evName string, version int, evB []byte) retro.HashedObject {
+
+	var payload = eventEnvelope(encoding, evName, version, evB)
 
 	hash := jp.hashFn()
-	hash.Write(payload.Bytes())
+	hash.Write(payload)
 
 	return &PackedEvent{
 		po{
 			hash:    Hash{HashAlgoNameSHA256, hash.Sum(nil)},
-			payload: payload.Bytes(),
-		}}, nil
+			payload: payload,
+		}}
 
 }
 
+// eventEnvelope returns the encoded event with a header naming the
+// encoding, the event and its version.
+func eventEnvelope(encoding, evName string, version int, evB []byte) []byte {
+
+	var payload bytes.Buffer
+
+	if version > 1 {
+		payload.WriteString(fmt.Sprintf("%s %s %s v%d %d", ObjectTypeEvent, encoding, evName, version, len(evB)))
+	} else {
+		payload.WriteString(fmt.Sprintf("%s %s %s %d", ObjectTypeEvent, encoding, evName, len(evB)))
+	}
+	payload.WriteString(HeaderContentSepRune)
+	payload.Write(evB)
+
+	return payload.Bytes()
+}
+
+// eventHeader is the parsed header of a packed event.
+type eventHeader struct {
+	encoding string
+	name     string
+	version  int
+}
+
+// parseEvent splits a packed event into its header and the encoded event.
+func parseEvent(b []byte) (eventHeader, []byte, error) {
+	var chunks = bytes.SplitN(b, []byte(HeaderContentSepRune), 2)
+	if len(chunks) != 2 {
+		return eventHeader{}, nil, xerrors.Errorf("json-packer: no header separator: %w", ErrEventScan)
+	}
+	var (
+		parts = strings.Split(string(chunks[0]), " ")
+		eh    = eventHeader{version: 1}
+	)
+	if len(parts) != 4 && len(parts) != 5 {
+		return eventHeader{}, nil, xerrors.Errorf("json-packer: malformed header %q: %w", chunks[0], ErrEventScan)
+	}
+	if len(parts) == 5 {
+		v, err := strconv.Atoi(strings.TrimPrefix(parts[3], "v"))
+		if err != nil {
+			return eventHeader{}, nil, xerrors.Errorf("json-packer: event version %q: %w", parts[3], ErrEventScan)
+		}
+		eh.version = v
+	}
+	eh.encoding, eh.name = parts[1], parts[2]
+	return eh, chunks[1], nil
+}
+
 // Unpack event takes a byte slice and returns an event name, and a payload
 // which can be unmarahelled into that event tyype, because of limitations of
 // the type safety system a event name and payload are the best we can do here.
 // The caller should use the event name to request an zero value event with
 // that name from the event registry and then decode it.
 //
+// The payload is returned in the version it was packed in, see
+// UnpackVersionedEvent.
+//
 // TODO: ensure that the byte slice given actually contains an event (e.g look
 // at the frontmatter)
 func (jp *JSONPacker) UnpackEvent(b []byte) (string, []byte, error) {
-	var (
-		chunks      = bytes.SplitN(b, []byte(HeaderContentSepRune), 2)
-		frontMatter = chunks[0]
-		payload     = chunks[1]
-		parts       = strings.SplitN(string(frontMatter), " ", 4)
-	)
-	return parts[2], payload, nil
+	name, _, payload, err := jp.UnpackVersionedEvent(b)
+	return name, payload, err
+}
+
+// UnpackVersionedEvent returns the event name, the version of the event's
+// schema the payload is in and the payload.
+//
+// The payload is always JSON, whichever encoding the event was packed in
+// (see CBORPacker), so that depots with events in several encodings can
+// be read by any Packer.
+func (jp *JSONPacker) UnpackVersionedEvent(b []byte) (string, int, []byte, error) {
+	eh, payload, err := parseEvent(b)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	switch {
+	case eh.encoding == EncodingJSON:
+	case eh.encoding == EncodingCBOR:
+		b, err := cborToJSON(payload)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		payload = b
+	case strings.HasSuffix(eh.encoding, EncryptedSuffix):
+		return "", 0, nil, xerrors.Errorf("json-packer: event is encrypted, it can only be unpacked by an EncryptingPacker: %w", ErrEventScan)
+	default:
+		return "", 0, nil, xerrors.Errorf("json-packer: event encoding %q: %w", eh.encoding, ErrEventScan)
+	}
+	return eh.name, eh.version, payload, nil
 }
 
 // UnpackAffix returns an unpacked affix given a byte stream containing an affix
@@ -130,6 +215,8 @@ func (jp *JSONPacker) UnpackCheckpoint(b []byte) (Checkpoint, error) {
 				res.AffixHash = HashStrToHash(cols[1])
 			case "parent":
 				res.ParentHashes = append(res.ParentHashes, HashStrToHash(cols[1]))
+			case "command":
+				res.CommandHash = HashStrToHash(cols[1])
 			default:
 				res.Fields[cols[0]] = cols[1]
 			}
@@ -208,6 +295,10 @@ func (jp *JSONPacker) PackCheckpoint(cp Checkpoint) (retro.HashedObject, error)
 		cpB.WriteString(fmt.Sprintf("parent %s\n", parentHash.String()))
 	}
 
+	if cp.CommandHash != nil {
+		cpB.WriteString(fmt.Sprintf("%s %s\n", ObjectTypeCommand, cp.CommandHash.String()))
+	}
+
 	var fieldKeys []string
 	for key := range cp.Fields {
 		fieldKeys = append(fieldKeys, key)
@@ -234,3 +325,166 @@ func (jp *JSONPacker) PackCheckpoint(cp Checkpoint) (retro.HashedObject, error)
 		}}, nil
 
 }
+
+// PackSnapshot packs a snapshot as a set of headers (like a checkpoint)
+// followed by a blank line and the aggregate's serialized state.
+func (jp *JSONPacker) PackSnapshot(s Snapshot) (retro.HashedObject, error) {
+
+	var (
+		sB      bytes.Buffer
+		payload bytes.Buffer
+	)
+
+	if len(s.Partition) == 0 {
+		return nil, ErrInvalidPartitioName
+	}
+
+	sB.WriteString(fmt.Sprintf("%s %s\n", ObjectTypeCheckpoint, s.CheckpointHash.String()))
+	sB.WriteString(fmt.Sprintf("partition %s\n", s.Partition))
+	sB.WriteString(fmt.Sprintf("aggregate %s\n", s.AggregateType))
+	sB.WriteString(fmt.Sprintf("schema %d\n", s.SchemaVersion))
+	sB.WriteString(fmt.Sprintf("events %d\n", s.Events))
+	if s.PreviousHash != nil {
+		sB.WriteString(fmt.Sprintf("previous %s\n", s.PreviousHash.String()))
+	}
+	sB.WriteString("\n")
+	sB.Write(s.State)
+
+	payload.WriteString(fmt.Sprintf("%s %d", ObjectTypeSnapshot, len(sB.Bytes())))
+	payload.WriteString(HeaderContentSepRune)
+	payload.Write(sB.Bytes())
+
+	hash := jp.hashFn()
+	hash.Write(payload.Bytes())
+
+	return &PackedSnapshot{
+		po{
+			hash:    Hash{HashAlgoNameSHA256, hash.Sum(nil)},
+			payload: payload.Bytes(),
+		}}, nil
+}
+
+// UnpackSnapshot returns an unpacked snapshot given a byte stream containing
+// a snapshot. The state is everything after the first blank line, it is not
+// scanned line by line as serialized state may be arbitrarily long.
+func (jp *JSONPacker) UnpackSnapshot(b []byte) (Snapshot, error) {
+	var (
+		res Snapshot
+
+		chunks = bytes.SplitN(b, []byte(HeaderContentSepRune), 2)
+	)
+	if len(chunks) != 2 {
+		return res, xerrors.Errorf("json-packer: no header separator: %w", ErrSnapshotScan)
+	}
+
+	var parts = bytes.SplitN(chunks[1], []byte("\n\n"), 2)
+	if len(parts) != 2 {
+		return res, xerrors.Errorf("json-packer: no blank line after headers: %w", ErrSnapshotScan)
+	}
+	res.State = parts[1]
+
+	for _, line := range strings.Split(string(parts[0]), "\n") {
+		var cols = strings.SplitN(line, " ", 2)
+		if len(cols) != 2 {
+			return res, xerrors.Errorf("json-packer: malformed header %q: %w", line, ErrSnapshotScan)
+		}
+		var err error
+		switch cols[0] {
+		case "checkpoint":
+			res.CheckpointHash = HashStrToHash(cols[1])
+		case "partition":
+			res.Partition = retro.PartitionName(cols[1])
+		case "aggregate":
+			res.AggregateType = cols[1]
+		case "schema":
+			res.SchemaVersion, err = strconv.Atoi(cols[1])
+		case "events":
+			res.Events, err = strconv.Atoi(cols[1])
+		case "previous":
+			res.PreviousHash = HashStrToHash(cols[1])
+		}
+		if err != nil {
+			return res, xerrors.Errorf("json-packer: %s: %w", err, ErrSnapshotScan)
+		}
+	}
+
+	return res, nil
+}
+
+// PackCommand packs a command as canonical JSON, the args are re-encoded
+// with sorted keys so that the same command always yields the same hash
+// however the client happened to order the keys.
+func (jp *JSONPacker) PackCommand(c Command) (retro.HashedObject, error) {
+
+	var payload bytes.Buffer
+
+	c, err := canonicalCommand(c)
+	if err != nil {
+		return nil, err
+	}
+
+	cB, err := json.Marshal(c)
+	if err != nil {
+		return nil, errors.WithMessage(err, "retro-json-pack: can't marshal command as json")
+	}
+
+	payload.WriteString(fmt.Sprintf("%s json %d", ObjectTypeCommand, len(cB)))
+	payload.WriteString(HeaderContentSepRune)
+	payload.Write(cB)
+
+	hash := jp.hashFn()
+	hash.Write(payload.Bytes())
+
+	return &PackedCommand{
+		po{
+			hash:    Hash{HashAlgoNameSHA256, hash.Sum(nil)},
+			payload: payload.Bytes(),
+		}}, nil
+}
+
+// canonicalCommand re-encodes the args of c and of the commands in its
+// batch with sorted keys, so that equal commands pack to equal hashes.
+func canonicalCommand(c Command) (Command, error) {
+	if len(c.Args) > 0 {
+		var (
+			args interface{}
+			dec  = json.NewDecoder(bytes.NewReader(c.Args))
+		)
+		dec.UseNumber()
+		if err := dec.Decode(&args); err != nil {
+			return c, errors.WithMessage(err, "retro-json-pack: can't decode command args")
+		}
+		canonical, err := json.Marshal(args)
+		if err != nil {
+			return c, errors.WithMessage(err, "retro-json-pack: can't marshal command args")
+		}
+		c.Args = canonical
+	}
+	if len(c.Batch) > 0 {
+		var batch = make([]Command, len(c.Batch))
+		for i, bc := range c.Batch {
+			var err error
+			if batch[i], err = canonicalCommand(bc); err != nil {
+				return c, err
+			}
+		}
+		c.Batch = batch
+	}
+	return c, nil
+}
+
+// UnpackCommand returns an unpacked command given a byte stream containing
+// a command.
+func (jp *JSONPacker) UnpackCommand(b []byte) (Command, error) {
+	var (
+		res    Command
+		chunks = bytes.SplitN(b, []byte(HeaderContentSepRune), 2)
+	)
+	if len(chunks) != 2 {
+		return res, xerrors.Errorf("json-packer: no header separator: %w", ErrCommandScan)
+	}
+	if err := json.Unmarshal(chunks[1], &res); err != nil {
+		return res, xerrors.Errorf("json-packer: %s: %w", err, ErrCommandScan)
+	}
+	return res, nil
+}
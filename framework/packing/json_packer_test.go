@@ -36,6 +36,29 @@ func Test_UnpackPack(t *testing.T) {
 
 	})
 
+	t.Run("versioned event", func(t *testing.T) {
+
+		// Arrange
+		jp := NewJSONPacker()
+
+		// Act
+		v1, _ := jp.PackVersionedEvent("dummy", 1, DummyEvent{"hello", "world"})
+		v2, _ := jp.PackVersionedEvent("dummy", 2, DummyEvent{"hello", "world"})
+		name, version, payload, err := jp.UnpackVersionedEvent(v2.Contents())
+
+		// Assert
+		test.H(t).IsNil(err)
+		test.H(t).StringEql(name, "dummy")
+		test.H(t).IntEql(version, 2)
+		test.H(t).StringEql(string(payload), `{"foo":"hello","bar":"world"}`)
+
+		packed, _ := jp.PackEvent("dummy", DummyEvent{"hello", "world"})
+		test.H(t).StringEql(v1.Hash().String(), packed.Hash().String())
+		_, version, _, err = jp.UnpackVersionedEvent(v1.Contents())
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(version, 1)
+	})
+
 	t.Run("exemplary affix", func(t *testing.T) {
 
 		// Arrange
@@ -68,6 +91,7 @@ func Test_UnpackPack(t *testing.T) {
 				CommandDesc:  []byte(`{"foo":"bar"}`),
 				Fields:       map[string]string{"session": "DEADBEEF-SESSIONID"},
 				ParentHashes: []retro.Hash{checkpointHash},
+				CommandHash:  hashStr("command"),
 			}
 		)
 
@@ -81,6 +105,73 @@ func Test_UnpackPack(t *testing.T) {
 		}
 
 	})
+
+	t.Run("exemplary snapshot", func(t *testing.T) {
+
+		var (
+			jp = NewJSONPacker()
+
+			snapshot = Snapshot{
+				Partition:      "widget/123",
+				CheckpointHash: hashStr("checkpoint"),
+				AggregateType:  "example.com/widgets.Widget",
+				SchemaVersion:  2,
+				Events:         12,
+				PreviousHash:   hashStr("previous"),
+				State:          []byte("{\"name\":\"sprocket\"}\n\n{\"trailing\":true}"),
+			}
+		)
+
+		packed, err := jp.PackSnapshot(snapshot)
+		test.H(t).IsNil(err)
+		test.H(t).StringEql(string(packed.Type()), string(ObjectTypeSnapshot))
+
+		unpackedSnapshot, err := jp.UnpackSnapshot(packed.Contents())
+
+		// Assert
+		test.H(t).IsNil(err)
+		if cmp.Equal(unpackedSnapshot, snapshot) != true {
+			t.Fatalf("equality assertion failed: %s", cmp.Diff(unpackedSnapshot, snapshot))
+		}
+
+	})
+
+	t.Run("exemplary command", func(t *testing.T) {
+
+		var (
+			jp = NewJSONPacker()
+
+			command = Command{
+				Path:     "widget/123",
+				Name:     "rename",
+				Args:     []byte(`{"name":"sprocket","size":1.50}`),
+				Redacted: []string{"args.password"},
+			}
+			reordered = Command{
+				Path:     "widget/123",
+				Name:     "rename",
+				Args:     []byte(`{ "size": 1.50, "name": "sprocket" }`),
+				Redacted: []string{"args.password"},
+			}
+		)
+
+		packed, err := jp.PackCommand(command)
+		test.H(t).IsNil(err)
+		test.H(t).StringEql(string(packed.Type()), string(ObjectTypeCommand))
+
+		packedReordered, err := jp.PackCommand(reordered)
+		test.H(t).IsNil(err)
+		test.H(t).StringEql(packedReordered.Hash().String(), packed.Hash().String())
+
+		unpackedCommand, err := jp.UnpackCommand(packed.Contents())
+
+		// Assert
+		test.H(t).IsNil(err)
+		if cmp.Equal(unpackedCommand, command) != true {
+			t.Fatalf("equality assertion failed: %s", cmp.Diff(unpackedCommand, command))
+		}
+
+	})
 }
 
 func Test_Pack(t *testing.T) {
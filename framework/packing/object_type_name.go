@@ -6,8 +6,11 @@ const (
 	ObjectTypeAffix      retro.ObjectTypeName = "affix"
 	ObjectTypeCheckpoint retro.ObjectTypeName = "checkpoint"
 	ObjectTypeEvent      retro.ObjectTypeName = "event"
+	ObjectTypeSnapshot   retro.ObjectTypeName = "snapshot"
+	ObjectTypeCommand    retro.ObjectTypeName = "command"
+	ObjectTypeBlob       retro.ObjectTypeName = "blob"
 
 	ObjectTypeUnknown retro.ObjectTypeName = "unknown object type"
 )
 
-var KnownObjectTypes []retro.ObjectTypeName = []retro.ObjectTypeName{ObjectTypeAffix, ObjectTypeCheckpoint, ObjectTypeEvent}
+var KnownObjectTypes []retro.ObjectTypeName = []retro.ObjectTypeName{ObjectTypeAffix, ObjectTypeCheckpoint, ObjectTypeEvent, ObjectTypeSnapshot, ObjectTypeCommand, ObjectTypeBlob}
@@ -21,7 +21,8 @@ func NewPackedObject(payloadStr string) retro.HashedObject {
 	}
 }
 
-// Type returns a ObjectTypeName of either Affix, Checkpoint or Event
+// Type returns a ObjectTypeName of either Affix, Checkpoint, Event, Snapshot,
+// Command or Blob
 func (p po) Type() retro.ObjectTypeName {
 	parts := bytes.SplitN(p.payload, []byte(" "), 2)
 	for _, kot := range KnownObjectTypes {
@@ -63,3 +64,27 @@ type PackedCheckpoint struct {
 func (pc PackedCheckpoint) TypeName() retro.ObjectTypeName {
 	return ObjectTypeCheckpoint
 }
+
+type PackedSnapshot struct {
+	retro.HashedObject
+}
+
+func (ps PackedSnapshot) TypeName() retro.ObjectTypeName {
+	return ObjectTypeSnapshot
+}
+
+type PackedCommand struct {
+	retro.HashedObject
+}
+
+func (pc PackedCommand) TypeName() retro.ObjectTypeName {
+	return ObjectTypeCommand
+}
+
+type PackedBlob struct {
+	retro.HashedObject
+}
+
+func (pb PackedBlob) TypeName() retro.ObjectTypeName {
+	return ObjectTypeBlob
+}
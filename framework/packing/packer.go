@@ -0,0 +1,35 @@
+package packing
+
+import "github.com/retro-framework/go-retro/framework/retro"
+
+const (
+	// EncodingJSON and EncodingCBOR name the encoding of an event's
+	// payload in its header.
+	EncodingJSON = "json"
+	EncodingCBOR = "cbor"
+)
+
+// Packer packs and unpacks the objects stored in a Depot. Affixes,
+// checkpoints, snapshots and commands have a single format, events may be
+// encoded differently by each Packer but can be unpacked by any of them.
+//
+// JSONPacker is the default, CBORPacker stores chosen events in a compact
+// binary encoding.
+type Packer interface {
+	PackEvent(evName string, ev retro.Event) (retro.HashedObject, error)
+	PackVersionedEvent(evName string, version int, ev retro.Event) (retro.HashedObject, error)
+	UnpackEvent(b []byte) (string, []byte, error)
+	UnpackVersionedEvent(b []byte) (string, int, []byte, error)
+
+	PackAffix(affix Affix) (retro.HashedObject, error)
+	UnpackAffix(b []byte) (Affix, error)
+
+	PackCheckpoint(cp Checkpoint) (retro.HashedObject, error)
+	UnpackCheckpoint(b []byte) (Checkpoint, error)
+
+	PackSnapshot(s Snapshot) (retro.HashedObject, error)
+	UnpackSnapshot(b []byte) (Snapshot, error)
+
+	PackCommand(c Command) (retro.HashedObject, error)
+	UnpackCommand(b []byte) (Command, error)
+}
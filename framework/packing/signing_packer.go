@@ -0,0 +1,180 @@
+package packing
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/retro-framework/go-retro/framework/retro"
+)
+
+// FieldSignature is the checkpoint field holding the signature of a
+// checkpoint signed by a SigningPacker, formatted as:
+//
+//	signature ed25519 <key-id> <base64 signature>
+const FieldSignature = "signature"
+
+// SignatureAlgoEd25519 is the only signature algorithm known.
+const SignatureAlgoEd25519 = "ed25519"
+
+// KeyID identifies a public key in the signatures made with it, it is the
+// first 8 bytes of the SHA-256 of the key in hex.
+func KeyID(pub ed25519.PublicKey) string {
+	var sum = sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// NewSigningPacker returns a SigningPacker which packs objects with p and
+// signs checkpoints with key.
+func NewSigningPacker(p Packer, key ed25519.PrivateKey) *SigningPacker {
+	return &SigningPacker{
+		Packer: p,
+		key:    key,
+		keyID:  KeyID(key.Public().(ed25519.PublicKey)),
+	}
+}
+
+// SigningPacker signs every checkpoint it packs, the signature covers the
+// checkpoint as it would have been packed without it, which covers the
+// affix, parents and command by their hashes, and therefore everything
+// the checkpoint references. See Verifier.
+//
+// ed25519 signatures are deterministic, the same checkpoint is packed to
+// the same hash every time (dry runs depend on this).
+type SigningPacker struct {
+	Packer
+
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+// PackCheckpoint packs the checkpoint with the underlying Packer and then
+// again with its signature, any signature it has already is replaced.
+func (sp *SigningPacker) PackCheckpoint(cp Checkpoint) (retro.HashedObject, error) {
+
+	var fields = make(map[string]string, len(cp.Fields)+1)
+	for k, v := range cp.Fields {
+		if k != FieldSignature {
+			fields[k] = v
+		}
+	}
+	cp.Fields = fields
+
+	unsigned, err := sp.Packer.PackCheckpoint(cp)
+	if err != nil {
+		return nil, err
+	}
+
+	var sig = ed25519.Sign(sp.key, unsigned.Contents())
+	cp.Fields[FieldSignature] = fmt.Sprintf("%s %s %s", SignatureAlgoEd25519, sp.keyID, base64.StdEncoding.EncodeToString(sig))
+
+	return sp.Packer.PackCheckpoint(cp)
+}
+
+// PackPartitionEvent is forwarded to the underlying Packer, which may
+// pack events depending on their partition (see PackPartitionEvent).
+func (sp *SigningPacker) PackPartitionEvent(pn retro.PartitionName, evName string, version int, ev retro.Event) (retro.HashedObject, error) {
+	return PackPartitionEvent(sp.Packer, pn, evName, version, ev)
+}
+
+// NewVerifier returns a Verifier which trusts signatures made with any of
+// the keys.
+func NewVerifier(keys ...ed25519.PublicKey) *Verifier {
+	var v = &Verifier{keys: make(map[string]ed25519.PublicKey, len(keys))}
+	for _, key := range keys {
+		v.keys[KeyID(key)] = key
+	}
+	return v
+}
+
+// Verifier verifies the signatures of checkpoints packed by a
+// SigningPacker against a set of trusted keys.
+type Verifier struct {
+	keys map[string]ed25519.PublicKey
+}
+
+// Verify returns nil if the packed checkpoint is signed by a trusted key.
+// Otherwise an error matching ErrUnsigned, ErrUnknownSigner,
+// ErrBadSignature or ErrSignatureFormat is returned.
+//
+// The signature is checked against the checkpoint with the signature line
+// removed, as stored, rather than unpacked and packed again.
+func (v *Verifier) Verify(packed retro.HashedObject) error {
+
+	if packed.Type() != ObjectTypeCheckpoint {
+		return xerrors.Errorf("packing: can't verify a %s: %w", packed.Type(), ErrCheckpointScan)
+	}
+
+	unsigned, sigField, err := stripSignature(packed.Contents())
+	if err != nil {
+		return err
+	}
+	if sigField == "" {
+		return ErrUnsigned
+	}
+
+	var cols = strings.Split(sigField, " ")
+	if len(cols) != 3 || cols[0] != SignatureAlgoEd25519 {
+		return xerrors.Errorf("packing: %q: %w", sigField, ErrSignatureFormat)
+	}
+	sig, err := base64.StdEncoding.DecodeString(cols[2])
+	if err != nil {
+		return xerrors.Errorf("packing: %s: %w", err, ErrSignatureFormat)
+	}
+
+	key, found := v.keys[cols[1]]
+	if !found {
+		return xerrors.Errorf("packing: key %s: %w", cols[1], ErrUnknownSigner)
+	}
+	if !ed25519.Verify(key, unsigned, sig) {
+		return xerrors.Errorf("packing: key %s: %w", cols[1], ErrBadSignature)
+	}
+	return nil
+}
+
+// stripSignature returns the packed checkpoint without its signature
+// line, with the length in its header adjusted, and the value of the
+// signature field. The value is empty if there is no signature line.
+func stripSignature(b []byte) ([]byte, string, error) {
+
+	var chunks = bytes.SplitN(b, []byte(HeaderContentSepRune), 2)
+	if len(chunks) != 2 {
+		return nil, "", xerrors.Errorf("packing: no header separator: %w", ErrCheckpointScan)
+	}
+
+	var (
+		content  bytes.Buffer
+		sigField string
+		inFields = true
+		r        = bufio.NewReader(bytes.NewReader(chunks[1]))
+	)
+	for {
+		line, err := r.ReadString('\n')
+		if inFields && strings.HasPrefix(line, FieldSignature+" ") {
+			sigField = strings.TrimSuffix(strings.TrimPrefix(line, FieldSignature+" "), "\n")
+		} else {
+			content.WriteString(line)
+		}
+		if line == "\n" {
+			// Fields end at the blank line before the command
+			// description.
+			inFields = false
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	var res bytes.Buffer
+	res.WriteString(fmt.Sprintf("%s %d", ObjectTypeCheckpoint, content.Len()))
+	res.WriteString(HeaderContentSepRune)
+	res.Write(content.Bytes())
+	return res.Bytes(), sigField, nil
+}
@@ -0,0 +1,71 @@
+// +build unit
+
+package packing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+
+	"golang.org/x/xerrors"
+
+	"github.com/retro-framework/go-retro/framework/retro"
+	test "github.com/retro-framework/go-retro/framework/test_helper"
+)
+
+func Test_SigningPacker(t *testing.T) {
+
+	var (
+		pub, key, _        = ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte{1}, 32)))
+		otherPub, other, _ = ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte{2}, 32)))
+
+		cp = Checkpoint{
+			AffixHash:    hashStr("affix"),
+			ParentHashes: []retro.Hash{hashStr("parent")},
+			Fields:       map[string]string{"date": "2019-01-01T00:00:00Z", "session": "abc"},
+			CommandDesc:  []byte(`{"path":"identity/1","name":"rename"}`),
+		}
+	)
+
+	t.Run("signs checkpoints verifiably", func(t *testing.T) {
+		packed, err := NewSigningPacker(NewJSONPacker(), key).PackCheckpoint(cp)
+		test.H(t).IsNil(err)
+		test.H(t).IsNil(NewVerifier(pub).Verify(packed))
+
+		unpacked, err := NewJSONPacker().UnpackCheckpoint(packed.Contents())
+		test.H(t).IsNil(err)
+		test.H(t).StringEql(unpacked.Fields["session"], "abc")
+		test.H(t).BoolEql(unpacked.Fields[FieldSignature] != "", true)
+	})
+
+	t.Run("packs the same checkpoint to the same hash", func(t *testing.T) {
+		a, _ := NewSigningPacker(NewJSONPacker(), key).PackCheckpoint(cp)
+		b, _ := NewSigningPacker(NewJSONPacker(), key).PackCheckpoint(cp)
+		test.H(t).StringEql(a.Hash().String(), b.Hash().String())
+	})
+
+	t.Run("reports unsigned checkpoints", func(t *testing.T) {
+		packed, _ := NewJSONPacker().PackCheckpoint(cp)
+		test.H(t).ErrEql(NewVerifier(pub).Verify(packed), ErrUnsigned)
+	})
+
+	t.Run("reports checkpoints signed with untrusted keys", func(t *testing.T) {
+		packed, _ := NewSigningPacker(NewJSONPacker(), other).PackCheckpoint(cp)
+		test.H(t).BoolEql(xerrors.Is(NewVerifier(pub).Verify(packed), ErrUnknownSigner), true)
+		test.H(t).IsNil(NewVerifier(pub, otherPub).Verify(packed))
+	})
+
+	t.Run("reports checkpoints altered after signing", func(t *testing.T) {
+		packed, _ := NewSigningPacker(NewJSONPacker(), key).PackCheckpoint(cp)
+		var altered = NewPackedObject(string(bytes.Replace(packed.Contents(), []byte("session abc"), []byte("session abd"), 1)))
+		test.H(t).BoolEql(xerrors.Is(NewVerifier(pub).Verify(altered), ErrBadSignature), true)
+	})
+
+	t.Run("replaces existing signatures", func(t *testing.T) {
+		signed, _ := NewSigningPacker(NewJSONPacker(), other).PackCheckpoint(cp)
+		unpacked, _ := NewJSONPacker().UnpackCheckpoint(signed.Contents())
+		resigned, err := NewSigningPacker(NewJSONPacker(), key).PackCheckpoint(unpacked)
+		test.H(t).IsNil(err)
+		test.H(t).IsNil(NewVerifier(pub).Verify(resigned))
+	})
+}
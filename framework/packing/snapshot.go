@@ -0,0 +1,22 @@
+package packing
+
+import "github.com/retro-framework/go-retro/framework/retro"
+
+// Snapshot records the serialized state of an aggregate as of a given
+// checkpoint, the last checkpoint which touched the aggregate's partition.
+// Rehydrating can start from the snapshot and replay only the events of
+// later checkpoints.
+//
+// AggregateType and SchemaVersion identify the shape of State, a snapshot
+// is only usable by the same aggregate type at the same schema version.
+// Events is the total number of events applied to reach State.
+// PreviousHash links to the snapshot this one superseded, if any.
+type Snapshot struct {
+	Partition      retro.PartitionName
+	CheckpointHash retro.Hash
+	AggregateType  string
+	SchemaVersion  int
+	Events         int
+	PreviousHash   retro.Hash
+	State          []byte
+}
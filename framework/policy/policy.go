@@ -0,0 +1,58 @@
+// Package policy provides the common Policies commands can be registered
+// with (see commands.Register), e.g:
+//
+//	commands.Register(&aggregates.Identity{}, &HideIdentity{}, policy.Authenticated, policy.OwnsTarget)
+//
+// The policies rely on optional interfaces of the session and aggregates,
+// see retro.IdentifiedSession, retro.RoleSession and retro.OwnedAggregate.
+package policy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/retro-framework/go-retro/framework/retro"
+)
+
+// Authenticated requires the session to be associated with an identity.
+var Authenticated retro.Policy = retro.PolicyFunc(func(_ context.Context, session retro.Session, _ retro.Aggregate) error {
+	_, err := identity(session)
+	return err
+})
+
+// OwnsTarget requires the identity of the session to own the aggregate the
+// command targets. Aggregates which don't implement retro.OwnedAggregate
+// are owned by nobody.
+var OwnsTarget retro.Policy = retro.PolicyFunc(func(_ context.Context, session retro.Session, target retro.Aggregate) error {
+	urn, err := identity(session)
+	if err != nil {
+		return err
+	}
+	if owned, ok := target.(retro.OwnedAggregate); ok && owned.OwnedBy(urn) {
+		return nil
+	}
+	return retro.ForbiddenError{Reason: fmt.Sprintf("%s does not own %s", urn, target.Name())}
+})
+
+// HasRole requires the identity of the session to hold role.
+func HasRole(role string) retro.Policy {
+	return retro.PolicyFunc(func(_ context.Context, session retro.Session, _ retro.Aggregate) error {
+		urn, err := identity(session)
+		if err != nil {
+			return err
+		}
+		if rs, ok := session.(retro.RoleSession); ok && rs.HasRole(role) {
+			return nil
+		}
+		return retro.ForbiddenError{Reason: fmt.Sprintf("%s does not have the role %q", urn, role)}
+	})
+}
+
+func identity(session retro.Session) (retro.URN, error) {
+	if is, ok := session.(retro.IdentifiedSession); ok {
+		if urn, ok := is.Identity(); ok {
+			return urn, nil
+		}
+	}
+	return "", retro.ForbiddenError{Reason: "session is not authenticated"}
+}
@@ -0,0 +1,80 @@
+// +build unit
+
+package policy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/retro-framework/go-retro/aggregates"
+	"github.com/retro-framework/go-retro/framework/retro"
+	test "github.com/retro-framework/go-retro/framework/test_helper"
+	"golang.org/x/xerrors"
+)
+
+type session struct {
+	aggregates.NamedAggregate
+	identity retro.URN
+	roles    []string
+}
+
+func (*session) ReactTo(retro.Event) error { return nil }
+
+func (s *session) Identity() (retro.URN, bool) { return s.identity, s.identity != "" }
+
+func (s *session) HasRole(role string) bool {
+	for _, r := range s.roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type profile struct {
+	aggregates.NamedAggregate
+}
+
+func (*profile) ReactTo(retro.Event) error { return nil }
+
+func (p *profile) OwnedBy(urn retro.URN) bool { return urn == p.URN() }
+
+func Test_Policies(t *testing.T) {
+
+	var (
+		ctx    = context.Background()
+		anon   = &session{}
+		alice  = &session{identity: "profile/alice", roles: []string{"admin"}}
+		bob    = &session{identity: "profile/bob"}
+		target = &profile{aggregates.NamedAggregate{PN: "profile/alice"}}
+	)
+
+	for _, tc := range []struct {
+		name      string
+		policy    retro.Policy
+		session   retro.Session
+		forbidden bool
+	}{
+		{"authenticated allows identified sessions", Authenticated, bob, false},
+		{"authenticated forbids anonymous sessions", Authenticated, anon, true},
+		{"authenticated forbids missing sessions", Authenticated, nil, true},
+		{"owns target allows the owner", OwnsTarget, alice, false},
+		{"owns target forbids others", OwnsTarget, bob, true},
+		{"owns target forbids anonymous sessions", OwnsTarget, anon, true},
+		{"has role allows role holders", HasRole("admin"), alice, false},
+		{"has role forbids others", HasRole("admin"), bob, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var err = tc.policy.Authorize(ctx, tc.session, target)
+			test.H(t).BoolEql(xerrors.Is(err, retro.ErrForbidden), tc.forbidden)
+			if !tc.forbidden {
+				test.H(t).IsNil(err)
+			}
+		})
+	}
+
+	t.Run("owns target forbids aggregates nobody owns", func(t *testing.T) {
+		var err = OwnsTarget.Authorize(ctx, alice, &aggregates.Session{})
+		test.H(t).BoolEql(xerrors.Is(err, retro.ErrForbidden), true)
+	})
+}
@@ -0,0 +1,107 @@
+package ref
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/retro-framework/go-retro/framework/retro"
+	"github.com/retro-framework/go-retro/framework/storage"
+)
+
+// DefaultBranch is the ref which is used whenever the context does not
+// name a branch explicitly.
+const DefaultBranch = "refs/heads/master"
+
+const branchPrefix = "refs/heads/"
+
+type ctxKey int
+
+const (
+	branchCtxKey ctxKey = iota
+	pinCtxKey
+)
+
+// WithBranch returns a copy of ctx which carries the given branch name.
+// The Depot, Repo and Engine will read from and write to that branch
+// instead of DefaultBranch. Both short ("qa-1") and full
+// ("refs/heads/qa-1") branch names are accepted.
+//
+// The name is not checked, names from untrusted sources should be checked
+// with BranchRef first. The ref stores refuse invalid names (see
+// ValidName) when the branch is read or written.
+func WithBranch(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, branchCtxKey, expand(name))
+}
+
+// BranchFromContext returns the full ref name of the branch carried in the
+// ctx, or DefaultBranch if there is none.
+func BranchFromContext(ctx context.Context) string {
+	if ctx != nil {
+		if name, ok := ctx.Value(branchCtxKey).(string); ok && name != "" {
+			return name
+		}
+	}
+	return DefaultBranch
+}
+
+// BranchRef expands a short branch name to a full ref name, full ref names
+// are returned untouched. An error matching storage.ErrInvalidRefName is
+// returned if the name is not valid (see ValidName).
+func BranchRef(name string) (string, error) {
+	var full = expand(name)
+	if err := ValidName(full); err != nil {
+		return "", err
+	}
+	return full, nil
+}
+
+// IsBranch returns true if the full ref name names a branch.
+func IsBranch(name string) bool {
+	return strings.HasPrefix(name, branchPrefix)
+}
+
+// ValidName returns an error matching storage.ErrInvalidRefName if name
+// can't be used as a ref name, e.g because it contains ".." parts, see
+// storage.ValidRefName for the rules.
+func ValidName(name string) error {
+	return storage.ValidRefName(name)
+}
+
+func expand(name string) string {
+	if strings.HasPrefix(name, "refs/") {
+		return name
+	}
+	return branchPrefix + name
+}
+
+// Pin fixes reads to a point in the past instead of the head of the
+// branch. If Checkpoint is set reads start at that checkpoint, if Time is
+// set only checkpoints dated at or before Time are read. Both may be set.
+type Pin struct {
+	Checkpoint retro.Hash
+	Time       time.Time
+}
+
+// IsZero returns true if the pin doesn't restrict reads at all.
+func (p Pin) IsZero() bool {
+	return p.Checkpoint == nil && p.Time.IsZero()
+}
+
+// WithPin returns a copy of ctx which carries the pin, the Repo will
+// rehydrate aggregates as they were at that point. Passing the zero Pin
+// unpins a pinned ctx.
+func WithPin(ctx context.Context, p Pin) context.Context {
+	return context.WithValue(ctx, pinCtxKey, p)
+}
+
+// PinFromContext returns the pin carried in ctx, or the zero Pin if there
+// is none.
+func PinFromContext(ctx context.Context) Pin {
+	if ctx != nil {
+		if p, ok := ctx.Value(pinCtxKey).(Pin); ok {
+			return p
+		}
+	}
+	return Pin{}
+}
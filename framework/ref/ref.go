@@ -6,9 +6,15 @@ import (
 
 // Store writes a named reference to a packing.Hash. It should return boolean
 // whether the ref is now changed, and an error in case of storage problems.
+//
+// CompareAndSwap atomically moves the named ref from old to new. If the ref
+// does not currently point at old storage.ErrRefChanged is returned and the
+// ref is left untouched. An old value of nil means the ref must not exist
+// yet.
 type Store interface {
 	Write(string, retro.Hash) (bool, error)
 	WriteSymbolic(string, string) (bool, error)
+	CompareAndSwap(name string, old, new retro.Hash) error
 }
 
 // ListableStore is optionally implementable by objects otherwise conforming to
@@ -6,14 +6,19 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/retro-framework/go-retro/framework/packing"
 	"github.com/retro-framework/go-retro/framework/retro"
+	"github.com/retro-framework/go-retro/framework/storage"
 	"github.com/retro-framework/go-retro/framework/storage/fs"
 	"github.com/retro-framework/go-retro/framework/storage/memory"
 	test "github.com/retro-framework/go-retro/framework/test_helper"
+	"golang.org/x/xerrors"
 )
 
 func Test_DB(t *testing.T) {
@@ -109,6 +114,71 @@ func Test_DB(t *testing.T) {
 
 			})
 
+			t.Run("compare and swap", func(t *testing.T) {
+
+				var db = dbFn()
+
+				t.Run("creates a ref if old is nil and the ref does not exist", func(t *testing.T) {
+					test.H(t).IsNil(db.CompareAndSwap("refs/heads/cas", nil, fooHash))
+				})
+
+				t.Run("refuses to create a ref which already exists", func(t *testing.T) {
+					test.H(t).ErrEql(db.CompareAndSwap("refs/heads/cas", nil, barHash), storage.ErrRefChanged)
+				})
+
+				t.Run("refuses to move a ref which does not have the old value", func(t *testing.T) {
+					test.H(t).ErrEql(db.CompareAndSwap("refs/heads/cas", barHash, fooHash), storage.ErrRefChanged)
+					packedHash, err := db.Retrieve("refs/heads/cas")
+					test.H(t).IsNil(err)
+					test.H(t).StringEql(packedHash.String(), fooHash.String())
+				})
+
+				t.Run("moves a ref which has the old value", func(t *testing.T) {
+					test.H(t).IsNil(db.CompareAndSwap("refs/heads/cas", fooHash, barHash))
+					packedHash, err := db.Retrieve("refs/heads/cas")
+					test.H(t).IsNil(err)
+					test.H(t).StringEql(packedHash.String(), barHash.String())
+				})
+
+				t.Run("lets exactly one of many concurrent writers win", func(t *testing.T) {
+					var (
+						wg   sync.WaitGroup
+						wins int32
+						head = packing.HashStr("concurrent")
+					)
+					test.H(t).IsNil(db.CompareAndSwap("refs/heads/race", nil, head))
+					for i := 0; i < 20; i++ {
+						wg.Add(1)
+						go func(i int) {
+							defer wg.Done()
+							if err := db.CompareAndSwap("refs/heads/race", head, packing.HashStr(fmt.Sprintf("%d", i))); err == nil {
+								atomic.AddInt32(&wins, 1)
+							}
+						}(i)
+					}
+					wg.Wait()
+					test.H(t).IntEql(int(wins), 1)
+				})
+			})
+
+			t.Run("refuses invalid ref names", func(t *testing.T) {
+
+				var db = dbFn()
+
+				for _, name := range []string{"", "/etc/x", "refs/heads/../../../x", "refs//heads", "refs/heads/x.lock", "refs/heads/x\n"} {
+					_, err := db.Write(name, fooHash)
+					test.H(t).BoolEql(xerrors.Is(err, storage.ErrInvalidRefName), true)
+					err = db.CompareAndSwap(name, nil, fooHash)
+					test.H(t).BoolEql(xerrors.Is(err, storage.ErrInvalidRefName), true)
+					_, err = db.WriteSymbolic(name, "refs/heads/main")
+					test.H(t).BoolEql(xerrors.Is(err, storage.ErrInvalidRefName), true)
+				}
+
+				if _, err := os.Stat(filepath.Join(tmpdir, "..", "x")); !os.IsNotExist(err) {
+					t.Errorf("expected nothing to be written outside of the store, got %v", err)
+				}
+			})
+
 			t.Run("listing objects", func(t *testing.T) {
 
 				var db = dbFn()
@@ -140,3 +210,23 @@ func Test_DB(t *testing.T) {
 	}
 
 }
+
+func Test_BranchRef(t *testing.T) {
+
+	for name, want := range map[string]string{
+		"qa-1":            "refs/heads/qa-1",
+		"refs/heads/qa-1": "refs/heads/qa-1",
+		"feature/login":   "refs/heads/feature/login",
+	} {
+		got, err := BranchRef(name)
+		test.H(t).IsNil(err)
+		test.H(t).StringEql(got, want)
+	}
+
+	for _, name := range []string{"", "../../etc/x", "refs/../x", "qa-1/", "qa//1", "qa-1.lock", "qa\x001", "qa\x7f"} {
+		_, err := BranchRef(name)
+		if !xerrors.Is(err, storage.ErrInvalidRefName) {
+			t.Errorf("expected %q to be refused with %q, got %v", name, storage.ErrInvalidRefName, err)
+		}
+	}
+}
@@ -0,0 +1,452 @@
+// Package replay re-runs the commands stored alongside the checkpoints of
+// a branch against the state their aggregates were in at the time, and
+// compares the events they yield now with the events which were stored.
+// It is meant for regression testing changes to the domain logic of
+// commands against real histories.
+package replay
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/flect"
+	"github.com/retro-framework/go-retro/framework/object"
+	"github.com/retro-framework/go-retro/framework/packing"
+	"github.com/retro-framework/go-retro/framework/ref"
+	"github.com/retro-framework/go-retro/framework/repository"
+	"github.com/retro-framework/go-retro/framework/retro"
+	"github.com/retro-framework/go-retro/framework/storage"
+	"golang.org/x/xerrors"
+)
+
+// Result is the outcome of replaying the command of a single checkpoint.
+// Checkpoints which can't be replayed faithfully (merges, checkpoints
+// written before commands were stored, commands with redacted args) are
+// skipped and the reason is given in Skipped.
+type Result struct {
+	Checkpoint retro.Hash
+	Time       time.Time
+	Command    packing.Command
+
+	Skipped     string
+	Err         error
+	Divergences []Divergence
+}
+
+// Diverged returns true if replaying the command failed, or yielded other
+// events than were stored.
+func (r Result) Diverged() bool {
+	return r.Err != nil || len(r.Divergences) > 0
+}
+
+// Divergence names a partition for which the stored and replayed events
+// differ. Either list may be empty if the partition was only written by
+// the stored or the replayed command.
+type Divergence struct {
+	Partition retro.PartitionName
+	Stored    []retro.Hash
+	Replayed  []retro.Hash
+}
+
+// Replayer replays the commands of a branch.
+type Replayer struct {
+	objdb    object.Source
+	refdb    ref.Source
+	repo     retro.Repo
+	resolver retro.Resolver
+	aggm     retro.AggregateManifest
+	evm      retro.EventManifest
+	packer   packing.Packer
+}
+
+// Option configures optional behaviour of the Replayer.
+type Option func(*Replayer)
+
+// WithPacker sets the Packer replayed events are packed with, it must be
+// the Packer the Engine was given for the replayed events to hash the
+// same. The default is a JSONPacker.
+func WithPacker(p packing.Packer) Option {
+	return func(r *Replayer) {
+		r.packer = p
+	}
+}
+
+// New returns a Replayer reading checkpoints from objdb and refdb which
+// resolves commands with resolver and rehydrates aggregates through repo.
+// repo must read from the same stores.
+func New(objdb object.Source, refdb ref.Source, repo retro.Repo, resolver retro.Resolver, aggm retro.AggregateManifest, evm retro.EventManifest, opts ...Option) Replayer {
+	var r = Replayer{
+		objdb:    objdb,
+		refdb:    refdb,
+		repo:     repo,
+		resolver: resolver,
+		aggm:     aggm,
+		evm:      evm,
+		packer:   packing.NewJSONPacker(),
+	}
+	for _, opt := range opts {
+		opt(&r)
+	}
+	return r
+}
+
+// Run replays every checkpoint of the branch named in ctx (see
+// ref.WithBranch), oldest first, or of the history of the pinned
+// checkpoint if ctx is pinned (see ref.WithPin).
+//
+// Each command (or each command of a batch, in order) is resolved and
+// applied with the aggregates as they were at the checkpoint's parent,
+// with the session recorded in the checkpoint and with a clock (see retro.WithClock) pinned to the checkpoint's date.
+// Nothing is persisted.
+//
+// Errors resolving or applying a command are reported in the Result of
+// the checkpoint, errors reading the object graph end the run.
+func (r Replayer) Run(ctx context.Context) ([]Result, error) {
+
+	var head = ref.PinFromContext(ctx).Checkpoint
+	if head == nil {
+		var err error
+		head, err = r.refdb.Retrieve(ref.BranchFromContext(ctx))
+		if err != nil {
+			return nil, xerrors.Errorf("replay: can't read head of %s: %w", ref.BranchFromContext(ctx), err)
+		}
+	}
+
+	walked, err := storage.NewWalker(r.objdb, storage.WithPacker(r.packer)).Ordered(head, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("replay: can't walk history of %s: %w", head, err)
+	}
+
+	var results = make([]Result, 0, len(walked))
+	for _, wc := range walked {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		res, err := r.replay(ctx, wc)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+func (r Replayer) replay(ctx context.Context, wc storage.WalkedCheckpoint) (Result, error) {
+
+	var (
+		jp  = r.packer
+		res = Result{Checkpoint: wc.CheckpointHash, Time: wc.Time}
+	)
+
+	packedCheckpoint, err := r.objdb.RetrievePacked(wc.CheckpointHash.String())
+	if err != nil {
+		return res, xerrors.Errorf("replay: can't retrieve checkpoint %s: %w", wc.CheckpointHash, err)
+	}
+	checkpoint, err := jp.UnpackCheckpoint(packedCheckpoint.Contents())
+	if err != nil {
+		return res, xerrors.Errorf("replay: can't unpack checkpoint %s: %w", wc.CheckpointHash, err)
+	}
+
+	switch {
+	case len(checkpoint.ParentHashes) > 1:
+		res.Skipped = "merge checkpoint"
+		return res, nil
+	case checkpoint.CommandHash == nil:
+		res.Skipped = "no command stored"
+		return res, nil
+	}
+
+	packedCommand, err := r.objdb.RetrievePacked(checkpoint.CommandHash.String())
+	if err != nil {
+		return res, xerrors.Errorf("replay: can't retrieve command %s: %w", checkpoint.CommandHash, err)
+	}
+	res.Command, err = jp.UnpackCommand(packedCommand.Contents())
+	if err != nil {
+		return res, xerrors.Errorf("replay: can't unpack command %s: %w", checkpoint.CommandHash, err)
+	}
+
+	// Batches are replayed command by command, each seeing the events of
+	// the ones before it as they did when the batch was applied.
+	var commands = []packing.Command{res.Command}
+	if len(res.Command.Batch) > 0 {
+		commands = res.Command.Batch
+	}
+
+	var redacted []string
+	for _, command := range commands {
+		redacted = append(redacted, command.Redacted...)
+	}
+	if len(redacted) > 0 {
+		res.Skipped = fmt.Sprintf("args redacted: %s", strings.Join(redacted, ", "))
+		return res, nil
+	}
+
+	ctx = ref.WithPin(ctx, parentPin(wc, checkpoint.ParentHashes))
+	ctx = retro.WithClock(ctx, retro.FixedClock(wc.Time))
+
+	var (
+		overlay = repository.NewOverlay(r.repo, r.evm, r.packer)
+		cmdRess = make([]retro.CommandResult, 0, len(commands))
+	)
+	for _, command := range commands {
+		cmdRes, err := r.apply(ctx, overlay, retro.SessionID(checkpoint.Fields["session"]), command)
+		if err != nil {
+			res.Err = err
+			return res, nil
+		}
+		cmdRess = append(cmdRess, cmdRes)
+	}
+
+	res.Divergences, err = r.diff(wc.Affix, cmdRess...)
+	if err != nil {
+		res.Err = err
+	}
+
+	return res, nil
+}
+
+// apply resolves and applies a single command through the overlay and adds
+// the events it yielded for named aggregates to the overlay.
+func (r Replayer) apply(ctx context.Context, overlay *repository.Overlay, sid retro.SessionID, command packing.Command) (retro.CommandResult, error) {
+
+	session, err := r.session(ctx, overlay, sid, command)
+	if err != nil {
+		return nil, err
+	}
+	ctx = retro.WithSession(ctx, session)
+
+	cmdDesc, err := command.Desc()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd, err := r.resolver.Resolve(ctx, overlay, cmdDesc)
+	if err != nil {
+		return nil, xerrors.Errorf("replay: can't resolve %s: %w", cmdDesc, err)
+	}
+
+	cmdRes, err := cmd.Apply(ctx, ioutil.Discard, session, overlay)
+	if err != nil {
+		return nil, xerrors.Errorf("replay: applying %s: %w", cmdDesc, err)
+	}
+
+	for agg, evs := range cmdRes {
+		if agg.Name() == "" {
+			continue
+		}
+		packedEvs, err := r.pack(agg.Name(), evs)
+		if err != nil {
+			return nil, err
+		}
+		overlay.Add(agg.Name(), packedEvs...)
+	}
+
+	return cmdRes, nil
+}
+
+// pack packs the events of the partition as the Engine does when persisting
+// them.
+func (r Replayer) pack(pn retro.PartitionName, evs []retro.Event) ([]retro.HashedObject, error) {
+	var (
+		jp        = r.packer
+		packedEvs = make([]retro.HashedObject, 0, len(evs))
+	)
+	for _, ev := range evs {
+		name, err := r.evm.KeyFor(ev)
+		if err != nil {
+			return nil, xerrors.Errorf("replay: can't look up event: %w", err)
+		}
+		packedEv, err := packing.PackPartitionEvent(jp, pn, name, retro.EventVersion(r.evm, name), ev)
+		if err != nil {
+			return nil, xerrors.Errorf("replay: can't pack event %s: %w", name, err)
+		}
+		packedEvs = append(packedEvs, packedEv)
+	}
+	return packedEvs, nil
+}
+
+// parentPin pins reads to the parent of the checkpoint. The first
+// checkpoint of a history has no parent, reads are pinned to the
+// checkpoint itself but restricted to checkpoints dated before it, which
+// leaves nothing to read.
+func parentPin(wc storage.WalkedCheckpoint, parents []retro.Hash) ref.Pin {
+	if len(parents) == 1 {
+		return ref.Pin{Checkpoint: parents[0]}
+	}
+	return ref.Pin{Checkpoint: wc.CheckpointHash, Time: wc.Time.Add(-time.Second)}
+}
+
+// session rehydrates the recorded session, commands starting the session
+// are applied without one, as the Engine does.
+func (r Replayer) session(ctx context.Context, repo retro.Repo, sid retro.SessionID, command packing.Command) (retro.Session, error) {
+
+	var sessionPath = filepath.Join("session", string(sid))
+	if sid == "" || command.Path == sessionPath {
+		return nil, nil
+	}
+
+	seshAgg, err := r.aggm.ForPath("session")
+	if err != nil {
+		return nil, xerrors.Errorf("replay: can't look up session aggregate: %w", err)
+	}
+	if seshAgg == nil {
+		return nil, xerrors.New("replay: no session aggregate registered")
+	}
+	if err := seshAgg.SetName(retro.PartitionName(sessionPath)); err != nil {
+		return nil, xerrors.Errorf("replay: can't name session aggregate: %w", err)
+	}
+	if err := repo.Rehydrate(ctx, seshAgg, retro.PartitionName(sessionPath)); err != nil {
+		return nil, xerrors.Errorf("replay: can't rehydrate %s: %w", sessionPath, err)
+	}
+
+	return seshAgg, nil
+}
+
+// diff compares the events stored in affix with the events in cmdRess, in
+// the order the commands yielding them were applied.
+//
+// Aggregates created by the command are named by the Engine when they are
+// persisted, the replayed ones have no name yet. They are matched with a
+// stored partition of the same type which no other aggregate wrote to,
+// preferring one with identical events.
+func (r Replayer) diff(affix packing.Affix, cmdRess ...retro.CommandResult) ([]Divergence, error) {
+
+	var (
+		replayed  = make(map[retro.PartitionName][]retro.Hash)
+		anonymous = make(map[string][][]retro.Event)
+	)
+
+	for _, cmdRes := range cmdRess {
+		for agg, evs := range cmdRes {
+			if len(evs) == 0 {
+				continue
+			}
+			if agg.Name() == "" {
+				var typeName = flect.Underscore(aggregateType(agg).Name())
+				anonymous[typeName] = append(anonymous[typeName], evs)
+				continue
+			}
+			hashes, err := r.hashes(agg.Name(), evs)
+			if err != nil {
+				return nil, err
+			}
+			replayed[agg.Name()] = append(replayed[agg.Name()], hashes...)
+		}
+	}
+
+	var typeNames = make([]string, 0, len(anonymous))
+	for typeName := range anonymous {
+		typeNames = append(typeNames, typeName)
+	}
+	sort.Strings(typeNames)
+
+	// Events are packed for the partition they are matched with, as the
+	// Packer may pack them differently depending on the partition (see
+	// packing.EncryptingPacker).
+	for _, typeName := range typeNames {
+		var unclaimed []retro.PartitionName
+		for pn := range affix {
+			if _, claimed := replayed[pn]; !claimed && pn.Dirname() == typeName {
+				unclaimed = append(unclaimed, pn)
+			}
+		}
+		sort.Slice(unclaimed, func(i, j int) bool { return unclaimed[i] < unclaimed[j] })
+
+		for i, evs := range anonymous[typeName] {
+			var (
+				match  = -1
+				hashes []retro.Hash
+			)
+			for j, pn := range unclaimed {
+				candidate, err := r.hashes(pn, evs)
+				if err != nil {
+					return nil, err
+				}
+				if j == 0 {
+					hashes = candidate
+				}
+				if equalHashes(affix[pn], candidate) {
+					match, hashes = j, candidate
+					break
+				}
+			}
+			if match < 0 && len(unclaimed) > 0 {
+				match = 0
+			}
+			if match < 0 {
+				var pn = retro.PartitionName(fmt.Sprintf("%s/<new-%d>", typeName, i))
+				// There's no partition to pack the events for.
+				hashes, err := r.hashes("", evs)
+				if err != nil {
+					return nil, err
+				}
+				replayed[pn] = hashes
+				continue
+			}
+			replayed[unclaimed[match]] = hashes
+			unclaimed = append(unclaimed[:match], unclaimed[match+1:]...)
+		}
+	}
+
+	var partitions = make(map[retro.PartitionName]bool, len(affix)+len(replayed))
+	for pn := range affix {
+		partitions[pn] = true
+	}
+	for pn := range replayed {
+		partitions[pn] = true
+	}
+
+	var divergences []Divergence
+	for pn := range partitions {
+		if !equalHashes(affix[pn], replayed[pn]) {
+			divergences = append(divergences, Divergence{
+				Partition: pn,
+				Stored:    affix[pn],
+				Replayed:  replayed[pn],
+			})
+		}
+	}
+	sort.Slice(divergences, func(i, j int) bool { return divergences[i].Partition < divergences[j].Partition })
+
+	return divergences, nil
+}
+
+// hashes returns the hashes of the events packed for the partition.
+func (r Replayer) hashes(pn retro.PartitionName, evs []retro.Event) ([]retro.Hash, error) {
+	packedEvs, err := r.pack(pn, evs)
+	if err != nil {
+		return nil, err
+	}
+	var hashes = make([]retro.Hash, len(packedEvs))
+	for i, packedEv := range packedEvs {
+		hashes[i] = packedEv.Hash()
+	}
+	return hashes, nil
+}
+
+func equalHashes(a, b []retro.Hash) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].String() != b[i].String() {
+			return false
+		}
+	}
+	return true
+}
+
+func aggregateType(agg retro.Aggregate) reflect.Type {
+	var v = reflect.ValueOf(agg)
+	if reflect.Ptr == v.Kind() || reflect.Interface == v.Kind() {
+		v = v.Elem()
+	}
+	return v.Type()
+}
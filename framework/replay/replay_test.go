@@ -0,0 +1,186 @@
+// +build integration
+
+package replay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/retro-framework/go-retro/aggregates"
+	"github.com/retro-framework/go-retro/commands"
+	"github.com/retro-framework/go-retro/events"
+	"github.com/retro-framework/go-retro/framework/depot"
+	"github.com/retro-framework/go-retro/framework/engine"
+	"github.com/retro-framework/go-retro/framework/ref"
+	"github.com/retro-framework/go-retro/framework/repository"
+	"github.com/retro-framework/go-retro/framework/resolver"
+	"github.com/retro-framework/go-retro/framework/retro"
+	"github.com/retro-framework/go-retro/framework/storage/memory"
+	test "github.com/retro-framework/go-retro/framework/test_helper"
+	"golang.org/x/xerrors"
+)
+
+type stepClock struct {
+	t time.Time
+}
+
+func (c *stepClock) Now() time.Time {
+	c.t = c.t.Add(5 * time.Second)
+	return c.t
+}
+
+type session struct{ aggregates.NamedAggregate }
+
+func (*session) ReactTo(retro.Event) error { return nil }
+
+type SessionStarted struct{}
+
+type Start struct{ s *session }
+
+func (cmd *Start) SetState(s retro.Aggregate) error {
+	if agg, ok := s.(*session); ok {
+		cmd.s = agg
+		return nil
+	}
+	return xerrors.New("can't cast aggregate state")
+}
+
+func (cmd *Start) Apply(context.Context, io.Writer, retro.Session, retro.Repo) (retro.CommandResult, error) {
+	return retro.CommandResult{cmd.s: []retro.Event{SessionStarted{}}}, nil
+}
+
+type counter struct {
+	aggregates.NamedAggregate
+	count int
+}
+
+func (c *counter) ReactTo(ev retro.Event) error {
+	if inc, ok := ev.(*Incremented); ok {
+		c.count = inc.To
+	}
+	return nil
+}
+
+type Incremented struct {
+	To int       `json:"to"`
+	At time.Time `json:"at"`
+}
+
+// Increment is registered as a single instance, step stands in for a
+// change to the domain logic between recording and replaying.
+type Increment struct {
+	c    *counter
+	step int
+}
+
+func (cmd *Increment) SetState(s retro.Aggregate) error {
+	if agg, ok := s.(*counter); ok {
+		cmd.c = agg
+		return nil
+	}
+	return xerrors.New("can't cast aggregate state")
+}
+
+func (cmd *Increment) Apply(ctx context.Context, _ io.Writer, _ retro.Session, _ retro.Repo) (retro.CommandResult, error) {
+	var ev = Incremented{To: cmd.c.count + cmd.step, At: retro.ClockFromContext(ctx).Now()}
+	return retro.CommandResult{cmd.c: []retro.Event{ev}}, nil
+}
+
+func Test_Replayer(t *testing.T) {
+
+	var (
+		ctx        = context.Background()
+		objdb      = &memory.ObjectStore{}
+		refdb      = &memory.RefStore{}
+		evM        = events.NewManifest()
+		aggM       = aggregates.NewManifest()
+		repo       = repository.NewSimpleRepository(objdb, refdb, evM)
+		idFn       = func() (string, error) { return fmt.Sprintf("%x", "replay"), nil }
+		manifestFn = func(step int) retro.CommandManifest {
+			var cmdM = commands.NewManifest()
+			cmdM.Register(&session{}, &Start{})
+			cmdM.Register(&counter{}, &Increment{step: step})
+			return cmdM
+		}
+	)
+
+	aggM.Register("session", &session{})
+	aggM.Register("counter", &counter{})
+	evM.Register(&SessionStarted{})
+	evM.Register(&Incremented{})
+
+	var e = engine.New(depot.NewSimple(objdb, refdb), repo, resolver.New(aggM, manifestFn(1)), idFn, &stepClock{}, aggM, evM)
+
+	sid, err := e.StartSession(ctx)
+	test.H(t).IsNil(err)
+	// The first increment creates the counter, which is named by the
+	// engine, the following ones rehydrate it.
+	var counterPath = fmt.Sprintf("counter/%x", "replay")
+	for i := 0; i < 3; i++ {
+		_, err := e.Apply(ctx, ioutil.Discard, sid, []byte(fmt.Sprintf(`{"path":%q,"name":"Increment"}`, counterPath)))
+		test.H(t).IsNil(err)
+	}
+
+	t.Run("reproduces the stored events", func(t *testing.T) {
+		results, err := New(objdb, refdb, repo, resolver.New(aggM, manifestFn(1)), aggM, evM).Run(ctx)
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(len(results), 4)
+		for _, res := range results {
+			test.H(t).StringEql(res.Skipped, "")
+			if res.Diverged() {
+				t.Errorf("expected %s (%s) to replay cleanly, got err %v and divergences %v", res.Checkpoint, res.Command.Name, res.Err, res.Divergences)
+			}
+		}
+	})
+
+	t.Run("reports divergences per checkpoint", func(t *testing.T) {
+		results, err := New(objdb, refdb, repo, resolver.New(aggM, manifestFn(2)), aggM, evM).Run(ctx)
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(len(results), 4)
+
+		test.H(t).BoolEql(results[0].Diverged(), false)
+		for _, res := range results[1:] {
+			test.H(t).IsNil(res.Err)
+			test.H(t).IntEql(len(res.Divergences), 1)
+			test.H(t).StringEql(string(res.Divergences[0].Partition), counterPath)
+			test.H(t).IntEql(len(res.Divergences[0].Stored), 1)
+			test.H(t).IntEql(len(res.Divergences[0].Replayed), 1)
+		}
+	})
+
+	t.Run("replays the history of a pinned checkpoint", func(t *testing.T) {
+		all, err := New(objdb, refdb, repo, resolver.New(aggM, manifestFn(1)), aggM, evM).Run(ctx)
+		test.H(t).IsNil(err)
+
+		var pinned = ref.WithPin(ctx, ref.Pin{Checkpoint: all[1].Checkpoint})
+		results, err := New(objdb, refdb, repo, resolver.New(aggM, manifestFn(1)), aggM, evM).Run(pinned)
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(len(results), 2)
+	})
+
+	t.Run("replays the commands of a batch in order", func(t *testing.T) {
+		var inc = []byte(fmt.Sprintf(`{"path":%q,"name":"Increment"}`, counterPath))
+		_, err := e.ApplyBatch(ctx, ioutil.Discard, sid, [][]byte{inc, inc})
+		test.H(t).IsNil(err)
+
+		results, err := New(objdb, refdb, repo, resolver.New(aggM, manifestFn(1)), aggM, evM).Run(ctx)
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(len(results), 5)
+		var last = results[4]
+		test.H(t).IntEql(len(last.Command.Batch), 2)
+		if last.Diverged() {
+			t.Errorf("expected the batch to replay cleanly, got err %v and divergences %v", last.Err, last.Divergences)
+		}
+
+		results, err = New(objdb, refdb, repo, resolver.New(aggM, manifestFn(2)), aggM, evM).Run(ctx)
+		test.H(t).IsNil(err)
+		last = results[4]
+		test.H(t).IntEql(len(last.Divergences), 1)
+		test.H(t).IntEql(len(last.Divergences[0].Stored), 2)
+		test.H(t).IntEql(len(last.Divergences[0].Replayed), 2)
+	})
+}
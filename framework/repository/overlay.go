@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/retro-framework/go-retro/framework/packing"
+	"github.com/retro-framework/go-retro/framework/retro"
+)
+
+// Overlay is a Repo which layers events which were not yet stored on top
+// of another Repo. Aggregates are rehydrated from the underlying Repo and
+// then react to the events added to the overlay, in the order they were
+// added. It allows a series of commands to see each other's events before
+// they are stored together, e.g a batch applied by the Engine.
+type Overlay struct {
+	retro.Repo
+
+	evm retro.EventManifest
+	jp  packing.Packer
+
+	mu      sync.Mutex
+	pending map[retro.PartitionName][]retro.HashedObject
+}
+
+// NewOverlay returns an empty Overlay on top of r, evm and jp are used to
+// unpack the events added to it.
+func NewOverlay(r retro.Repo, evm retro.EventManifest, jp packing.Packer) *Overlay {
+	return &Overlay{
+		Repo:    r,
+		evm:     evm,
+		jp:      jp,
+		pending: make(map[retro.PartitionName][]retro.HashedObject),
+	}
+}
+
+// Add adds packed events for the partition, they are applied after the
+// ones added earlier.
+func (o *Overlay) Add(pn retro.PartitionName, packedEvs ...retro.HashedObject) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pending[pn] = append(o.pending[pn], packedEvs...)
+}
+
+// Exists returns true if events were added for the partition or it exists
+// in the underlying Repo.
+func (o *Overlay) Exists(ctx context.Context, pn retro.PartitionName) bool {
+	o.mu.Lock()
+	var pending = len(o.pending[pn]) > 0
+	o.mu.Unlock()
+	return pending || o.Repo.Exists(ctx, pn)
+}
+
+// Rehydrate rehydrates dst from the underlying Repo and applies the events
+// added for the partition on top.
+func (o *Overlay) Rehydrate(ctx context.Context, dst retro.Aggregate, pn retro.PartitionName) error {
+
+	if err := o.Repo.Rehydrate(ctx, dst, pn); err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	var pending = o.pending[pn]
+	o.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	spnOverlay, _ := opentracing.StartSpanFromContext(ctx, "repository.Overlay.Rehydrate")
+	defer spnOverlay.Finish()
+
+	for _, packedEv := range pending {
+		if err := reactTo(spnOverlay, o.jp, o.evm, dst, packedEv); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error applying pending event %s", packedEv.Hash()))
+		}
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/retro-framework/go-retro/events"
@@ -12,12 +13,15 @@ import (
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/log"
 	"github.com/pkg/errors"
+	"github.com/retro-framework/go-retro/framework/index"
+	"github.com/retro-framework/go-retro/framework/lock"
 	"github.com/retro-framework/go-retro/framework/matcher"
 	"github.com/retro-framework/go-retro/framework/object"
 	"github.com/retro-framework/go-retro/framework/packing"
 	"github.com/retro-framework/go-retro/framework/ref"
 	"github.com/retro-framework/go-retro/framework/retro"
 	"github.com/retro-framework/go-retro/framework/storage"
+	"golang.org/x/xerrors"
 )
 
 type simple struct {
@@ -25,12 +29,49 @@ type simple struct {
 	refdb ref.DB
 
 	eventManifest retro.EventManifest
+	packer        packing.Packer
 
-	matcher retro.Matcher
+	locks retro.LockManager
+	index *index.Index
+
+	snapshotPolicy SnapshotPolicy
 }
 
 type double struct {
 	fixture retro.EventFixture
+
+	locks retro.LockManager
+}
+
+// Option configures optional behaviour of the simple repository.
+type Option func(*simple)
+
+// WithPacker sets the Packer used to unpack events and to pack and unpack
+// snapshots, the default is a JSONPacker. Events are read whichever
+// Packer packed them.
+func WithPacker(p packing.Packer) Option {
+	return func(s *simple) {
+		s.packer = p
+	}
+}
+
+// WithLockManager makes the repository use lm for Claim and Release,
+// several engine processes sharing one depot need to share a
+// LockManager (e.g lock.Redis) too. By default claims are only
+// exclusive within the repository itself.
+func WithLockManager(lm retro.LockManager) Option {
+	return func(s *simple) {
+		s.locks = lm
+	}
+}
+
+// WithIndex makes Exists and Rehydrate look up the checkpoints touching a
+// partition in idx instead of walking the whole history. The index should
+// be shared with the Depot so that it is updated as checkpoints are stored.
+func WithIndex(idx *index.Index) Option {
+	return func(s *simple) {
+		s.index = idx
+	}
 }
 
 func NewEmptyMemory() retro.Repo {
@@ -38,29 +79,35 @@ func NewEmptyMemory() retro.Repo {
 		objdb:         &memory.ObjectStore{},
 		refdb:         &memory.RefStore{},
 		eventManifest: events.NewManifest(),
+		packer:        packing.NewJSONPacker(),
+		locks:         lock.NewMemory(),
 	}
 }
 
-func NewSimpleRepository(odb object.DB, rdb ref.DB, evM retro.EventManifest) retro.Repo {
-	return simple{
+func NewSimpleRepository(odb object.DB, rdb ref.DB, evM retro.EventManifest, opts ...Option) retro.Repo {
+	var s = simple{
 		objdb:         odb,
 		refdb:         rdb,
 		eventManifest: evM,
+		packer:        packing.NewJSONPacker(),
+		locks:         lock.NewMemory(),
 	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return s
 }
 
 func NewSimpleRepositoryDouble(evFix retro.EventFixture) retro.Repo {
-	return double{evFix}
+	return double{evFix, lock.NewMemory()}
 }
 
 func (s double) Claim(ctx context.Context, partition string) bool {
-	// TODO: Implement locking properly
-	return true
+	return s.locks.Claim(ctx, partition)
 }
 
 func (s double) Release(partition string) {
-	// TODO: Implement locking properly
-	return
+	s.locks.Release(partition)
 }
 
 func (s double) Exists(_ context.Context, partitionName retro.PartitionName) bool {
@@ -85,20 +132,25 @@ func (s double) Rehydrate(ctx context.Context, dst retro.Aggregate, partitionNam
 	return nil
 }
 
+// Claim blocks until the partition could be claimed exclusively or the
+// ctx is done, it returns true if the claim was granted.
 func (s simple) Claim(ctx context.Context, partition string) bool {
-	// TODO: Implement locking properly
-	return true
+	return s.locks.Claim(ctx, partition)
 }
 
 func (s simple) Release(partition string) {
-	// TODO: Implement locking properly
-	return
+	s.locks.Release(partition)
 }
 
 func (s simple) Exists(ctx context.Context, partitionName retro.PartitionName) bool {
+	if s.index != nil {
+		found, _ := s.existsInIndex(ctx, partitionName)
+		return found
+	}
 	found, _ := simplePartitionExistenceChecker{
 		objdb:   s.objdb,
 		refdb:   s.refdb,
+		packer:  s.packer,
 		pattern: partitionName,
 		matcher: matcher.NewGlobPattern(string(partitionName)),
 	}.Exists(ctx, partitionName)
@@ -111,175 +163,190 @@ func (s simple) Rehydrate(ctx context.Context, dst retro.Aggregate, partitionNam
 	spnRehydrate.SetTag("partitionName", string(partitionName))
 	defer spnRehydrate.Finish()
 
-	var (
-		oStack storage.AffixStack
-		jp     *packing.JSONPacker
-	)
-
-	// Resolve the head ref for the given ctx
-	headRef, err := s.refdb.Retrieve(refFromCtx(ctx))
+	// Resolve the head ref (or the pinned checkpoint) for the given ctx
+	var pin = ref.PinFromContext(ctx)
+	headRef, branch, err := readHead(ctx, s.refdb)
 	if err != nil {
-		return errors.Wrapf(err, "unknown ref, can't lookup partitions for %s", string(partitionName))
+		return retro.NotFoundError{Path: partitionName, Err: errors.Wrap(err, "unknown ref, can't lookup partitions")}
 	}
 
 	spanGatherCheckpoints := opentracing.StartSpan("gathering relevant checkpoints", opentracing.ChildOf(spnRehydrate.Context()))
 	defer spanGatherCheckpoints.Finish()
-	// enqueueCheckpointIfRelevant will push the checkpoint and any ancestors
-	// onto the stack and we'll continue when the recursive enqueueCheckpointIfRelevant
-	// breaks the loop and we come back here.
-	err = s.enqueueCheckpointIfRelevant(partitionName, headRef, &oStack)
-	if err != nil {
-		return errors.Wrap(err, "error when stacking relevant partitions")
-	}
-	spnRehydrate.LogFields(log.Int("found.checkpoints", oStack.Len()))
-	spanGatherCheckpoints.Finish()
-
-	spanDrainCheckpoints := opentracing.StartSpan("draining relavant checkpoints", opentracing.ChildOf(spnRehydrate.Context()))
-	defer spanDrainCheckpoints.Finish()
-	for {
-		h := oStack.Pop()
-		if h == nil {
-			break
+	var history []partitionCheckpoint
+	if s.index != nil {
+		entries, err := s.index.Entries(branch, headRef, partitionName)
+		if err != nil {
+			return errors.Wrap(err, "error when looking up relevant partitions in index")
 		}
-		for partitionName, affixEvHashes := range h.Affix {
-			match, err := s.matcher.DoesMatch(partitionName)
-			if err != nil {
-				return fmt.Errorf("error checking partition name %s against pattern %s for match", partitionName, partitionName)
+		for _, e := range entries {
+			if !visibleAt(pin, e.Time) {
+				continue
 			}
+			history = append(history, partitionCheckpoint{e.Checkpoint, e.Events})
+		}
+	} else {
+		walked, err := storage.NewWalker(s.objdb, storage.WithPacker(s.packer)).Ordered(headRef, nil)
+		if err != nil {
+			return errors.Wrap(err, "error when stacking relevant partitions")
+		}
+		for _, wc := range walked {
 			// the affix contains events for other aggregates
 			// but no biggie
-			// TODO: remove this guard and test this case(!)
-			if partitionName != dst.Name() {
-				continue
+			if evHashes := wc.Affix[partitionName]; len(evHashes) > 0 && visibleAt(pin, wc.Time) {
+				history = append(history, partitionCheckpoint{wc.CheckpointHash, evHashes})
 			}
-			if match {
-
-				for _, evHash := range affixEvHashes {
-
-					spanApplyEv := opentracing.StartSpan(
-						fmt.Sprintf("apply event %s", evHash.String()),
-						opentracing.ChildOf(spanDrainCheckpoints.Context()),
-					)
-					defer spanApplyEv.Finish()
-
-					spanApplyEv.LogFields(
-						log.String("event.hash", evHash.String()),
-					)
-
-					packedEv, err := s.objdb.RetrievePacked(evHash.String())
-					if err != nil {
-						// TODO: test me
-						return errors.Wrap(err, "error retrieving packed object from odb from evHash")
-					}
-
-					if packedEv.Type() != packing.ObjectTypeEvent {
-						// TODO: test me
-						return errors.Wrap(err, fmt.Sprintf("object was not a %s but a %s", packing.ObjectTypeEvent, packedEv.Type()))
-					}
-
-					evName, evPayload, err := jp.UnpackEvent(packedEv.Contents())
-					if err != nil {
-						// TODO: test me
-						return errors.Wrap(err, fmt.Sprintf("can't unpack event %s", packedEv.Contents()))
-					}
-
-					spanApplyEv.LogFields(
-						log.String("event.name", evName),
-						log.String("event.payload", string(evPayload)),
-					)
-
-					ev, err := s.eventManifest.ForName(evName)
-					if err != nil {
-						return errors.Wrap(err, fmt.Sprintf("can't get event with name %s from manifest", evName))
-					}
-
-					err = json.Unmarshal(evPayload, &ev)
-					if err != nil {
-						return errors.Wrap(err, fmt.Sprintf("can't get unmarshal %s into event registered with name %s: %s", evPayload, evName, err))
-					}
-
-					if err = dst.ReactTo(ev); err != nil {
-						return errors.Wrap(err, fmt.Sprintf("error applying %q", evName))
-					}
-
-					spanApplyEv.Finish()
-				}
+		}
+	}
+	spnRehydrate.LogFields(log.Int("found.checkpoints", len(history)))
+	spanGatherCheckpoints.Finish()
+
+	// Start from the newest usable snapshot, if there is one, and replay
+	// only the checkpoints which came after it. Snapshots cover the whole
+	// ancestry of a checkpoint, which may include checkpoints dated after
+	// a pinned time, so they are not used when pinned to a time.
+	var snap restoredSnapshot
+	if pin.Time.IsZero() {
+		snap = s.restoreSnapshot(dst, partitionName, history)
+	}
+	spnRehydrate.LogFields(log.Int("snapshot.checkpoints", snap.checkpoints))
+
+	spanDrainCheckpoints := opentracing.StartSpan("draining relavant checkpoints", opentracing.ChildOf(spnRehydrate.Context()))
+	defer spanDrainCheckpoints.Finish()
+	var replayed int
+	for _, pc := range history[snap.checkpoints:] {
+		for _, evHash := range pc.events {
+			if err := s.applyEvent(spanDrainCheckpoints, dst, evHash); err != nil {
+				return err
 			}
+			replayed++
 		}
 	}
 	spanDrainCheckpoints.Finish()
 
+	// Snapshots of the past would only get in the way of rehydrating the
+	// present, they are written only when reading from the head.
+	if s.snapshotPolicy != nil && pin.IsZero() && replayed > 0 && s.snapshotPolicy(partitionName, replayed) {
+		err := s.writeSnapshot(dst, partitionName, history[len(history)-1].hash, snap.events+replayed, snap.hash)
+		if err != nil {
+			// A missing snapshot only costs time, don't fail the rehydration.
+			spnRehydrate.LogFields(log.Error(err))
+		}
+	}
+
 	return nil
 }
 
-// enqueueCheckpointIfRelevant pushes checkpoint hashes and affix metadata onto a stack
-// which the caller can then drain. enqueueCheckpointIfRelevant is expected to be called
-// with a HEAD ref so that the most recent checkpoint on any given thread is pushed onto
-// the stack first, and emitted last.
-func (s simple) enqueueCheckpointIfRelevant(pattern retro.PartitionName, checkpointObjHash retro.Hash, st *storage.AffixStack) error {
+// applyEvent retrieves and unpacks the event and applies it to dst.
+func (s simple) applyEvent(parent opentracing.Span, dst retro.Aggregate, evHash retro.Hash) error {
 
-	var jp *packing.JSONPacker
+	spanApplyEv := opentracing.StartSpan(
+		fmt.Sprintf("apply event %s", evHash.String()),
+		opentracing.ChildOf(parent.Context()),
+	)
+	defer spanApplyEv.Finish()
 
-	// Unpack a Checkpoint
-	packedCheckpoint, err := s.objdb.RetrievePacked(checkpointObjHash.String())
+	spanApplyEv.LogFields(
+		log.String("event.hash", evHash.String()),
+	)
+
+	packedEv, err := s.objdb.RetrievePacked(evHash.String())
 	if err != nil {
-		// TODO: test this case
-		return errors.Wrap(err, fmt.Sprintf("can't read object %s", checkpointObjHash.String()))
+		// TODO: test me
+		return errors.Wrap(err, "error retrieving packed object from odb from evHash")
 	}
 
-	if packedCheckpoint.Type() != packing.ObjectTypeCheckpoint {
-		// TODO: test this case
-		return errors.Wrap(err, fmt.Sprintf("object was not a %s but a %s", packing.ObjectTypeCheckpoint, packedCheckpoint.Type()))
+	return reactTo(spanApplyEv, s.packer, s.eventManifest, dst, packedEv)
+}
+
+// reactTo unpacks the packed event and applies it to dst.
+func reactTo(spanApplyEv opentracing.Span, jp packing.Packer, evm retro.EventManifest, dst retro.Aggregate, packedEv retro.HashedObject) error {
+
+	if packedEv.Type() != packing.ObjectTypeEvent {
+		// TODO: test me
+		return errors.New(fmt.Sprintf("object was not a %s but a %s", packing.ObjectTypeEvent, packedEv.Type()))
 	}
 
-	checkpoint, err := jp.UnpackCheckpoint(packedCheckpoint.Contents())
+	evName, evVersion, evPayload, err := jp.UnpackVersionedEvent(packedEv.Contents())
+	if xerrors.Is(err, retro.ErrErased) {
+		return err
+	}
 	if err != nil {
-		// TODO: test this case
-		return errors.Wrap(err, fmt.Sprintf("can't read object %s", checkpointObjHash.String()))
+		// TODO: test me
+		return errors.Wrap(err, fmt.Sprintf("can't unpack event %s", packedEv.Contents()))
 	}
 
-	// Unpack the Affix
-	packedAffix, err := s.objdb.RetrievePacked(checkpoint.AffixHash.String())
+	// Aggregates only know the latest version of events
+	evPayload, err = retro.UpcastEvent(evm, evName, evVersion, evPayload)
 	if err != nil {
-		// TODO: test this case
-		return errors.Wrap(err, fmt.Sprintf("retrieve affix %s for checkpoint %s", checkpoint.AffixHash.String(), packedCheckpoint.Hash().String()))
+		return errors.Wrap(err, fmt.Sprintf("can't upcast event %s", evName))
 	}
 
-	if packedAffix.Type() != packing.ObjectTypeAffix {
-		// TODO: test this case
-		return errors.Wrap(err, fmt.Sprintf("object was not a %s but a %s", packing.ObjectTypeAffix, packedCheckpoint.Type()))
+	spanApplyEv.LogFields(
+		log.String("event.name", evName),
+		log.String("event.payload", string(evPayload)),
+	)
+
+	ev, err := evm.ForName(evName)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("can't get event with name %s from manifest", evName))
 	}
 
-	affix, err := jp.UnpackAffix(packedAffix.Contents())
+	err = json.Unmarshal(evPayload, &ev)
 	if err != nil {
-		// TODO: test this case
-		return errors.Wrap(err, fmt.Sprintf("unpack affix %s for checkpoint %s", checkpoint.AffixHash.String(), packedCheckpoint.Hash().String()))
+		return errors.Wrap(err, fmt.Sprintf("can't get unmarshal %s into event registered with name %s: %s", evPayload, evName, err))
 	}
 
-	for partition := range affix {
-		matched, err := matcher.NewGlobPattern(string(pattern)).DoesMatch(string(partition))
-		if err != nil {
-			// TODO: test this case
-			return errors.Wrap(err, fmt.Sprintf("error checking partition name %s against pattern %s for match", partition, pattern))
-		}
-		if matched {
-			st.Push(storage.RelevantCheckpoint{
-				Time:           time.Time{},
-				CheckpointHash: packedCheckpoint.Hash(),
-				Affix:          affix,
-			})
-		}
+	if err = dst.ReactTo(ev); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error applying %q", evName))
 	}
 
-	// TODO: we are not deterministic when parents are ordered, we push stuff in a maybe random order
-	// subject possibly to the order the hashes are written by the packer, which I believe to be alphabetic
-	// Either way we should peek into a structure and find out which checkpoint is younger and start there
-	for _, parentCheckpointHash := range checkpoint.ParentHashes {
-		err := s.enqueueCheckpointIfRelevant(pattern, parentCheckpointHash, st)
-		if err != nil {
-			errors.Wrap(err, fmt.Sprintf("error looking up parent hash %s for checkpoint %s", parentCheckpointHash.String(), packedCheckpoint.Hash().String()))
+	return nil
+}
+
+// existsInIndex checks whether any checkpoint reachable from the head of
+// the branch in ctx touched a partition matching partitionName.
+func (s simple) existsInIndex(ctx context.Context, partitionName retro.PartitionName) (bool, error) {
+	headRef, branch, err := readHead(ctx, s.refdb)
+	if err != nil {
+		return false, err
+	}
+	// Only patterns need to be matched against every partition name,
+	// plain partition names are looked up directly.
+	var entries []index.Entry
+	if !strings.ContainsAny(string(partitionName), "*?[{\\") {
+		entries, err = s.index.Entries(branch, headRef, partitionName)
+	} else {
+		entries, err = s.index.Matching(branch, headRef, matcher.NewGlobPattern(string(partitionName)))
+	}
+	var pin = ref.PinFromContext(ctx)
+	for _, e := range entries {
+		if visibleAt(pin, e.Time) {
+			return true, err
 		}
 	}
+	return false, err
+}
 
-	return nil
+// readHead returns the checkpoint reads through ctx start from, the
+// pinned checkpoint if there is one, else the head of the branch. The
+// branch name is returned only in the latter case, the index uses it to
+// cache reachability.
+func readHead(ctx context.Context, refdb ref.Source) (retro.Hash, string, error) {
+	if pin := ref.PinFromContext(ctx); pin.Checkpoint != nil {
+		return pin.Checkpoint, "", nil
+	}
+	var branch = ref.BranchFromContext(ctx)
+	headRef, err := refdb.Retrieve(branch)
+	return headRef, branch, err
+}
+
+// visibleAt returns true unless the pin restricts reads to checkpoints
+// dated before t. Checkpoints without a date (t is zero, see
+// storage.CheckpointTime) can't be placed in time and are never visible
+// when pinned to a time.
+func visibleAt(pin ref.Pin, t time.Time) bool {
+	if pin.Time.IsZero() {
+		return true
+	}
+	return !t.IsZero() && !t.After(pin.Time)
 }
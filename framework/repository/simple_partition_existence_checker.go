@@ -2,7 +2,6 @@ package repository
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
@@ -11,11 +10,13 @@ import (
 	"github.com/retro-framework/go-retro/framework/ref"
 	"github.com/retro-framework/go-retro/framework/retro"
 	"github.com/retro-framework/go-retro/framework/storage"
+	"golang.org/x/xerrors"
 )
 
 type simplePartitionExistenceChecker struct {
 	objdb   object.DB
 	refdb   ref.DB
+	packer  packing.Packer
 	pattern retro.PartitionName
 	matcher retro.Matcher
 }
@@ -32,80 +33,38 @@ func (s simplePartitionExistenceChecker) Exists(ctx context.Context, partitionNa
 	spnExists, ctx := opentracing.StartSpanFromContext(ctx, "simplePartitionExistenceChecker.Exists")
 	spnExists.SetTag("partitionName", string(partitionName))
 	defer spnExists.Finish()
-	headRef, err := s.refdb.Retrieve(refFromCtx(ctx))
+	headRef, _, err := readHead(ctx, s.refdb)
 	if err != nil {
 		spnExists.SetTag("error", err)
 		return false, err
 	}
-	return s.returnTruOnMatching(ctx, headRef)
-}
-
-// / enqueueCheckpointIfRelevant pushes checkpoint hashes and affix metadata onto a stack
-// which the caller can then drain. enqueueCheckpointIfRelevant is expected to be called
-// with a HEAD ref so that the most recent checkpoint on any given thread is pushed onto
-// the stack first, and emitted last.
-func (s simplePartitionExistenceChecker) returnTruOnMatching(ctx context.Context, checkpointObjHash retro.Hash) (bool, error) {
 
-	var jp *packing.JSONPacker
-
-	// Unpack a Checkpoint
-	packedCheckpoint, err := s.objdb.RetrievePacked(checkpointObjHash.String())
-	if err != nil {
-		// database is likely
-		if err == storage.ErrUnknownRef {
-			return false, nil
+	var (
+		found bool
+		pin   = ref.PinFromContext(ctx)
+	)
+	err = storage.NewWalker(s.objdb, storage.WithPacker(s.packer)).Walk(headRef, nil, func(wc storage.WalkedCheckpoint) error {
+		if !visibleAt(pin, wc.Time) {
+			return nil
 		}
-		// TODO: test this case
-		return false, errors.Wrap(err, fmt.Sprintf("can't read object %s", checkpointObjHash.String()))
-	}
-
-	if packedCheckpoint.Type() != packing.ObjectTypeCheckpoint {
-		// TODO: test this case
-		return false, errors.Wrap(err, fmt.Sprintf("object was not a %s but a %s", packing.ObjectTypeCheckpoint, packedCheckpoint.Type()))
-	}
-
-	checkpoint, err := jp.UnpackCheckpoint(packedCheckpoint.Contents())
-	if err != nil {
-		// TODO: test this case
-		return false, errors.Wrap(err, fmt.Sprintf("can't read object %s", checkpointObjHash.String()))
-	}
-
-	// Unpack the Affix
-	packedAffix, err := s.objdb.RetrievePacked(checkpoint.AffixHash.String())
-	if err != nil {
-		// TODO: test this case
-		return false, errors.Wrap(err, fmt.Sprintf("retrieve affix %s for checkpoint %s", checkpoint.AffixHash.String(), packedCheckpoint.Hash().String()))
-	}
-
-	if packedAffix.Type() != packing.ObjectTypeAffix {
-		// TODO: test this case
-		return false, errors.Wrap(err, fmt.Sprintf("object was not a %s but a %s", packing.ObjectTypeAffix, packedCheckpoint.Type()))
-	}
-
-	affix, err := jp.UnpackAffix(packedAffix.Contents())
-	if err != nil {
-		// TODO: test this case
-		return false, errors.Wrap(err, fmt.Sprintf("unpack affix %s for checkpoint %s", checkpoint.AffixHash.String(), packedCheckpoint.Hash().String()))
-	}
-
-	for partition := range affix {
-		matched, err := s.matcher.DoesMatch(string(partition))
-		if err != nil {
-			// TODO: test this case
-			return false, errors.Wrap(err, fmt.Sprintf("error checking partition name %s against pattern %s for match", partition, s.pattern))
-		}
-		if matched {
-			return true, nil
+		for partition := range wc.Affix {
+			matched, err := s.matcher.DoesMatch(string(partition))
+			if err != nil {
+				return errors.Wrapf(err, "error checking partition name %s against pattern %s for match", partition, s.pattern)
+			}
+			if matched {
+				found = true
+				return storage.ErrStopWalk
+			}
 		}
+		return nil
+	})
+	// database is likely empty
+	if xerrors.Is(err, storage.ErrUnknownRef) {
+		return false, nil
 	}
-
-	// TODO: we are not deterministic when parents are ordered, we push stuff in a maybe random order
-	// subject possibly to the order the hashes are written by the packer, which I believe to be alphabetic
-	// Either way we should peek into a structure and find out which checkpoint is younger and start there
-	for _, parentCheckpointHash := range checkpoint.ParentHashes {
-		return s.returnTruOnMatching(ctx, parentCheckpointHash)
+	if err != nil {
+		spnExists.SetTag("error", err)
 	}
-
-	return false, nil
-
+	return found, err
 }
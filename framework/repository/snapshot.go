@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/pkg/errors"
+	"github.com/retro-framework/go-retro/framework/packing"
+	"github.com/retro-framework/go-retro/framework/retro"
+)
+
+// SnapshotRefPrefix is prepended to a partition name to get the name of the
+// ref pointing at the newest snapshot of that partition. Older snapshots
+// are reachable through packing.Snapshot.PreviousHash.
+const SnapshotRefPrefix = "refs/snapshots/"
+
+// SnapshotPolicy decides whether a snapshot of the partition should be
+// written after rehydrating it, replayed is the number of events which had
+// to be replayed on top of the snapshot the rehydration started from (or
+// from scratch).
+type SnapshotPolicy func(pn retro.PartitionName, replayed int) bool
+
+// EveryNEvents returns a SnapshotPolicy which writes a snapshot whenever at
+// least n events had to be replayed.
+func EveryNEvents(n int) SnapshotPolicy {
+	return func(_ retro.PartitionName, replayed int) bool {
+		return replayed >= n
+	}
+}
+
+// WithSnapshotPolicy makes Rehydrate write snapshots of aggregates which
+// implement retro.SnapshottableAggregate when p says so. Existing
+// snapshots are used regardless of the policy.
+func WithSnapshotPolicy(p SnapshotPolicy) Option {
+	return func(s *simple) {
+		s.snapshotPolicy = p
+	}
+}
+
+// partitionCheckpoint is a checkpoint which touched the partition being
+// rehydrated and the events it holds for that partition.
+type partitionCheckpoint struct {
+	hash   retro.Hash
+	events []retro.Hash
+}
+
+// restoredSnapshot describes where rehydrating continues after restoring
+// a snapshot, checkpoints is zero if no snapshot was restored. hash is the
+// newest snapshot of the partition, restored or not.
+type restoredSnapshot struct {
+	// checkpoints is the number of checkpoints in the history covered
+	// by the snapshot.
+	checkpoints int
+	events      int
+	hash        retro.Hash
+}
+
+// restoreSnapshot walks the snapshots of the partition, newest first, and
+// restores dst from the first usable one. A snapshot is usable if it was
+// taken of the same aggregate type at the same schema version, and as of a
+// checkpoint which is part of history (i.e reachable from the head being
+// rehydrated from).
+//
+// Snapshots which can't be read are skipped, rehydrating from scratch is
+// always possible.
+func (s simple) restoreSnapshot(dst retro.Aggregate, pn retro.PartitionName, history []partitionCheckpoint) restoredSnapshot {
+
+	var (
+		jp = s.packer
+		sa retro.SnapshottableAggregate
+		ok bool
+	)
+
+	if sa, ok = dst.(retro.SnapshottableAggregate); !ok {
+		return restoredSnapshot{}
+	}
+
+	var positions = make(map[string]int, len(history))
+	for i, pc := range history {
+		positions[pc.hash.String()] = i + 1
+	}
+
+	snapshotHash, err := s.refdb.Retrieve(SnapshotRefPrefix + string(pn))
+	if err != nil {
+		return restoredSnapshot{}
+	}
+
+	// Whichever snapshot is restored, new snapshots are chained onto the
+	// newest one.
+	var none = restoredSnapshot{hash: snapshotHash}
+
+	for h := snapshotHash; h != nil; {
+		packedSnapshot, err := s.objdb.RetrievePacked(h.String())
+		if err != nil || packedSnapshot.Type() != packing.ObjectTypeSnapshot {
+			return none
+		}
+		snapshot, err := jp.UnpackSnapshot(packedSnapshot.Contents())
+		if err != nil {
+			return none
+		}
+		var covered, reachable = positions[snapshot.CheckpointHash.String()]
+		if reachable && snapshot.AggregateType == aggregateType(sa) && snapshot.SchemaVersion == sa.SchemaVersion() {
+			if err := restoreState(sa, snapshot.State); err == nil {
+				return restoredSnapshot{covered, snapshot.Events, snapshotHash}
+			}
+		}
+		h = snapshot.PreviousHash
+	}
+
+	return none
+}
+
+// writeSnapshot stores the state of dst as of the checkpoint and points
+// the partition's snapshot ref at it. If someone else wrote a snapshot
+// concurrently theirs is kept.
+func (s simple) writeSnapshot(dst retro.Aggregate, pn retro.PartitionName, checkpoint retro.Hash, events int, previous retro.Hash) error {
+
+	sa, ok := dst.(retro.SnapshottableAggregate)
+	if !ok {
+		return nil
+	}
+
+	state, err := json.Marshal(sa)
+	if err != nil {
+		return errors.Wrapf(err, "can't marshal state of %s for snapshot", pn)
+	}
+
+	packedSnapshot, err := s.packer.PackSnapshot(packing.Snapshot{
+		Partition:      pn,
+		CheckpointHash: checkpoint,
+		AggregateType:  aggregateType(sa),
+		SchemaVersion:  sa.SchemaVersion(),
+		Events:         events,
+		PreviousHash:   previous,
+		State:          state,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "can't pack snapshot of %s", pn)
+	}
+
+	if _, err := s.objdb.WritePacked(packedSnapshot); err != nil {
+		return errors.Wrapf(err, "can't store snapshot of %s", pn)
+	}
+
+	return s.refdb.CompareAndSwap(SnapshotRefPrefix+string(pn), previous, packedSnapshot.Hash())
+}
+
+// restoreState unmarshals state into a copy of dst first so that dst is
+// left untouched if the state can't be read. Unexported fields (which are
+// not part of the state) keep the values they had in dst.
+func restoreState(dst retro.SnapshottableAggregate, state []byte) error {
+	var v = reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr {
+		return errors.Errorf("can't restore snapshot into non-pointer %T", dst)
+	}
+	var fresh = reflect.New(v.Elem().Type())
+	fresh.Elem().Set(v.Elem())
+	if err := json.Unmarshal(state, fresh.Interface()); err != nil {
+		return err
+	}
+	v.Elem().Set(fresh.Elem())
+	return nil
+}
+
+func aggregateType(agg retro.Aggregate) string {
+	var t = reflect.TypeOf(agg)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.PkgPath() + "." + t.Name()
+}
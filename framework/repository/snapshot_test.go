@@ -0,0 +1,164 @@
+// +build integration
+
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/retro-framework/go-retro/aggregates"
+	"github.com/retro-framework/go-retro/events"
+	"github.com/retro-framework/go-retro/framework/packing"
+	"github.com/retro-framework/go-retro/framework/ref"
+	"github.com/retro-framework/go-retro/framework/retro"
+	"github.com/retro-framework/go-retro/framework/storage/memory"
+	test "github.com/retro-framework/go-retro/framework/test_helper"
+)
+
+type counted struct{}
+
+type counter struct {
+	aggregates.NamedAggregate
+	Count int `json:"count"`
+
+	version int
+	applied int
+}
+
+func (c *counter) ReactTo(ev retro.Event) error {
+	c.Count++
+	c.applied++
+	return nil
+}
+
+func (c *counter) SchemaVersion() int { return c.version }
+
+func Test_Snapshots(t *testing.T) {
+
+	var (
+		jp    = packing.NewJSONPacker()
+		objdb = &memory.ObjectStore{}
+		refdb = &memory.RefStore{}
+		evM   = events.NewManifest()
+		ctx   = context.Background()
+		pn    = retro.PartitionName("counter/1")
+	)
+
+	test.H(t).IsNil(evM.Register(&counted{}))
+	evName, err := evM.KeyFor(&counted{})
+	test.H(t).IsNil(err)
+
+	// commit writes a checkpoint with n events for the partition on top
+	// of the current head.
+	var commit = func(t *testing.T, n int) {
+		var evHashes []retro.Hash
+		for i := 0; i < n; i++ {
+			ev, err := jp.PackEvent(evName, struct{ N int }{i})
+			test.H(t).IsNil(err)
+			_, err = objdb.WritePacked(ev)
+			test.H(t).IsNil(err)
+			evHashes = append(evHashes, ev.Hash())
+		}
+		affix, err := jp.PackAffix(packing.Affix{pn: evHashes})
+		test.H(t).IsNil(err)
+		_, err = objdb.WritePacked(affix)
+		test.H(t).IsNil(err)
+		var parents []retro.Hash
+		if head, err := refdb.Retrieve(ref.DefaultBranch); err == nil {
+			parents = append(parents, head)
+		}
+		checkpoint, err := jp.PackCheckpoint(packing.Checkpoint{
+			AffixHash:    affix.Hash(),
+			CommandDesc:  []byte(`{"test":"snapshots"}`),
+			ParentHashes: parents,
+		})
+		test.H(t).IsNil(err)
+		_, err = objdb.WritePacked(checkpoint)
+		test.H(t).IsNil(err)
+		_, err = refdb.Write(ref.DefaultBranch, checkpoint.Hash())
+		test.H(t).IsNil(err)
+	}
+
+	var repo = NewSimpleRepository(objdb, refdb, evM, WithSnapshotPolicy(EveryNEvents(3)))
+
+	t.Run("replays everything when there is no snapshot", func(t *testing.T) {
+		commit(t, 2)
+
+		var c = &counter{version: 1}
+		test.H(t).IsNil(repo.Rehydrate(ctx, c, pn))
+		test.H(t).IntEql(c.Count, 2)
+		test.H(t).IntEql(c.applied, 2)
+
+		_, err := refdb.Retrieve(SnapshotRefPrefix + string(pn))
+		test.H(t).NotNil(err)
+	})
+
+	t.Run("writes a snapshot when the policy says so", func(t *testing.T) {
+		commit(t, 2)
+
+		var c = &counter{version: 1}
+		test.H(t).IsNil(repo.Rehydrate(ctx, c, pn))
+		test.H(t).IntEql(c.Count, 4)
+		test.H(t).IntEql(c.applied, 4)
+
+		_, err := refdb.Retrieve(SnapshotRefPrefix + string(pn))
+		test.H(t).IsNil(err)
+	})
+
+	t.Run("replays only the events after the snapshot", func(t *testing.T) {
+		commit(t, 1)
+
+		var c = &counter{version: 1}
+		test.H(t).IsNil(repo.Rehydrate(ctx, c, pn))
+		test.H(t).IntEql(c.Count, 5)
+		test.H(t).IntEql(c.applied, 1)
+	})
+
+	t.Run("ignores snapshots of another schema version", func(t *testing.T) {
+		var c = &counter{version: 2}
+		test.H(t).IsNil(repo.Rehydrate(ctx, c, pn))
+		test.H(t).IntEql(c.Count, 5)
+		test.H(t).IntEql(c.applied, 5)
+
+		t.Run("and chains the new snapshot onto the old ones", func(t *testing.T) {
+			head, err := refdb.Retrieve(SnapshotRefPrefix + string(pn))
+			test.H(t).IsNil(err)
+			packedSnapshot, err := objdb.RetrievePacked(head.String())
+			test.H(t).IsNil(err)
+			snapshot, err := jp.UnpackSnapshot(packedSnapshot.Contents())
+			test.H(t).IsNil(err)
+			test.H(t).IntEql(snapshot.SchemaVersion, 2)
+			test.H(t).IntEql(snapshot.Events, 5)
+			test.H(t).NotNil(snapshot.PreviousHash)
+
+			var old = &counter{version: 1}
+			test.H(t).IsNil(repo.Rehydrate(ctx, old, pn))
+			test.H(t).IntEql(old.Count, 5)
+			test.H(t).IntEql(old.applied, 1)
+		})
+	})
+
+	t.Run("hides undated checkpoints when pinned to a time", func(t *testing.T) {
+		var c = &counter{version: 1}
+		test.H(t).IsNil(NewSimpleRepository(objdb, refdb, evM).Rehydrate(ref.WithPin(ctx, ref.Pin{Time: time.Now()}), c, pn))
+		test.H(t).IntEql(c.Count, 0)
+	})
+
+	t.Run("ignores snapshots taken on checkpoints the head can't reach", func(t *testing.T) {
+		var branch = ref.WithBranch(ctx, "other")
+		head, err := refdb.Retrieve(ref.DefaultBranch)
+		test.H(t).IsNil(err)
+		packedCheckpoint, err := objdb.RetrievePacked(head.String())
+		test.H(t).IsNil(err)
+		checkpoint, err := jp.UnpackCheckpoint(packedCheckpoint.Contents())
+		test.H(t).IsNil(err)
+		_, err = refdb.Write(ref.BranchFromContext(branch), checkpoint.ParentHashes[0])
+		test.H(t).IsNil(err)
+
+		var c = &counter{version: 2}
+		test.H(t).IsNil(NewSimpleRepository(objdb, refdb, evM).Rehydrate(branch, c, pn))
+		test.H(t).IntEql(c.Count, 4)
+		test.H(t).IntEql(c.applied, 4)
+	})
+}
@@ -0,0 +1,90 @@
+// +build integration
+
+package repository
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/retro-framework/go-retro/aggregates"
+	"github.com/retro-framework/go-retro/events"
+	"github.com/retro-framework/go-retro/framework/packing"
+	"github.com/retro-framework/go-retro/framework/ref"
+	"github.com/retro-framework/go-retro/framework/retro"
+	"github.com/retro-framework/go-retro/framework/storage/memory"
+	test "github.com/retro-framework/go-retro/framework/test_helper"
+)
+
+type renamed struct {
+	Name string `json:"name"`
+}
+
+type renamer struct {
+	aggregates.NamedAggregate
+	names []string
+}
+
+func (r *renamer) ReactTo(ev retro.Event) error {
+	r.names = append(r.names, ev.(*renamed).Name)
+	return nil
+}
+
+func Test_Upcasting(t *testing.T) {
+
+	type versionedManifest interface {
+		retro.EventManifest
+		RegisterVersion(retro.Event, int) error
+		RegisterUpcaster(string, int, retro.Upcaster) error
+	}
+
+	var (
+		jp    = packing.NewJSONPacker()
+		objdb = &memory.ObjectStore{}
+		refdb = &memory.RefStore{}
+		evM   = events.NewManifest().(versionedManifest)
+		pn    = retro.PartitionName("renamer/1")
+	)
+
+	// Version 1 called the name "title"
+	test.H(t).IsNil(evM.RegisterVersion(&renamed{}, 2))
+	test.H(t).IsNil(evM.RegisterUpcaster("renamed", 1, func(b []byte) ([]byte, error) {
+		return []byte(strings.Replace(string(b), `"title"`, `"name"`, 1)), nil
+	}))
+
+	var (
+		v1, _ = jp.PackEvent("renamed", struct {
+			Title string `json:"title"`
+		}{"old"})
+		v2, _ = jp.PackVersionedEvent("renamed", 2, renamed{"new"})
+	)
+	for _, obj := range []retro.HashedObject{v1, v2} {
+		_, err := objdb.WritePacked(obj)
+		test.H(t).IsNil(err)
+	}
+	affix, err := jp.PackAffix(packing.Affix{pn: {v1.Hash(), v2.Hash()}})
+	test.H(t).IsNil(err)
+	_, err = objdb.WritePacked(affix)
+	test.H(t).IsNil(err)
+	checkpoint, err := jp.PackCheckpoint(packing.Checkpoint{
+		AffixHash:   affix.Hash(),
+		CommandDesc: []byte(`{"test":"upcasting"}`),
+	})
+	test.H(t).IsNil(err)
+	_, err = objdb.WritePacked(checkpoint)
+	test.H(t).IsNil(err)
+	_, err = refdb.Write(ref.DefaultBranch, checkpoint.Hash())
+	test.H(t).IsNil(err)
+
+	t.Run("rehydrates aggregates with the latest version of events", func(t *testing.T) {
+		var (
+			repo = NewSimpleRepository(objdb, refdb, evM)
+			agg  = &renamer{}
+		)
+		test.H(t).IsNil(repo.Rehydrate(context.Background(), agg, pn))
+		if diff := cmp.Diff(agg.names, []string{"old", "new"}); diff != "" {
+			t.Errorf("names differ: (-got +want)\n%s", diff)
+		}
+	})
+}
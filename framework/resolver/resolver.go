@@ -10,7 +10,10 @@ import (
 
 	"github.com/gobuffalo/flect"
 	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/retro-framework/go-retro/framework/object"
 	"github.com/retro-framework/go-retro/framework/retro"
+	"github.com/retro-framework/go-retro/framework/validation"
+	"golang.org/x/xerrors"
 )
 
 type Error struct {
@@ -22,13 +25,39 @@ func (e Error) Error() string {
 	return fmt.Sprintf("resolver: op: %q err: %q", e.Op, e.Err)
 }
 
+// Unwrap returns the underlying error, e.g the retro.ForbiddenError of a
+// policy which denied the command or the retro.UnknownCommandError for a
+// command which isn't registered.
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
 type resolver struct {
 	aggm retro.AggregateManifest
 	cmdm retro.CommandManifest
+
+	// blobs is optional, see WithBlobs
+	blobs object.BlobSource
 }
 
-func New(aggm retro.AggregateManifest, cmdm retro.CommandManifest) retro.Resolver {
-	return &resolver{aggm, cmdm}
+// Option configures a resolver, see New.
+type Option func(*resolver)
+
+// WithBlobs makes the resolver check that the blobs referenced by
+// retro.BlobRefs in command args are stored in src, and validate their size
+// as stored (see validation.WithBlobs) rather than as claimed by the client.
+func WithBlobs(src object.BlobSource) Option {
+	return func(r *resolver) {
+		r.blobs = src
+	}
+}
+
+func New(aggm retro.AggregateManifest, cmdm retro.CommandManifest, opts ...Option) retro.Resolver {
+	var r = &resolver{aggm: aggm, cmdm: cmdm}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 // Resolve uses the byte slice provided and unmarshals it with JSON
@@ -55,6 +84,11 @@ func New(aggm retro.AggregateManifest, cmdm retro.CommandManifest) retro.Resolve
 // this to work the command registered under that name must implement retro.CommandWithArgs. The
 // arguments will be parsed into a copy of the registered arg type for this command and passed to
 // the command the command's "SetArgs" method.
+//
+// If the command was registered with policies (see retro.PolicyManifest)
+// they are checked against the target aggregate and the session carried
+// in ctx (see retro.WithSession), an Error wrapping the policy's error
+// (typically a retro.ForbiddenError) is returned if any denies it.
 func (r *resolver) Resolve(ctx context.Context, repository retro.Repo, b []byte) (retro.Command, error) {
 
 	spnResolve, ctx := opentracing.StartSpanFromContext(ctx, "resolver.Resolve")
@@ -70,7 +104,7 @@ func (r *resolver) Resolve(ctx context.Context, repository retro.Repo, b []byte)
 	spnUnmarshal.SetTag("payload", string(b))
 	var cmdDesc commandDesc
 	if err := json.Unmarshal(b, &cmdDesc); err != nil {
-		err = Error{"json-unmarshal", err}
+		err = Error{"json-unmarshal", retro.BadArgsError{Err: err}}
 		spnUnmarshal.LogKV("event", "error", "error.object", err)
 		spnUnmarshal.Finish()
 		return nil, err
@@ -82,7 +116,7 @@ func (r *resolver) Resolve(ctx context.Context, repository retro.Repo, b []byte)
 	spnValidateCmdDesc := opentracing.StartSpan("validate command description", opentracing.ChildOf(spnResolve.Context()))
 	if errs, ok := cmdDesc.HasErrors(); !ok {
 		spnValidateCmdDesc.Finish()
-		return nil, Error{"validate-cmd-desc", errs[0]}
+		return nil, Error{"validate-cmd-desc", retro.BadArgsError{Err: errs[0]}}
 	}
 	spnValidateCmdDesc.Finish()
 
@@ -107,7 +141,7 @@ func (r *resolver) resolve(ctx context.Context, spnResolve opentracing.Span, rep
 		return nil, err
 	}
 	if agg == nil {
-		err := Error{"agg-lookup", fmt.Errorf("could not find aggreate")}
+		err := Error{"agg-lookup", retro.NotFoundError{Path: retro.PartitionName(cmdDesc.Path)}}
 		spnAggLookup.SetTag("error", err)
 		spnAggLookup.Finish()
 		return nil, err
@@ -153,7 +187,7 @@ func (r *resolver) resolve(ctx context.Context, spnResolve opentracing.Span, rep
 	}
 
 	if len(cmds) == 0 {
-		return nil, Error{"agg-cmd-lookup", fmt.Errorf("aggregate has no registered commands")}
+		return nil, Error{"agg-cmd-lookup", retro.UnknownCommandError{Name: cmdDesc.Name, Aggregate: cmdDesc.AggregateType()}}
 	}
 
 	sp := opentracing.StartSpan("iterating over aggregate commands", opentracing.ChildOf(spnAggCmdLookup.Context()))
@@ -174,13 +208,13 @@ func (r *resolver) resolve(ctx context.Context, spnResolve opentracing.Span, rep
 	spnAggCmdLookup.Finish()
 
 	if cmd == nil {
-		return nil, Error{"agg-cmd-lookup", fmt.Errorf("no command registered with name %s for aggregate %v", cmdDesc.Name, reflect.TypeOf(agg).Elem().Name())}
+		return nil, Error{"agg-cmd-lookup", retro.UnknownCommandError{Name: cmdDesc.Name, Aggregate: reflect.TypeOf(agg).Elem().Name()}}
 	}
 
 	if len(cmdDesc.Args) > 0 {
 		var cmdWithArgs, ok = cmd.(retro.CommandWithArgs)
 		if !ok {
-			return nil, Error{"cast-cmd-with-args", errors.New("args given, but command does not implement CommandWithArgs")}
+			return nil, Error{"cast-cmd-with-args", retro.BadArgsError{Err: errors.New("args given, but command does not implement CommandWithArgs")}}
 		}
 
 		var typedArgs, found = r.cmdm.ArgTypeFor(cmd)
@@ -190,11 +224,21 @@ func (r *resolver) resolve(ctx context.Context, spnResolve opentracing.Span, rep
 
 		err := json.Unmarshal(cmdDesc.Args, typedArgs)
 		if err != nil {
-			return nil, Error{"assign-args", err}
+			return nil, Error{"assign-args", retro.BadArgsError{Err: err}}
+		}
+
+		// Validation failures are returned as retro.ValidationErrors,
+		// see package validation for the rules args can declare.
+		var vopts []validation.Option
+		if r.blobs != nil {
+			vopts = append(vopts, validation.WithBlobs(r.blobs))
+		}
+		if err := validation.Validate(typedArgs, vopts...); err != nil {
+			return nil, Error{"validate-args", err}
 		}
 
 		if err := cmdWithArgs.SetArgs(typedArgs); err != nil {
-			return nil, Error{"assign-args", err}
+			return nil, Error{"assign-args", retro.BadArgsError{Err: err}}
 		}
 	}
 
@@ -203,21 +247,27 @@ func (r *resolver) resolve(ctx context.Context, spnResolve opentracing.Span, rep
 		defer spnRehydrate.Finish()
 		err = repository.Rehydrate(ctx, agg, retro.PartitionName(cmdDesc.Path))
 		if err != nil {
-			// TODO: This exit condition is a nasty "magic string"
-			// artefact. It is designed ot match against a string
-			// in the "simple-aggregate-rehydrater.go" file where
-			// a "not found" error manifests as unknown ref. We don't
-			// necessarily expect to find something to rehydrate,
-			// this may be a SessionStart event, so we're happy to
-			// swallow an error about a non-exixtent partition and
-			// failure to rehydrate something we're in the process
-			// of creating. These errors need to be better typed.
-			if !strings.Contains(err.Error(), "unknown ref") {
+			// We don't necessarily expect to find something to
+			// rehydrate, this may be a SessionStart event, so we're
+			// happy to swallow an error about a non-existent
+			// partition and failure to rehydrate something we're in
+			// the process of creating.
+			if !xerrors.Is(err, retro.ErrNotFound) {
 				return nil, Error{"agg-rehydrate", err}
 			}
 		}
 	}
 
+	// Policies see the rehydrated aggregate and the session the command
+	// is applied with, the Engine passes it in the context.
+	if pm, ok := r.cmdm.(retro.PolicyManifest); ok {
+		for _, p := range pm.PoliciesFor(cmd) {
+			if err := p.Authorize(ctx, retro.SessionFromContext(ctx), agg); err != nil {
+				return nil, Error{"authorize", err}
+			}
+		}
+	}
+
 	cmd.SetState(agg)
 
 	// TODO: Could implement an INFO level warning incase args are absent but
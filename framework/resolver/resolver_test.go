@@ -17,6 +17,7 @@ import (
 	"github.com/retro-framework/go-retro/framework/retro"
 	"github.com/retro-framework/go-retro/framework/storage/memory"
 	test "github.com/retro-framework/go-retro/framework/test_helper"
+	"golang.org/x/xerrors"
 )
 
 type OneEvent struct{}
@@ -309,3 +310,148 @@ func Benchmark_Resolver_ResolveExistingCmdSuccessfully(b *testing.B) {
 		r.Resolve(ctx, repository, []byte(`{"path":"agg/123", "name":"dummyCmd"}`))
 	}
 }
+
+func Test_Resolver_Policies(t *testing.T) {
+
+	var setup = func(p retro.Policy) (retro.Resolver, retro.Repo) {
+		var (
+			objdb = &memory.ObjectStore{}
+			refdb = &memory.RefStore{}
+			evM   = events.NewManifest()
+			aggM  = aggregates.NewManifest()
+			cmdM  = commands.NewManifest()
+			cmd   = &dummyCmd{}
+		)
+		aggM.Register("agg", &dummyAggregate{})
+		cmdM.Register(&dummyAggregate{}, cmd)
+		test.H(t).IsNil(cmdM.(retro.PolicyManifest).Require(cmd, p))
+		return New(aggM, cmdM), repository.NewSimpleRepository(objdb, refdb, evM)
+	}
+
+	t.Run("passes the session in the context and the target to policies", func(t *testing.T) {
+
+		// Arrange
+		var (
+			session    = &dummySession{}
+			seen       retro.Session
+			seenTarget retro.Aggregate
+			r, repo    = setup(retro.PolicyFunc(func(_ context.Context, s retro.Session, target retro.Aggregate) error {
+				seen, seenTarget = s, target
+				return nil
+			}))
+		)
+
+		// Act
+		_, err := r.Resolve(retro.WithSession(context.Background(), session), repo, []byte(`{"path":"agg/123", "name":"dummyCmd"}`))
+
+		// Assert
+		test.H(t).IsNil(err)
+		test.H(t).BoolEql(seen == retro.Session(session), true)
+		if _, ok := seenTarget.(*dummyAggregate); !ok {
+			t.Fatalf("expected the policy to see the target aggregate, got %T", seenTarget)
+		}
+	})
+
+	t.Run("returns the error of a policy denying the command", func(t *testing.T) {
+
+		// Arrange
+		var r, repo = setup(retro.PolicyFunc(func(context.Context, retro.Session, retro.Aggregate) error {
+			return retro.ForbiddenError{Reason: "not today"}
+		}))
+
+		// Act
+		cmd, err := r.Resolve(context.Background(), repo, []byte(`{"path":"agg/123", "name":"dummyCmd"}`))
+
+		// Assert
+		test.H(t).BoolEql(cmd == nil, true)
+		test.H(t).BoolEql(xerrors.Is(err, retro.ErrForbidden), true)
+	})
+}
+
+type validatedCmd struct {
+	dummyCmd
+
+	argsSet bool
+}
+
+type validatedArgs struct {
+	Name   string         `json:"name" validate:"required"`
+	Avatar *retro.BlobRef `json:"avatar" validate:"maxbytes=4"`
+}
+
+func (vc *validatedCmd) SetArgs(retro.CommandArgs) error {
+	vc.argsSet = true
+	return nil
+}
+
+func Test_Resolver_Errors(t *testing.T) {
+
+	var (
+		objdb = &memory.ObjectStore{}
+		refdb = &memory.RefStore{}
+		evM   = events.NewManifest()
+		aggM  = aggregates.NewManifest()
+		cmdM  = commands.NewManifest()
+		repo  = repository.NewSimpleRepository(objdb, refdb, evM)
+	)
+	var vc = &validatedCmd{}
+	aggM.Register("agg", &dummyAggregate{})
+	cmdM.Register(&dummyAggregate{}, &dummyCmd{})
+	cmdM.RegisterWithArgs(&dummyAggregate{}, vc, &validatedArgs{})
+	var r = New(aggM, cmdM)
+
+	for _, tc := range []struct {
+		name string
+		desc string
+		want error
+	}{
+		{"malformed descriptions are bad args", `{"path":"agg/123"`, retro.ErrBadArgs},
+		{"descriptions without a name are bad args", `{"path":"agg/123"}`, retro.ErrBadArgs},
+		{"args for commands without args are bad args", `{"path":"agg/123", "name":"dummyCmd", "args":{"a":1}}`, retro.ErrBadArgs},
+		{"unregistered aggregates are not found", `{"path":"nope/123", "name":"dummyCmd"}`, retro.ErrNotFound},
+		{"unregistered commands are unknown", `{"path":"agg/123", "name":"nope"}`, retro.ErrUnknownCommand},
+		{"args failing validation are invalid", `{"path":"agg/123", "name":"validatedCmd", "args":{"name":""}}`, retro.ErrValidation},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := r.Resolve(context.Background(), repo, []byte(tc.desc))
+			test.H(t).NotNil(err)
+			test.H(t).BoolEql(xerrors.Is(err, tc.want), true)
+		})
+	}
+
+	t.Run("args are validated before they are set", func(t *testing.T) {
+		_, err := r.Resolve(context.Background(), repo, []byte(`{"path":"agg/123", "name":"validatedCmd", "args":{"name":""}}`))
+		var ves retro.ValidationErrors
+		test.H(t).BoolEql(xerrors.As(err, &ves), true)
+		test.H(t).StringEql(ves.Fields()["name"], "is required")
+		test.H(t).BoolEql(vc.argsSet, false)
+	})
+
+	t.Run("checks referenced blobs against the object store", func(t *testing.T) {
+		big, err := objdb.WriteBlob(bytes.NewReader([]byte("abcdef")))
+		test.H(t).IsNil(err)
+		var withBlobs = New(aggM, cmdM, WithBlobs(objdb))
+
+		for _, avatar := range []string{
+			`{"hash":"sha256:00","size":1}`,
+			fmt.Sprintf(`{"hash":%q,"size":1}`, big.Hash),
+		} {
+			_, err = withBlobs.Resolve(context.Background(), repo, []byte(`{"path":"agg/123", "name":"validatedCmd", "args":{"name":"a","avatar":`+avatar+`}}`))
+			var ves retro.ValidationErrors
+			test.H(t).BoolEql(xerrors.As(err, &ves), true)
+			test.H(t).BoolEql(ves.Fields()["avatar"] != "", true)
+		}
+
+		// without the object store the client's size is trusted
+		_, err = r.Resolve(context.Background(), repo, []byte(fmt.Sprintf(`{"path":"agg/123", "name":"validatedCmd", "args":{"name":"a","avatar":{"hash":%q,"size":1}}}`, big.Hash)))
+		test.H(t).BoolEql(xerrors.Is(err, retro.ErrValidation), false)
+	})
+
+	t.Run("unknown commands name the command and aggregate", func(t *testing.T) {
+		_, err := r.Resolve(context.Background(), repo, []byte(`{"path":"agg/123", "name":"nope"}`))
+		var uce retro.UnknownCommandError
+		test.H(t).BoolEql(xerrors.As(err, &uce), true)
+		test.H(t).StringEql(uce.Name, "nope")
+		test.H(t).StringEql(uce.Aggregate, "dummyAggregate")
+	})
+}
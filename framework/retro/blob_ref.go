@@ -0,0 +1,9 @@
+package retro
+
+// BlobRef references a blob in the object database by its hash, events
+// carry BlobRefs rather than large binary data, which would otherwise be
+// read on every rehydrate.
+type BlobRef struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
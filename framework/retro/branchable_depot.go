@@ -0,0 +1,10 @@
+package retro
+
+import "context"
+
+// BranchableDepot is an optional interface for Depots which can create
+// new branches pointing at any existing checkpoint. Branches share the
+// underlying object storage, creating one is cheap.
+type BranchableDepot interface {
+	CreateBranch(ctx context.Context, name string, from Hash) error
+}
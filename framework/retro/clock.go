@@ -1,6 +1,9 @@
 package retro
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 // Clock allows dependency injection of a function returning
 // the current time. Due to all the test code dealing with serialized
@@ -8,3 +11,33 @@ import "time"
 type Clock interface {
 	Now() time.Time
 }
+
+type clockCtxKey struct{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now().UTC() }
+
+// WithClock returns a copy of ctx which carries c. The Engine passes its
+// clock to commands this way, commands which depend on the time should
+// read it from ClockFromContext so that they can be replayed.
+func WithClock(ctx context.Context, c Clock) context.Context {
+	return context.WithValue(ctx, clockCtxKey{}, c)
+}
+
+// ClockFromContext returns the clock carried in ctx, or a clock returning
+// the current time in UTC if there is none.
+func ClockFromContext(ctx context.Context) Clock {
+	if ctx != nil {
+		if c, ok := ctx.Value(clockCtxKey{}).(Clock); ok && c != nil {
+			return c
+		}
+	}
+	return systemClock{}
+}
+
+// FixedClock is a Clock which always returns the same time.
+type FixedClock time.Time
+
+// Now returns the fixed time.
+func (c FixedClock) Now() time.Time { return time.Time(c) }
@@ -21,7 +21,9 @@ type Depot interface {
 	StorePacked(...HashedObject) error
 
 	// Head pointer operations are important for the engine
-	// to be able to do things without mangling the history
+	// to be able to do things without mangling the history.
+	// Both operate on the branch named in the context (see
+	// ref.WithBranch) or the default branch.
 	HeadPointer(context.Context) (Hash, error)
-	MoveHeadPointer(old, new Hash) error
+	MoveHeadPointer(ctx context.Context, old, new Hash) error
 }
@@ -0,0 +1,10 @@
+package retro
+
+import "context"
+
+// DiffableDepot is an optional interface for Depots which can tell which
+// partitions were written to by the checkpoints reachable from head but
+// not from since. A nil since means the whole history of head.
+type DiffableDepot interface {
+	PartitionsTouched(ctx context.Context, since, head Hash) ([]PartitionName, error)
+}
@@ -0,0 +1,152 @@
+package retro
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// The errors below (together with ErrForbidden) classify the failures
+// callers of the Engine can do something about, they are matched with
+// xerrors.Is through whatever wraps them. The typed errors carry the
+// details and can be retrieved with xerrors.As.
+var (
+	// ErrNotFound is matched by NotFoundError.
+	ErrNotFound = xerrors.New("not found")
+
+	// ErrUnknownCommand is matched by UnknownCommandError.
+	ErrUnknownCommand = xerrors.New("unknown command")
+
+	// ErrBadArgs is matched by BadArgsError and returned for command
+	// descriptions or args which can't be understood.
+	ErrBadArgs = xerrors.New("bad args")
+
+	// ErrConflict is returned when a command could not be applied
+	// because of a concurrent write, or reuses an idempotency key.
+	ErrConflict = xerrors.New("conflict")
+
+	// ErrValidation is matched by ValidationError.
+	ErrValidation = xerrors.New("validation failed")
+
+	// ErrErased is matched by ErasedError.
+	ErrErased = xerrors.New("erased")
+)
+
+// NotFoundError is returned when the aggregate a command targets does not
+// exist, Err is the underlying error, if any.
+type NotFoundError struct {
+	Path PartitionName
+	Err  error
+}
+
+func (e NotFoundError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("not found: %s: %s", e.Path, e.Err)
+	}
+	return fmt.Sprintf("not found: %s", e.Path)
+}
+
+func (e NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+func (e NotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// ErasedError is returned when reading the events of a partition which
+// has been erased by deleting its key (see KeyStore), the partition can't
+// be rehydrated and its events can't be read.
+type ErasedError struct {
+	Partition PartitionName
+}
+
+func (e ErasedError) Error() string {
+	return fmt.Sprintf("erased: %s", e.Partition)
+}
+
+func (e ErasedError) Is(target error) bool {
+	return target == ErrErased
+}
+
+// UnknownCommandError is returned when no command with the given name is
+// registered for the aggregate a command targets.
+type UnknownCommandError struct {
+	Name      string
+	Aggregate string
+}
+
+func (e UnknownCommandError) Error() string {
+	return fmt.Sprintf("unknown command: %s for aggregate %s", e.Name, e.Aggregate)
+}
+
+func (e UnknownCommandError) Is(target error) bool {
+	return target == ErrUnknownCommand
+}
+
+// BadArgsError is returned when a command description or its args can't be
+// unmarshalled or set on the command, it only classifies Err and reads
+// the same.
+type BadArgsError struct {
+	Err error
+}
+
+func (e BadArgsError) Error() string {
+	return e.Err.Error()
+}
+
+func (e BadArgsError) Is(target error) bool {
+	return target == ErrBadArgs
+}
+
+func (e BadArgsError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError is returned by commands whose args are well formed but
+// which can't be applied as given, e.g a name which must not be empty.
+// Field names the offending arg, if any.
+type ValidationError struct {
+	Field  string `json:"field,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (e ValidationError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("validation failed: %s %s", e.Field, e.Reason)
+	}
+	return fmt.Sprintf("validation failed: %s", e.Reason)
+}
+
+func (e ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// ValidationErrors collects the ValidationError for every arg which failed
+// validation, see package validation.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	var reasons = make([]string, len(e))
+	for i, ve := range e {
+		reasons[i] = ve.Error()
+	}
+	return strings.Join(reasons, ", ")
+}
+
+func (e ValidationErrors) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// Fields returns the reason for the first failure of each field, keyed by
+// field name, convenient for showing next to form inputs.
+func (e ValidationErrors) Fields() map[string]string {
+	var res = make(map[string]string, len(e))
+	for _, ve := range e {
+		if _, seen := res[ve.Field]; !seen {
+			res[ve.Field] = ve.Reason
+		}
+	}
+	return res
+}
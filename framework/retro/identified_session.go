@@ -0,0 +1,9 @@
+package retro
+
+// IdentifiedSession is an optional interface for Sessions which can be
+// associated with an identity, policies use it to tell who is applying a
+// command. The URN is only meaningful if the bool is true.
+type IdentifiedSession interface {
+	Session
+	Identity() (URN, bool)
+}
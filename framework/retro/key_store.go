@@ -0,0 +1,18 @@
+package retro
+
+// KeyStore holds the keys the events of partitions are encrypted with, one
+// key per partition (see packing.EncryptingPacker). Deleting the key of a
+// partition erases it: its events stay in the depot, and history keeps
+// their hashes, but they can no longer be read. Blobs their events
+// reference are not encrypted, depot.Simple.Erase deletes them too.
+type KeyStore interface {
+	// Key returns the key of the partition, if there is none yet it is
+	// created if create is set. An ErasedError is returned for partitions
+	// whose key has been deleted, whether or not create is set, and for
+	// partitions which have no key and create is not set.
+	Key(pn PartitionName, create bool) ([]byte, error)
+
+	// Delete deletes the key of the partition, it is remembered that the
+	// partition was erased so that no new key is created for it.
+	Delete(pn PartitionName) error
+}
@@ -0,0 +1,21 @@
+package retro
+
+import (
+	"context"
+	"time"
+)
+
+// KeyedDepot is an optional interface for Depots which index the
+// idempotency keys checkpoints were stored with, the Engine needs it to
+// accept commands carrying an idempotency key.
+type KeyedDepot interface {
+
+	// Keyed returns the most recent checkpoint reachable from the head
+	// of the branch named in the context which was stored with key, and
+	// its date. The Hash is nil if there is none.
+	Keyed(ctx context.Context, key string) (Hash, time.Time, error)
+
+	// RetrievePacked retrieves a stored object, the Engine reads the
+	// checkpoint returned by Keyed to describe it again.
+	RetrievePacked(string) (HashedObject, error)
+}
@@ -0,0 +1,11 @@
+package retro
+
+import "context"
+
+// LockManager hands out exclusive claims on named resources, typically
+// partitions. Claim blocks until the claim is granted, or returns false
+// once the context is done. Release gives up a claim previously granted.
+type LockManager interface {
+	Claim(context.Context, string) bool
+	Release(string)
+}
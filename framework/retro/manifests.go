@@ -46,3 +46,12 @@ type CommandManifest interface {
 type ListingCommandManifest interface {
 	List() map[string][]string
 }
+
+// PolicyManifest is an optional interface for CommandManifests which record
+// the policies commands were registered with. Require adds policies for a
+// registered command, PoliciesFor returns them in the order they were
+// added, all of them must authorize the command.
+type PolicyManifest interface {
+	Require(Command, ...Policy) error
+	PoliciesFor(Command) []Policy
+}
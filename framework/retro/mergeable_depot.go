@@ -0,0 +1,10 @@
+package retro
+
+import "context"
+
+// MergeableDepot is an optional interface for Depots which can merge one
+// branch into another. Merge returns the new head of the branch merged
+// into.
+type MergeableDepot interface {
+	Merge(ctx context.Context, into, from string) (Hash, error)
+}
@@ -0,0 +1,9 @@
+package retro
+
+// OwnedAggregate is an optional interface for Aggregates which belong to
+// one or more identities, policies use it to decide whether a session may
+// apply commands to them.
+type OwnedAggregate interface {
+	Aggregate
+	OwnedBy(URN) bool
+}
@@ -0,0 +1,43 @@
+package retro
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/xerrors"
+)
+
+// Policy is a requirement a command was registered with (see
+// PolicyManifest), it is checked by the Resolver before the command is
+// handed back to be applied.
+type Policy interface {
+
+	// Authorize returns an error, typically a ForbiddenError, if session
+	// may not apply the command to target. session is nil for commands
+	// applied without a session, target may not exist yet.
+	Authorize(ctx context.Context, session Session, target Aggregate) error
+}
+
+// PolicyFunc adapts a function to a Policy.
+type PolicyFunc func(ctx context.Context, session Session, target Aggregate) error
+
+// Authorize calls f.
+func (f PolicyFunc) Authorize(ctx context.Context, session Session, target Aggregate) error {
+	return f(ctx, session, target)
+}
+
+// ErrForbidden is matched (with xerrors.Is) by ForbiddenError.
+var ErrForbidden = xerrors.New("forbidden")
+
+// ForbiddenError is returned when a Policy denies a command.
+type ForbiddenError struct {
+	Reason string
+}
+
+func (e ForbiddenError) Error() string {
+	return fmt.Sprintf("forbidden: %s", e.Reason)
+}
+
+func (e ForbiddenError) Is(target error) bool {
+	return target == ErrForbidden
+}
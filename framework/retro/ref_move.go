@@ -1,11 +1,12 @@
 package retro
 
 // RefMove represents a head pointer movement
-// it contains the old and new hashes. A boolean
-// is set indicating whether this is a FF move
-// or not.
+// it contains the name of the ref which moved
+// and the old and new hashes. A boolean is set
+// indicating whether this is a FF move or not.
 type RefMove struct {
-	Old Hash
-	New Hash
-	FF  bool
+	Name string
+	Old  Hash
+	New  Hash
+	FF   bool
 }
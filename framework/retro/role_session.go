@@ -0,0 +1,8 @@
+package retro
+
+// RoleSession is an optional interface for Sessions whose identity may
+// hold roles, e.g "admin".
+type RoleSession interface {
+	Session
+	HasRole(string) bool
+}
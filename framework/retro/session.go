@@ -1,6 +1,27 @@
 package retro
 
+import "context"
+
 // Session is a type alias for Aggregate to make the function signatures
 // more self-explanatory, feasibly in the future a session will be a
 // superset of an Aggregate
 type Session Aggregate
+
+type sessionCtxKey struct{}
+
+// WithSession returns a copy of ctx which carries the session a command is
+// applied with. The Engine passes the session to the Resolver this way so
+// that it can check the policies of the command.
+func WithSession(ctx context.Context, s Session) context.Context {
+	return context.WithValue(ctx, sessionCtxKey{}, s)
+}
+
+// SessionFromContext returns the session carried in ctx, or nil.
+func SessionFromContext(ctx context.Context) Session {
+	if ctx != nil {
+		if s, ok := ctx.Value(sessionCtxKey{}).(Session); ok {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package retro
+
+// SnapshottableAggregate is implemented by Aggregates whose state may be
+// stored in snapshots to avoid replaying their whole history. The state
+// is serialized with encoding/json, so it must survive a roundtrip.
+//
+// SchemaVersion must be changed whenever the serialized form of the state
+// changes (or the meaning of events does) so that older snapshots are no
+// longer used.
+type SnapshottableAggregate interface {
+	Aggregate
+	SchemaVersion() int
+}
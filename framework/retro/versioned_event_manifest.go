@@ -0,0 +1,39 @@
+package retro
+
+// Upcaster rewrites the payload of an event from one version of the event's
+// schema to the next.
+type Upcaster func(payload []byte) ([]byte, error)
+
+// VersionedEventManifest is an optional interface for EventManifests which
+// know several versions of an event's schema. Events are always packed in
+// their latest version, payloads packed in an older version are upcast
+// one version at a time before they are unmarshalled, so aggregates and
+// projections only ever see the latest version.
+type VersionedEventManifest interface {
+
+	// VersionFor returns the latest version of the event registered with
+	// name, events registered without a version are version 1.
+	VersionFor(name string) int
+
+	// Upcast rewrites a payload of the event registered with name from
+	// version to the latest version.
+	Upcast(name string, version int, payload []byte) ([]byte, error)
+}
+
+// EventVersion returns the version events registered with name are packed
+// in, 1 unless evm is a VersionedEventManifest.
+func EventVersion(evm EventManifest, name string) int {
+	if vm, ok := evm.(VersionedEventManifest); ok {
+		return vm.VersionFor(name)
+	}
+	return 1
+}
+
+// UpcastEvent upcasts payload to the latest version if evm is a
+// VersionedEventManifest, otherwise payload is returned as it is.
+func UpcastEvent(evm EventManifest, name string, version int, payload []byte) ([]byte, error) {
+	if vm, ok := evm.(VersionedEventManifest); ok {
+		return vm.Upcast(name, version, payload)
+	}
+	return payload, nil
+}
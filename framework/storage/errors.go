@@ -7,4 +7,9 @@ import (
 var (
 	ErrUnknownRef         = xerrors.New("storage: ref unknown")
 	ErrUnknownSymbolicRef = xerrors.New("storage: symbolic ref unknown")
+	ErrRefChanged         = xerrors.New("storage: ref does not have the expected value")
+	ErrRefLocked          = xerrors.New("storage: ref is locked")
+	ErrInvalidRefName     = xerrors.New("storage: invalid ref name")
+	ErrNotACheckpoint     = xerrors.New("storage: object is not a checkpoint")
+	ErrNotAnAffix         = xerrors.New("storage: object is not an affix")
 )
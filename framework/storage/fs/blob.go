@@ -0,0 +1,187 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/retro-framework/go-retro/framework/packing"
+	"github.com/retro-framework/go-retro/framework/retro"
+)
+
+var (
+	ErrNotABlob    = errors.New("object is not a blob")
+	ErrBlobInAPack = errors.New("blob is in a pack, repack all to unpack it")
+)
+
+// WriteBlob writes the blob read from r as a loose object without holding
+// it in memory. The header of a blob gives its size, which isn't known
+// until r is drained, so r is first copied to a temporary file which is
+// then hashed and deflated into a second one, renamed into place.
+//
+// Errors reading r are returned as they are.
+func (s *ObjectStore) WriteBlob(r io.Reader) (retro.BlobRef, error) {
+
+	if err := s.mkdirAll(s.BasePath); err != nil {
+		return retro.BlobRef{}, ErrUnableToCreateBaseDir
+	}
+
+	raw, err := ioutil.TempFile(s.BasePath, "tmp-blob-")
+	if err != nil {
+		return retro.BlobRef{}, ErrUnableToCreateObjectFile
+	}
+	defer os.Remove(raw.Name())
+	defer raw.Close()
+
+	size, err := io.Copy(raw, r)
+	if err != nil {
+		return retro.BlobRef{}, err
+	}
+	if _, err := raw.Seek(0, io.SeekStart); err != nil {
+		return retro.BlobRef{}, ErrUnableToReadObjectFile
+	}
+
+	deflated, err := ioutil.TempFile(s.BasePath, "tmp-obj-")
+	if err != nil {
+		return retro.BlobRef{}, ErrUnableToCreateObjectFile
+	}
+	defer os.Remove(deflated.Name())
+
+	var (
+		h  = sha256.New()
+		zw = zlib.NewWriter(deflated)
+		w  = io.MultiWriter(h, zw)
+	)
+
+	w.Write(packing.BlobHeader(size))
+	if _, err := io.Copy(w, raw); err != nil {
+		deflated.Close()
+		return retro.BlobRef{}, ErrUnableToWriteObject
+	}
+	if err := zw.Close(); err != nil {
+		deflated.Close()
+		return retro.BlobRef{}, ErrUnableToWriteObject
+	}
+	if err := deflated.Close(); err != nil {
+		return retro.BlobRef{}, ErrUnableToWriteObject
+	}
+
+	var (
+		hb      = h.Sum(nil)
+		ref     = retro.BlobRef{Hash: packing.NewHash(packing.HashAlgoNameSHA256, hb).String(), Size: size}
+		objPath = s.loosePath(hb)
+	)
+
+	if _, err := os.Stat(objPath); err == nil || s.inPacks(hb) {
+		return ref, nil
+	}
+
+	if err := s.mkdirAll(filepath.Dir(objPath)); err != nil {
+		return retro.BlobRef{}, ErrUnableToCreateObjectDir
+	}
+
+	if err := os.Rename(deflated.Name(), objPath); err != nil {
+		return retro.BlobRef{}, ErrUnableToWriteObject
+	}
+
+	return ref, nil
+}
+
+// OpenBlob streams loose blobs from disk. Repack leaves blobs loose, blobs
+// in packs written before it did are read into memory.
+func (s *ObjectStore) OpenBlob(str string) (io.ReadCloser, int64, error) {
+
+	hb, err := hashBytes(str)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	f, err := os.Open(s.loosePath(hb))
+	if os.IsNotExist(err) {
+		return s.openPackedBlob(hb)
+	}
+	if err != nil {
+		return nil, 0, ErrUnableToReadObjectFile
+	}
+
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, 0, ErrUnableToInflateObject
+	}
+
+	br := bufio.NewReader(zr)
+	size, err := packing.ReadBlobHeader(br)
+	if err != nil {
+		f.Close()
+		return nil, 0, ErrNotABlob
+	}
+
+	return blobReader{io.LimitReader(br, size), f}, size, nil
+}
+
+func (s *ObjectStore) openPackedBlob(hb []byte) (io.ReadCloser, int64, error) {
+	orig, err := s.retrieveFromPacks(hb)
+	if err != nil {
+		return nil, 0, err
+	}
+	if packing.NewPackedObject(string(orig)).Type() != packing.ObjectTypeBlob {
+		return nil, 0, ErrNotABlob
+	}
+	b, err := packing.UnpackBlob(orig)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), int64(len(b)), nil
+}
+
+// blobReader reads a loose blob, closing it closes the file.
+type blobReader struct {
+	io.Reader
+	f *os.File
+}
+
+func (br blobReader) Close() error {
+	return br.f.Close()
+}
+
+// DeleteBlob deletes the loose blob. Blobs are never packed (see Repack),
+// but blobs from packs written before that are only unpacked when all
+// objects are repacked, until then ErrBlobInAPack is returned for them.
+func (s *ObjectStore) DeleteBlob(str string) error {
+
+	hb, err := hashBytes(str)
+	if err != nil {
+		return err
+	}
+
+	var objPath = s.loosePath(hb)
+	typ, err := looseType(objPath)
+	if os.IsNotExist(err) {
+		orig, err := s.retrieveFromPacks(hb)
+		if err != nil {
+			return err
+		}
+		if packing.NewPackedObject(string(orig)).Type() != packing.ObjectTypeBlob {
+			return ErrNotABlob
+		}
+		return ErrBlobInAPack
+	}
+	if err != nil {
+		return err
+	}
+	if typ != packing.ObjectTypeBlob {
+		return ErrNotABlob
+	}
+
+	if err := os.Remove(objPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
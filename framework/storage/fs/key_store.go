@@ -0,0 +1,119 @@
+package fs
+
+import (
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/retro-framework/go-retro/framework/retro"
+)
+
+var (
+	ErrUnableToReadKey      = errors.New("unable to read key file")
+	ErrUnableToWriteKey     = errors.New("unable to write key file")
+	ErrInvalidPartitionName = errors.New("invalid partition name for key")
+)
+
+const keysDirName = "keys"
+
+// KeyStore keeps the key of each partition in a file below BasePath/keys,
+// deleting a key replaces its file with an empty one which marks the
+// partition as erased.
+//
+// Filesystems may keep copies of overwritten data (journals, snapshots,
+// backups), keys should be kept where deleting them is final.
+type KeyStore struct {
+	BasePath string
+}
+
+func (ks KeyStore) path(pn retro.PartitionName) (string, error) {
+	if pn == "" || strings.Contains(string(pn), "..") || strings.HasPrefix(string(pn), "/") {
+		return "", ErrInvalidPartitionName
+	}
+	return filepath.Join(ks.BasePath, keysDirName, filepath.FromSlash(string(pn))), nil
+}
+
+func (ks KeyStore) Key(pn retro.PartitionName, create bool) ([]byte, error) {
+
+	keyPath, err := ks.path(pn)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ioutil.ReadFile(keyPath)
+	switch {
+	case err == nil && len(key) == 0:
+		return nil, retro.ErasedError{Partition: pn}
+	case err == nil:
+		return key, nil
+	case !os.IsNotExist(err):
+		return nil, ErrUnableToReadKey
+	case !create:
+		return nil, retro.ErasedError{Partition: pn}
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	tmp, err := ks.writeTemp(keyPath, key)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+
+	// Linking fails if someone else created the key first, theirs is the
+	// one used.
+	if err := os.Link(tmp, keyPath); os.IsExist(err) {
+		return ks.Key(pn, false)
+	} else if err != nil {
+		return nil, ErrUnableToWriteKey
+	}
+
+	return key, nil
+}
+
+func (ks KeyStore) Delete(pn retro.PartitionName) error {
+
+	keyPath, err := ks.path(pn)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ks.writeTemp(keyPath, nil)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if err := os.Rename(tmp, keyPath); err != nil {
+		return ErrUnableToWriteKey
+	}
+	return nil
+}
+
+// writeTemp writes b to a temporary file next to keyPath, readable only
+// by the owner.
+func (ks KeyStore) writeTemp(keyPath string, b []byte) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return "", ErrUnableToWriteKey
+	}
+	f, err := ioutil.TempFile(filepath.Dir(keyPath), "tmp-key-")
+	if err != nil {
+		return "", ErrUnableToWriteKey
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", ErrUnableToWriteKey
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", ErrUnableToWriteKey
+	}
+	return f.Name(), nil
+}
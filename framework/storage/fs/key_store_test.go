@@ -0,0 +1,68 @@
+// +build integration
+
+package fs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"golang.org/x/xerrors"
+
+	"github.com/retro-framework/go-retro/framework/retro"
+	test "github.com/retro-framework/go-retro/framework/test_helper"
+)
+
+func Test_KeyStore(t *testing.T) {
+
+	var newKeyStore = func(t *testing.T) (KeyStore, func()) {
+		tmpdir, err := ioutil.TempDir("", "retro_key_store_test")
+		test.H(t).IsNil(err)
+		return KeyStore{BasePath: tmpdir}, func() { os.RemoveAll(tmpdir) }
+	}
+
+	t.Run("creates a key once and returns it thereafter", func(t *testing.T) {
+		ks, cleanup := newKeyStore(t)
+		defer cleanup()
+
+		_, err := ks.Key("identity/alice", false)
+		test.H(t).BoolEql(xerrors.Is(err, retro.ErrErased), true)
+
+		created, err := ks.Key("identity/alice", true)
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(len(created), 32)
+
+		again, err := ks.Key("identity/alice", true)
+		test.H(t).IsNil(err)
+		test.H(t).BoolEql(bytes.Equal(created, again), true)
+
+		other, err := ks.Key("identity/bob", true)
+		test.H(t).IsNil(err)
+		test.H(t).BoolEql(bytes.Equal(created, other), false)
+	})
+
+	t.Run("does not create a new key once deleted", func(t *testing.T) {
+		ks, cleanup := newKeyStore(t)
+		defer cleanup()
+
+		_, err := ks.Key("identity/alice", true)
+		test.H(t).IsNil(err)
+		test.H(t).IsNil(ks.Delete("identity/alice"))
+
+		_, err = ks.Key("identity/alice", true)
+		var erased retro.ErasedError
+		test.H(t).BoolEql(xerrors.As(err, &erased), true)
+		test.H(t).StringEql(string(erased.Partition), "identity/alice")
+	})
+
+	t.Run("refuses partition names escaping the key dir", func(t *testing.T) {
+		ks, cleanup := newKeyStore(t)
+		defer cleanup()
+
+		for _, pn := range []retro.PartitionName{"", "../identity", "/etc/passwd"} {
+			_, err := ks.Key(pn, true)
+			test.H(t).ErrEql(err, ErrInvalidPartitionName)
+		}
+	})
+}
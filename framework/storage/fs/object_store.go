@@ -1,15 +1,20 @@
 package fs
 
 import (
+	"bufio"
 	"bytes"
 	"compress/zlib"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/retro-framework/go-retro/framework/packing"
 	"github.com/retro-framework/go-retro/framework/retro"
@@ -31,12 +36,26 @@ var (
 
 type ObjectStore struct {
 	BasePath string
+
+	// packs are loaded lazily from BasePath/pack the first time an object
+	// can't be found loose, and reloaded whenever a lookup misses.
+	mu          sync.RWMutex
+	packs       []*packFile
+	packsLoaded bool
 }
 
 func (s *ObjectStore) mkdirAll(path string) error {
 	return os.MkdirAll(path, 0766)
 }
 
+func (s *ObjectStore) loosePath(hb []byte) string {
+	return filepath.Join(s.BasePath, fmt.Sprintf("%x/%x/%x", hb[0:1], hb[1:2], hb[2:]))
+}
+
+func (s *ObjectStore) packDir() string {
+	return filepath.Join(s.BasePath, packDirName)
+}
+
 func (s *ObjectStore) WritePacked(p retro.HashedObject) (int, error) {
 
 	// TODO: What if basepath points to a _file_ not a dir?
@@ -56,7 +75,7 @@ func (s *ObjectStore) WritePacked(p retro.HashedObject) (int, error) {
 
 	var (
 		hb      = p.Hash().Bytes()
-		objPath = filepath.Join(s.BasePath, fmt.Sprintf("%x/%x/%x", hb[0:1], hb[1:2], hb[2:]))
+		objPath = s.loosePath(hb)
 		objDir  = filepath.Dir(objPath)
 	)
 
@@ -67,32 +86,95 @@ func (s *ObjectStore) WritePacked(p retro.HashedObject) (int, error) {
 	}
 
 	if _, err := os.Stat(objPath); os.IsNotExist(err) {
-		f, err := os.Create(objPath)
-		if err != nil {
-			return 0, ErrUnableToCreateObjectFile
-		}
-		defer f.Close()
 
-		n, err := f.Write(b.Bytes())
-		if err != nil {
-			return 0, ErrUnableToWriteObject
-		}
-
-		if n != len(b.Bytes()) {
-			return 0, ErrUnableToCompletelyWriteObject
+		if s.inPacks(hb) {
+			return 0, nil
 		}
 
-		return n, nil
+		return writeLoose(objPath, b.Bytes())
 	}
 
 	return 0, nil
 }
 
+// writeLoose writes the deflated object to objPath. Loose objects are
+// written to a temporary file and renamed into place so that a concurrent
+// Repack never picks up a partially written object.
+func writeLoose(objPath string, deflated []byte) (int, error) {
+
+	f, err := ioutil.TempFile(filepath.Dir(objPath), "tmp-obj-")
+	if err != nil {
+		return 0, ErrUnableToCreateObjectFile
+	}
+	defer os.Remove(f.Name())
+
+	n, err := f.Write(deflated)
+	if err != nil {
+		f.Close()
+		return 0, ErrUnableToWriteObject
+	}
+
+	if n != len(deflated) {
+		f.Close()
+		return 0, ErrUnableToCompletelyWriteObject
+	}
+
+	if err := f.Close(); err != nil {
+		return 0, ErrUnableToWriteObject
+	}
+
+	if err := os.Rename(f.Name(), objPath); err != nil {
+		return 0, ErrUnableToWriteObject
+	}
+
+	return n, nil
+}
+
+// looseType returns the type of the loose object at objPath, only its
+// header is inflated.
+func looseType(objPath string) (retro.ObjectTypeName, error) {
+	f, err := os.Open(objPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		return "", ErrUnableToInflateObject
+	}
+	typ, err := bufio.NewReader(io.LimitReader(zr, 64)).ReadString(' ')
+	if err != nil {
+		return "", ErrUnableToInflateObject
+	}
+	return retro.ObjectTypeName(strings.TrimSuffix(typ, " ")), nil
+}
+
 // TODO: should also parse the aglo out of the string and set the PO Hash
 // algo/etc to the right values., the new PackedObject could be kept and
 // maybe simply take an AlgoName in the second position?
 func (s *ObjectStore) RetrievePacked(str string) (retro.HashedObject, error) {
 
+	hb, err := hashBytes(str)
+	if err != nil {
+		return nil, err
+	}
+
+	orig, err := s.retrieveLoose(hb)
+	if os.IsNotExist(err) {
+		orig, err = s.retrieveFromPacks(hb)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	po := packing.NewPackedObject(string(orig))
+	return po, nil
+}
+
+// hashBytes returns the bytes of a hash given as a string with prefix
+// (e.g sha256:b937....19251876f7).
+func hashBytes(str string) ([]byte, error) {
+
 	parts := strings.Split(str, ":") // ["sha256", "hexbyteshexbtytes"]
 	if len(parts) != 2 {
 		return nil, ErrBadObjectHashForRetrieve
@@ -108,28 +190,231 @@ func (s *ObjectStore) RetrievePacked(str string) (retro.HashedObject, error) {
 		return nil, ErrUnsupportedHash
 	}
 
-	var (
-		h       = packing.NewHash(packing.HashAlgoNameSHA256, dst)
-		hb      = h.Bytes()
-		objPath = filepath.Join(s.BasePath, fmt.Sprintf("%x/%x/%x", hb[0:1], hb[1:2], hb[2:]))
-	)
+	return packing.NewHash(packing.HashAlgoNameSHA256, dst).Bytes(), nil
+}
 
-	content, err := ioutil.ReadFile(objPath)
+func (s *ObjectStore) retrieveLoose(hb []byte) ([]byte, error) {
+	content, err := ioutil.ReadFile(s.loosePath(hb))
+	if os.IsNotExist(err) {
+		return nil, err
+	}
 	if err != nil {
 		return nil, ErrUnableToReadObjectFile
 	}
+	return inflate(content)
+}
 
-	b := bytes.NewReader([]byte(content))
-	r, err := zlib.NewReader(b)
+// retrieveFromPacks looks the object up in the known packs, if it can't be
+// found there the packs are reloaded (a Repack may have moved the object
+// from loose into a new pack since we last looked) and the lookup is tried
+// once more, including the loose object.
+func (s *ObjectStore) retrieveFromPacks(hb []byte) ([]byte, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		if attempt > 0 || !s.loaded() {
+			if err := s.reloadPacks(); err != nil {
+				return nil, err
+			}
+			if orig, err := s.retrieveLoose(hb); !os.IsNotExist(err) {
+				return orig, err
+			}
+		}
+		s.mu.RLock()
+		packs := s.packs
+		s.mu.RUnlock()
+		for _, p := range packs {
+			orig, found, err := p.retrieve(hb)
+			if found {
+				return orig, nil
+			}
+			if err == ErrUnableToReadPack {
+				// Pack was removed by a concurrent Repack{All: true}
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, ErrNoSuchObject
+}
+
+func (s *ObjectStore) inPacks(hb []byte) bool {
+	if !s.loaded() {
+		if err := s.reloadPacks(); err != nil {
+			return false
+		}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, p := range s.packs {
+		if p.contains(hb) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ObjectStore) loaded() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.packsLoaded
+}
+
+func (s *ObjectStore) reloadPacks() error {
+	idxPaths, err := filepath.Glob(filepath.Join(s.packDir(), "pack-*.idx"))
 	if err != nil {
-		return nil, ErrUnableToInflateObject
+		return ErrUnableToReadPack
 	}
-	r.Close()
+	sort.Strings(idxPaths)
+	var packs = make([]*packFile, 0, len(idxPaths))
+	for _, idxPath := range idxPaths {
+		p, err := loadPackFile(idxPath)
+		if err == ErrUnableToReadPack {
+			continue // removed while we were looking
+		}
+		if err != nil {
+			return err
+		}
+		packs = append(packs, p)
+	}
+	s.mu.Lock()
+	s.packs, s.packsLoaded = packs, true
+	s.mu.Unlock()
+	return nil
+}
 
-	// TODO: Check for err here, can this really fail with a local
-	// buffer?
-	orig, _ := ioutil.ReadAll(r)
+// Repack consolidates all loose objects into a new pack and removes the
+// loose copies. Blobs are left loose, packing reads objects into memory
+// and blobs (e.g images) gain nothing from delta compression, with All
+// blobs found in existing packs are written back loose. Objects larger
+// than 256MiB are left loose too. Writers may continue to write (loose)
+// objects while a repack is running, objects written after the loose
+// objects were listed are simply picked up by the next repack. Repack
+// returns the number of objects written to the new pack.
+func (s *ObjectStore) Repack(opts RepackOptions) (int, error) {
 
-	po := packing.NewPackedObject(string(orig))
-	return po, nil
+	loosePaths, err := filepath.Glob(filepath.Join(s.BasePath, "[0-9a-f][0-9a-f]", "[0-9a-f][0-9a-f]", "*"))
+	if err != nil {
+		return 0, ErrUnableToReadObjectFile
+	}
+
+	var (
+		objs     []packObject
+		seen     = make(map[string]bool)
+		packed   []string
+		oldPacks []*packFile
+	)
+
+	for _, p := range loosePaths {
+		rel, err := filepath.Rel(s.BasePath, p)
+		if err != nil {
+			continue
+		}
+		hb, err := hex.DecodeString(strings.Replace(filepath.ToSlash(rel), "/", "", -1))
+		if err != nil || len(hb) != sha256.Size {
+			continue // temporary files, etc
+		}
+		typ, err := looseType(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if typ == packing.ObjectTypeBlob {
+			continue
+		}
+		orig, err := s.retrieveLoose(hb)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if len(orig) > maxPackObjectSize {
+			continue
+		}
+		objs = append(objs, packObject{hb, orig})
+		seen[string(hb)] = true
+		packed = append(packed, p)
+	}
+
+	if opts.All {
+		if err := s.reloadPacks(); err != nil {
+			return 0, err
+		}
+		s.mu.RLock()
+		oldPacks = s.packs
+		s.mu.RUnlock()
+		for _, p := range oldPacks {
+			err := p.each(func(hb, contents []byte) error {
+				if seen[string(hb)] {
+					return nil
+				}
+				seen[string(hb)] = true
+				if packing.NewPackedObject(string(contents)).Type() == packing.ObjectTypeBlob {
+					return s.unpackBlob(hb, contents)
+				}
+				objs = append(objs, packObject{append([]byte(nil), hb...), contents})
+				return nil
+			})
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if len(objs) == 0 {
+		// Old packs may have held only blobs, which are loose now.
+		for _, p := range oldPacks {
+			os.Remove(strings.TrimSuffix(p.path, ".pack") + ".idx")
+			os.Remove(p.path)
+		}
+		if len(oldPacks) > 0 {
+			return 0, s.reloadPacks()
+		}
+		return 0, nil
+	}
+
+	pack, err := writePack(s.packDir(), objs, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	s.packs, s.packsLoaded = append([]*packFile{pack}, s.packs...), true
+	s.mu.Unlock()
+
+	// Only now that the pack is visible to readers is it safe to drop
+	// the loose objects and old packs.
+	for _, p := range packed {
+		os.Remove(p)
+	}
+	for _, p := range oldPacks {
+		if p.path == pack.path {
+			continue
+		}
+		os.Remove(strings.TrimSuffix(p.path, ".pack") + ".idx")
+		os.Remove(p.path)
+	}
+
+	return len(objs), s.reloadPacks()
+}
+
+// unpackBlob writes a blob found in a pack back as a loose object, packs
+// written before blobs were left loose may hold some.
+func (s *ObjectStore) unpackBlob(hb, contents []byte) error {
+	var objPath = s.loosePath(hb)
+	if _, err := os.Stat(objPath); err == nil {
+		return nil
+	}
+	if err := s.mkdirAll(filepath.Dir(objPath)); err != nil {
+		return ErrUnableToCreateObjectDir
+	}
+	deflated, err := deflate(contents)
+	if err != nil {
+		return ErrUnableToWriteObject
+	}
+	_, err = writeLoose(objPath, deflated)
+	return err
 }
@@ -0,0 +1,285 @@
+// +build integration
+
+package fs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/retro-framework/go-retro/framework/packing"
+	"github.com/retro-framework/go-retro/framework/retro"
+	test "github.com/retro-framework/go-retro/framework/test_helper"
+)
+
+func Test_ObjectStore_Repack(t *testing.T) {
+
+	var packEvents = func(t *testing.T, from, to int) []retro.HashedObject {
+		var (
+			jp  = packing.NewJSONPacker()
+			res []retro.HashedObject
+		)
+		for i := from; i < to; i++ {
+			pEv, err := jp.PackEvent("set_author_name", struct {
+				Name string `json:"name"`
+				Bio  string `json:"bio"`
+			}{fmt.Sprintf("Author %d", i), "Writes mostly about event sourcing, occasionally about cooking."})
+			test.H(t).IsNil(err)
+			res = append(res, pEv)
+		}
+		return res
+	}
+
+	var looseCount = func(t *testing.T, dir string) int {
+		paths, err := filepath.Glob(filepath.Join(dir, "[0-9a-f][0-9a-f]", "[0-9a-f][0-9a-f]", "*"))
+		test.H(t).IsNil(err)
+		return len(paths)
+	}
+
+	t.Run("moves loose objects into a pack and retrieves them from there", func(t *testing.T) {
+
+		tmpdir, err := ioutil.TempDir("", "retro_framework_fs_repack_test")
+		test.H(t).IsNil(err)
+		defer os.RemoveAll(tmpdir)
+
+		var (
+			s    = &ObjectStore{BasePath: tmpdir}
+			objs = packEvents(t, 0, 50)
+		)
+
+		for _, obj := range objs {
+			_, err := s.WritePacked(obj)
+			test.H(t).IsNil(err)
+		}
+		test.H(t).IntEql(looseCount(t, tmpdir), 50)
+
+		n, err := s.Repack(RepackOptions{Deltas: true})
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(n, 50)
+		test.H(t).IntEql(looseCount(t, tmpdir), 0)
+
+		// A fresh store has to discover the packs on disk
+		for _, store := range []*ObjectStore{s, &ObjectStore{BasePath: tmpdir}} {
+			for _, obj := range objs {
+				po, err := store.RetrievePacked(obj.Hash().String())
+				test.H(t).IsNil(err)
+				test.H(t).StringEql(string(po.Contents()), string(obj.Contents()))
+			}
+		}
+
+		t.Run("does not write objects which are already packed", func(t *testing.T) {
+			n, err := s.WritePacked(objs[0])
+			test.H(t).IsNil(err)
+			test.H(t).IntEql(n, 0)
+			test.H(t).IntEql(looseCount(t, tmpdir), 0)
+		})
+
+		t.Run("consolidates existing packs", func(t *testing.T) {
+			for _, obj := range packEvents(t, 50, 60) {
+				_, err := s.WritePacked(obj)
+				test.H(t).IsNil(err)
+			}
+			n, err := s.Repack(RepackOptions{Deltas: true, All: true})
+			test.H(t).IsNil(err)
+			test.H(t).IntEql(n, 60)
+
+			idxs, err := filepath.Glob(filepath.Join(tmpdir, packDirName, "*.idx"))
+			test.H(t).IsNil(err)
+			test.H(t).IntEql(len(idxs), 1)
+
+			for _, obj := range objs {
+				_, err := s.RetrievePacked(obj.Hash().String())
+				test.H(t).IsNil(err)
+			}
+		})
+	})
+
+	t.Run("allows writers to continue while repacking", func(t *testing.T) {
+
+		tmpdir, err := ioutil.TempDir("", "retro_framework_fs_repack_test")
+		test.H(t).IsNil(err)
+		defer os.RemoveAll(tmpdir)
+
+		var (
+			s    = &ObjectStore{BasePath: tmpdir}
+			objs = packEvents(t, 0, 200)
+			wg   sync.WaitGroup
+		)
+
+		for _, obj := range objs[:100] {
+			_, err := s.WritePacked(obj)
+			test.H(t).IsNil(err)
+		}
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for _, obj := range objs[100:] {
+				if _, err := s.WritePacked(obj); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 3; i++ {
+				if _, err := s.Repack(RepackOptions{Deltas: i%2 == 0}); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+		wg.Wait()
+
+		for _, obj := range objs {
+			po, err := s.RetrievePacked(obj.Hash().String())
+			test.H(t).IsNil(err)
+			test.H(t).StringEql(string(po.Contents()), string(obj.Contents()))
+		}
+	})
+
+	t.Run("errors when retrieving an object in neither loose nor packed storage", func(t *testing.T) {
+		tmpdir, err := ioutil.TempDir("", "retro_framework_fs_repack_test")
+		test.H(t).IsNil(err)
+		defer os.RemoveAll(tmpdir)
+
+		var s = &ObjectStore{BasePath: tmpdir}
+		_, err = s.RetrievePacked(packing.NewPackedObject("nope").Hash().String())
+		test.H(t).ErrEql(err, ErrNoSuchObject)
+	})
+
+	t.Run("leaves blobs loose", func(t *testing.T) {
+		tmpdir, err := ioutil.TempDir("", "retro_framework_fs_repack_test")
+		test.H(t).IsNil(err)
+		defer os.RemoveAll(tmpdir)
+
+		var s = &ObjectStore{BasePath: tmpdir}
+		ref, err := s.WriteBlob(strings.NewReader("not really a jpeg"))
+		test.H(t).IsNil(err)
+		_, err = s.WritePacked(packEvents(t, 0, 1)[0])
+		test.H(t).IsNil(err)
+
+		n, err := s.Repack(RepackOptions{Deltas: true})
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(n, 1)
+		test.H(t).IntEql(looseCount(t, tmpdir), 1)
+
+		rc, size, err := s.OpenBlob(ref.Hash)
+		test.H(t).IsNil(err)
+		defer rc.Close()
+		b, err := ioutil.ReadAll(rc)
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(int(size), 17)
+		test.H(t).StringEql(string(b), "not really a jpeg")
+	})
+
+	t.Run("unpacks blobs from old packs when repacking all", func(t *testing.T) {
+		tmpdir, err := ioutil.TempDir("", "retro_framework_fs_repack_test")
+		test.H(t).IsNil(err)
+		defer os.RemoveAll(tmpdir)
+
+		// Packs written before blobs were left loose hold them.
+		var (
+			s    = &ObjectStore{BasePath: tmpdir}
+			blob = packing.PackBlob([]byte("not really a jpeg"))
+		)
+		_, err = writePack(s.packDir(), []packObject{{blob.Hash().Bytes(), blob.Contents()}}, RepackOptions{})
+		test.H(t).IsNil(err)
+
+		rc, _, err := s.OpenBlob(blob.Hash().String())
+		test.H(t).IsNil(err)
+		rc.Close()
+
+		_, err = s.Repack(RepackOptions{All: true})
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(looseCount(t, tmpdir), 1)
+		packs, err := filepath.Glob(filepath.Join(tmpdir, packDirName, "*.pack"))
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(len(packs), 0)
+
+		rc, size, err := s.OpenBlob(blob.Hash().String())
+		test.H(t).IsNil(err)
+		defer rc.Close()
+		b, err := ioutil.ReadAll(rc)
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(int(size), 17)
+		test.H(t).StringEql(string(b), "not really a jpeg")
+	})
+
+	t.Run("deletes blobs and nothing else", func(t *testing.T) {
+		tmpdir, err := ioutil.TempDir("", "retro_framework_fs_repack_test")
+		test.H(t).IsNil(err)
+		defer os.RemoveAll(tmpdir)
+
+		var (
+			s  = &ObjectStore{BasePath: tmpdir}
+			ev = packEvents(t, 0, 1)[0]
+		)
+		ref, err := s.WriteBlob(strings.NewReader("not really a jpeg"))
+		test.H(t).IsNil(err)
+		_, err = s.WritePacked(ev)
+		test.H(t).IsNil(err)
+
+		test.H(t).IsNil(s.DeleteBlob(ref.Hash))
+		_, _, err = s.OpenBlob(ref.Hash)
+		test.H(t).ErrEql(err, ErrNoSuchObject)
+		test.H(t).ErrEql(s.DeleteBlob(ref.Hash), ErrNoSuchObject)
+
+		test.H(t).ErrEql(s.DeleteBlob(ev.Hash().String()), ErrNotABlob)
+		_, err = s.RetrievePacked(ev.Hash().String())
+		test.H(t).IsNil(err)
+
+		var packed = packing.PackBlob([]byte("packed long ago"))
+		_, err = writePack(s.packDir(), []packObject{{packed.Hash().Bytes(), packed.Contents()}}, RepackOptions{})
+		test.H(t).IsNil(err)
+		test.H(t).ErrEql(s.DeleteBlob(packed.Hash().String()), ErrBlobInAPack)
+	})
+}
+
+func Test_Delta(t *testing.T) {
+	var (
+		base   = []byte(`event json set_author_name 43` + "\u0000" + `{"name":"Author 1","bio":"Writes a lot."}`)
+		target = []byte(`event json set_author_name 44` + "\u0000" + `{"name":"Author 22","bio":"Writes a lot."}`)
+	)
+	delta := makeDelta(base, target)
+	if len(delta) >= len(target) {
+		t.Fatalf("expected delta (%d bytes) to be smaller than target (%d bytes)", len(delta), len(target))
+	}
+	res, err := applyDelta(base, delta)
+	test.H(t).IsNil(err)
+	test.H(t).StringEql(string(res), string(target))
+
+	_, err = applyDelta(target, delta)
+	test.H(t).ErrEql(err, ErrCorruptDelta)
+
+	// corrupt deltas must neither panic nor allocate what they claim
+	var uvarints = func(vs ...uint64) []byte {
+		var b []byte
+		for _, v := range vs {
+			var buf [binary.MaxVarintLen64]byte
+			b = append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+		}
+		return b
+	}
+	for name, delta := range map[string][]byte{
+		"huge target":      uvarints(uint64(len(base)), math.MaxUint64),
+		"overflowing copy": append(uvarints(uint64(len(base)), 1, uint64(deltaOpCopy)), uvarints(2, math.MaxUint64-1)...),
+	} {
+		_, err = applyDelta(base, delta)
+		if err != ErrCorruptDelta {
+			t.Errorf("%s: expected %q, got %v", name, ErrCorruptDelta, err)
+		}
+	}
+}
+
+func Test_ReadPackEntry(t *testing.T) {
+	var pack = []byte{packEntryFull, 0xff, 0xff, 0xff, 0xff}
+	_, err := readPackEntry(bytes.NewReader(pack), int64(len(pack)), 0, true)
+	test.H(t).ErrEql(err, ErrCorruptPackEntry)
+}
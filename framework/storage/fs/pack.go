@@ -0,0 +1,559 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Packfiles consolidate many loose objects into a single file. Each pack
+// comes with an index file (.idx) that holds the object hashes in sorted
+// order alongside the offset of the object in the pack so that lookups are
+// a binary search away.
+//
+// Pack layout (all integers big endian):
+//
+//	"RPCK" | uint32 version | uint32 object count
+//	entries...
+//
+// Each entry is a one byte kind, followed (for deltas only) by the uint64
+// offset of the base entry in the same pack, a uint32 length and that many
+// bytes of zlib compressed data. Full entries hold the packed object
+// contents, delta entries hold instructions to rebuild the object from the
+// base entry. Deltas are only ever made against full entries, the chain
+// depth is always one.
+//
+// Index layout:
+//
+//	"RIDX" | uint32 version | uint32 object count
+//	(32 byte hash | uint64 offset)... sorted by hash
+//
+// The pack is always renamed into place before the index, readers discover
+// packs by their index so they never see a partially written pack.
+
+var (
+	ErrUnableToCreatePackDir = errors.New("unable to create pack dir")
+	ErrUnableToWritePack     = errors.New("unable to write pack")
+	ErrUnableToReadPack      = errors.New("unable to read pack")
+	ErrCorruptPackIndex      = errors.New("corrupt pack index")
+	ErrCorruptPackEntry      = errors.New("corrupt pack entry")
+	ErrCorruptDelta          = errors.New("corrupt delta")
+)
+
+const (
+	packDirName = "pack"
+	packMagic   = "RPCK"
+	idxMagic    = "RIDX"
+	packVersion = 1
+
+	packHeaderSize = 12
+	idxEntrySize   = sha256.Size + 8
+
+	packEntryFull  byte = 1
+	packEntryDelta byte = 2
+
+	deltaOpCopy   byte = 1
+	deltaOpInsert byte = 2
+
+	// deltaBlockSize is the granularity with which we fingerprint the base
+	// object when looking for copyable runs, smaller blocks find more
+	// matches in the small JSON payloads typical for events.
+	deltaBlockSize = 8
+
+	// DefaultDeltaWindow is how many previous objects of the same kind are
+	// considered as delta bases when repacking.
+	DefaultDeltaWindow = 10
+
+	// maxPackObjectSize is the largest object which is packed, larger
+	// ones stay loose. Lengths read from packs are checked against it so
+	// that corrupt packs can't make readers allocate arbitrary amounts.
+	maxPackObjectSize = 256 << 20
+)
+
+// RepackOptions controls how Repack builds new packs.
+type RepackOptions struct {
+	// Deltas enables delta compression between similar objects
+	// (e.g events of the same name).
+	Deltas bool
+	// DeltaWindow is the number of candidate bases per object, if zero
+	// DefaultDeltaWindow is used.
+	DeltaWindow int
+	// All also consolidates objects from existing packs into the new
+	// pack and removes the old packs afterwards.
+	All bool
+}
+
+type packFile struct {
+	path string // path to the .pack file
+	idx  []byte // index entries, sorted by hash
+	n    int
+}
+
+func (p *packFile) hashAt(i int) []byte {
+	return p.idx[i*idxEntrySize : i*idxEntrySize+sha256.Size]
+}
+
+func (p *packFile) offsetAt(i int) int64 {
+	return int64(binary.BigEndian.Uint64(p.idx[i*idxEntrySize+sha256.Size : (i+1)*idxEntrySize]))
+}
+
+func (p *packFile) offset(hb []byte) (int64, bool) {
+	i := sort.Search(p.n, func(i int) bool {
+		return bytes.Compare(p.hashAt(i), hb) >= 0
+	})
+	if i < p.n && bytes.Equal(p.hashAt(i), hb) {
+		return p.offsetAt(i), true
+	}
+	return 0, false
+}
+
+func (p *packFile) contains(hb []byte) bool {
+	_, found := p.offset(hb)
+	return found
+}
+
+func (p *packFile) retrieve(hb []byte) ([]byte, bool, error) {
+	off, found := p.offset(hb)
+	if !found {
+		return nil, false, nil
+	}
+	f, err := os.Open(p.path)
+	if err != nil {
+		return nil, false, ErrUnableToReadPack
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, false, ErrUnableToReadPack
+	}
+	contents, err := readPackEntry(f, fi.Size(), off, true)
+	return contents, err == nil, err
+}
+
+// each calls fn for every object in the pack in index order.
+func (p *packFile) each(fn func(hb, contents []byte) error) error {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return ErrUnableToReadPack
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return ErrUnableToReadPack
+	}
+	for i := 0; i < p.n; i++ {
+		contents, err := readPackEntry(f, fi.Size(), p.offsetAt(i), true)
+		if err != nil {
+			return err
+		}
+		if err := fn(p.hashAt(i), contents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadPackFile(idxPath string) (*packFile, error) {
+	b, err := ioutil.ReadFile(idxPath)
+	if err != nil {
+		return nil, ErrUnableToReadPack
+	}
+	if len(b) < packHeaderSize || string(b[0:4]) != idxMagic {
+		return nil, ErrCorruptPackIndex
+	}
+	if binary.BigEndian.Uint32(b[4:8]) != packVersion {
+		return nil, ErrCorruptPackIndex
+	}
+	n := int(binary.BigEndian.Uint32(b[8:12]))
+	if len(b) != packHeaderSize+n*idxEntrySize {
+		return nil, ErrCorruptPackIndex
+	}
+	return &packFile{
+		path: strings.TrimSuffix(idxPath, ".idx") + ".pack",
+		idx:  b[packHeaderSize:],
+		n:    n,
+	}, nil
+}
+
+// readPackEntry reads the entry at off of the pack r, which is size bytes
+// long, and rebuilds the object if it's a delta.
+func readPackEntry(r io.ReaderAt, size, off int64, allowDelta bool) ([]byte, error) {
+	var kind [1]byte
+	if _, err := r.ReadAt(kind[:], off); err != nil {
+		return nil, ErrCorruptPackEntry
+	}
+	off++
+
+	var baseOff int64
+	switch kind[0] {
+	case packEntryFull:
+	case packEntryDelta:
+		if !allowDelta {
+			return nil, ErrCorruptPackEntry
+		}
+		var b [8]byte
+		if _, err := r.ReadAt(b[:], off); err != nil {
+			return nil, ErrCorruptPackEntry
+		}
+		baseOff = int64(binary.BigEndian.Uint64(b[:]))
+		off += 8
+	default:
+		return nil, ErrCorruptPackEntry
+	}
+
+	var l [4]byte
+	if _, err := r.ReadAt(l[:], off); err != nil {
+		return nil, ErrCorruptPackEntry
+	}
+	off += 4
+
+	n := int64(binary.BigEndian.Uint32(l[:]))
+	if n > size-off {
+		return nil, ErrCorruptPackEntry
+	}
+	compressed := make([]byte, n)
+	if _, err := r.ReadAt(compressed, off); err != nil {
+		return nil, ErrCorruptPackEntry
+	}
+
+	data, err := inflate(compressed)
+	if err != nil {
+		return nil, err
+	}
+
+	if kind[0] == packEntryFull {
+		return data, nil
+	}
+
+	base, err := readPackEntry(r, size, baseOff, false)
+	if err != nil {
+		return nil, err
+	}
+	return applyDelta(base, data)
+}
+
+type packObject struct {
+	hb       []byte
+	contents []byte
+}
+
+// deltaGroup returns the key used to decide which objects are similar
+// enough to be worth delta compressing against each other. It's the object
+// header without the trailing length, e.g "event json set_author_name".
+func deltaGroup(contents []byte) string {
+	i := bytes.IndexByte(contents, 0)
+	if i < 0 {
+		return ""
+	}
+	hdr := string(contents[:i])
+	if j := strings.LastIndexByte(hdr, ' '); j >= 0 {
+		return hdr[:j]
+	}
+	return hdr
+}
+
+// writePack writes objs to a new pack and index in dir and returns the
+// loaded pack. Objects are first written to temporary files and renamed into
+// place, the pack before the index.
+func writePack(dir string, objs []packObject, opts RepackOptions) (*packFile, error) {
+
+	if err := os.MkdirAll(dir, 0766); err != nil {
+		return nil, ErrUnableToCreatePackDir
+	}
+
+	window := opts.DeltaWindow
+	if window <= 0 {
+		window = DefaultDeltaWindow
+	}
+
+	// Similar objects next to each other, larger objects first so that
+	// they become the bases, deltas that remove data are smaller than
+	// those adding it.
+	sort.SliceStable(objs, func(i, j int) bool {
+		gi, gj := deltaGroup(objs[i].contents), deltaGroup(objs[j].contents)
+		if gi != gj {
+			return gi < gj
+		}
+		if len(objs[i].contents) != len(objs[j].contents) {
+			return len(objs[i].contents) > len(objs[j].contents)
+		}
+		return bytes.Compare(objs[i].hb, objs[j].hb) < 0
+	})
+
+	tmp, err := ioutil.TempFile(dir, "tmp-pack-")
+	if err != nil {
+		return nil, ErrUnableToWritePack
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	type base struct {
+		off      int64
+		contents []byte
+	}
+
+	var (
+		w       = bufio.NewWriter(tmp)
+		off     = int64(packHeaderSize)
+		offsets = make(map[string]int64, len(objs))
+		bases   []base
+		group   string
+		hdr     [packHeaderSize]byte
+		nameSum = sha256.New()
+	)
+
+	copy(hdr[0:4], packMagic)
+	binary.BigEndian.PutUint32(hdr[4:8], packVersion)
+	binary.BigEndian.PutUint32(hdr[8:12], uint32(len(objs)))
+	w.Write(hdr[:])
+
+	for _, obj := range objs {
+
+		if g := deltaGroup(obj.contents); g != group {
+			group, bases = g, bases[:0]
+		}
+
+		var (
+			kind    = packEntryFull
+			data    = obj.contents
+			baseOff int64
+		)
+
+		if opts.Deltas {
+			for _, b := range bases {
+				d := makeDelta(b.contents, obj.contents)
+				if len(d) < len(obj.contents)/2 && (kind == packEntryFull || len(d) < len(data)) {
+					kind, data, baseOff = packEntryDelta, d, b.off
+				}
+			}
+		}
+
+		compressed, err := deflate(data)
+		if err != nil {
+			return nil, ErrUnableToWritePack
+		}
+
+		offsets[string(obj.hb)] = off
+
+		var entryHdr bytes.Buffer
+		entryHdr.WriteByte(kind)
+		if kind == packEntryDelta {
+			binary.Write(&entryHdr, binary.BigEndian, uint64(baseOff))
+		}
+		binary.Write(&entryHdr, binary.BigEndian, uint32(len(compressed)))
+
+		if kind == packEntryFull && opts.Deltas {
+			bases = append(bases, base{off, obj.contents})
+			if len(bases) > window {
+				bases = bases[1:]
+			}
+		}
+
+		n1, _ := w.Write(entryHdr.Bytes())
+		n2, err := w.Write(compressed)
+		if err != nil {
+			return nil, ErrUnableToWritePack
+		}
+		off += int64(n1 + n2)
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, ErrUnableToWritePack
+	}
+	if err := tmp.Sync(); err != nil {
+		return nil, ErrUnableToWritePack
+	}
+
+	// Index, sorted by hash
+	sort.Slice(objs, func(i, j int) bool {
+		return bytes.Compare(objs[i].hb, objs[j].hb) < 0
+	})
+
+	var idx bytes.Buffer
+	copy(hdr[0:4], idxMagic)
+	idx.Write(hdr[:])
+	for _, obj := range objs {
+		idx.Write(obj.hb)
+		binary.Write(&idx, binary.BigEndian, uint64(offsets[string(obj.hb)]))
+		nameSum.Write(obj.hb)
+	}
+
+	var (
+		name     = fmt.Sprintf("pack-%s", hex.EncodeToString(nameSum.Sum(nil)))
+		packPath = filepath.Join(dir, name+".pack")
+		idxPath  = filepath.Join(dir, name+".idx")
+	)
+
+	if err := tmp.Close(); err != nil {
+		return nil, ErrUnableToWritePack
+	}
+	if err := os.Rename(tmp.Name(), packPath); err != nil {
+		return nil, ErrUnableToWritePack
+	}
+
+	tmpIdx, err := ioutil.TempFile(dir, "tmp-idx-")
+	if err != nil {
+		return nil, ErrUnableToWritePack
+	}
+	defer os.Remove(tmpIdx.Name())
+	if _, err := tmpIdx.Write(idx.Bytes()); err != nil {
+		tmpIdx.Close()
+		return nil, ErrUnableToWritePack
+	}
+	if err := tmpIdx.Close(); err != nil {
+		return nil, ErrUnableToWritePack
+	}
+	if err := os.Rename(tmpIdx.Name(), idxPath); err != nil {
+		return nil, ErrUnableToWritePack
+	}
+
+	return loadPackFile(idxPath)
+}
+
+func deflate(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func inflate(b []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, ErrUnableToInflateObject
+	}
+	defer r.Close()
+	orig, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, ErrUnableToInflateObject
+	}
+	return orig, nil
+}
+
+// makeDelta encodes target as a list of copy (from base) and insert
+// instructions. Base is fingerprinted in fixed size blocks, matches found
+// in the target are extended in both directions as far as they go.
+func makeDelta(base, target []byte) []byte {
+
+	var (
+		out    bytes.Buffer
+		insert []byte
+		index  = make(map[string]int)
+		varint [binary.MaxVarintLen64]byte
+	)
+
+	var putUvarint = func(v int) {
+		n := binary.PutUvarint(varint[:], uint64(v))
+		out.Write(varint[:n])
+	}
+
+	var flush = func() {
+		if len(insert) == 0 {
+			return
+		}
+		out.WriteByte(deltaOpInsert)
+		putUvarint(len(insert))
+		out.Write(insert)
+		insert = insert[:0]
+	}
+
+	putUvarint(len(base))
+	putUvarint(len(target))
+
+	for i := 0; i+deltaBlockSize <= len(base); i += deltaBlockSize {
+		if _, exists := index[string(base[i:i+deltaBlockSize])]; !exists {
+			index[string(base[i:i+deltaBlockSize])] = i
+		}
+	}
+
+	for i := 0; i < len(target); {
+		if i+deltaBlockSize <= len(target) {
+			if j, found := index[string(target[i:i+deltaBlockSize])]; found {
+				n := deltaBlockSize
+				for j+n < len(base) && i+n < len(target) && base[j+n] == target[i+n] {
+					n++
+				}
+				// The pending insert holds the target bytes directly
+				// preceding i, reclaim as many as match the base.
+				for len(insert) > 0 && j > 0 && base[j-1] == insert[len(insert)-1] {
+					i, j, n = i-1, j-1, n+1
+					insert = insert[:len(insert)-1]
+				}
+				flush()
+				out.WriteByte(deltaOpCopy)
+				putUvarint(j)
+				putUvarint(n)
+				i += n
+				continue
+			}
+		}
+		insert = append(insert, target[i])
+		i++
+	}
+	flush()
+
+	return out.Bytes()
+}
+
+func applyDelta(base, delta []byte) ([]byte, error) {
+
+	var r = bytes.NewReader(delta)
+
+	baseLen, err := binary.ReadUvarint(r)
+	if err != nil || baseLen != uint64(len(base)) {
+		return nil, ErrCorruptDelta
+	}
+	targetLen, err := binary.ReadUvarint(r)
+	if err != nil || targetLen > maxPackObjectSize {
+		return nil, ErrCorruptDelta
+	}
+
+	var out = make([]byte, 0, targetLen)
+	for {
+		op, err := r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		switch op {
+		case deltaOpCopy:
+			off, err1 := binary.ReadUvarint(r)
+			n, err2 := binary.ReadUvarint(r)
+			if err1 != nil || err2 != nil || n > uint64(len(base)) || off > uint64(len(base))-n {
+				return nil, ErrCorruptDelta
+			}
+			out = append(out, base[off:off+n]...)
+		case deltaOpInsert:
+			n, err := binary.ReadUvarint(r)
+			if err != nil || n > uint64(r.Len()) {
+				return nil, ErrCorruptDelta
+			}
+			var b = make([]byte, n)
+			r.Read(b)
+			out = append(out, b...)
+		default:
+			return nil, ErrCorruptDelta
+		}
+	}
+
+	if uint64(len(out)) != targetLen {
+		return nil, ErrCorruptDelta
+	}
+
+	return out, nil
+}
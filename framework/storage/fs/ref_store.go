@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/retro-framework/go-retro/framework/packing"
 	"github.com/retro-framework/go-retro/framework/retro"
@@ -24,10 +25,21 @@ var (
 	ErrBadHashForRetrieve  = errors.New("no valid hash in ref ")
 )
 
+// RefStore stores each ref in a file below BasePath named after the ref,
+// names which are not valid (see storage.ValidRefName) are refused so that
+// refs can't be read or written outside of BasePath.
 type RefStore struct {
 	BasePath string
 }
 
+// refPath returns the path of the file holding the ref.
+func (s *RefStore) refPath(name string) (string, error) {
+	if err := storage.ValidRefName(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.BasePath, filepath.FromSlash(name)), nil
+}
+
 func (r *RefStore) mkdirAll(path string) error {
 	return os.MkdirAll(path, 0766)
 }
@@ -44,7 +56,7 @@ func (s *RefStore) Ls() (map[string]retro.Hash, error) {
 			return nil, err
 		}
 		switch mode := fi.Mode(); {
-		case mode.IsRegular():
+		case mode.IsRegular() && !strings.HasSuffix(file, ".lock"):
 			contents, err := ioutil.ReadFile(file)
 			if err != nil {
 				return nil, err // TODO: wrap me
@@ -55,58 +67,40 @@ func (s *RefStore) Ls() (map[string]retro.Hash, error) {
 	return hashes, nil
 }
 
-func (s *RefStore) Write(name string, hash retro.Hash) (bool, error) {
-
-	// TODO: What if basepath points to a _file_ not a dir?
-	if _, err := os.Stat(s.BasePath); os.IsNotExist(err) {
-		if err := s.mkdirAll(s.BasePath); err != nil {
-			return false, ErrUnableToCreateBaseDir
+// lockTimeout is how long we wait for another writer to release the
+// lock on a ref before giving up with storage.ErrRefLocked.
+const lockTimeout = 2 * time.Second
+
+// lock takes the lock on the ref at refPath by exclusively creating a
+// sibling ".lock" file. The new contents of the ref are written to the lock
+// file and renamed over the ref to commit them, see writeRef.
+func (s *RefStore) lock(refPath string) (*os.File, error) {
+	var deadline = time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(refPath+".lock", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if err == nil {
+			return f, nil
 		}
-	}
-
-	var (
-		refPath = filepath.Join(s.BasePath, name)
-		refDir  = filepath.Dir(refPath)
-	)
-
-	var writeRefFile = func() error {
-		f, err := os.Create(refPath)
-		if err != nil {
-			return ErrUnableToCreateRefFile
+		if !os.IsExist(err) {
+			return nil, ErrUnableToCreateRefFile
 		}
-		defer f.Close()
-		n, err := f.WriteString(hash.String())
-		if err != nil {
-			return ErrUnableToWriteRef
+		if time.Now().After(deadline) {
+			return nil, storage.ErrRefLocked
 		}
-
-		if n != len(hash.String()) {
-			return ErrUnableToCompletelyWriteRef
-		}
-
-		return nil
+		time.Sleep(5 * time.Millisecond)
 	}
+}
 
-	if _, err := os.Stat(refPath); os.IsNotExist(err) {
-		if err := s.mkdirAll(refDir); err != nil {
-			return false, ErrUnableToCreateRefDir
-		}
-		return true, writeRefFile()
-	}
+// writeRef takes the lock for name, reads the existing contents and hands
+// them to decide which returns whether the ref should be (over)written with
+// contents. Reading, deciding and writing happen whilst holding the lock so
+// concurrent writers (even in other processes) can't interleave.
+func (s *RefStore) writeRef(name, contents string, decide func(existing []byte, exists bool) (bool, error)) (bool, error) {
 
-	// Check if we have to write the file, read it first
-	fileData, err := ioutil.ReadFile(refPath)
+	refPath, err := s.refPath(name)
 	if err != nil {
-		return false, ErrUnableToReadRefFile
+		return false, err
 	}
-	if string(fileData) != hash.String() {
-		return true, writeRefFile()
-	}
-	return false, nil
-
-}
-
-func (s *RefStore) WriteSymbolic(name, ref string) (bool, error) {
 
 	// TODO: What if basepath points to a _file_ not a dir?
 	if _, err := os.Stat(s.BasePath); os.IsNotExist(err) {
@@ -115,53 +109,86 @@ func (s *RefStore) WriteSymbolic(name, ref string) (bool, error) {
 		}
 	}
 
-	var (
-		symRefPath = filepath.Join(s.BasePath, name)
-		symRefDir  = filepath.Dir(symRefPath)
-	)
-
-	symRefContents := fmt.Sprintf("ref: %s", ref)
-
-	var writeRefFile = func() error {
-		f, err := os.Create(symRefPath)
-		if err != nil {
-			return ErrUnableToCreateRefFile
-		}
-		defer f.Close()
+	if err := s.mkdirAll(filepath.Dir(refPath)); err != nil {
+		return false, ErrUnableToCreateRefDir
+	}
 
-		n, err := f.WriteString(symRefContents)
-		if err != nil {
-			return ErrUnableToWriteRef
-		}
+	lockFile, err := s.lock(refPath)
+	if err != nil {
+		return false, err
+	}
 
-		if n != len(ref)+5 {
-			return ErrUnableToCompletelyWriteRef
+	var committed bool
+	defer func() {
+		if !committed {
+			lockFile.Close()
+			os.Remove(lockFile.Name())
 		}
+	}()
 
-		return nil
+	existing, err := ioutil.ReadFile(refPath)
+	if err != nil && !os.IsNotExist(err) {
+		return false, ErrUnableToReadRefFile
 	}
 
-	if _, err := os.Stat(symRefPath); os.IsNotExist(err) {
-		if err := s.mkdirAll(symRefDir); err != nil {
-			return false, ErrUnableToCreateRefDir
-		}
-		return true, writeRefFile()
+	write, err := decide(existing, err == nil)
+	if err != nil || !write {
+		return false, err
 	}
 
-	// Check if we have to write the file, read it first
-	fileData, err := ioutil.ReadFile(symRefPath)
+	n, err := lockFile.WriteString(contents)
 	if err != nil {
-		return false, ErrUnableToReadRefFile
+		return false, ErrUnableToWriteRef
 	}
-	if string(fileData) != symRefContents {
-		return true, writeRefFile()
+	if n != len(contents) {
+		return false, ErrUnableToCompletelyWriteRef
 	}
-	return false, nil
+	if err := lockFile.Close(); err != nil {
+		return false, ErrUnableToWriteRef
+	}
+	if err := os.Rename(lockFile.Name(), refPath); err != nil {
+		return false, ErrUnableToWriteRef
+	}
+	committed = true
+
+	return true, nil
+}
+
+func (s *RefStore) Write(name string, hash retro.Hash) (bool, error) {
+	return s.writeRef(name, hash.String(), func(existing []byte, exists bool) (bool, error) {
+		return !exists || string(existing) != hash.String(), nil
+	})
+}
+
+// CompareAndSwap moves the ref from old to new, the ref is locked with a
+// lockfile for the duration of the comparison and the new value is renamed
+// into place.
+func (s *RefStore) CompareAndSwap(name string, old, new retro.Hash) error {
+	_, err := s.writeRef(name, new.String(), func(existing []byte, exists bool) (bool, error) {
+		if old == nil && exists {
+			return false, storage.ErrRefChanged
+		}
+		if old != nil && (!exists || string(existing) != old.String()) {
+			return false, storage.ErrRefChanged
+		}
+		return true, nil
+	})
+	return err
+}
+
+func (s *RefStore) WriteSymbolic(name, ref string) (bool, error) {
+	var symRefContents = fmt.Sprintf("ref: %s", ref)
+	return s.writeRef(name, symRefContents, func(existing []byte, exists bool) (bool, error) {
+		return !exists || string(existing) != symRefContents, nil
+	})
 }
 
 func (s *RefStore) Retrieve(name string) (retro.Hash, error) {
 
-	var refPath = filepath.Join(s.BasePath, name)
+	refPath, err := s.refPath(name)
+	if err != nil {
+		return nil, err
+	}
 
 	if _, err := os.Stat(refPath); os.IsNotExist(err) {
 		return nil, storage.ErrUnknownRef
@@ -188,7 +215,10 @@ func (s *RefStore) Retrieve(name string) (retro.Hash, error) {
 
 func (s *RefStore) RetrieveSymbolic(name string) (string, error) {
 
-	var symRefPath = filepath.Join(s.BasePath, name)
+	symRefPath, err := s.refPath(name)
+	if err != nil {
+		return "", err
+	}
 
 	if _, err := os.Stat(symRefPath); os.IsNotExist(err) {
 		return "", storage.ErrUnknownRef
@@ -0,0 +1,52 @@
+package memory
+
+import (
+	"crypto/rand"
+	"sync"
+
+	"github.com/retro-framework/go-retro/framework/retro"
+)
+
+// KeyStore holds the keys of partitions in memory, the keys of erased
+// partitions are kept as nil.
+type KeyStore struct {
+	sync.Mutex
+	k map[retro.PartitionName][]byte
+}
+
+func (ks *KeyStore) Key(pn retro.PartitionName, create bool) ([]byte, error) {
+	ks.Lock()
+	defer ks.Unlock()
+
+	if ks.k == nil {
+		ks.k = make(map[retro.PartitionName][]byte)
+	}
+
+	key, known := ks.k[pn]
+	switch {
+	case known && key == nil:
+		return nil, retro.ErasedError{Partition: pn}
+	case known:
+		return key, nil
+	case !create:
+		return nil, retro.ErasedError{Partition: pn}
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	ks.k[pn] = key
+	return key, nil
+}
+
+func (ks *KeyStore) Delete(pn retro.PartitionName) error {
+	ks.Lock()
+	defer ks.Unlock()
+
+	if ks.k == nil {
+		ks.k = make(map[retro.PartitionName][]byte)
+	}
+	ks.k[pn] = nil
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"compress/zlib"
 	"errors"
+	"io"
 	"io/ioutil"
 	"sync"
 
@@ -14,6 +15,7 @@ import (
 var (
 	ErrNoSuchObject          = errors.New("no such object in object database")
 	ErrUnableToInflateObject = errors.New("error running zlib inflate")
+	ErrNotABlob              = errors.New("object is not a blob")
 )
 
 type ObjectStore struct {
@@ -81,3 +83,48 @@ func (os *ObjectStore) RetrievePacked(s string) (retro.HashedObject, error) {
 	}
 	return nil, ErrNoSuchObject
 }
+
+// WriteBlob reads the whole blob from r, the memory store holds it in
+// memory regardless.
+func (os *ObjectStore) WriteBlob(r io.Reader) (retro.BlobRef, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return retro.BlobRef{}, err
+	}
+	var pb = packing.PackBlob(b)
+	if _, err := os.WritePacked(pb); err != nil {
+		return retro.BlobRef{}, err
+	}
+	return retro.BlobRef{Hash: pb.Hash().String(), Size: int64(len(b))}, nil
+}
+
+func (os *ObjectStore) OpenBlob(s string) (io.ReadCloser, int64, error) {
+	po, err := os.RetrievePacked(s)
+	if err != nil {
+		return nil, 0, err
+	}
+	if po.Type() != packing.ObjectTypeBlob {
+		return nil, 0, ErrNotABlob
+	}
+	b, err := packing.UnpackBlob(po.Contents())
+	if err != nil {
+		return nil, 0, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), int64(len(b)), nil
+}
+
+// DeleteBlob deletes the blob, deleting any other type of object fails with
+// ErrNotABlob.
+func (os *ObjectStore) DeleteBlob(s string) error {
+	po, err := os.RetrievePacked(s)
+	if err != nil {
+		return err
+	}
+	if po.Type() != packing.ObjectTypeBlob {
+		return ErrNotABlob
+	}
+	os.Lock()
+	defer os.Unlock()
+	delete(os.o, s)
+	return nil
+}
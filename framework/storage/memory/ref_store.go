@@ -1,25 +1,40 @@
 package memory
 
 import (
+	"sync"
+
 	"github.com/retro-framework/go-retro/framework/retro"
 	"github.com/retro-framework/go-retro/framework/storage"
 )
 
 // RefStore is used for storing references, references such as
 // refs/heads/master (branch) or HEAD (symbolic) or refs/wurtzel/booger for
-// arbitrary checkpoints.
+// arbitrary checkpoints. Like the filesystem store it refuses to write
+// refs whose names are not valid (see storage.ValidRefName).
 type RefStore struct {
+	sync.RWMutex
 	r map[string]retro.Hash
 	s map[string]string
 }
 
 func (r *RefStore) Ls() (map[string]retro.Hash, error) {
-	return r.r, nil
+	r.RLock()
+	defer r.RUnlock()
+	var res = make(map[string]retro.Hash, len(r.r))
+	for k, v := range r.r {
+		res[k] = v
+	}
+	return res, nil
 }
 
 // Write ref returns a boolean indicating whether the ref was changed
 // or not, and errors incase of malformation, and misc problems.
 func (r *RefStore) Write(name string, newRef retro.Hash) (bool, error) {
+	if err := storage.ValidRefName(name); err != nil {
+		return false, err
+	}
+	r.Lock()
+	defer r.Unlock()
 	if r.r == nil {
 		r.r = make(map[string]retro.Hash)
 	}
@@ -32,7 +47,34 @@ func (r *RefStore) Write(name string, newRef retro.Hash) (bool, error) {
 	return true, nil
 }
 
+// CompareAndSwap moves the ref from old to new, holding the write lock for
+// the duration of the comparison and the write.
+func (r *RefStore) CompareAndSwap(name string, old, new retro.Hash) error {
+	if err := storage.ValidRefName(name); err != nil {
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.r == nil {
+		r.r = make(map[string]retro.Hash)
+	}
+	existingRef, exists := r.r[name]
+	if old == nil && exists {
+		return storage.ErrRefChanged
+	}
+	if old != nil && (!exists || existingRef.String() != old.String()) {
+		return storage.ErrRefChanged
+	}
+	r.r[name] = new
+	return nil
+}
+
 func (r *RefStore) WriteSymbolic(name string, ref string) (bool, error) {
+	if err := storage.ValidRefName(name); err != nil {
+		return false, err
+	}
+	r.Lock()
+	defer r.Unlock()
 	if r.s == nil {
 		r.s = make(map[string]string)
 	}
@@ -46,6 +88,8 @@ func (r *RefStore) WriteSymbolic(name string, ref string) (bool, error) {
 }
 
 func (r *RefStore) Retrieve(name string) (retro.Hash, error) {
+	r.RLock()
+	defer r.RUnlock()
 	if existingRef, exists := r.r[name]; exists {
 		return existingRef, nil
 	}
@@ -53,6 +97,8 @@ func (r *RefStore) Retrieve(name string) (retro.Hash, error) {
 }
 
 func (r *RefStore) RetrieveSymbolic(name string) (string, error) {
+	r.RLock()
+	defer r.RUnlock()
 	if existingRef, exists := r.s[name]; exists {
 		return existingRef, nil
 	}
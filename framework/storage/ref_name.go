@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// ValidRefName returns an error matching ErrInvalidRefName if name can't
+// be used as the name of a ref. Ref names are slash separated paths
+// (refs/heads/master) and the filesystem ref store writes them as such, so
+// names which could escape its directory or clash with its lock files are
+// refused: names which are empty, start with a slash, have empty, "." or
+// ".." parts, contain control characters, or have a part ending in
+// ".lock".
+func ValidRefName(name string) error {
+	if name == "" {
+		return xerrors.Errorf("storage: empty ref name: %w", ErrInvalidRefName)
+	}
+	if strings.HasPrefix(name, "/") {
+		return xerrors.Errorf("storage: ref name %q starts with a slash: %w", name, ErrInvalidRefName)
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return xerrors.Errorf("storage: ref name %q contains control characters: %w", name, ErrInvalidRefName)
+		}
+	}
+	for _, part := range strings.Split(name, "/") {
+		switch {
+		case part == "", part == ".", part == "..":
+			return xerrors.Errorf("storage: ref name %q has an empty, \".\" or \"..\" part: %w", name, ErrInvalidRefName)
+		case strings.HasSuffix(part, ".lock"):
+			return xerrors.Errorf("storage: ref name %q has a part ending in .lock: %w", name, ErrInvalidRefName)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+// +build unit
+
+package storage
+
+import (
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+func Test_ValidRefName(t *testing.T) {
+
+	for _, name := range []string{"HEAD", "refs/heads/master", "refs/heads/feature/login", "refs/heads/v1.2"} {
+		if err := ValidRefName(name); err != nil {
+			t.Errorf("expected %q to be valid, got %s", name, err)
+		}
+	}
+
+	for _, name := range []string{
+		"",
+		"/refs/heads/master",
+		"refs/heads/../../etc/x",
+		"refs/heads/..",
+		"refs/./heads",
+		"refs//heads",
+		"refs/heads/",
+		"refs/heads/x.lock",
+		"refs/heads.lock/x",
+		"refs/heads/x\x00",
+		"refs/heads/x\ny",
+		"refs/heads/x\x7f",
+	} {
+		if err := ValidRefName(name); !xerrors.Is(err, ErrInvalidRefName) {
+			t.Errorf("expected %q to be invalid, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/retro-framework/go-retro/framework/packing"
+	"github.com/retro-framework/go-retro/framework/retro"
+	"golang.org/x/xerrors"
+)
+
+// ErrUnverified is matched by UnverifiedError.
+var ErrUnverified = xerrors.New("storage: checkpoint does not verify")
+
+// UnverifiedError reports a checkpoint whose signature could not be
+// verified, Err is the reason (e.g packing.ErrUnsigned). It matches
+// ErrUnverified and Err with xerrors.Is.
+type UnverifiedError struct {
+	Checkpoint retro.Hash
+	Err        error
+}
+
+func (e UnverifiedError) Error() string {
+	return fmt.Sprintf("storage: checkpoint %s does not verify: %s", e.Checkpoint, e.Err)
+}
+
+func (e UnverifiedError) Is(target error) bool {
+	return target == ErrUnverified
+}
+
+func (e UnverifiedError) Unwrap() error {
+	return e.Err
+}
+
+// Verify verifies the signature of each checkpoint reachable from head but
+// not from since (see Exclusive, since may be nil to verify all of head's
+// history) with v. Checkpoints which don't verify are reported, newest
+// first, the error is only set if the object graph can't be walked.
+func (w Walker) Verify(head, since retro.Hash, v *packing.Verifier) ([]UnverifiedError, error) {
+	exclusive, err := w.exclusive(head, since, false)
+	if err != nil {
+		return nil, err
+	}
+	var unverified []UnverifiedError
+	for _, wc := range exclusive {
+		packedCheckpoint, err := w.objdb.RetrievePacked(wc.CheckpointHash.String())
+		if err != nil {
+			return nil, xerrors.Errorf("storage: can't retrieve checkpoint %s: %w", wc.CheckpointHash, err)
+		}
+		if err := v.Verify(packedCheckpoint); err != nil {
+			unverified = append(unverified, UnverifiedError{Checkpoint: wc.CheckpointHash, Err: err})
+		}
+	}
+	return unverified, nil
+}
@@ -0,0 +1,71 @@
+// +build unit
+
+package storage
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/retro-framework/go-retro/framework/packing"
+	"github.com/retro-framework/go-retro/framework/retro"
+	test "github.com/retro-framework/go-retro/framework/test_helper"
+	"golang.org/x/xerrors"
+)
+
+func Test_Walker_Verify(t *testing.T) {
+
+	var (
+		pub, key, _     = ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte{1}, 32)))
+		_, untrusted, _ = ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte{2}, 32)))
+
+		objdb = objects{}
+	)
+
+	var checkpoint = func(t *testing.T, p packing.Packer, parents ...retro.HashedObject) retro.HashedObject {
+		packedAffix, err := p.PackAffix(packing.Affix{})
+		test.H(t).IsNil(err)
+		var parentHashes []retro.Hash
+		for _, p := range parents {
+			parentHashes = append(parentHashes, p.Hash())
+		}
+		packedCheckpoint, err := p.PackCheckpoint(packing.Checkpoint{
+			AffixHash:    packedAffix.Hash(),
+			Fields:       map[string]string{"date": "2019-02-01T00:00:00Z"},
+			ParentHashes: parentHashes,
+		})
+		test.H(t).IsNil(err)
+		objdb[packedAffix.Hash().String()] = packedAffix
+		objdb[packedCheckpoint.Hash().String()] = packedCheckpoint
+		return packedCheckpoint
+	}
+
+	var (
+		root   = checkpoint(t, packing.NewJSONPacker())
+		signed = checkpoint(t, packing.NewSigningPacker(packing.NewJSONPacker(), key), root)
+		forged = checkpoint(t, packing.NewSigningPacker(packing.NewJSONPacker(), untrusted), signed)
+		tip    = checkpoint(t, packing.NewSigningPacker(packing.NewJSONPacker(), key), forged)
+	)
+
+	t.Run("reports unsigned and badly signed checkpoints newest first", func(t *testing.T) {
+		unverified, err := NewWalker(objdb).Verify(tip.Hash(), nil, packing.NewVerifier(pub))
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(len(unverified), 2)
+		test.H(t).StringEql(unverified[0].Checkpoint.String(), forged.Hash().String())
+		test.H(t).BoolEql(xerrors.Is(unverified[0], packing.ErrUnknownSigner), true)
+		test.H(t).StringEql(unverified[1].Checkpoint.String(), root.Hash().String())
+		test.H(t).BoolEql(xerrors.Is(unverified[1], packing.ErrUnsigned), true)
+		test.H(t).BoolEql(xerrors.Is(unverified[1], ErrUnverified), true)
+	})
+
+	t.Run("skips checkpoints reachable from since", func(t *testing.T) {
+		unverified, err := NewWalker(objdb).Verify(tip.Hash(), forged.Hash(), packing.NewVerifier(pub))
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(len(unverified), 0)
+
+		unverified, err = NewWalker(objdb).Verify(tip.Hash(), signed.Hash(), packing.NewVerifier(pub))
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(len(unverified), 1)
+		test.H(t).StringEql(unverified[0].Checkpoint.String(), forged.Hash().String())
+	})
+}
@@ -0,0 +1,406 @@
+package storage
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/retro-framework/go-retro/framework/packing"
+	"github.com/retro-framework/go-retro/framework/retro"
+	"golang.org/x/xerrors"
+)
+
+// ErrStopWalk can be returned from a WalkFunc to end the walk early, Walk
+// returns nil in that case.
+var ErrStopWalk = xerrors.New("storage: stop walk")
+
+// WalkedCheckpoint is a checkpoint visited whilst walking the object graph
+// together with its affix and parents.
+type WalkedCheckpoint struct {
+	RelevantCheckpoint
+	Parents []retro.Hash
+}
+
+// WalkFunc is called once for every checkpoint visited.
+type WalkFunc func(WalkedCheckpoint) error
+
+// objectSource is object.Source, which can't be imported here as the
+// object package's tests import the storage backends.
+type objectSource interface {
+	RetrievePacked(string) (retro.HashedObject, error)
+}
+
+// Walker walks the DAG of checkpoints in an object store. It walks
+// iteratively, so arbitrarily long histories can be walked, and visits
+// every checkpoint exactly once however many paths lead to it.
+type Walker struct {
+	objdb  objectSource
+	packer packing.Packer
+}
+
+// WalkerOption configures a Walker, see NewWalker.
+type WalkerOption func(*Walker)
+
+// WithPacker makes the Walker unpack checkpoints and affixes with p, the
+// default is a packing.JSONPacker.
+func WithPacker(p packing.Packer) WalkerOption {
+	return func(w *Walker) {
+		w.packer = p
+	}
+}
+
+// NewWalker returns a Walker reading checkpoints and affixes from objdb.
+func NewWalker(objdb objectSource, opts ...WalkerOption) Walker {
+	var w = Walker{objdb: objdb, packer: packing.NewJSONPacker()}
+	for _, opt := range opts {
+		opt(&w)
+	}
+	return w
+}
+
+// Walk visits head and its ancestors breadth first, i.e roughly newest
+// first, parents in the order they are recorded in the checkpoint. The
+// checkpoints in except are neither visited nor walked through, which
+// allows walking only what was added since an earlier head.
+//
+// Errors reading the object graph and errors returned from fn (except
+// ErrStopWalk) end the walk and are returned, wrapped with xerrors.
+func (w Walker) Walk(head retro.Hash, except map[string]bool, fn WalkFunc) error {
+	err := w.walk(head, except, true, fn)
+	if xerrors.Is(err, ErrStopWalk) {
+		return nil
+	}
+	return err
+}
+
+// Ordered returns head and its ancestors (excluding those in except)
+// ordered topologically, oldest first. A checkpoint is never returned
+// before any of its parents, ties are broken with the date field of the
+// checkpoints and then their hashes so that merged histories are ordered
+// the same way every time.
+func (w Walker) Ordered(head retro.Hash, except map[string]bool) ([]WalkedCheckpoint, error) {
+	var walked []WalkedCheckpoint
+	err := w.walk(head, except, true, func(wc WalkedCheckpoint) error {
+		walked = append(walked, wc)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return topological(walked), nil
+}
+
+// Ancestors returns the set of checkpoints reachable from head, including
+// head itself. Affixes are not read.
+func (w Walker) Ancestors(head retro.Hash) (map[string]bool, error) {
+	var res = make(map[string]bool)
+	err := w.walk(head, nil, false, func(wc WalkedCheckpoint) error {
+		res[wc.CheckpointHash.String()] = true
+		return nil
+	})
+	return res, err
+}
+
+// IsAncestor returns true if ancestor is reachable from descendant, a
+// checkpoint is considered to be its own ancestor. Affixes are not read.
+func (w Walker) IsAncestor(ancestor, descendant retro.Hash) (bool, error) {
+	var found bool
+	err := w.walk(descendant, nil, false, func(wc WalkedCheckpoint) error {
+		if wc.CheckpointHash.String() == ancestor.String() {
+			found = true
+			return ErrStopWalk
+		}
+		return nil
+	})
+	if xerrors.Is(err, ErrStopWalk) {
+		err = nil
+	}
+	return found, err
+}
+
+// Exclusive returns the checkpoints reachable from head but not from
+// since, newest first, with their affixes. If since is nil every ancestor
+// of head is returned.
+//
+// Unlike walking head with the Ancestors of since excepted, the history
+// shared by both is only walked until it is certain nothing older can be
+// reachable from head alone, which relies on checkpoints being younger
+// than their parents. Checkpoints dated before one of their parents (e.g
+// through clock skew) may cause checkpoints reachable from both to be
+// returned, never the reverse.
+func (w Walker) Exclusive(head, since retro.Hash) ([]WalkedCheckpoint, error) {
+	return w.exclusive(head, since, true)
+}
+
+func (w Walker) exclusive(head, since retro.Hash, withAffix bool) ([]WalkedCheckpoint, error) {
+
+	if since == nil {
+		var res []WalkedCheckpoint
+		err := w.walk(head, nil, withAffix, func(wc WalkedCheckpoint) error {
+			res = append(res, wc)
+			return nil
+		})
+		return res, err
+	}
+
+	const (
+		fromHead = 1 << iota
+		fromSince
+		fromBoth = fromHead | fromSince
+	)
+
+	var (
+		nodes = make(map[string]*paintedCheckpoint)
+		queue = &paintedHeap{}
+		order []*paintedCheckpoint
+	)
+
+	var paint = func(h retro.Hash, flags int) error {
+		n, found := nodes[h.String()]
+		if !found {
+			wc, affixHash, err := w.read(h)
+			if err != nil {
+				return err
+			}
+			n = &paintedCheckpoint{WalkedCheckpoint: wc, affixHash: affixHash}
+			nodes[h.String()] = n
+		} else if n.flags&flags == flags {
+			return nil
+		}
+		n.flags |= flags
+		heap.Push(queue, n)
+		return nil
+	}
+
+	var stale = func() bool {
+		for _, n := range *queue {
+			if n.flags != fromBoth {
+				return false
+			}
+		}
+		return true
+	}
+
+	if err := paint(head, fromHead); err != nil {
+		return nil, err
+	}
+	if err := paint(since, fromSince); err != nil {
+		return nil, err
+	}
+
+	for queue.Len() > 0 && !stale() {
+		var n = heap.Pop(queue).(*paintedCheckpoint)
+		if !n.walked {
+			n.walked = true
+			order = append(order, n)
+		}
+		for _, p := range n.Parents {
+			if err := paint(p, n.flags); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var res []WalkedCheckpoint
+	for _, n := range order {
+		if n.flags != fromHead {
+			continue
+		}
+		if withAffix {
+			var err error
+			if n.Affix, err = w.readAffix(n.CheckpointHash, n.affixHash); err != nil {
+				return nil, err
+			}
+		}
+		res = append(res, n.WalkedCheckpoint)
+	}
+	return res, nil
+}
+
+func (w Walker) walk(head retro.Hash, except map[string]bool, withAffix bool, fn WalkFunc) error {
+
+	var (
+		queue   = []retro.Hash{head}
+		visited = make(map[string]bool)
+	)
+
+	for len(queue) > 0 {
+		var h = queue[0]
+		queue = queue[1:]
+
+		if visited[h.String()] || except[h.String()] {
+			continue
+		}
+		visited[h.String()] = true
+
+		wc, affixHash, err := w.read(h)
+		if err != nil {
+			return err
+		}
+
+		if withAffix {
+			if wc.Affix, err = w.readAffix(h, affixHash); err != nil {
+				return err
+			}
+		}
+
+		if err := fn(wc); err != nil {
+			return err
+		}
+
+		queue = append(queue, wc.Parents...)
+	}
+
+	return nil
+}
+
+// read reads the checkpoint h, returning it without its affix, and the
+// hash of its affix.
+func (w Walker) read(h retro.Hash) (WalkedCheckpoint, retro.Hash, error) {
+	packedCheckpoint, err := w.objdb.RetrievePacked(h.String())
+	if err != nil {
+		return WalkedCheckpoint{}, nil, xerrors.Errorf("storage: can't retrieve checkpoint %s: %w", h, err)
+	}
+	if packedCheckpoint.Type() != packing.ObjectTypeCheckpoint {
+		return WalkedCheckpoint{}, nil, xerrors.Errorf("storage: object %s is a %s: %w", h, packedCheckpoint.Type(), ErrNotACheckpoint)
+	}
+	checkpoint, err := w.packer.UnpackCheckpoint(packedCheckpoint.Contents())
+	if err != nil {
+		return WalkedCheckpoint{}, nil, xerrors.Errorf("storage: can't unpack checkpoint %s: %w", h, err)
+	}
+	t, err := CheckpointTime(checkpoint.Fields)
+	if err != nil {
+		return WalkedCheckpoint{}, nil, xerrors.Errorf("storage: parsing date of checkpoint %s as rfc3339: %w", h, err)
+	}
+	return WalkedCheckpoint{
+		RelevantCheckpoint: RelevantCheckpoint{Time: t, CheckpointHash: h},
+		Parents:            checkpoint.ParentHashes,
+	}, checkpoint.AffixHash, nil
+}
+
+func (w Walker) readAffix(h, affixHash retro.Hash) (packing.Affix, error) {
+	packedAffix, err := w.objdb.RetrievePacked(affixHash.String())
+	if err != nil {
+		return nil, xerrors.Errorf("storage: can't retrieve affix %s for checkpoint %s: %w", affixHash, h, err)
+	}
+	if packedAffix.Type() != packing.ObjectTypeAffix {
+		return nil, xerrors.Errorf("storage: object %s is a %s: %w", affixHash, packedAffix.Type(), ErrNotAnAffix)
+	}
+	affix, err := w.packer.UnpackAffix(packedAffix.Contents())
+	if err != nil {
+		return nil, xerrors.Errorf("storage: can't unpack affix %s for checkpoint %s: %w", affixHash, h, err)
+	}
+	return affix, nil
+}
+
+// topological orders walked checkpoints parents first, parents which were
+// not walked (e.g because they were excepted) are ignored.
+func topological(walked []WalkedCheckpoint) []WalkedCheckpoint {
+
+	var (
+		byHash   = make(map[string]WalkedCheckpoint, len(walked))
+		pending  = make(map[string]int, len(walked))
+		children = make(map[string][]string, len(walked))
+		ready    = &walkedHeap{}
+		ordered  = make([]WalkedCheckpoint, 0, len(walked))
+	)
+
+	for _, wc := range walked {
+		byHash[wc.CheckpointHash.String()] = wc
+	}
+
+	for k, wc := range byHash {
+		for _, p := range wc.Parents {
+			if _, known := byHash[p.String()]; known {
+				pending[k]++
+				children[p.String()] = append(children[p.String()], k)
+			}
+		}
+		if pending[k] == 0 {
+			heap.Push(ready, wc)
+		}
+	}
+
+	for ready.Len() > 0 {
+		var wc = heap.Pop(ready).(WalkedCheckpoint)
+		ordered = append(ordered, wc)
+		for _, c := range children[wc.CheckpointHash.String()] {
+			pending[c]--
+			if pending[c] == 0 {
+				heap.Push(ready, byHash[c])
+			}
+		}
+	}
+
+	return ordered
+}
+
+// NewAffixStack returns an AffixStack of the checkpoints (oldest first)
+// which pops the oldest checkpoint first.
+func NewAffixStack(ordered []RelevantCheckpoint) AffixStack {
+	var st AffixStack
+	for i := len(ordered) - 1; i >= 0; i-- {
+		st.Push(ordered[i])
+	}
+	return st
+}
+
+// CheckpointTime parses the date field of a checkpoint, checkpoints without
+// a date field yield the zero time. Such checkpoints sort before any dated
+// one, readers pinned to a time must not treat them as old (they are not
+// visible at any time).
+func CheckpointTime(fields map[string]string) (time.Time, error) {
+	dateStr, ok := fields["date"]
+	if !ok {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, dateStr)
+}
+
+type walkedHeap []WalkedCheckpoint
+
+func (h walkedHeap) Len() int { return len(h) }
+func (h walkedHeap) Less(i, j int) bool {
+	if !h[i].Time.Equal(h[j].Time) {
+		return h[i].Time.Before(h[j].Time)
+	}
+	return h[i].CheckpointHash.String() < h[j].CheckpointHash.String()
+}
+func (h walkedHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *walkedHeap) Push(x interface{}) { *h = append(*h, x.(WalkedCheckpoint)) }
+func (h *walkedHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
+
+// paintedCheckpoint is a checkpoint visited by Exclusive, flags records
+// which of the two heads it is known to be reachable from.
+type paintedCheckpoint struct {
+	WalkedCheckpoint
+	affixHash retro.Hash
+	flags     int
+	walked    bool
+}
+
+// paintedHeap pops the newest checkpoint first, a checkpoint is queued
+// again whenever it is painted with more flags.
+type paintedHeap []*paintedCheckpoint
+
+func (h paintedHeap) Len() int { return len(h) }
+func (h paintedHeap) Less(i, j int) bool {
+	if !h[i].Time.Equal(h[j].Time) {
+		return h[i].Time.After(h[j].Time)
+	}
+	return h[i].CheckpointHash.String() > h[j].CheckpointHash.String()
+}
+func (h paintedHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *paintedHeap) Push(x interface{}) { *h = append(*h, x.(*paintedCheckpoint)) }
+func (h *paintedHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
@@ -0,0 +1,242 @@
+// +build unit
+
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/retro-framework/go-retro/framework/packing"
+	"github.com/retro-framework/go-retro/framework/retro"
+	test "github.com/retro-framework/go-retro/framework/test_helper"
+	"golang.org/x/xerrors"
+)
+
+// objects is the smallest possible object.Source, storage can't import
+// the memory store.
+type objects map[string]retro.HashedObject
+
+var errNotFound = xerrors.New("not found")
+
+func (o objects) RetrievePacked(h string) (retro.HashedObject, error) {
+	if obj, ok := o[h]; ok {
+		return obj, nil
+	}
+	return nil, errNotFound
+}
+
+// countingObjects counts how often each object is retrieved.
+type countingObjects struct {
+	objects
+	retrieved map[string]int
+}
+
+func (o countingObjects) RetrievePacked(h string) (retro.HashedObject, error) {
+	o.retrieved[h]++
+	return o.objects.RetrievePacked(h)
+}
+
+// countingPacker counts the checkpoints it unpacks.
+type countingPacker struct {
+	packing.Packer
+	unpacked *int
+}
+
+func (p countingPacker) UnpackCheckpoint(b []byte) (packing.Checkpoint, error) {
+	*p.unpacked++
+	return p.Packer.UnpackCheckpoint(b)
+}
+
+func Test_Walker(t *testing.T) {
+
+	var (
+		jp    = packing.NewJSONPacker()
+		objdb = objects{}
+		start = time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC)
+	)
+
+	var checkpoint = func(t *testing.T, seconds int, pn retro.PartitionName, parents ...retro.HashedObject) retro.HashedObject {
+		// the event itself is never read
+		ev, err := jp.PackEvent("ev", struct{}{})
+		test.H(t).IsNil(err)
+		packedAffix, err := jp.PackAffix(packing.Affix{pn: {ev.Hash()}})
+		test.H(t).IsNil(err)
+		var parentHashes []retro.Hash
+		for _, p := range parents {
+			parentHashes = append(parentHashes, p.Hash())
+		}
+		packedCheckpoint, err := jp.PackCheckpoint(packing.Checkpoint{
+			AffixHash:    packedAffix.Hash(),
+			CommandDesc:  []byte(fmt.Sprintf(`{"partition":%q}`, pn)),
+			Fields:       map[string]string{"date": start.Add(time.Duration(seconds) * time.Second).Format(time.RFC3339)},
+			ParentHashes: parentHashes,
+		})
+		test.H(t).IsNil(err)
+		objdb[packedAffix.Hash().String()] = packedAffix
+		objdb[packedCheckpoint.Hash().String()] = packedCheckpoint
+		return packedCheckpoint
+	}
+
+	var hashes = func(objs ...retro.HashedObject) []string {
+		var res []string
+		for _, o := range objs {
+			res = append(res, o.Hash().String())
+		}
+		return res
+	}
+
+	var walkedHashes = func(walked []WalkedCheckpoint) []string {
+		var res []string
+		for _, wc := range walked {
+			res = append(res, wc.CheckpointHash.String())
+		}
+		return res
+	}
+
+	// root is followed by a diamond, left is dated after right but right
+	// is the second parent of the merge.
+	var (
+		root  = checkpoint(t, 0, "root/1")
+		left  = checkpoint(t, 2, "left/1", root)
+		right = checkpoint(t, 1, "right/1", root)
+		merge = checkpoint(t, 3, "merge/1", left, right)
+		tip   = checkpoint(t, 4, "tip/1", merge)
+	)
+
+	t.Run("visits every checkpoint once", func(t *testing.T) {
+		var (
+			counting = countingObjects{objdb, make(map[string]int)}
+			visited  []string
+		)
+		err := NewWalker(counting).Walk(tip.Hash(), nil, func(wc WalkedCheckpoint) error {
+			visited = append(visited, wc.CheckpointHash.String())
+			return nil
+		})
+		test.H(t).IsNil(err)
+		if diff := cmp.Diff(visited, hashes(tip, merge, left, right, root)); diff != "" {
+			t.Errorf("visited checkpoints differ: (-got +want)\n%s", diff)
+		}
+		test.H(t).IntEql(counting.retrieved[root.Hash().String()], 1)
+	})
+
+	t.Run("orders topologically using the date as tie breaker", func(t *testing.T) {
+		walked, err := NewWalker(objdb).Ordered(tip.Hash(), nil)
+		test.H(t).IsNil(err)
+		if diff := cmp.Diff(walkedHashes(walked), hashes(root, right, left, merge, tip)); diff != "" {
+			t.Errorf("ordered checkpoints differ: (-got +want)\n%s", diff)
+		}
+		if _, ok := walked[0].Affix["root/1"]; !ok {
+			t.Errorf("expected affix of root to be read, got %v", walked[0].Affix)
+		}
+	})
+
+	t.Run("does not walk through excepted checkpoints", func(t *testing.T) {
+		walked, err := NewWalker(objdb).Ordered(tip.Hash(), map[string]bool{left.Hash().String(): true})
+		test.H(t).IsNil(err)
+		if diff := cmp.Diff(walkedHashes(walked), hashes(root, right, merge, tip)); diff != "" {
+			t.Errorf("ordered checkpoints differ: (-got +want)\n%s", diff)
+		}
+	})
+
+	t.Run("stops early", func(t *testing.T) {
+		var visited int
+		err := NewWalker(objdb).Walk(tip.Hash(), nil, func(wc WalkedCheckpoint) error {
+			visited++
+			return ErrStopWalk
+		})
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(visited, 1)
+	})
+
+	t.Run("finds ancestors", func(t *testing.T) {
+		ancestors, err := NewWalker(objdb).Ancestors(left.Hash())
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(len(ancestors), 2)
+
+		isAncestor, err := NewWalker(objdb).IsAncestor(right.Hash(), tip.Hash())
+		test.H(t).IsNil(err)
+		test.H(t).BoolEql(isAncestor, true)
+
+		isAncestor, err = NewWalker(objdb).IsAncestor(left.Hash(), right.Hash())
+		test.H(t).IsNil(err)
+		test.H(t).BoolEql(isAncestor, false)
+	})
+
+	t.Run("finds checkpoints exclusive to head", func(t *testing.T) {
+		exclusive, err := NewWalker(objdb).Exclusive(tip.Hash(), left.Hash())
+		test.H(t).IsNil(err)
+		if diff := cmp.Diff(walkedHashes(exclusive), hashes(tip, merge, right)); diff != "" {
+			t.Errorf("exclusive checkpoints differ: (-got +want)\n%s", diff)
+		}
+		test.H(t).IntEql(len(exclusive[2].Affix["right/1"]), 1)
+
+		exclusive, err = NewWalker(objdb).Exclusive(left.Hash(), tip.Hash())
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(len(exclusive), 0)
+
+		exclusive, err = NewWalker(objdb).Exclusive(left.Hash(), nil)
+		test.H(t).IsNil(err)
+		if diff := cmp.Diff(walkedHashes(exclusive), hashes(left, root)); diff != "" {
+			t.Errorf("exclusive checkpoints differ: (-got +want)\n%s", diff)
+		}
+	})
+
+	t.Run("does not walk history shared with since", func(t *testing.T) {
+		var counting = countingObjects{objdb, make(map[string]int)}
+		exclusive, err := NewWalker(counting).Exclusive(tip.Hash(), merge.Hash())
+		test.H(t).IsNil(err)
+		if diff := cmp.Diff(walkedHashes(exclusive), hashes(tip)); diff != "" {
+			t.Errorf("exclusive checkpoints differ: (-got +want)\n%s", diff)
+		}
+		for _, cp := range []retro.HashedObject{left, right, root} {
+			test.H(t).IntEql(counting.retrieved[cp.Hash().String()], 0)
+		}
+	})
+
+	t.Run("unpacks with the given packer", func(t *testing.T) {
+		var unpacked int
+		walked, err := NewWalker(objdb, WithPacker(countingPacker{jp, &unpacked})).Ordered(tip.Hash(), nil)
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(unpacked, len(walked))
+	})
+
+	t.Run("passes errors through", func(t *testing.T) {
+		var (
+			incomplete = objects{tip.Hash().String(): tip}
+			sentinel   = xerrors.New("sentinel")
+		)
+		for k, v := range objdb {
+			if k != root.Hash().String() {
+				incomplete[k] = v
+			}
+		}
+		_, err := NewWalker(incomplete).Ordered(tip.Hash(), nil)
+		if !xerrors.Is(err, errNotFound) {
+			t.Errorf("expected missing parent to yield %q, got %v", errNotFound, err)
+		}
+
+		affix, err := jp.PackAffix(packing.Affix{"affix/1": {}})
+		test.H(t).IsNil(err)
+		objdb[affix.Hash().String()] = affix
+		_, err = NewWalker(objdb).Ordered(affix.Hash(), nil)
+		if !xerrors.Is(err, ErrNotACheckpoint) {
+			t.Errorf("expected walking an affix to yield %q, got %v", ErrNotACheckpoint, err)
+		}
+
+		err = NewWalker(objdb).Walk(tip.Hash(), nil, func(wc WalkedCheckpoint) error { return sentinel })
+		test.H(t).ErrEql(err, sentinel)
+	})
+
+	t.Run("walks long histories", func(t *testing.T) {
+		var head = root
+		for i := 0; i < 10000; i++ {
+			head = checkpoint(t, i, "long/1", head)
+		}
+		walked, err := NewWalker(objdb).Ordered(head.Hash(), nil)
+		test.H(t).IsNil(err)
+		test.H(t).IntEql(len(walked), 10001)
+		test.H(t).StringEql(walked[0].CheckpointHash.String(), root.Hash().String())
+	})
+}
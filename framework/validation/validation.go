@@ -0,0 +1,309 @@
+// Package validation validates command args declared with "validate"
+// struct tags, e.g:
+//
+//	type Args struct {
+//		Name   string         `json:"name" validate:"required,maxlen=100"`
+//		Price  uint16         `json:"price" validate:"min=1"`
+//		Radius string         `json:"radius" validate:"oneof=public private"`
+//		Images [][]byte       `json:"images" validate:"maxlen=10,maxbytes=1048576"`
+//		Avatar *retro.BlobRef `json:"avatar" validate:"maxbytes=1048576"`
+//	}
+//
+// The rules are:
+//
+//	required     the value must not be the zero value (or empty)
+//	minlen=N     strings, slices and maps must have a length of at least N
+//	maxlen=N     strings, slices and maps must have a length of at most N
+//	min=N        numbers must be at least N
+//	max=N        numbers must be at most N
+//	oneof=A B C  strings and numbers must be one of the space separated values
+//	maxbytes=N   []byte, or each []byte of a [][]byte, must be at most N bytes,
+//	             as must the blobs retro.BlobRefs reference
+//
+// Rules other than required are skipped for empty values, combine them with
+// required if the value must be given. Fields are named in errors by their
+// JSON name, as that is how they are given in commands.
+//
+// The Size of a retro.BlobRef is given by the client, so unless the blobs
+// are looked up (see WithBlobs) maxbytes only checks what the client
+// claims.
+//
+// The resolver validates args before it sets them on commands.
+package validation
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/retro-framework/go-retro/framework/object"
+	"github.com/retro-framework/go-retro/framework/retro"
+)
+
+// TagName is the struct tag the rules are read from.
+const TagName = "validate"
+
+// Option configures Validate.
+type Option func(*validator)
+
+// WithBlobs makes Validate look up the blob every retro.BlobRef references
+// in src, whether or not the field has rules. BlobRefs to blobs which can't
+// be opened fail validation, and maxbytes checks the size of the blob as
+// stored rather than the Size in the BlobRef.
+func WithBlobs(src object.BlobSource) Option {
+	return func(vr *validator) {
+		vr.blobs = src
+	}
+}
+
+type validator struct {
+	blobs object.BlobSource
+
+	// sizes are the stored sizes of the blobs looked up, by hash
+	sizes map[string]int64
+}
+
+// Validate checks the fields of args, a struct or a pointer to one, against
+// the rules in their tags. It returns retro.ValidationErrors listing every
+// failed rule, or an error if a tag can't be understood.
+func Validate(args interface{}, opts ...Option) error {
+	var vr = validator{sizes: make(map[string]int64)}
+	for _, opt := range opts {
+		opt(&vr)
+	}
+
+	var v = reflect.ValueOf(args)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var errs retro.ValidationErrors
+	for i := 0; i < v.NumField(); i++ {
+		var sf = v.Type().Field(i)
+		if vr.blobs != nil {
+			if reason := vr.lookupBlobs(v.Field(i)); reason != "" {
+				errs = append(errs, retro.ValidationError{Field: fieldName(sf), Reason: reason})
+				continue
+			}
+		}
+		tag, ok := sf.Tag.Lookup(TagName)
+		if !ok || tag == "" || tag == "-" {
+			continue
+		}
+		for _, r := range strings.Split(tag, ",") {
+			reason, err := vr.check(v.Field(i), r)
+			if err != nil {
+				return fmt.Errorf("validation: field %s: %s", sf.Name, err)
+			}
+			if reason != "" {
+				errs = append(errs, retro.ValidationError{Field: fieldName(sf), Reason: reason})
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// lookupBlobs opens the blobs referenced by a retro.BlobRef, *retro.BlobRef
+// or []retro.BlobRef field and records their sizes. It returns the reason
+// the field fails validation if any of them can't be opened.
+func (vr *validator) lookupBlobs(v reflect.Value) string {
+	var refs []retro.BlobRef
+	switch {
+	case isBlobRef(v.Type()):
+		if ref, ok := blobRef(v); ok {
+			refs = append(refs, ref)
+		}
+	case v.Kind() == reflect.Slice && isBlobRef(v.Type().Elem()):
+		for i := 0; i < v.Len(); i++ {
+			if ref, ok := blobRef(v.Index(i)); ok {
+				refs = append(refs, ref)
+			}
+		}
+	}
+	for _, ref := range refs {
+		if _, known := vr.sizes[ref.Hash]; known {
+			continue
+		}
+		rc, size, err := vr.blobs.OpenBlob(ref.Hash)
+		if err != nil {
+			return fmt.Sprintf("references unknown blob %q", ref.Hash)
+		}
+		rc.Close()
+		vr.sizes[ref.Hash] = size
+	}
+	return ""
+}
+
+// check returns the reason the value fails the rule, if it does.
+func (vr *validator) check(v reflect.Value, rule string) (string, error) {
+	var name, param = rule, ""
+	if i := strings.Index(rule, "="); i >= 0 {
+		name, param = rule[:i], rule[i+1:]
+	}
+
+	if name == "required" {
+		if isEmpty(v) {
+			return "is required", nil
+		}
+		return "", nil
+	}
+
+	if isEmpty(v) {
+		return "", nil
+	}
+
+	switch name {
+	case "minlen", "maxlen":
+		n, err := strconv.Atoi(param)
+		if err != nil {
+			return "", fmt.Errorf("%s needs an integer: %s", name, err)
+		}
+		if !hasLen(v) {
+			return "", fmt.Errorf("%s can't be used with %s", name, v.Kind())
+		}
+		if name == "minlen" && v.Len() < n {
+			return fmt.Sprintf("must have a length of at least %d", n), nil
+		}
+		if name == "maxlen" && v.Len() > n {
+			return fmt.Sprintf("must have a length of at most %d", n), nil
+		}
+	case "min", "max":
+		n, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return "", fmt.Errorf("%s needs a number: %s", name, err)
+		}
+		f, ok := number(v)
+		if !ok {
+			return "", fmt.Errorf("%s can't be used with %s", name, v.Kind())
+		}
+		if name == "min" && f < n {
+			return fmt.Sprintf("must be at least %s", param), nil
+		}
+		if name == "max" && f > n {
+			return fmt.Sprintf("must be at most %s", param), nil
+		}
+	case "oneof":
+		var s string
+		switch v.Kind() {
+		case reflect.String:
+			s = v.String()
+		default:
+			if _, ok := number(v); !ok {
+				return "", fmt.Errorf("oneof can't be used with %s", v.Kind())
+			}
+			s = fmt.Sprint(v.Interface())
+		}
+		var options = strings.Fields(param)
+		for _, o := range options {
+			if s == o {
+				return "", nil
+			}
+		}
+		return fmt.Sprintf("must be one of %s", strings.Join(options, ", ")), nil
+	case "maxbytes":
+		n, err := strconv.Atoi(param)
+		if err != nil {
+			return "", fmt.Errorf("maxbytes needs an integer: %s", err)
+		}
+		switch {
+		case isBytes(v.Type()) || isBlobRef(v.Type()):
+			if vr.size(v) > n {
+				return fmt.Sprintf("must be at most %d bytes", n), nil
+			}
+		case v.Kind() == reflect.Slice && (isBytes(v.Type().Elem()) || isBlobRef(v.Type().Elem())):
+			for i := 0; i < v.Len(); i++ {
+				if vr.size(v.Index(i)) > n {
+					return fmt.Sprintf("must each be at most %d bytes", n), nil
+				}
+			}
+		default:
+			return "", fmt.Errorf("maxbytes can't be used with %s", v.Type())
+		}
+	default:
+		return "", fmt.Errorf("unknown rule %q", name)
+	}
+	return "", nil
+}
+
+func isEmpty(v reflect.Value) bool {
+	if hasLen(v) {
+		return v.Len() == 0
+	}
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+func hasLen(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return true
+	}
+	return false
+}
+
+func isBytes(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8
+}
+
+var blobRefType = reflect.TypeOf(retro.BlobRef{})
+
+func isBlobRef(t reflect.Type) bool {
+	return t == blobRefType || (t.Kind() == reflect.Ptr && t.Elem() == blobRefType)
+}
+
+// blobRef returns the retro.BlobRef held by v, which may be a nil pointer.
+func blobRef(v reflect.Value) (retro.BlobRef, bool) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return retro.BlobRef{}, false
+		}
+		v = v.Elem()
+	}
+	return v.Interface().(retro.BlobRef), true
+}
+
+// size returns the length of a []byte or the size of the blob a
+// retro.BlobRef references, as stored if it was looked up.
+func (vr *validator) size(v reflect.Value) int {
+	if isBlobRef(v.Type()) {
+		ref, ok := blobRef(v)
+		if !ok {
+			return 0
+		}
+		if stored, known := vr.sizes[ref.Hash]; known {
+			return int(stored)
+		}
+		return int(ref.Size)
+	}
+	return v.Len()
+}
+
+func number(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// fieldName returns the JSON name of the field, or its Go name if it has
+// none.
+func fieldName(sf reflect.StructField) string {
+	if name := strings.Split(sf.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+		return name
+	}
+	return sf.Name
+}
@@ -0,0 +1,117 @@
+// +build unit
+
+package validation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/retro-framework/go-retro/framework/retro"
+	"github.com/retro-framework/go-retro/framework/storage/memory"
+	test "github.com/retro-framework/go-retro/framework/test_helper"
+	"golang.org/x/xerrors"
+)
+
+type args struct {
+	Name   string   `json:"name" validate:"required,minlen=2,maxlen=5"`
+	Price  uint16   `json:"price" validate:"required,min=1,max=100"`
+	Radius string   `json:"radius,omitempty" validate:"oneof=public private"`
+	Avatar []byte   `validate:"maxbytes=4"`
+	Images [][]byte `json:"images" validate:"maxlen=2,maxbytes=2"`
+	Free   bool     `json:"free"`
+}
+
+func Test_Validate(t *testing.T) {
+
+	t.Run("accepts valid args", func(t *testing.T) {
+		test.H(t).IsNil(Validate(&args{Name: "bob", Price: 5, Radius: "public", Avatar: []byte("abcd"), Images: [][]byte{[]byte("ab")}}))
+	})
+
+	t.Run("skips rules other than required for empty values", func(t *testing.T) {
+		test.H(t).IsNil(Validate(args{Name: "bob", Price: 1}))
+	})
+
+	t.Run("returns an error for every failed rule named by JSON name", func(t *testing.T) {
+		var err = Validate(&args{
+			Name:   "bobby tables",
+			Radius: "secret",
+			Avatar: []byte("abcde"),
+			Images: [][]byte{[]byte("a"), []byte("abc"), []byte("b")},
+		})
+		test.H(t).BoolEql(xerrors.Is(err, retro.ErrValidation), true)
+
+		var ves retro.ValidationErrors
+		test.H(t).BoolEql(xerrors.As(err, &ves), true)
+		if diff := cmp.Diff(ves, retro.ValidationErrors{
+			{Field: "name", Reason: "must have a length of at most 5"},
+			{Field: "price", Reason: "is required"},
+			{Field: "radius", Reason: "must be one of public, private"},
+			{Field: "Avatar", Reason: "must be at most 4 bytes"},
+			{Field: "images", Reason: "must have a length of at most 2"},
+			{Field: "images", Reason: "must each be at most 2 bytes"},
+		}); diff != "" {
+			t.Errorf("validation errors differ: (-got +want)\n%s", diff)
+		}
+		test.H(t).StringEql(ves.Fields()["images"], "must have a length of at most 2")
+	})
+
+	t.Run("checks the size of referenced blobs", func(t *testing.T) {
+		var err = Validate(&struct {
+			Avatar *retro.BlobRef  `json:"avatar" validate:"required,maxbytes=4"`
+			Images []retro.BlobRef `json:"images" validate:"maxbytes=2"`
+		}{
+			Avatar: &retro.BlobRef{Hash: "sha256:00", Size: 5},
+			Images: []retro.BlobRef{{Hash: "sha256:01", Size: 2}, {Hash: "sha256:02", Size: 3}},
+		})
+		var ves retro.ValidationErrors
+		test.H(t).BoolEql(xerrors.As(err, &ves), true)
+		if diff := cmp.Diff(ves, retro.ValidationErrors{
+			{Field: "avatar", Reason: "must be at most 4 bytes"},
+			{Field: "images", Reason: "must each be at most 2 bytes"},
+		}); diff != "" {
+			t.Errorf("validation errors differ: (-got +want)\n%s", diff)
+		}
+	})
+
+	t.Run("looks up referenced blobs", func(t *testing.T) {
+		var objdb = &memory.ObjectStore{}
+		big, err := objdb.WriteBlob(strings.NewReader("abcdef"))
+		test.H(t).IsNil(err)
+		small, err := objdb.WriteBlob(strings.NewReader("ab"))
+		test.H(t).IsNil(err)
+
+		type blobArgs struct {
+			Avatar *retro.BlobRef  `json:"avatar" validate:"maxbytes=4"`
+			Images []retro.BlobRef `json:"images"`
+		}
+
+		test.H(t).IsNil(Validate(&blobArgs{Avatar: &small, Images: []retro.BlobRef{big}}, WithBlobs(objdb)))
+
+		err = Validate(&blobArgs{
+			Avatar: &retro.BlobRef{Hash: big.Hash, Size: 1},
+			Images: []retro.BlobRef{small, {Hash: "sha256:00", Size: 1}},
+		}, WithBlobs(objdb))
+		var ves retro.ValidationErrors
+		test.H(t).BoolEql(xerrors.As(err, &ves), true)
+		if diff := cmp.Diff(ves, retro.ValidationErrors{
+			{Field: "avatar", Reason: "must be at most 4 bytes"},
+			{Field: "images", Reason: `references unknown blob "sha256:00"`},
+		}); diff != "" {
+			t.Errorf("validation errors differ: (-got +want)\n%s", diff)
+		}
+	})
+
+	t.Run("checks numeric ranges", func(t *testing.T) {
+		var err = Validate(&args{Name: "bob", Price: 101})
+		test.H(t).StringEql(err.Error(), "validation failed: price must be at most 100")
+	})
+
+	t.Run("errors for rules it can't understand", func(t *testing.T) {
+		var err = Validate(struct {
+			N int `validate:"maxbytes=1"`
+		}{1})
+		test.H(t).NotNil(err)
+		test.H(t).BoolEql(xerrors.Is(err, retro.ErrValidation), false)
+	})
+}